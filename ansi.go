@@ -0,0 +1,58 @@
+package command
+
+import "strings"
+
+// stripANSI removes the terminal escape sequences from a line: CSI
+// sequences such as color codes, OSC sequences such as titles and
+// hyperlinks, and any other escape with its following character. A sequence
+// cut off by the end of the line is removed up to the end. A line without
+// escapes is returned as is.
+func stripANSI(line string) string {
+	if strings.IndexByte(line, '\x1b') < 0 {
+		return line
+	}
+	var b strings.Builder
+	b.Grow(len(line))
+	for i := 0; i < len(line); {
+		if line[i] != '\x1b' {
+			b.WriteByte(line[i])
+			i++
+			continue
+		}
+		i = escapeEnd(line, i)
+	}
+	return b.String()
+}
+
+// escapeEnd is the index just past the escape sequence starting at line[i]
+func escapeEnd(line string, i int) int {
+	i++
+	if i == len(line) {
+		return i
+	}
+	switch line[i] {
+	case '[':
+		// Parameter and intermediate bytes, then one final byte
+		for i++; i < len(line); i++ {
+			if c := line[i]; c >= 0x40 && c <= 0x7e {
+				return i + 1
+			}
+		}
+		return i
+	case ']':
+		// Terminated by BEL or by the string terminator ESC \
+		for i++; i < len(line); i++ {
+			switch line[i] {
+			case '\a':
+				return i + 1
+			case '\x1b':
+				if i+1 < len(line) && line[i+1] == '\\' {
+					return i + 2
+				}
+			}
+		}
+		return i
+	default:
+		return i + 1
+	}
+}
@@ -0,0 +1,72 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+const plainLog = "build started\nok   pkg/a\nFAIL pkg/b\n3 passed, 1 failed\n"
+
+// coloredLog is plainLog as a terminal shows it, with a window title set
+// through OSC and color codes through CSI
+const coloredLog = "\x1b]0;ci\x07build started\n" +
+	"\x1b[32mok\x1b[0m   pkg/a\n" +
+	"\x1b[1;31mFAIL\x1b[0m pkg/b\n" +
+	"\x1b[1m3 passed\x1b[0m, \x1b[31m1 failed\x1b[0m\n"
+
+func TestDiff_IgnoreANSIColoredLogMatchesPlain(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "plain.log", plainLog)
+	b := writeFile(t, dir, "colored.log", coloredLog)
+
+	stdout, _, err := execute(command.Diff(a, b, command.IgnoreANSI), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("colored log should match its plain twin:\n%q", stdout)
+	}
+
+	stdout, _, _ = execute(command.Diff(a, b), "")
+	if stdout == "" {
+		t.Error("without IgnoreANSI the escape sequences should differ")
+	}
+}
+
+func TestDiff_IgnoreANSIReportsTextualDifference(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "plain.log", plainLog)
+	b := writeFile(t, dir, "colored.log", strings.Replace(coloredLog, "pkg/b", "pkg/c", 1))
+
+	stdout, _, _ := execute(command.Diff(a, b, command.IgnoreANSI), "")
+	want := "3c3\n< FAIL pkg/b\n---\n> \x1b[1;31mFAIL\x1b[0m pkg/c\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	stdout, _, _ = execute(command.Diff(a, b, command.IgnoreANSI, command.StripANSI), "")
+	if want := "3c3\n< FAIL pkg/b\n---\n> FAIL pkg/c\n"; stdout != want {
+		t.Errorf("StripANSI should print plain lines: got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_IgnoreANSITruncatedSequences(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\ntwo\nthree\nfour\n")
+	b := writeFile(t, dir, "b", "one\x1b\ntwo\x1b[1;3\nthree\x1b]0;title\nfour\x1b]8;;\x1b\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.IgnoreANSI), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("truncated sequences should strip to the end of the line:\n%q", stdout)
+	}
+}
+
+func TestDiff_StripANSIRequiresIgnoreANSI(t *testing.T) {
+	_, stderr, err := execute(command.Diff("a", "b", command.StripANSI), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "StripANSI requires IgnoreANSI") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
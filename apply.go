@@ -0,0 +1,359 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxApplyOffset bounds how far from its stated position a hunk is searched
+// for when the context does not match there
+const maxApplyOffset = 100
+
+// errBinaryPatch is the error of applying a notice that binary files differ,
+// which records no change that could be made
+var errBinaryPatch = errors.New("binary files differ, and the diff holds no changes to apply")
+
+// Apply applies a unified diff for a single file to original and returns
+// the patched lines. Every hunk's context and deleted lines are verified;
+// a hunk whose lines are not found at the stated position is searched for
+// nearby, the way patch(1) applies hunks with an offset.
+func Apply(original []string, unifiedDiff string) ([]string, error) {
+	return ApplyReader(original, strings.NewReader(unifiedDiff))
+}
+
+// ApplyReader is Apply reading the unified diff from r
+func ApplyReader(original []string, r io.Reader) ([]string, error) {
+	patches, err := parsePatch(r, false)
+	if err != nil {
+		return nil, err
+	}
+	switch len(patches) {
+	case 0:
+		return append([]string(nil), original...), nil
+	case 1:
+		if patches[0].Binary {
+			return nil, errBinaryPatch
+		}
+		return applyHunks(original, patches[0].hunks, maxApplyOffset, 0, nil)
+	default:
+		return nil, fmt.Errorf("diff changes %d files, use ApplyFiles", len(patches))
+	}
+}
+
+// ApplyBytes applies a unified diff to the bytes of a file and returns the
+// patched bytes. Lines keep their terminators on both sides, carriage
+// returns included, and a "\ No newline at end of file" marker takes the
+// newline off the line before it, so the result is the second file byte for
+// byte; for files with CRLF lines the diff must be made under PreserveEOL.
+func ApplyBytes(original []byte, unifiedDiff string) ([]byte, error) {
+	patches, err := parsePatch(strings.NewReader(unifiedDiff), true)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(original), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	switch len(patches) {
+	case 0:
+	case 1:
+		if patches[0].Binary {
+			return nil, errBinaryPatch
+		}
+		if lines, err = applyHunks(lines, patches[0].hunks, maxApplyOffset, 0, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("diff changes %d files, use ApplyFiles", len(patches))
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// ApplyFiles applies a unified diff that may change several files. originals
+// holds the lines of every file the diff changes, keyed by the name on its
+// "---" header line; the result holds the patched lines under the same keys.
+// A section from /dev/null creates the file on its "+++" line, which keys
+// its lines, and a section to /dev/null deletes its file, whose key holds
+// nil.
+func ApplyFiles(originals map[string][]string, r io.Reader) (map[string][]string, error) {
+	patches, err := parsePatch(r, false)
+	if err != nil {
+		return nil, err
+	}
+	patched := make(map[string][]string, len(patches))
+	for _, patch := range patches {
+		name, original := patch.OldName, []string(nil)
+		if patch.Binary {
+			return nil, fmt.Errorf("%s: %w", name, errBinaryPatch)
+		}
+		if name == devNull {
+			name = patch.NewName
+		} else if lines, ok := originals[name]; ok {
+			original = lines
+		} else {
+			return nil, fmt.Errorf("%s: no original lines given", name)
+		}
+		lines, err := applyHunks(original, patch.hunks, maxApplyOffset, 0, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if patch.NewName == devNull {
+			if len(lines) > 0 {
+				return nil, fmt.Errorf("%s: deleted file keeps %d lines", name, len(lines))
+			}
+			lines = nil
+		}
+		patched[name] = lines
+	}
+	return patched, nil
+}
+
+// DefaultFuzz is the fuzz ApplyFuzzy uses when ApplyOptions leaves it
+// zero, as patch(1) does
+const DefaultFuzz = 2
+
+// ApplyOptions set how far ApplyFuzzy strays from a hunk as written. A zero
+// field takes the default, maxApplyOffset lines of offset and DefaultFuzz,
+// and a negative one allows none.
+type ApplyOptions struct {
+	// MaxOffset is how many lines before or after its stated position,
+	// less the offset of the hunk before it, a hunk is searched for
+	MaxOffset int
+
+	// Fuzz is how many lines of context at each end of a hunk may be left
+	// unmatched when the whole hunk is not found, like patch -F
+	Fuzz int
+}
+
+// limits resolves the zero and negative values of o
+func (o ApplyOptions) limits() (maxOffset, fuzz int) {
+	maxOffset, fuzz = o.MaxOffset, o.Fuzz
+	if maxOffset == 0 {
+		maxOffset = maxApplyOffset
+	}
+	if fuzz == 0 {
+		fuzz = DefaultFuzz
+	}
+	return max(maxOffset, 0), max(fuzz, 0)
+}
+
+// HunkPlacement records where ApplyFuzzy applied a hunk
+type HunkPlacement struct {
+	// Hunk is the number of the hunk in its diff, from 1, and DiffLine the
+	// line of its header
+	Hunk, DiffLine int
+
+	// Line is the line of the original the hunk's old lines start at, or
+	// that an insertion follows
+	Line int
+
+	// Offset is how far Line is from the line the hunk states, and Fuzz
+	// how many of its context lines at each end went unmatched
+	Offset, Fuzz int
+}
+
+// ApplyReport is how ApplyFuzzy applied the hunks of a diff, in order
+type ApplyReport struct {
+	Hunks []HunkPlacement
+}
+
+// Warnings describes every hunk that did not apply exactly as written, the
+// way patch(1) reports it
+func (r ApplyReport) Warnings() []string {
+	var warnings []string
+	for _, h := range r.Hunks {
+		if h.Offset == 0 && h.Fuzz == 0 {
+			continue
+		}
+		warning := fmt.Sprintf("Hunk #%d succeeded at %d", h.Hunk, h.Line)
+		if h.Fuzz > 0 {
+			warning += fmt.Sprintf(" with fuzz %d", h.Fuzz)
+		}
+		if h.Offset != 0 {
+			lines := "lines"
+			if h.Offset == 1 || h.Offset == -1 {
+				lines = "line"
+			}
+			warning += fmt.Sprintf(" (offset %d %s)", h.Offset, lines)
+		}
+		warnings = append(warnings, warning+".")
+	}
+	return warnings
+}
+
+// HunkError is a hunk that could not be applied: Hunk is its number, from
+// 1, DiffLine the line of its header and Stated the line the header gives.
+// Line is the line of the original, from 1, that first mismatches the hunk
+// where most of it matches, Want the hunk's line there and Got the
+// original's, empty past its end.
+type HunkError struct {
+	Hunk, DiffLine int
+	Stated         int
+	Line           int
+	Want, Got      string
+}
+
+func (e *HunkError) Error() string {
+	return fmt.Sprintf("hunk %d (diff line %d) does not apply at line %d: line %d is %q, not %q", e.Hunk, e.DiffLine, e.Stated, e.Line, e.Got, e.Want)
+}
+
+// ApplyFuzzy is Apply for slightly stale diffs: a hunk not found within
+// opts.MaxOffset lines of its stated position is searched for again with
+// ever more of its context left unmatched, up to opts.Fuzz lines at each
+// end, the way patch(1) applies it. The report records the offset and fuzz
+// each hunk applied with. A hunk that does not apply is a *HunkError.
+func ApplyFuzzy(original []string, unifiedDiff string, opts ApplyOptions) ([]string, ApplyReport, error) {
+	var report ApplyReport
+	patches, err := parsePatch(strings.NewReader(unifiedDiff), false)
+	if err != nil {
+		return nil, report, err
+	}
+	switch len(patches) {
+	case 0:
+		return append([]string(nil), original...), report, nil
+	case 1:
+		if patches[0].Binary {
+			return nil, report, errBinaryPatch
+		}
+		maxOffset, fuzz := opts.limits()
+		lines, err := applyHunks(original, patches[0].hunks, maxOffset, fuzz, &report)
+		return lines, report, err
+	default:
+		return nil, report, fmt.Errorf("diff changes %d files, use ApplyFiles", len(patches))
+	}
+}
+
+// applyHunks applies hunks in order, each within maxOffset lines of where
+// it states and with up to fuzz lines of context at each end unmatched,
+// recording where each applied in report when it is not nil. The offset at
+// which one hunk applied carries over to the next, and hunks may not
+// overlap.
+func applyHunks(original []string, hunks []patchHunk, maxOffset, fuzz int, report *ApplyReport) ([]string, error) {
+	var patched []string
+	cursor, offset := 0, 0
+	for i, h := range hunks {
+		// An empty old range names the line before the insertion point
+		start := h.OldStart
+		if h.OldLines > 0 {
+			start--
+		}
+
+		var at, level int
+		var old, replacement []string
+		found := false
+		for level = 0; level <= fuzz && !found; level++ {
+			top, bottom := contextFuzz(h.Ops, level)
+			if level > 0 && top+bottom == 0 {
+				break
+			}
+			old, replacement = hunkSides(h.Ops[top : len(h.Ops)-bottom])
+			at, found = locateHunk(original, old, start+top+offset, cursor, maxOffset)
+			at -= top
+		}
+		if !found {
+			old, _ = hunkSides(h.Ops)
+			line, want, got := nearestMismatch(original, old, start+offset, cursor, maxOffset)
+			return nil, &HunkError{Hunk: i + 1, DiffLine: h.line, Stated: h.OldStart, Line: line + 1, Want: want, Got: got}
+		}
+		level--
+		top, _ := contextFuzz(h.Ops, level)
+
+		patched = append(patched, original[cursor:at+top]...)
+		patched = append(patched, replacement...)
+		cursor = at + top + len(old)
+		offset = at - start
+		if report != nil {
+			report.Hunks = append(report.Hunks, HunkPlacement{Hunk: i + 1, DiffLine: h.line, Line: at + 1, Offset: at - start, Fuzz: level})
+		}
+	}
+	return append(patched, original[cursor:]...), nil
+}
+
+// hunkSides splits the ops of a hunk into the lines it expects and the
+// lines it leaves in their place
+func hunkSides(ops []Op) (old, replacement []string) {
+	for _, op := range ops {
+		if op.Kind != OpInsert {
+			old = append(old, op.Text)
+		}
+		if op.Kind != OpDelete {
+			replacement = append(replacement, op.Text)
+		}
+	}
+	return old, replacement
+}
+
+// contextFuzz is how many ops at the top and bottom of a hunk fuzz level
+// leaves unmatched: up to level lines of the context at each end
+func contextFuzz(ops []Op, level int) (top, bottom int) {
+	for top < level && top < len(ops) && ops[top].Kind == OpEqual {
+		top++
+	}
+	for bottom < level && bottom < len(ops)-top && ops[len(ops)-1-bottom].Kind == OpEqual {
+		bottom++
+	}
+	return top, bottom
+}
+
+// locateHunk finds where old occurs in lines, trying want first and then
+// positions ever further from it up to maxOffset, but never before floor
+func locateHunk(lines, old []string, want, floor, maxOffset int) (int, bool) {
+	for distance := 0; distance <= maxOffset; distance++ {
+		for _, at := range []int{want - distance, want + distance} {
+			if at >= floor && at+len(old) <= len(lines) && matchesAt(lines, old, at) {
+				return at, true
+			}
+			if distance == 0 {
+				break
+			}
+		}
+	}
+	return 0, false
+}
+
+// nearestMismatch finds, among the positions locateHunk searches, the one
+// where most lines of old match, the nearest to want first, and returns the
+// index of its first mismatching line with the line old has there and the
+// line lines has, empty past its end
+func nearestMismatch(lines, old []string, want, floor, maxOffset int) (line int, wantLine, got string) {
+	best, bestAt := -1, max(want, floor)
+	for distance := 0; distance <= maxOffset; distance++ {
+		for _, at := range []int{want - distance, want + distance} {
+			if at >= floor && at <= len(lines) {
+				matched := 0
+				for i, text := range old {
+					if at+i < len(lines) && lines[at+i] == text {
+						matched++
+					}
+				}
+				if matched > best {
+					best, bestAt = matched, at
+				}
+			}
+			if distance == 0 {
+				break
+			}
+		}
+	}
+	for i, text := range old {
+		if bestAt+i >= len(lines) {
+			return bestAt + i, text, ""
+		}
+		if lines[bestAt+i] != text {
+			return bestAt + i, text, lines[bestAt+i]
+		}
+	}
+	return bestAt, "", ""
+}
+
+// matchesAt reports whether lines holds old starting at index at
+func matchesAt(lines, old []string, at int) bool {
+	for i, line := range old {
+		if lines[at+i] != line {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+package command_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// splitLines splits text into lines without their terminators
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func TestApply_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	oldText := numberedLines(30, map[int]string{3: "old 3", 20: "old 20"})
+	newText := numberedLines(30, map[int]string{3: "new 3", 20: "new 20"})
+	a := writeFile(t, dir, "a", oldText)
+	b := writeFile(t, dir, "b", newText)
+
+	patch, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+
+	got, err := command.Apply(splitLines(oldText), patch)
+	assertion.NoError(t, err)
+	if strings.Join(got, "\n") != strings.Join(splitLines(newText), "\n") {
+		t.Errorf("patched lines differ:\n%s", strings.Join(got, "\n"))
+	}
+
+	got, err = command.ApplyReader(splitLines(oldText), strings.NewReader(patch))
+	assertion.NoError(t, err)
+	if len(got) != 30 || got[19] != "new 20" {
+		t.Errorf("ApplyReader got %q", got)
+	}
+}
+
+func TestApply_RoundTripShapes(t *testing.T) {
+	tests := []struct {
+		name, a, b string
+	}{
+		{"first line", "a\nb\nc\n", "x\nb\nc\n"},
+		{"last line", "a\nb\nc\n", "a\nb\nx\n"},
+		{"insert at top", "b\nc\n", "a\nb\nc\n"},
+		{"append", "a\nb\n", "a\nb\nc\nd\n"},
+		{"into empty", "", "a\nb\n"},
+		{"to empty", "a\nb\n", ""},
+		{"overlapping context", numberedLines(20, map[int]string{5: "x"}), numberedLines(20, map[int]string{8: "y"})},
+		{"separate hunks", numberedLines(40, map[int]string{2: "x", 35: "y"}), numberedLines(40, map[int]string{20: "z"})},
+		{"repeated lines", "x\nx\ny\nx\nx\n", "x\ny\nx\ny\nx\n"},
+	}
+	for _, tt := range tests {
+		for _, context := range []int{1, 3, 5} {
+			t.Run(fmt.Sprintf("%s/U%d", tt.name, context), func(t *testing.T) {
+				dir := t.TempDir()
+				a := writeFile(t, dir, "a", tt.a)
+				b := writeFile(t, dir, "b", tt.b)
+				patch, _, err := execute(command.Diff(a, b, command.Unified, command.UnifiedContext(context)), "")
+				assertDiffers(t, err)
+				got, err := command.Apply(splitLines(tt.a), patch)
+				assertion.NoError(t, err)
+				if strings.Join(got, "\n") != strings.Join(splitLines(tt.b), "\n") {
+					t.Errorf("patched lines differ:\n%s\npatch:\n%s", strings.Join(got, "\n"), patch)
+				}
+			})
+		}
+	}
+}
+
+func TestApply_NormalizedContext(t *testing.T) {
+	// The context lines are equal only under IgnoreCase and IgnoreWhitespace
+	oldText := "Alpha\n  beta\t\nold\nDelta\n"
+	newText := "alpha\nbeta\nnew\ndelta\n"
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", oldText)
+	b := writeFile(t, dir, "b", newText)
+
+	for _, context := range []int{1, 3} {
+		t.Run(fmt.Sprintf("U%d", context), func(t *testing.T) {
+			patch, _, err := execute(command.Diff(a, b, command.Unified, command.UnifiedContext(context), command.IgnoreCase, command.IgnoreWhitespace), "")
+			assertDiffers(t, err)
+			// The patch carries the old file's context and applies to it,
+			// changing only the line that differs after normalization
+			got, err := command.Apply(splitLines(oldText), patch)
+			assertion.NoError(t, err)
+			if strings.Join(got, ",") != "Alpha,  beta\t,new,Delta" {
+				t.Errorf("got %q from patch:\n%s", got, patch)
+			}
+			_, err = command.Apply(splitLines(newText), patch)
+			assertion.Error(t, err)
+		})
+	}
+
+	// Each context diff section shows its own file's text
+	stdout, _, err := execute(command.Diff(a, b, command.ContextDiff, command.IgnoreCase, command.IgnoreWhitespace), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stdout, "  Alpha\n    beta\t\n! old\n  Delta\n") || !strings.Contains(stdout, "  alpha\n  beta\n! new\n  delta\n") {
+		t.Errorf("got:\n%s", stdout)
+	}
+
+	// So does each side-by-side column
+	stdout, _, err = execute(command.Diff(a, b, command.SideBySide, command.Width(30), command.IgnoreCase, command.IgnoreWhitespace), "")
+	assertDiffers(t, err)
+	if first, _, _ := strings.Cut(stdout, "\n"); !strings.HasPrefix(first, "Alpha") || !strings.HasSuffix(first, "alpha") {
+		t.Errorf("got:\n%s", stdout)
+	}
+}
+
+func TestApply_NoNewlineMarkerAndEmptyRanges(t *testing.T) {
+	patch := "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n@@ -2 +3 @@\n-two\n\\ No newline at end of file\n+TWO\n\\ No newline at end of file\n"
+	got, err := command.Apply([]string{"one", "two"}, patch)
+	assertion.NoError(t, err)
+	if strings.Join(got, ",") != "x,y,one,TWO" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestApply_ContextMismatch(t *testing.T) {
+	patch := "--- a\n+++ b\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n@@ -8,2 +8,2 @@\n-eight\n+EIGHT\n nine\n"
+	_, err := command.Apply([]string{"one", "two", "three", "four", "five", "six", "seven", "8", "nine"}, patch)
+	assertion.Error(t, err)
+	if !strings.Contains(err.Error(), "hunk 2 (diff line 8) does not apply at line 8") {
+		t.Errorf("error does not name the failing hunk: %v", err)
+	}
+}
+
+func TestApply_Offset(t *testing.T) {
+	original := splitLines(numberedLines(20, nil))
+	// The hunk claims line 5 but the file has three extra lines in front
+	shifted := append([]string{"extra a", "extra b", "extra c"}, original...)
+	patch := "--- a\n+++ b\n@@ -4,3 +4,3 @@\n line 4\n-line 5\n+changed 5\n line 6\n"
+
+	got, err := command.Apply(shifted, patch)
+	assertion.NoError(t, err)
+	if got[7] != "changed 5" || got[6] != "line 4" || len(got) != 23 {
+		t.Errorf("hunk applied at the wrong place: %q", got)
+	}
+}
+
+func TestApplyFiles(t *testing.T) {
+	patch := "diff -u a/x b/x\n--- a/x\t2024-01-01\n+++ b/x\n@@ -1 +1 @@\n-x\n+X\n" +
+		"--- a/y\n+++ b/y\n@@ -1,2 +1,3 @@\n y\n+inserted\n z\n"
+	got, err := command.ApplyFiles(map[string][]string{"a/x": {"x"}, "a/y": {"y", "z"}}, strings.NewReader(patch))
+	assertion.NoError(t, err)
+	if strings.Join(got["a/x"], ",") != "X" || strings.Join(got["a/y"], ",") != "y,inserted,z" {
+		t.Errorf("got %q", got)
+	}
+
+	_, err = command.Apply([]string{"x"}, patch)
+	assertion.Error(t, err)
+}
+
+func TestApplyFuzzy(t *testing.T) {
+	patch := "--- a\n+++ b\n@@ -9,5 +9,5 @@\n line 9\n line 10\n-line 11\n+changed 11\n line 12\n line 13\n"
+	// lines is numberedLines after extra new lines at the top
+	lines := func(extra int, replace map[int]string) []string {
+		var out []string
+		for i := range extra {
+			out = append(out, fmt.Sprintf("new %d", i+1))
+		}
+		return append(out, splitLines(numberedLines(20, replace))...)
+	}
+
+	tests := []struct {
+		name     string
+		original []string
+		want     command.HunkPlacement
+		warnings string
+	}{
+		{"clean", lines(0, nil), command.HunkPlacement{Hunk: 1, DiffLine: 3, Line: 9}, ""},
+		{"offset", lines(1, nil), command.HunkPlacement{Hunk: 1, DiffLine: 3, Line: 10, Offset: 1},
+			"Hunk #1 succeeded at 10 (offset 1 line)."},
+		{"fuzz 1", lines(0, map[int]string{9: "stale 9"}), command.HunkPlacement{Hunk: 1, DiffLine: 3, Line: 9, Fuzz: 1},
+			"Hunk #1 succeeded at 9 with fuzz 1."},
+		{"fuzz 1 and offset", lines(3, map[int]string{9: "stale 9", 13: "stale 13"}), command.HunkPlacement{Hunk: 1, DiffLine: 3, Line: 12, Offset: 3, Fuzz: 1},
+			"Hunk #1 succeeded at 12 with fuzz 1 (offset 3 lines)."},
+		{"fuzz 2", lines(0, map[int]string{9: "stale 9", 10: "stale 10"}), command.HunkPlacement{Hunk: 1, DiffLine: 3, Line: 9, Fuzz: 2},
+			"Hunk #1 succeeded at 9 with fuzz 2."},
+	}
+	for _, tt := range tests {
+		got, report, err := command.ApplyFuzzy(tt.original, patch, command.ApplyOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(report.Hunks) != 1 || report.Hunks[0] != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, report.Hunks, tt.want)
+		}
+		if warnings := strings.Join(report.Warnings(), "\n"); warnings != tt.warnings {
+			t.Errorf("%s: got warnings %q, want %q", tt.name, warnings, tt.warnings)
+		}
+		// The unmatched context is left as it was
+		want := append([]string(nil), tt.original...)
+		want[tt.want.Line+1] = "changed 11"
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got %q", tt.name, got)
+		}
+	}
+
+	// Apply allows no fuzz, and neither does a negative Fuzz
+	stale := lines(0, map[int]string{9: "stale 9"})
+	_, err := command.Apply(stale, patch)
+	assertion.Error(t, err)
+	_, _, err = command.ApplyFuzzy(stale, patch, command.ApplyOptions{Fuzz: -1})
+	assertion.Error(t, err)
+	// Nor does a hunk apply past MaxOffset
+	_, _, err = command.ApplyFuzzy(lines(3, nil), patch, command.ApplyOptions{MaxOffset: 2})
+	assertion.Error(t, err)
+
+	// A hunk off by more than the fuzz is rejected with its nearest
+	// mismatch
+	_, _, err = command.ApplyFuzzy(lines(0, map[int]string{10: "stale 10"}), patch, command.ApplyOptions{Fuzz: 1})
+	var hunkErr *command.HunkError
+	if !errors.As(err, &hunkErr) || hunkErr.Hunk != 1 || hunkErr.Line != 10 || hunkErr.Want != "line 10" || hunkErr.Got != "stale 10" {
+		t.Fatalf("got %v", err)
+	}
+	if want := `hunk 1 (diff line 3) does not apply at line 9: line 10 is "stale 10", not "line 10"`; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
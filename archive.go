@@ -0,0 +1,247 @@
+package command
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// memberSet is a sorted collection of named files that can be compared
+// against another collection, such as the members of a zip archive or the
+// regular files below a directory.
+type memberSet struct {
+	names []string
+	open  func(name string) (io.ReadCloser, error)
+	label func(name string) string
+	close func() error
+}
+
+// splitZipMember splits an operand of the form archive.zip//inner/path into
+// the archive path and the member name
+func splitZipMember(path string) (archive, member string, ok bool) {
+	i := strings.Index(strings.ToLower(path), ".zip//")
+	if i < 0 {
+		return "", "", false
+	}
+	return path[:i+len(".zip")], path[i+len(".zip//"):], true
+}
+
+// isZipArchive reports whether path is a regular file that opens as a zip archive
+func isZipArchive(path string) bool {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	_ = r.Close()
+	return true
+}
+
+// zipMember closes the archive along with the member being read
+type zipMember struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (z zipMember) Close() error {
+	err := z.ReadCloser.Close()
+	if archiveErr := z.archive.Close(); err == nil {
+		err = archiveErr
+	}
+	return err
+}
+
+// openZipMember opens a single member inside a zip archive
+func openZipMember(archive, member string) (io.ReadCloser, error) {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := r.Open(member)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return zipMember{ReadCloser: rc, archive: r}, nil
+}
+
+// zipMembers lists the file members of a zip archive
+func zipMembers(path string) (memberSet, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return memberSet{}, err
+	}
+
+	files := make(map[string]*zip.File)
+	var names []string
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files[f.Name] = f
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	return memberSet{
+		names: names,
+		open: func(name string) (io.ReadCloser, error) {
+			return files[name].Open()
+		},
+		label: func(name string) string { return path + "//" + name },
+		close: r.Close,
+	}, nil
+}
+
+// directoryMembers lists the regular files below a directory, recursively
+func directoryMembers(path string) (memberSet, error) {
+	var names []string
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return memberSet{}, err
+	}
+	sort.Strings(names)
+
+	return memberSet{
+		names: names,
+		open: func(name string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(path, filepath.FromSlash(name)))
+		},
+		label: func(name string) string { return path + "/" + name },
+		close: func() error { return nil },
+	}, nil
+}
+
+// openMemberSet lists the members of a zip archive or a directory
+func openMemberSet(path string) (memberSet, error) {
+	if isZipArchive(path) {
+		return zipMembers(path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return memberSet{}, err
+	}
+	if !info.IsDir() {
+		return memberSet{}, fmt.Errorf("not a zip archive or directory")
+	}
+	return directoryMembers(path)
+}
+
+// mergeSorted walks two sorted name lists in step, calling fn once for every
+// distinct name with a note of which lists contain it
+func mergeSorted(left, right []string, fn func(name string, inLeft, inRight bool) error) error {
+	i, j := 0, 0
+	for i < len(left) || j < len(right) {
+		var err error
+		switch {
+		case j >= len(right) || (i < len(left) && left[i] < right[j]):
+			err = fn(left[i], true, false)
+			i++
+		case i >= len(left) || right[j] < left[i]:
+			err = fn(right[j], false, true)
+			j++
+		default:
+			err = fn(left[i], true, true)
+			i++
+			j++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMember reads all lines of a single member
+func (p command) readMember(set memberSet, name string) ([]string, error) {
+	rc, err := set.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var buf lineBuffer
+	return p.readNamed(&buf, set.label(name), rc)
+}
+
+// compareArchives compares the members of two zip archives, or of a zip
+// archive and a directory. Members present on one side only are reported,
+// members present on both sides are diffed in the selected format.
+func (p command) compareArchives(stdout, stderr io.Writer, path1, path2 string) error {
+	left, err := openMemberSet(path1)
+	if err != nil {
+		return fileTrouble(stderr, path1, err)
+	}
+	defer left.close()
+
+	right, err := openMemberSet(path2)
+	if err != nil {
+		return fileTrouble(stderr, path2, err)
+	}
+	defer right.close()
+
+	var trouble error
+	differ := false
+	err = mergeSorted(left.names, right.names, func(name string, inLeft, inRight bool) error {
+		if !inRight {
+			_, _ = fmt.Fprintf(stdout, "Only in %s: %s\n", p.displayPath(path1), name)
+			differ = true
+			return nil
+		}
+		if !inLeft {
+			_, _ = fmt.Fprintf(stdout, "Only in %s: %s\n", p.displayPath(path2), name)
+			differ = true
+			return nil
+		}
+
+		label1, label2 := left.label(name), right.label(name)
+		lines1, err := p.readMember(left, name)
+		if err != nil {
+			p.notice(stderr, "%s: %v", label1, err)
+			trouble = &FileError{Path: label1, Err: err}
+			return nil
+		}
+		lines2, err := p.readMember(right, name)
+		if err != nil {
+			p.notice(stderr, "%s: %v", label2, err)
+			trouble = &FileError{Path: label2, Err: err}
+			return nil
+		}
+
+		if !bool(p.Flags.Brief) && !p.identical(lines1, lines2) {
+			p.writeBanner(stdout, label1, label2)
+		}
+		err = p.diffLines(stdout, label1, label2, lines1, lines2)
+		if errors.Is(err, ErrFilesDiffer) {
+			differ = true
+			return nil
+		}
+		return err
+	})
+
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "diff: %v\n", err)
+		return err
+	}
+	if trouble != nil {
+		return trouble
+	}
+	return verdict(differ)
+}
@@ -0,0 +1,89 @@
+package command_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// writeZip builds a zip archive in memory from name/content pairs and writes it to dir
+func writeZip(t *testing.T, dir, name string, members map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for member, content := range members {
+		w, err := zw.Create(member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, dir, name, buf.String())
+}
+
+func TestDiff_ZipMemberOperand(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeZip(t, dir, "a.zip", map[string]string{"inner/file.txt": "one\ntwo\n"})
+	other := writeFile(t, dir, "file.txt", "one\nthree\n")
+
+	stdout, _, err := execute(command.Diff(archive+"//inner/file.txt", other), "")
+	assertDiffers(t, err)
+	want := "2c2\n< two\n---\n> three\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_ZipAgainstZip(t *testing.T) {
+	dir := t.TempDir()
+	a := writeZip(t, dir, "a.zip", map[string]string{
+		"same.txt":    "x\n",
+		"changed.txt": "old\n",
+		"left.txt":    "l\n",
+	})
+	b := writeZip(t, dir, "b.zip", map[string]string{
+		"same.txt":    "x\n",
+		"changed.txt": "new\n",
+		"right.txt":   "r\n",
+	})
+
+	stdout, _, err := execute(command.Diff(a, b, command.Zip, command.Brief), "")
+	assertDiffers(t, err)
+	want := "Files " + a + "//changed.txt and " + b + "//changed.txt differ\n" +
+		"Only in " + a + ": left.txt\n" +
+		"Only in " + b + ": right.txt\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_ZipAgainstExtractedTree(t *testing.T) {
+	dir := t.TempDir()
+	members := map[string]string{"top.txt": "a\n", "sub/nested.txt": "b\nc\n"}
+	archive := writeZip(t, dir, "a.zip", members)
+
+	tree := filepath.Join(dir, "tree")
+	for name, content := range members {
+		path := filepath.Join(tree, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Dir(path), filepath.Base(path), content)
+	}
+
+	stdout, _, err := execute(command.Diff(archive, tree, command.Zip), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("expected no differences, got %q", stdout)
+	}
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"fmt"
+	"io"
+)
+
+// defaultBinaryCheck is GNU diff's heuristic: any NUL byte in the first
+// buffer read makes an input binary
+var defaultBinaryCheck = BinaryCheck{SampleBytes: 8192, MaxNULs: 0, MaxNonText: 1}
+
+// binaryCheck is the heuristic in effect
+func (f flags) binaryCheck() BinaryCheck {
+	if f.Binary != nil {
+		return *f.Binary
+	}
+	return defaultBinaryCheck
+}
+
+// classify reports whether data, the start of an input, is binary under
+// the check, and how many of its bytes were examined
+func (c BinaryCheck) classify(data []byte) (bool, int) {
+	if len(data) > c.SampleBytes {
+		data = data[:c.SampleBytes]
+	}
+	nuls, control := 0, 0
+	for _, b := range data {
+		switch {
+		case b == 0:
+			nuls++
+			control++
+		case b < ' ' && b != '\t' && b != '\n' && b != '\v' && b != '\f' && b != '\r' && b != '\b' && b != 0x1b, b == 0x7f:
+			control++
+		}
+	}
+	if nuls > c.MaxNULs {
+		return true, len(data)
+	}
+	return len(data) > 0 && float64(control)/float64(len(data)) > c.MaxNonText, len(data)
+}
+
+// detectBinary classifies the input name from its first bytes in data,
+// logging the decision, unless Text makes every input text
+func (p command) detectBinary(name string, data []byte) bool {
+	if bool(p.Flags.Text) {
+		return false
+	}
+	binary, sampled := p.Flags.binaryCheck().classify(data)
+	if p.Flags.Logger != nil {
+		p.Flags.Logger.Debug("binary check", "name", name, "sampled", sampled, "binary", binary)
+	}
+	return binary
+}
+
+// recordBinary remembers whether the input name read into buf is binary
+func (p command) recordBinary(buf *lineBuffer, name string) {
+	if p.Flags.binary == nil {
+		return
+	}
+	p.Flags.binary[name] = p.detectBinary(name, buf.data.Bytes())
+}
+
+// binaryPair reports whether either input of a comparison was taken for
+// binary when it was read
+func (f flags) binaryPair(name1, name2 string) bool {
+	return f.binary[name1] || f.binary[name2]
+}
+
+// writeBinaryDiffer reports differing inputs of which one is binary the
+// way GNU diff does, in place of their hunks
+func (p command) writeBinaryDiffer(w io.Writer, file1Path, file2Path string) {
+	_, _ = fmt.Fprintf(w, "Binary files %s and %s differ\n", p.displayPath(file1Path), p.displayPath(file2Path))
+}
@@ -0,0 +1,123 @@
+package command_test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_Binary(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\x00y\n")
+	b := writeFile(t, dir, "b", "x\x00z\n")
+	c := writeFile(t, dir, "c", "x\x00y\n")
+
+	// Read whole and streamed, a binary input is only reported as differing
+	for _, format := range []any{command.NoUnified, command.Unified} {
+		stdout, _, err := execute(command.Diff(a, b, format), "")
+		assertDiffers(t, err)
+		if want := "Binary files " + a + " and " + b + " differ\n"; stdout != want {
+			t.Errorf("got %q, want %q", stdout, want)
+		}
+		stdout, _, err = execute(command.Diff(a, c, format), "")
+		assertion.NoError(t, err)
+		if stdout != "" {
+			t.Errorf("identical binary files print nothing, got %q", stdout)
+		}
+	}
+
+	stdout, _, err := execute(command.Diff(a, b, command.Brief), "")
+	assertDiffers(t, err)
+	if stdout != "Files "+a+" and "+b+" differ\n" {
+		t.Errorf("got %q", stdout)
+	}
+
+	// Text compares the lines whatever the heuristic says
+	for _, opts := range [][]any{{a, b, command.Text}, {a, b, command.BinaryHeuristic(1, 0, 0), command.Text}} {
+		stdout, _, err = execute(command.Diff(opts...), "")
+		assertDiffers(t, err)
+		if stdout != "1c1\n< x\x00y\n---\n> x\x00z\n" {
+			t.Errorf("got %q", stdout)
+		}
+	}
+}
+
+func TestDiff_BinaryHeuristic(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		check   command.BinaryCheck
+		binary  bool
+	}{
+		{"NUL inside the sample", strings.Repeat("a", 15) + "\x00", command.BinaryHeuristic(16, 0, 1), true},
+		{"NUL past the sample", strings.Repeat("a", 16) + "\x00", command.BinaryHeuristic(16, 0, 1), false},
+		{"NULs at the limit", "a\x00b\x00c\n", command.BinaryHeuristic(64, 2, 1), false},
+		{"NULs over the limit", "a\x00b\x00c\x00\n", command.BinaryHeuristic(64, 2, 1), true},
+		{"control bytes at the limit", "\x01bcdefghi\n", command.BinaryHeuristic(10, 100, 0.1), false},
+		{"control bytes over the limit", "\x01\x02cdefghi\n", command.BinaryHeuristic(10, 100, 0.1), true},
+		{"white space and escapes are text", "\t\v\f\r\b\x1b[1m\n", command.BinaryHeuristic(64, 0, 0), false},
+		{"mainframe export", "REC001\x1cFIELD\x1dVALUE\x1e\n", command.BinaryHeuristic(64, 0, 0.2), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", tt.content)
+			b := writeFile(t, dir, "b", tt.content+"more\n")
+			stdout, _, err := execute(command.Diff(a, b, tt.check), "")
+			assertDiffers(t, err)
+			if binary := strings.HasPrefix(stdout, "Binary files"); binary != tt.binary {
+				t.Errorf("got %q, want binary %v", stdout, tt.binary)
+			}
+		})
+	}
+
+	// A sample of mostly control bytes is binary without any NUL
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "\x1c\x1d\x1e\n")
+	b := writeFile(t, dir, "b", "\x1c\x1d\n")
+	stdout, _, _ := execute(command.Diff(a, b, command.BinaryHeuristic(64, 0, 0.5)), "")
+	if !strings.HasPrefix(stdout, "Binary files") {
+		t.Errorf("got %q", stdout)
+	}
+}
+
+func TestDiff_BinaryLogged(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", strings.Repeat("a", 100)+"\n")
+	b := writeFile(t, dir, "b", "\x00\n")
+
+	var log recorder
+	_, _, err := execute(command.Diff(a, b, command.BinaryHeuristic(32, 0, 1), command.Logger(slog.New(&log))), "")
+	assertDiffers(t, err)
+	checks := log.events("binary check")
+	if len(checks) != 2 {
+		t.Fatalf("got %v", checks)
+	}
+	for _, check := range checks {
+		want := map[string]struct {
+			sampled int64
+			binary  bool
+		}{a: {32, false}, b: {2, true}}[check["name"].String()]
+		if check["sampled"].Int64() != want.sampled || check["binary"].Bool() != want.binary {
+			t.Errorf("unexpected binary check %v", check)
+		}
+	}
+}
+
+func TestDiff_BinaryInDirectory(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	writeFile(t, dir1, "data.bin", "\x00\x01")
+	writeFile(t, dir2, "data.bin", "\x00\x02")
+
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Unified), "")
+	assertDiffers(t, err)
+	// The binary pair has no banner, unlike the text pairs
+	want := "Binary files " + filepath.Join(dir1, "data.bin") + " and " + filepath.Join(dir2, "data.bin") + " differ\n"
+	if !strings.Contains(stdout, want) || strings.Contains(stdout, "data.bin\n") || !strings.Contains(stdout, "-nested old\n") {
+		t.Errorf("got:\n%s", stdout)
+	}
+}
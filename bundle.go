@@ -0,0 +1,138 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// devNull is the name a patch gives the missing side of a file it creates
+// or deletes
+const devNull = "/dev/null"
+
+// bundleName is the name of path below root in a PatchBundle, with the
+// a/ or b/ prefix of its side
+func bundleName(prefix, root, path string) string {
+	return prefix + cacheKey(root, path)
+}
+
+// bundlePair adds the section of a differing file pair to the PatchBundle
+func (p command) bundlePair(state *walkState, path1, path2 string, lines1, lines2 []string) {
+	if p.Flags.Bundle == nil {
+		return
+	}
+	name1, name2 := bundleName("a/", state.roots[0], path1), bundleName("b/", state.roots[1], path2)
+	p.writeBundleSection(p.Flags.Bundle.Writer, gitHeader(name1, name2), name1, name2, lines1, lines2)
+}
+
+// gitHeader is the "diff --git" line that starts a section of a
+// PatchBundle, so patch and git apply read a section without hunks, such
+// as the creation of an empty file, as one of its own
+func gitHeader(name1, name2 string) string {
+	return fmt.Sprintf("diff --git %s %s\n", name1, name2)
+}
+
+// gitMode is the mode git records for a regular file
+func gitMode(mode fs.FileMode) string {
+	if mode&0o111 != 0 {
+		return "100755"
+	}
+	return "100644"
+}
+
+// patchHeaderName is a name as a ---/+++ header line gives it. One containing
+// white space is followed by a tab, as git and GNU diff follow it, or patch
+// would take what follows the space for a timestamp.
+func patchHeaderName(name string) string {
+	if strings.ContainsAny(name, " \t") {
+		return name + "\t"
+	}
+	return name
+}
+
+// bundleOnlyIn adds the files below an entry found on one side only to the
+// PatchBundle, each compared with an empty file: deleted when the entry is
+// in the first tree, created when it is in the second
+func (p command) bundleOnlyIn(stderr io.Writer, path string, left bool, state *walkState) {
+	if p.Flags.Bundle == nil {
+		return
+	}
+	source := state.tree(left)
+	err := source.walk(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			p.notice(stderr, "%s: %v", file, err)
+			state.fail(file, err)
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		buf := &state.right
+		if left {
+			buf = &state.left
+		}
+		lines, err := source.readLines(p, buf, file)
+		if err != nil {
+			p.notice(stderr, "%s: %v", file, err)
+			state.fail(file, err)
+			return nil
+		}
+		mode := "100644"
+		if info, err := entry.Info(); err == nil {
+			mode = gitMode(info.Mode())
+		}
+		if left {
+			name := bundleName("a/", state.roots[0], file)
+			p.Flags.open = p.Flags.openEnds(file, devNull)
+			header := gitHeader(name, "b/"+name[len("a/"):]) + "deleted file mode " + mode + "\n"
+			p.writeBundleSection(p.Flags.Bundle.Writer, header, name, devNull, lines, nil)
+		} else {
+			name := bundleName("b/", state.roots[1], file)
+			p.Flags.open = p.Flags.openEnds(devNull, file)
+			header := gitHeader("a/"+name[len("b/"):], name) + "new file mode " + mode + "\n"
+			if !p.writeBundleSection(p.Flags.Bundle.Writer, header, devNull, name, nil, lines) {
+				// An empty file is created by its header alone
+				_, _ = io.WriteString(p.Flags.Bundle.Writer, header)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		state.fail(path, err)
+	}
+}
+
+// writeBundleSection writes the patch turning lines1 into lines2 under the
+// given header names, after the extended header lines of git, and reports
+// whether it wrote anything: there is no section without hunks. Hunks are
+// plain unified hunks with the configured context, without the decorations
+// of unified output.
+func (p command) writeBundleSection(w io.Writer, extended, name1, name2 string, lines1, lines2 []string) bool {
+	script := p.compare(lines1, lines2)
+	before, after := p.Flags.unifiedContext()
+	if bool(p.Flags.FullContext) || p.Flags.UnifiedContext < 0 {
+		before, after = len(script), len(script)
+	}
+	hunks := p.hunks(script, lines1, lines2, before, after)
+	if len(hunks) == 0 {
+		return false
+	}
+
+	_, _ = fmt.Fprintf(w, "%s--- %s\n+++ %s\n", extended, patchHeaderName(name1), patchHeaderName(name2))
+	for _, h := range hunks {
+		_, _ = fmt.Fprintf(w, "@@ -%s +%s @@\n", unifiedRange(h.startA, h.countA), unifiedRange(h.startB, h.countB))
+		for _, e := range h.edits {
+			switch e.kind {
+			case opEqual:
+				_, _ = fmt.Fprintf(w, " %s\n", lines1[e.a])
+			case opDelete:
+				_, _ = fmt.Fprintf(w, "-%s\n", lines1[e.a])
+			case opInsert:
+				_, _ = fmt.Fprintf(w, "+%s\n", lines2[e.b])
+			}
+			p.writeNoNewline(w, e, len(lines1), len(lines2))
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package command_test
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// bundleTrees builds two trees that differ by changed, created and deleted
+// files, at the top level and in subdirectories found on one side only
+func bundleTrees(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	tree1 := writeTree(t, filepath.Join(root, "tree1"), map[string]string{
+		"same.txt":         "same\n",
+		"changed.txt":      numberedLines(20, map[int]string{4: "old 4", 17: "old 17"}),
+		"deleted.txt":      "gone\nfor good\n",
+		"sub/nested.txt":   "nested old\n",
+		"sp ace.txt":       "spaced old\n",
+		"old/dir/file.txt": "whole directory removed\n",
+	})
+	tree2 := writeTree(t, filepath.Join(root, "tree2"), map[string]string{
+		"same.txt":         "same\n",
+		"changed.txt":      numberedLines(20, map[int]string{4: "new 4", 17: "new 17"}),
+		"created.txt":      "brand new\n",
+		"sub/nested.txt":   "nested new\n",
+		"sp ace.txt":       "spaced new\n",
+		"new/dir/file.txt": "whole directory added\n",
+		"new/top.txt":      "added too\n",
+	})
+	return tree1, tree2
+}
+
+// copyTree copies the files below dir into a new directory
+func copyTree(t *testing.T, dir string) string {
+	t.Helper()
+	copied := t.TempDir()
+	for name, content := range readTreeLines(t, dir, "") {
+		writeTree(t, copied, map[string]string{name: strings.Join(content, "\n") + "\n"})
+	}
+	return copied
+}
+
+// readTreeLines reads every file below dir, keyed by prefix and its
+// slash-separated relative path
+func readTreeLines(t *testing.T, dir, prefix string) map[string][]string {
+	t.Helper()
+	files := make(map[string][]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[prefix+filepath.ToSlash(rel)] = splitLines(string(data))
+		return nil
+	})
+	assertion.NoError(t, err)
+	return files
+}
+
+func TestDiff_PatchBundleRoundTrip(t *testing.T) {
+	tree1, tree2 := bundleTrees(t)
+
+	var bundle bytes.Buffer
+	stdout, _, err := execute(command.Diff(tree1, tree2, command.Recursive, command.PatchBundle(&bundle)), "")
+	assertDiffers(t, err)
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		if !strings.HasPrefix(line, "Files ") && !strings.HasPrefix(line, "Only in ") {
+			t.Errorf("stdout should hold only the summary, got %q", line)
+		}
+	}
+	for _, header := range []string{
+		"diff --git a/changed.txt b/changed.txt\n--- a/changed.txt\n+++ b/changed.txt\n",
+		"diff --git a/created.txt b/created.txt\nnew file mode 100644\n--- /dev/null\n+++ b/created.txt\n@@ -0,0 +1 @@\n+brand new\n",
+		"diff --git a/deleted.txt b/deleted.txt\ndeleted file mode 100644\n--- a/deleted.txt\n+++ /dev/null\n@@ -1,2 +0,0 @@\n",
+		"--- a/sp ace.txt\t\n+++ b/sp ace.txt\t\n@@ -1 +1 @@\n",
+		"--- /dev/null\n+++ b/new/dir/file.txt\n",
+		"--- a/old/dir/file.txt\n+++ /dev/null\n",
+		"--- a/sub/nested.txt\n+++ b/sub/nested.txt\n",
+	} {
+		if !strings.Contains(bundle.String(), header) {
+			t.Errorf("bundle lacks %q:\n%s", header, bundle.String())
+		}
+	}
+
+	// Apply the bundle to a copy of tree1 the way patch -p1 would
+	patched, err := command.ApplyFiles(readTreeLines(t, tree1, "a/"), &bundle)
+	assertion.NoError(t, err)
+	copied := copyTree(t, tree1)
+	for name, lines := range patched {
+		path := filepath.Join(copied, filepath.FromSlash(name[len("a/"):]))
+		if lines == nil {
+			assertion.NoError(t, os.Remove(path))
+			continue
+		}
+		writeTree(t, copied, map[string]string{name[len("a/"):]: strings.Join(lines, "\n") + "\n"})
+	}
+	for _, dir := range []string{"old/dir", "old"} {
+		assertion.NoError(t, os.Remove(filepath.Join(copied, dir)))
+	}
+
+	stdout, _, err = execute(command.Diff(copied, tree2, command.Recursive), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("patched copy differs from tree2:\n%s", stdout)
+	}
+}
+
+func TestDiff_PatchBundleAppliesWithTools(t *testing.T) {
+	tree1, tree2 := bundleTrees(t)
+	writeTree(t, tree2, map[string]string{"empty.txt": "", "new/dir/empty.txt": ""})
+
+	var bundle bytes.Buffer
+	_, _, err := execute(command.Diff(tree1, tree2, command.Recursive, command.PatchBundle(&bundle)), "")
+	assertDiffers(t, err)
+	if !strings.Contains(bundle.String(), "diff --git a/empty.txt b/empty.txt\nnew file mode 100644\ndiff --git ") {
+		t.Errorf("empty file is not created by its header:\n%s", bundle.String())
+	}
+
+	for name, args := range map[string][]string{
+		"patch":     {"patch", "-p1", "--quiet", "--force", "--fuzz=0"},
+		"git apply": {"git", "apply"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := exec.LookPath(args[0]); err != nil {
+				t.Skip(err)
+			}
+			copied := copyTree(t, tree1)
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Dir = copied
+			// Outside any repository, so git applies to the directory
+			cmd.Env = append(os.Environ(), "GIT_CEILING_DIRECTORIES="+filepath.Dir(copied))
+			cmd.Stdin = bytes.NewReader(bundle.Bytes())
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%s failed: %v\n%s", name, err, output)
+			}
+			for _, dir := range []string{"old/dir", "old"} {
+				_ = os.Remove(filepath.Join(copied, dir))
+			}
+
+			stdout, _, err := execute(command.Diff(copied, tree2, command.Recursive), "")
+			assertion.NoError(t, err)
+			if stdout != "" {
+				t.Errorf("patched copy differs from tree2:\n%s", stdout)
+			}
+		})
+	}
+}
+
+func TestDiff_PatchBundleRequiresDirectories(t *testing.T) {
+	var bundle bytes.Buffer
+	_, stderr, err := execute(command.Diff("a", "b", command.PatchBundle(&bundle)), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "PatchBundle requires Recursive") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\n")
+	_, stderr, err = execute(command.Diff(a, dir, command.Recursive, command.PatchBundle(&bundle)), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "PatchBundle requires two directories") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
@@ -0,0 +1,124 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// fileSignature identifies a file's content by its size and modification
+// time. Files rewritten within the filesystem's timestamp granularity
+// without a size change keep their signature, which is the caveat of
+// CacheFile.
+type fileSignature struct {
+	Size    int64
+	ModTime int64
+}
+
+// cacheEntry is the recorded verdict for one file pair
+type cacheEntry struct {
+	Left, Right fileSignature
+	Identical   bool
+}
+
+// cacheContents is the JSON stored in a CacheFile
+type cacheContents struct {
+	Fingerprint string
+	Pairs       map[string]cacheEntry
+}
+
+// pairCache remembers the verdicts of a directory comparison between runs.
+// Verdicts are looked up in the previous run's entries and every pair seen
+// in this run is recorded afresh, so entries for removed files drop out.
+type pairCache struct {
+	path        string
+	fingerprint string
+	previous    map[string]cacheEntry
+	current     map[string]cacheEntry
+}
+
+// comparisonFingerprint covers every flag that can change whether two files
+// compare equal; a cache written under other flags is discarded
+func (p command) comparisonFingerprint() string {
+	csvColumns := ""
+	if p.Flags.CSVIgnore != nil {
+		csvColumns = strconv.Quote(p.Flags.CSVIgnore.Delimiter) + p.Flags.CSVIgnore.columnList()
+	}
+	return fmt.Sprintf("v1 case=%t whitespace=%t ansi=%t volatile=%s json=%t csv=%s focus=%q ignore=%q all=%t blank=%t maxbytes=%d raw=%t gnu=%t eol=%t",
+		bool(p.Flags.IgnoreCase), bool(p.Flags.IgnoreWhitespace), bool(p.Flags.IgnoreANSI), p.Flags.Volatile.names(), bool(p.Flags.CanonicalJSON),
+		csvColumns, p.Flags.Focus, p.Flags.IgnorePatterns, bool(p.Flags.IgnoreMatchAll), bool(p.Flags.IgnoreBlankLines), p.Flags.MaxCompareBytes, bool(p.Flags.RawBytes), bool(p.Flags.GNUCompat), bool(p.Flags.PreserveEOL))
+}
+
+// loadPairCache reads the CacheFile. A missing, unreadable or outdated cache
+// starts empty, as does every cache under NoCache.
+func (p command) loadPairCache() *pairCache {
+	cache := &pairCache{
+		path:        string(p.Flags.CacheFile),
+		fingerprint: p.comparisonFingerprint(),
+		current:     make(map[string]cacheEntry),
+	}
+	if bool(p.Flags.NoCache) {
+		return cache
+	}
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		return cache
+	}
+	var contents cacheContents
+	if json.Unmarshal(data, &contents) == nil && contents.Fingerprint == cache.fingerprint {
+		cache.previous = contents.Pairs
+	}
+	return cache
+}
+
+// signatures stats both files of a pair in the trees of the walk
+func (s *walkState) signatures(path1, path2 string) (fileSignature, fileSignature, error) {
+	sig1, err := s.trees[0].signature(path1)
+	if err != nil {
+		return fileSignature{}, fileSignature{}, err
+	}
+	sig2, err := s.trees[1].signature(path2)
+	if err != nil {
+		return fileSignature{}, fileSignature{}, err
+	}
+	return sig1, sig2, nil
+}
+
+// lookup returns the previous verdict for a pair whose files still have the
+// recorded signatures
+func (c *pairCache) lookup(key string, left, right fileSignature) (identical, ok bool) {
+	entry, ok := c.previous[key]
+	if !ok || entry.Left != left || entry.Right != right {
+		return false, false
+	}
+	return entry.Identical, true
+}
+
+// record stores the verdict for a pair
+func (c *pairCache) record(key string, left, right fileSignature, identical bool) {
+	c.current[key] = cacheEntry{Left: left, Right: right, Identical: identical}
+}
+
+// save writes the verdicts recorded in this run to the CacheFile
+func (c *pairCache) save() error {
+	data, err := json.Marshal(cacheContents{Fingerprint: c.fingerprint, Pairs: c.current})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// cacheKey names a pair by its path relative to the compared directories,
+// or by its full path without a root
+func cacheKey(root, path string) string {
+	if root == "" {
+		return filepath.ToSlash(path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
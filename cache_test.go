@@ -0,0 +1,101 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	command "github.com/yupsh/diff"
+)
+
+// cachedRun compares the fixture trees with a CacheFile and returns stdout
+// and the files read in full
+func cachedRun(t *testing.T, dir1, dir2, cache string, opts ...any) (string, []string) {
+	t.Helper()
+	var opened []string
+	params := []any{dir1, dir2, command.Recursive, command.Brief, command.CacheFile(cache),
+		command.OpenHook(func(path string) { opened = append(opened, path) })}
+	stdout, _, _ := execute(command.Diff(append(params, opts...)...), "")
+	return stdout, opened
+}
+
+func TestDiff_CacheFileSkipsUnchangedPairs(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	cache := filepath.Join(t.TempDir(), "diff.cache")
+
+	first, opened := cachedRun(t, dir1, dir2, cache)
+	if len(opened) != 6 {
+		t.Errorf("read %d files, want 6: %v", len(opened), opened)
+	}
+
+	second, opened := cachedRun(t, dir1, dir2, cache)
+	if len(opened) != 0 {
+		t.Errorf("read %d files, want 0: %v", len(opened), opened)
+	}
+	if second != first {
+		t.Errorf("cached run printed:\n%s\nwant:\n%s", second, first)
+	}
+}
+
+func TestDiff_CacheFileTouchedFileIsCompared(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	cache := filepath.Join(t.TempDir(), "diff.cache")
+	cachedRun(t, dir1, dir2, cache)
+
+	touched := filepath.Join(dir1, "same.txt")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(touched, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	_, opened := cachedRun(t, dir1, dir2, cache)
+	if want := []string{touched, filepath.Join(dir2, "same.txt")}; !slices.Equal(opened, want) {
+		t.Errorf("read %v, want %v", opened, want)
+	}
+
+	_, opened = cachedRun(t, dir1, dir2, cache)
+	if len(opened) != 0 {
+		t.Errorf("read %d files, want 0: %v", len(opened), opened)
+	}
+}
+
+func TestDiff_CacheFileInvalidatedByFlags(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	cache := filepath.Join(t.TempDir(), "diff.cache")
+	cachedRun(t, dir1, dir2, cache)
+
+	_, opened := cachedRun(t, dir1, dir2, cache, command.IgnoreCase)
+	if len(opened) != 6 {
+		t.Errorf("read %d files, want 6: %v", len(opened), opened)
+	}
+}
+
+func TestDiff_NoCacheComparesEveryPair(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	cache := filepath.Join(t.TempDir(), "diff.cache")
+	cachedRun(t, dir1, dir2, cache)
+
+	_, opened := cachedRun(t, dir1, dir2, cache, command.NoCache)
+	if len(opened) != 6 {
+		t.Errorf("read %d files, want 6: %v", len(opened), opened)
+	}
+}
+
+func TestDiff_CacheFileFullDiffReadsChangedPairs(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	cache := filepath.Join(t.TempDir(), "diff.cache")
+	cachedRun(t, dir1, dir2, cache)
+
+	var opened []string
+	stdout, _, _ := execute(command.Diff(dir1, dir2, command.Recursive, command.CacheFile(cache),
+		command.OpenHook(func(path string) { opened = append(opened, path) })), "")
+	if len(opened) != 4 {
+		t.Errorf("read %d files, want 4: %v", len(opened), opened)
+	}
+	if !strings.Contains(stdout, "< old") {
+		t.Errorf("changed pair missing from output:\n%s", stdout)
+	}
+}
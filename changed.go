@@ -0,0 +1,69 @@
+package command
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// signature reports the size and modification time of the regular file at
+// path, through FileStat when it is set
+func (p command) signature(path string) (fileSignature, bool) {
+	stat := os.Stat
+	if p.Flags.FileStat != nil {
+		stat = p.Flags.FileStat
+	}
+	info, err := stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return fileSignature{}, false
+	}
+	return fileSignature{Size: info.Size(), ModTime: info.ModTime().UnixNano()}, true
+}
+
+// watch takes the signature of the file at path and returns a func that
+// reports whether the signature has changed since. Anything that is not a
+// regular file, such as a pipe or an archive member, never changes.
+func (p command) watch(path string) func() bool {
+	before, ok := p.signature(path)
+	return func() bool {
+		if !ok {
+			return false
+		}
+		after, _ := p.signature(path)
+		return after != before
+	}
+}
+
+// watchesInput reports whether one side of a two-operand comparison is a
+// file checked for changes while it is read, rather than stdin, Expected or
+// a ReaderOperand
+func (p command) watchesInput(side int, path string) bool {
+	return !p.readsStdin(side, path) && !p.supplied(side)
+}
+
+// readConsistent reads the file at path with read, and reads it again up
+// to RetryOnChange times while it changes during a read. A file still
+// changing returns the lines of its last read with ErrChangedWhileRead.
+func (p command) readConsistent(path string, read func() ([]string, error)) ([]string, error) {
+	for attempt := 0; ; attempt++ {
+		changed := p.watch(path)
+		lines, err := read()
+		if err != nil || !changed() {
+			return lines, err
+		}
+		if attempt >= int(p.Flags.RetryOnChange) {
+			return lines, ErrChangedWhileRead
+		}
+	}
+}
+
+// changedTrouble warns that path changed while it was read when err is
+// ErrChangedWhileRead, and returns the trouble that makes of the
+// comparison; any other err is left to the caller and returns nil
+func (p command) changedTrouble(stderr io.Writer, path string, err error) error {
+	if !errors.Is(err, ErrChangedWhileRead) {
+		return nil
+	}
+	p.notice(stderr, "warning: %s changed while being read", path)
+	return &FileError{Path: path, Err: err}
+}
@@ -0,0 +1,126 @@
+package command_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// growingStat is a FileStat that appends a line to the file at target each
+// time it is stated after a read, up to times times, as a log being written
+// to while diff reads it. calls counts the stats of target.
+func growingStat(t *testing.T, target string, times int, calls *int) command.FileStat {
+	t.Helper()
+	return func(path string) (os.FileInfo, error) {
+		if path == target {
+			*calls++
+			if *calls%2 == 0 && *calls/2 <= times {
+				file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+				assertion.NoError(t, err)
+				_, err = file.WriteString("appended\n")
+				assertion.NoError(t, err)
+				assertion.NoError(t, file.Close())
+			}
+		}
+		return os.Stat(path)
+	}
+}
+
+func TestDiff_RetryOnChange(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.log", "one\n")
+	b := writeFile(t, dir, "b.log", "one\nappended\n")
+
+	// The file grows during the first read only, and the retry reads it whole
+	calls := 0
+	stdout, stderr, err := execute(command.Diff(a, b, command.RetryOnChange(1), growingStat(t, a, 1, &calls)), "")
+	assertion.NoError(t, err)
+	if stdout != "" || stderr != "" || calls != 4 {
+		t.Errorf("got stdout %q, stderr %q after %d stats", stdout, stderr, calls)
+	}
+
+	// A file that keeps growing is compared as last read
+	writeFile(t, dir, "a.log", "one\n")
+	calls = 0
+	stdout, stderr, err = execute(command.Diff(a, b, command.RetryOnChange(2), growingStat(t, a, 10, &calls)), "")
+	if !errors.Is(err, command.ErrChangedWhileRead) || calls != 6 {
+		t.Fatalf("got %v after %d stats", err, calls)
+	}
+	if want := "diff: warning: " + a + " changed while being read\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+	if stdout != "3d2\n< appended\n" {
+		t.Errorf("got stdout %q", stdout)
+	}
+}
+
+func TestDiff_ChangedWhileRead(t *testing.T) {
+	opts := map[string][]any{
+		"normal":   nil,
+		"streamed": {command.Unified},
+	}
+	for name, opt := range opts {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a.log", "one\n")
+			b := writeFile(t, dir, "b.log", "two\n")
+
+			calls := 0
+			stdout, stderr, err := execute(command.Diff(append([]any{a, b, growingStat(t, b, 1, &calls)}, opt...)...), "")
+			var fileErr *command.FileError
+			if !errors.As(err, &fileErr) || fileErr.Path != b || !errors.Is(err, command.ErrChangedWhileRead) {
+				t.Fatalf("a change while reading is trouble, got %v", err)
+			}
+			if !strings.Contains(stderr, "diff: warning: "+b+" changed while being read") {
+				t.Errorf("got stderr %q", stderr)
+			}
+			if !strings.Contains(stdout, "two") {
+				t.Errorf("the lines read are still compared:\n%s", stdout)
+			}
+		})
+	}
+
+	// Inputs that are not files are never checked
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\n")
+	stat := command.FileStat(func(path string) (os.FileInfo, error) {
+		if path == "-" {
+			t.Error("stdin was stated")
+		}
+		return os.Stat(path)
+	})
+	_, _, err := execute(command.Diff(a, "-", stat, command.Unified), "x\n")
+	if errors.Is(err, command.ErrChangedWhileRead) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestDiff_ChangedWhileReadInDirectory(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	calls := 0
+	target := filepath.Join(dir1, "same.txt")
+	stdout, stderr, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, growingStat(t, target, 1, &calls)), "")
+	if !errors.Is(err, command.ErrChangedWhileRead) {
+		t.Fatalf("got %v", err)
+	}
+	if want := "diff: warning: " + target + " changed while being read\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+	// The rest of the tree is still compared
+	if !strings.Contains(stdout, "nested.txt") {
+		t.Errorf("got:\n%s", stdout)
+	}
+}
+
+func TestDiff_RetryOnChangeInvalid(t *testing.T) {
+	_, stderr, err := execute(command.Diff("a", "b", command.RetryOnChange(-1)), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "non-negative number of retries") {
+		t.Errorf("got stderr %q", stderr)
+	}
+}
@@ -0,0 +1,246 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// chunkSyncLines is the shortest run of equal lines Chunked cuts at. The run
+// is lengthened to keep a full context on both sides of the cut.
+const chunkSyncLines = 8
+
+// maxSyncCandidates bounds the positions tried for each line when searching
+// for a synchronization point, so repeated lines cannot make it quadratic
+const maxSyncCandidates = 64
+
+// chunkSide is the buffered window of one side of a Chunked comparison
+type chunkSide struct {
+	scanner *bufio.Scanner
+	lines   []string
+	base    int
+	eof     bool
+
+	// open is whether the last line read has no terminator
+	open bool
+
+	// strip removes escape sequences from every line read, for StripANSI
+	strip bool
+}
+
+// fill reads lines until the window holds window lines and one more, so
+// that a window that is all of the rest of the input is known to be, or
+// until the input ends
+func (s *chunkSide) fill(window int) error {
+	for !s.eof && len(s.lines) <= window {
+		if !s.scanner.Scan() {
+			s.eof = true
+			return s.scanner.Err()
+		}
+		s.lines = append(s.lines, s.scanner.Text())
+		if s.strip {
+			s.lines[len(s.lines)-1] = stripANSI(s.lines[len(s.lines)-1])
+		}
+	}
+	return nil
+}
+
+// replaced is how many of the buffered lines a window of window lines
+// replaces whole: all of the window, but never the last line of the input
+func (s *chunkSide) replaced(window int) int {
+	if s.eof {
+		return max(len(s.lines)-1, 0)
+	}
+	return min(len(s.lines), window)
+}
+
+// drop discards the first n buffered lines
+func (s *chunkSide) drop(n int) {
+	kept := copy(s.lines, s.lines[n:])
+	clear(s.lines[kept:])
+	s.lines = s.lines[:kept]
+	s.base += n
+}
+
+// diffChunked prints the unified diff of two files read a window at a time.
+// Equal stretches are skipped down to the context they lend a hunk. After a
+// difference the windows are cut inside the nearest run of sync equal lines,
+// and the part before the cut is compared and printed on its own; the run is
+// long enough that no hunk spans a cut. Without such a run the windows are
+// replaced whole by a hunk without context, the lines after it being unread,
+// and the hunk that follows keeps no more trailing context than it has
+// before it, so that patch(1) anchors neither hunk at an end of the file.
+func (p command) diffChunked(stdin io.Reader, stdout, stderr io.Writer, file1Path, file2Path string) error {
+	file1, err := p.openInput(stdin, 0, file1Path)
+	if err != nil {
+		return fileTrouble(stderr, file1Path, err)
+	}
+	defer file1.Close()
+
+	file2, err := p.openInput(stdin, 1, file2Path)
+	if err != nil {
+		return fileTrouble(stderr, file2Path, err)
+	}
+	defer file2.Close()
+
+	if bool(p.Flags.Reverse) {
+		file1Path, file2Path = file2Path, file1Path
+		file1, file2 = file2, file1
+	}
+	side1 := &chunkSide{strip: bool(p.Flags.StripANSI)}
+	side2 := &chunkSide{strip: bool(p.Flags.StripANSI)}
+	side1.scanner = p.endScanner(file1, &side1.open)
+	side2.scanner = p.endScanner(file2, &side2.open)
+
+	before, after := p.Flags.unifiedContext()
+	sync := max(chunkSyncLines, before+after+int(p.Flags.InterHunkContext)+1)
+	window := max(int(p.Flags.Chunked), 2*sync)
+
+	// A window whose search settled is noted once the pair is done
+	headers, differ, settled := false, false, false
+	defer func() { p.noteNotMinimal(file1Path, file2Path, settled) }()
+	compare := func(lines1, lines2 []string) []edit {
+		script, s := p.compareSettled(lines1, lines2)
+		settled = settled || s
+		return script
+	}
+	coarse := false
+	emit := func(n1, n2 int, script []edit, context bool) {
+		p.Flags.sequencer.beginOutput()
+		defer p.Flags.sequencer.endOutput()
+		b, a := before, after
+		if !context {
+			b, a = 0, 0
+		}
+		for i, h := range buildHunks(script, b, a, int(p.Flags.InterHunkContext), nil) {
+			if i == 0 && coarse {
+				h = balanceContext(h)
+			}
+			differ = true
+			if !headers && !bool(p.Flags.NoHeader) {
+				fmt.Fprintf(stdout, "--- %s\n", p.header(0, file1Path))
+				fmt.Fprintf(stdout, "+++ %s\n", p.header(1, file2Path))
+				headers = true
+			}
+			p.writeUnifiedHunk(stdout, h, file2Path, side1.lines, side2.lines, side1.base, side2.base, lineNumberWidth(0))
+		}
+		coarse = !context
+		side1.drop(n1)
+		side2.drop(n2)
+	}
+
+	for {
+		if err := side1.fill(window); err != nil {
+			return fileTrouble(stderr, file1Path, err)
+		}
+		if err := side2.fill(window); err != nil {
+			return fileTrouble(stderr, file2Path, err)
+		}
+		if p.Flags.ChunkProbe != nil {
+			p.Flags.ChunkProbe(len(side1.lines) + len(side2.lines))
+		}
+		done := side1.eof && side2.eof
+
+		// Once both ends are read a last line terminated on one side only
+		// is kept with its context, to be compared as a change
+		keep := before
+		ends := [2]bool{side1.open, side2.open}
+		if done && p.Flags.endsDiffer(ends) {
+			keep = before + 1
+		}
+		prefix := 0
+		for prefix < len(side1.lines) && prefix < len(side2.lines) && p.linesEqual(side1.lines[prefix], side2.lines[prefix]) {
+			prefix++
+		}
+		switch {
+		case prefix == len(side1.lines) && prefix == len(side2.lines) && done && !p.Flags.endsDiffer(ends):
+			return verdict(differ)
+		case prefix > keep:
+			// Only the last lines of an equal stretch can be context
+			side1.drop(prefix - keep)
+			side2.drop(prefix - keep)
+		case done:
+			p.Flags.open = ends
+			emit(len(side1.lines), len(side2.lines), compare(side1.lines, side2.lines), true)
+			return verdict(differ)
+		default:
+			if i, j, ok := p.syncPoint(side1.lines, side2.lines, prefix, sync); ok {
+				n1, n2 := i+after, j+after
+				emit(n1, n2, compare(side1.lines[:n1], side2.lines[:n2]), true)
+				continue
+			}
+			p.notice(stderr, "%s: no %d equal lines within %d lines of line %d; replacing them whole",
+				p.displayPath(file1Path), sync, window, side1.base+prefix+1)
+			// The line read past a window stays for the next one, where
+			// it may turn out to be the last, and so does the last line of
+			// a side already read to the end: nothing can follow a line
+			// without a terminator in a patch
+			n1, n2 := side1.replaced(window), side2.replaced(window)
+			emit(n1, n2, replaceScript(min(prefix, n1, n2), n1, n2), false)
+		}
+	}
+}
+
+// syncPoint finds where lines1 and lines2 both continue with n equal lines,
+// at or after from, preferring the pair of positions earliest in sum
+func (p command) syncPoint(lines1, lines2 []string, from, n int) (int, int, bool) {
+	positions := make(map[string][]int)
+	for j := from; j+n <= len(lines2); j++ {
+		key := p.compareKey(lines2[j])
+		if len(positions[key]) < maxSyncCandidates {
+			positions[key] = append(positions[key], j)
+		}
+	}
+
+	best1, best2, found := 0, 0, false
+	for i := from; i+n <= len(lines1); i++ {
+		if found && i+from >= best1+best2 {
+			break
+		}
+		for _, j := range positions[p.compareKey(lines1[i])] {
+			if found && i+j >= best1+best2 {
+				break
+			}
+			k := 0
+			for k < n && p.linesEqual(lines1[i+k], lines2[j+k]) {
+				k++
+			}
+			if k == n {
+				best1, best2, found = i, j, true
+				break
+			}
+		}
+	}
+	return best1, best2, found
+}
+
+// balanceContext drops the trailing context of h beyond its leading context
+func balanceContext(h hunk) hunk {
+	leading, trailing := 0, 0
+	for leading < len(h.edits) && h.edits[leading].kind == opEqual {
+		leading++
+	}
+	for trailing < len(h.edits) && h.edits[len(h.edits)-1-trailing].kind == opEqual {
+		trailing++
+	}
+	if trailing <= leading {
+		return h
+	}
+	return newHunk(h.edits[:len(h.edits)-(trailing-leading)])
+}
+
+// replaceScript keeps the first prefix lines and replaces the rest of len1
+// lines with all len2 remaining lines
+func replaceScript(prefix, len1, len2 int) []edit {
+	script := make([]edit, 0, len1+len2-prefix)
+	for k := range prefix {
+		script = append(script, edit{kind: opEqual, a: k, b: k})
+	}
+	for a := prefix; a < len1; a++ {
+		script = append(script, edit{kind: opDelete, a: a, b: prefix})
+	}
+	for b := prefix; b < len2; b++ {
+		script = append(script, edit{kind: opInsert, a: len1, b: b})
+	}
+	return script
+}
@@ -0,0 +1,263 @@
+package command_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// writeGenerated writes n generated lines, changing every line whose number
+// is a multiple of every when every is positive
+func writeGenerated(t *testing.T, path string, n, every int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if every > 0 && i%every == 0 {
+			fmt.Fprintf(&b, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+		if b.Len() > 1<<16 || i == n {
+			if _, err := f.WriteString(b.String()); err != nil {
+				t.Fatal(err)
+			}
+			b.Reset()
+		}
+	}
+}
+
+// applyPatch applies a unified diff to a file and compares the result with
+// the expected file
+func applyPatch(t *testing.T, original, patched, patch string) {
+	t.Helper()
+	read := func(path string) []string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return splitLines(string(data))
+	}
+	got, err := command.Apply(read(original), patch)
+	assertion.NoError(t, err)
+	want := read(patched)
+	if len(got) != len(want) {
+		t.Fatalf("patched file has %d lines, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("line %d: got %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+// applyStrictly checks a unified diff the way patch(1) reads it, then
+// applies it as applyPatch does. patch takes a hunk with less trailing than
+// leading context to end the file, and one with less leading than trailing
+// context to start it, so such a hunk anywhere else does not apply. Where
+// patch is installed the diff must also turn original into patched with it,
+// without fuzz.
+func applyStrictly(t *testing.T, original, patched, patch string) {
+	t.Helper()
+	data, err := os.ReadFile(original)
+	assertion.NoError(t, err)
+	lines := len(splitLines(string(data)))
+	diffs, err := command.Parse(strings.NewReader(patch))
+	assertion.NoError(t, err)
+	for _, diff := range diffs {
+		for _, h := range diff.Hunks {
+			leading, trailing := 0, 0
+			for leading < len(h.Ops) && h.Ops[leading].Kind == command.OpEqual {
+				leading++
+			}
+			for trailing < len(h.Ops) && h.Ops[len(h.Ops)-1-trailing].Kind == command.OpEqual {
+				trailing++
+			}
+			last := h.OldStart + h.OldLines - 1
+			if h.OldLines == 0 {
+				last = h.OldStart
+			}
+			if trailing < leading && last != lines {
+				t.Fatalf("hunk at line %d has %d lines of leading context but %d trailing, and does not end the file", h.OldStart, leading, trailing)
+			}
+			if leading < trailing && h.OldStart > 1 {
+				t.Fatalf("hunk at line %d has %d lines of leading context but %d trailing, and does not start the file", h.OldStart, leading, trailing)
+			}
+		}
+	}
+	applyPatch(t, original, patched, patch)
+
+	if _, err := exec.LookPath("patch"); err != nil {
+		return
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	cmd := exec.Command("patch", "--quiet", "--force", "--fuzz=0", "--output="+out, original)
+	cmd.Stdin = strings.NewReader(patch)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("patch failed: %v\n%s", err, output)
+	}
+	got, err := os.ReadFile(out)
+	assertion.NoError(t, err)
+	want, err := os.ReadFile(patched)
+	assertion.NoError(t, err)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("patch made %q, want %q", got, want)
+	}
+}
+
+func TestDiff_ChunkedMatchesWholeFileDiff(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeGenerated(t, a, 20_000, 0)
+	writeGenerated(t, b, 20_000, 997)
+
+	whole, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	chunked, _, err := execute(command.Diff(a, b, command.Unified, command.Chunked(100)), "")
+	assertDiffers(t, err)
+	if chunked != whole {
+		t.Errorf("chunked output differs from the whole-file diff:\n%s", chunked)
+	}
+	applyPatch(t, a, b, chunked)
+
+	reversed, _, _ := execute(command.Diff(a, b, command.Unified, command.Chunked(100), command.Reverse), "")
+	applyPatch(t, b, a, reversed)
+}
+
+func TestDiff_ChunkedInsertionsAndDeletions(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(500, nil))
+	var b strings.Builder
+	for i := 1; i <= 500; i++ {
+		switch {
+		case i%50 == 0:
+			// dropped
+		case i%70 == 0:
+			fmt.Fprintf(&b, "line %d\nextra after %d\nmore after %d\n", i, i, i)
+		default:
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+	}
+	bPath := writeFile(t, dir, "b", b.String())
+
+	stdout, _, err := execute(command.Diff(a, bPath, command.Unified, command.Chunked(40)), "")
+	assertDiffers(t, err)
+	applyPatch(t, a, bPath, stdout)
+
+	identical, _, err := execute(command.Diff(a, a, command.Unified, command.Chunked(40)), "")
+	assertion.NoError(t, err)
+	if identical != "" {
+		t.Errorf("identical files printed %q", identical)
+	}
+}
+
+func TestDiff_ChunkedWithoutSyncPoint(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(100, nil))
+	var b strings.Builder
+	for i := 1; i <= 100; i++ {
+		if i <= 3 || i > 80 {
+			fmt.Fprintf(&b, "line %d\n", i)
+		} else {
+			fmt.Fprintf(&b, "other %d\n", i)
+		}
+	}
+	bPath := writeFile(t, dir, "b", b.String())
+
+	stdout, stderr, err := execute(command.Diff(a, bPath, command.Unified, command.Chunked(20)), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stderr, "replacing them whole") {
+		t.Errorf("expected a notice about the coarse replacement, got %q", stderr)
+	}
+	applyStrictly(t, a, bPath, stdout)
+
+	// A replacement reaching the end of an unterminated file keeps the
+	// marker, and so the missing newline
+	short := writeFile(t, dir, "short", strings.TrimSuffix(strings.ReplaceAll(numberedLines(11, nil), "line", "other"), "\n"))
+	long := writeFile(t, dir, "long", numberedLines(16, nil))
+	for _, pair := range [][2]string{{long, short}, {short, long}} {
+		stdout, _, err := execute(command.Diff(pair[0], pair[1], command.Unified, command.Chunked(14)), "")
+		assertDiffers(t, err)
+		original, _ := os.ReadFile(pair[0])
+		want, _ := os.ReadFile(pair[1])
+		got, err := command.ApplyBytes(original, stdout)
+		assertion.NoError(t, err)
+		if string(got) != string(want) {
+			t.Errorf("patch turns %q into %q, want %q:\n%s", original, got, want, stdout)
+		}
+		applyStrictly(t, pair[0], pair[1], stdout)
+	}
+}
+
+func TestDiff_ChunkedRandomPatchesApply(t *testing.T) {
+	dir := t.TempDir()
+	rng := rand.New(rand.NewPCG(476, 1))
+	random := func() string {
+		var b strings.Builder
+		for range rng.IntN(60) {
+			fmt.Fprintf(&b, "%c\n", 'a'+rng.IntN(4))
+		}
+		if b.Len() > 0 && rng.IntN(3) == 0 {
+			return strings.TrimSuffix(b.String(), "\n")
+		}
+		return b.String()
+	}
+
+	// Few runs of equal lines, so most windows are replaced whole
+	for i := range 200 {
+		a := writeFile(t, dir, "a", random())
+		b := writeFile(t, dir, "b", random())
+		stdout, _, _ := execute(command.Diff(a, b, command.Unified, command.Chunked(16+i%8)), "")
+		if t.Run(fmt.Sprint(i), func(t *testing.T) { applyStrictly(t, a, b, stdout) }) {
+			continue
+		}
+		data1, _ := os.ReadFile(a)
+		data2, _ := os.ReadFile(b)
+		t.Fatalf("%q against %q:\n%s", data1, data2, stdout)
+	}
+}
+
+func TestDiff_ChunkedUnsupportedOptions(t *testing.T) {
+	_, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Chunked(10)), "")
+	assertion.Error(t, err)
+	for _, opt := range []any{command.Brief, command.FullContext, command.ShowIndex} {
+		_, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.Chunked(10), opt), "")
+		assertion.Error(t, err)
+	}
+}
+
+func TestDiff_ChunkedLargeFilesBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates two 2M-line files")
+	}
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeGenerated(t, a, 2_000_000, 0)
+	writeGenerated(t, b, 2_000_000, 4999)
+
+	const window = 256
+	peak := 0
+	probe := command.ChunkProbe(func(buffered int) { peak = max(peak, buffered) })
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.Chunked(window), probe), "")
+	assertDiffers(t, err)
+	// Each side reads one line past its window
+	if peak > 2*(window+1) {
+		t.Errorf("buffered %d lines, want at most %d", peak, 2*(window+1))
+	}
+	if hunks := strings.Count(stdout, "\n@@ "); hunks != 2_000_000/4999 {
+		t.Errorf("got %d hunks, want one per change", hunks)
+	}
+	applyPatch(t, a, b, stdout)
+}
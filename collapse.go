@@ -0,0 +1,56 @@
+package command
+
+import "fmt"
+
+// foldRange is a run of items [start, end) hidden behind one fold marker
+type foldRange struct {
+	start, end int
+}
+
+// unchangedFolds finds the runs of more than keep unchanged items among total
+// items and returns the part of each run to fold away. keep/2 items stay
+// visible on each side of a fold that has changes on that side; runs at the
+// start or end of the items keep context only towards the changes.
+func unchangedFolds(keep, total int, unchanged func(i int) bool) []foldRange {
+	var folds []foldRange
+	for i := 0; i < total; {
+		if !unchanged(i) {
+			i++
+			continue
+		}
+		end := i
+		for end < total && unchanged(end) {
+			end++
+		}
+		if end-i > keep {
+			start, stop := i, end
+			if i > 0 {
+				start += keep / 2
+			}
+			if end < total {
+				stop -= keep / 2
+			}
+			if start < stop {
+				folds = append(folds, foldRange{start: start, end: stop})
+			}
+		}
+		i = end
+	}
+	return folds
+}
+
+// foldMarker is the line shown in place of count folded unchanged lines
+func foldMarker(count int) string {
+	if count == 1 {
+		return "… 1 unchanged line …"
+	}
+	return fmt.Sprintf("… %d unchanged lines …", count)
+}
+
+// folds returns the folds among total items when CollapseUnchanged is set
+func (p command) folds(total int, unchanged func(i int) bool) []foldRange {
+	if p.Flags.Collapse == nil {
+		return nil
+	}
+	return unchangedFolds(int(*p.Flags.Collapse), total, unchanged)
+}
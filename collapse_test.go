@@ -0,0 +1,58 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_CollapseUnchangedFullContext(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(40, nil))
+	b := writeFile(t, dir, "b", numberedLines(40, map[int]string{2: "changed 2", 30: "changed 30"}))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.FullContext, command.CollapseUnchanged(4)), "")
+	assertDiffers(t, err)
+	want := "--- " + a + "\n+++ " + b + "\n@@ -1,40 +1,40 @@\n" +
+		" line 1\n-line 2\n+changed 2\n line 3\n line 4\n" +
+		"… 23 unchanged lines …\n" +
+		" line 28\n line 29\n-line 30\n+changed 30\n line 31\n line 32\n" +
+		"… 8 unchanged lines …\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_CollapseUnchangedLeadingRun(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(10, nil))
+	b := writeFile(t, dir, "b", numberedLines(10, map[int]string{9: "changed 9"}))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.FullContext, command.CollapseUnchanged(2)), "")
+	assertDiffers(t, err)
+	body := strings.SplitN(stdout, "@@\n", 2)[1]
+	// The leading run keeps one line next to the change; the single
+	// trailing line is not more than the limit and stays
+	if want := "… 7 unchanged lines …\n line 8\n-line 9\n+changed 9\n line 10\n"; body != want {
+		t.Errorf("got:\n%s\nwant:\n%s", body, want)
+	}
+
+	stdout, _, err = execute(command.Diff(a, b, command.Unified, command.FullContext), "")
+	assertDiffers(t, err)
+	if strings.Contains(stdout, "unchanged line") {
+		t.Errorf("folded without CollapseUnchanged:\n%s", stdout)
+	}
+}
+
+func TestDiff_CollapseUnchangedSideBySide(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "a\nb\nc\nd\ne\nf\n")
+	b := writeFile(t, dir, "b", "A\nb\nc\nd\ne\nf\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.SideBySide, command.Width(30), command.CollapseUnchanged(0)), "")
+	assertDiffers(t, err)
+	if want := "a             |  A\n… 5 unchanged lines …\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
@@ -0,0 +1,113 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SGR sequences used by the colored renderers
+const (
+	sgrReset   = "\x1b[0m"
+	sgrBold    = "\x1b[1m"
+	sgrRed     = "\x1b[31m"
+	sgrGreen   = "\x1b[32m"
+	sgrYellow  = "\x1b[33m"
+	sgrMagenta = "\x1b[35m"
+	sgrCyan    = "\x1b[36m"
+)
+
+// useColor decides whether output written to w should be colored. ColorAuto
+// colors only terminals, and never when NO_COLOR is set or TERM is dumb.
+func useColor(mode ColorMode, w io.Writer) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+			return false
+		}
+		return isTerminal(w)
+	default:
+		return false
+	}
+}
+
+// isTerminal reports whether w is a character device such as a terminal,
+// looking through the stdout side of an outputSequencer
+func isTerminal(w io.Writer) bool {
+	if s, ok := w.(sequencedWriter); ok && !s.diagnostic {
+		w = s.s.out
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in the given SGR sequence when color is enabled
+func (p command) paint(sgr, s string) string {
+	if !p.Flags.color {
+		return s
+	}
+	return sgr + s + sgrReset
+}
+
+// linkPlaceholder matches the placeholders of a Hyperlinks template
+var linkPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validate checks that a Hyperlinks template names the file, uses only
+// known placeholders and is a URI with a scheme
+func (h Hyperlinks) validate() error {
+	template := string(h)
+	if !strings.Contains(template, "{path}") {
+		return fmt.Errorf("hyperlink template %q has no {path}", template)
+	}
+	for _, placeholder := range linkPlaceholder.FindAllString(template, -1) {
+		if placeholder != "{path}" && placeholder != "{line}" {
+			return fmt.Errorf("hyperlink template %q has unknown placeholder %s", template, placeholder)
+		}
+	}
+	uri, err := url.Parse(h.expand("/x", 1))
+	if err != nil || uri.Scheme == "" {
+		return fmt.Errorf("hyperlink template %q is not a URI with a scheme", template)
+	}
+	return nil
+}
+
+// expand fills in the template for an absolute slash-separated path
+func (h Hyperlinks) expand(path string, line int) string {
+	escaped := (&url.URL{Path: path}).EscapedPath()
+	return strings.NewReplacer("{path}", escaped, "{line}", strconv.Itoa(line)).Replace(string(h))
+}
+
+// hyperlink wraps text in an OSC 8 hyperlink to line of the file at path.
+// Links follow the color decision, so piped output never carries them, and
+// operands that are not files on disk are never linked.
+func (p command) hyperlink(path string, line int, text string) string {
+	if !p.Flags.color || p.Flags.Hyperlinks == "" || path == "-" || path == expectedLabel {
+		return text
+	}
+	if _, err := os.Stat(path); err != nil {
+		return text
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return text
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasPrefix(abs, "/") {
+		abs = "/" + abs
+	}
+	return "\x1b]8;;" + p.Flags.Hyperlinks.expand(abs, line) + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
@@ -0,0 +1,66 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// osc8 wraps text in an OSC 8 hyperlink to uri
+func osc8(uri, text string) string {
+	return "\x1b]8;;" + uri + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+func TestDiff_Hyperlinks(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "old file", numberedLines(30, map[int]string{3: "changed 3"}))
+	b := writeFile(t, dir, "new file", numberedLines(30, map[int]string{20: "changed 20"}))
+	absB, err := filepath.Abs(b)
+	assertion.NoError(t, err)
+	uriB := "file://" + strings.ReplaceAll(filepath.ToSlash(absB), " ", "%20")
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.ColorAlways, command.Hyperlinks(command.FileLinks)), "")
+	assertDiffers(t, err)
+	for _, want := range []string{
+		"+++ " + osc8(uriB+"#1", b) + "\n",
+		osc8(uriB+"#1", "@@ -1,6 +1,6 @@") + "\n",
+		osc8(uriB+"#17", "@@ -17,7 +17,7 @@") + "\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%q", want, stdout)
+		}
+	}
+
+	stdout, _, _ = execute(command.Diff(a, b, command.Unified, command.ColorAlways, command.Hyperlinks("vscode://file{path}:{line}")), "")
+	if want := osc8("vscode://file"+strings.ReplaceAll(filepath.ToSlash(absB), " ", "%20")+":17", "@@ -17,7 +17,7 @@"); !strings.Contains(stdout, want) {
+		t.Errorf("missing %q in:\n%q", want, stdout)
+	}
+
+	// Without color, as when piped, there are no escape sequences at all
+	stdout, _, _ = execute(command.Diff(a, b, command.Unified, command.Hyperlinks(command.FileLinks)), "")
+	if strings.Contains(stdout, "\x1b") {
+		t.Errorf("uncolored output carries escapes: %q", stdout)
+	}
+}
+
+func TestDiff_HyperlinksBanner(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	abs, err := filepath.Abs(filepath.Join(dir1, "changed.txt"))
+	assertion.NoError(t, err)
+
+	stdout, _, _ := execute(command.Diff(dir1, dir2, command.ColorAlways, command.Hyperlinks(command.FileLinks)), "")
+	if want := "diff " + osc8("file://"+filepath.ToSlash(abs)+"#1", dir1+string(os.PathSeparator)+"changed.txt") + " "; !strings.HasPrefix(stdout, want) {
+		t.Errorf("got %q, want prefix %q", stdout, want)
+	}
+}
+
+func TestDiff_HyperlinksInvalidTemplate(t *testing.T) {
+	for _, template := range []string{"file://x#{line}", "file://{path}#{column}", "{path}"} {
+		_, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Hyperlinks(template)), "")
+		assertion.Error(t, err)
+	}
+}
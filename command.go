@@ -2,10 +2,15 @@ package command
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	gloo "github.com/gloo-foo/framework"
@@ -13,174 +18,1007 @@ import (
 
 type command gloo.Inputs[string, flags]
 
+// maxTees bounds the number of TeeFormat sinks a single run may register
+const maxTees = 4
+
+// expectedLabel names the inline Expected content in headers and messages
+const expectedLabel = "expected"
+
+// Flags is the configuration of a comparison for Go callers that build it
+// as a struct rather than as option values. Each field holds what the
+// option of the same type sets, and a zero field takes the option's
+// default; ContextLinesSet and UnifiedContextSet mark a zero context size
+// as given.
+type Flags = flags
+
+// Diff builds the command from its operands and options. It is built as
+// New builds it from the Flags the options set, and an invalid
+// configuration, or a parameter that is neither, is reported when it runs.
 func Diff(parameters ...any) gloo.Command {
-	cmd := command(gloo.Initialize[string, flags](parameters...))
-	if cmd.Flags.UnifiedContext == 0 && bool(cmd.Flags.Unified) {
+	return newCommand(parameters...)
+}
+
+// New builds the command comparing the two files or directories under f;
+// it is the typed counterpart of Diff. An invalid configuration is
+// returned here rather than when the command runs.
+func New(files [2]string, f Flags) (gloo.Command, error) {
+	cmd := build(files[:], f)
+	if cmd.Flags.err != nil {
+		return nil, cmd.Flags.err
+	}
+	return cmd, nil
+}
+
+// Operand is one side of a command built by NewWithOptions: a path, which
+// names a file or directory as the operands of Diff do, or a reader
+type Operand struct {
+	name   string
+	reader io.Reader
+}
+
+// PathOperand is the file or directory at path, or standard input for "-"
+func PathOperand(path string) Operand {
+	return Operand{name: path}
+}
+
+// ReaderOperand is the input read from r, shown as name in headers. It is
+// read once, never checked for changes, and never taken for a directory.
+func ReaderOperand(name string, r io.Reader) Operand {
+	return Operand{name: name, reader: r}
+}
+
+// NewWithOptions is New for operands that need not be paths
+func NewWithOptions(a, b Operand, f Flags) (gloo.Command, error) {
+	if a.reader != nil && bool(f.UpdateFirst) {
+		return nil, fmt.Errorf("UpdateFirst needs a file to update, not the reader %s", a.name)
+	}
+	f.readers = [2]io.Reader{a.reader, b.reader}
+	return New([2]string{a.name, b.name}, f)
+}
+
+// newCommand initializes the flags from parameters and builds the command
+// from them as New does
+func newCommand(parameters ...any) command {
+	inputs := gloo.Initialize[string, flags](parameters...)
+	cmd := build(inputs.Positional, inputs.Flags)
+	if cmd.Flags.err == nil && len(inputs.Ambiguous) > 0 {
+		cmd.Flags.err = fmt.Errorf("unknown parameter %v of type %T", inputs.Ambiguous[0], inputs.Ambiguous[0])
+	}
+	return cmd
+}
+
+// build is the command comparing the operands under f, prepared
+func build(operands []string, f flags) command {
+	return prepare(command{Positional: operands, Flags: f})
+}
+
+// prepare applies the defaults and checks and compiles the options shared
+// by the command and Run
+func prepare(cmd command) command {
+	// Context sizes default even when the format is only used by a TeeFormat
+	// sink, and only a zero given as such asks for no context
+	if cmd.Flags.UnifiedContext == 0 && !cmd.Flags.UnifiedContextSet {
 		cmd.Flags.UnifiedContext = 3
 	}
-	if cmd.Flags.ContextLines == 0 && bool(cmd.Flags.ContextDiff) {
+	if cmd.Flags.ContextLines == 0 && !cmd.Flags.ContextLinesSet {
 		cmd.Flags.ContextLines = 3
 	}
+	if cmd.Flags.Width <= 0 {
+		cmd.Flags.Width = 130
+	}
+	if cmd.Flags.MoveMinLines <= 0 {
+		cmd.Flags.MoveMinLines = defaultMoveMinLines
+	}
+
+	// Options are checked and compiled once here; a failure is reported
+	// when the command runs
+	if cmd.Flags.err = cmd.Flags.Validate(); cmd.Flags.err != nil {
+		return cmd
+	}
+	cmd.Flags.volatile = cmd.Flags.Volatile.rules()
+	cmd.Flags.hunkFilters, cmd.Flags.err = compileHunkFilters(cmd.Flags.HunkFilters)
+	if cmd.Flags.err == nil && cmd.Flags.SectionLabel != nil {
+		cmd.Flags.section, cmd.Flags.err = compileSectionLabel(*cmd.Flags.SectionLabel)
+	}
+	if cmd.Flags.err == nil {
+		cmd.Flags.ignorePatterns, cmd.Flags.err = compileIgnorePatterns(cmd.Flags.IgnorePatterns)
+	}
+	if cmd.Flags.err == nil && cmd.Flags.Focus != "" {
+		cmd.Flags.focus, cmd.Flags.err = compileFocus(cmd.Flags.Focus)
+	}
+	if cmd.Flags.err == nil && cmd.Flags.Template != "" {
+		cmd.Flags.template, cmd.Flags.err = parseTemplate(string(cmd.Flags.Template))
+	}
 	return cmd
 }
 
 func (p command) Executor() gloo.CommandExecutor {
-	return func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
-		// Need two file paths to compare
-		if len(p.Positional) < 2 {
+	return func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+		// Work on a copy so per-run state never leaks between executions
+		p := p
+		if p.Flags.Logger != nil {
+			defer func() { p.logError(err) }()
+		}
+		// Registered first, so held diagnostics follow the end of the
+		// encoded line
+		stdout, stderr = p.sequence(stdout, stderr)
+		if p.Flags.sequencer != nil {
+			defer p.Flags.sequencer.finish(p.Flags.Logger)
+		}
+		if bool(p.Flags.Discard) {
+			stdout = io.Discard
+		}
+		if p.Flags.EncodedOutput != EncodeNone {
+			encoder := newLineEncoder(stdout, p.Flags.EncodedOutput)
+			defer func() { _ = encoder.Close() }()
+			stdout = encoder
+		}
+		p.Flags.color = useColor(p.Flags.Color, stdout)
+		if bool(p.Flags.ShowIndex) {
+			p.Flags.digests = make(map[string]string)
+		}
+		if bool(p.Flags.CanonicalJSON) {
+			p.Flags.canonical = make(map[string]bool)
+		}
+		p.Flags.stderr = stderr
+		if bool(p.Flags.FocusKeepNumbers) {
+			p.Flags.focusIndex = make(map[string][]int)
+		}
+		if bool(p.Flags.JSONDetail) {
+			p.Flags.lineRanges = make(map[string][]ByteRange)
+		}
+		p.Flags.unterminated = make(map[string]bool)
+		p.Flags.binary = make(map[string]bool)
+		if p.Flags.OutputPrefix != "" {
+			stdout = newPrefixWriter(stdout, string(p.Flags.OutputPrefix))
+		}
+
+		if p.Flags.err != nil {
+			_, _ = fmt.Fprintf(stderr, "diff: %v\n", p.Flags.err)
+			return p.Flags.err
+		}
+
+		if p.Flags.Commands != nil {
+			return p.compareCommands(ctx, stdout, stderr)
+		}
+		if p.Flags.Pairs != "" {
+			return p.comparePairs(stdout, stderr)
+		}
+
+		p.Positional, p.Flags.literalFrom = splitEndOfOptions(p.Positional)
+
+		// Need two file paths to compare, or one when Expected supplies the second
+		operands := 2
+		if p.Flags.Expected != nil {
+			operands = 1
+		}
+		if len(p.Positional) < operands {
 			_, _ = fmt.Fprintf(stderr, "diff: missing operand after '%s'\n", strings.Join(p.Positional, " "))
 			return fmt.Errorf("diff requires two files to compare")
 		}
+		if len(p.Positional) > operands {
+			_, _ = fmt.Fprintf(stderr, "diff: extra operand '%s'\n", p.Positional[operands])
+			return fmt.Errorf("diff compares exactly two files")
+		}
+		if err := ctx.Err(); err != nil {
+			_, _ = fmt.Fprintf(stderr, "diff: %v\n", err)
+			return err
+		}
+
+		// Both sides reading stdin read one captured copy of it
+		if p.stdinReads(p.Positional) > 1 {
+			buffer := newStdinBuffer(stdin, p.Flags.stdinLimit())
+			defer func() { _ = buffer.Close() }()
+			p.Flags.stdin = buffer
+		}
 
 		file1Path := p.Positional[0]
-		file2Path := p.Positional[1]
+		file2Path := expectedLabel
+		if p.Flags.Expected == nil {
+			file2Path = p.Positional[1]
+		}
 
-		// Read both files
-		lines1, err := readFileLines(file1Path)
-		if err != nil {
-			_, _ = fmt.Fprintf(stderr, "diff: %s: %v\n", file1Path, err)
-			return err
+		if p.Flags.Bundle != nil && (p.supplied(0) || p.supplied(1) || !isDirectory(file1Path) || !isDirectory(file2Path)) {
+			_, _ = fmt.Fprintf(stderr, "diff: PatchBundle requires two directories\n")
+			return fmt.Errorf("PatchBundle requires two directories")
 		}
 
-		lines2, err := readFileLines(file2Path)
-		if err != nil {
-			_, _ = fmt.Fprintf(stderr, "diff: %s: %v\n", file2Path, err)
+		if p.Flags.AssumeUnchanged != "" && (isDirectory(file1Path) || isDirectory(file2Path)) {
+			_, _ = fmt.Fprintf(stderr, "diff: AssumeUnchanged requires two files\n")
+			return fmt.Errorf("AssumeUnchanged requires two files")
+		}
+
+		// First-difference mode streams both files and stops at the first mismatch
+		if bool(p.Flags.FirstDifference) {
+			return p.reportFirstDifference(stdin, stdout, stderr, file1Path, file2Path)
+		}
+
+		if !p.supplied(0) && !p.supplied(1) {
+			// Zip mode compares archive members instead of the archives themselves
+			if bool(p.Flags.Zip) && (isZipArchive(file1Path) || isZipArchive(file2Path)) {
+				if bool(p.Flags.Reverse) {
+					return p.compareArchives(stdout, stderr, file2Path, file1Path)
+				}
+				return p.compareArchives(stdout, stderr, file1Path, file2Path)
+			}
+
+			// Directory operands compare their entries; a file compared against a
+			// directory is compared against the file of the same name inside it
+			dir1, dir2 := isDirectory(file1Path), isDirectory(file2Path)
+			switch {
+			case dir1 && dir2:
+				if bool(p.Flags.UpdateFirst) && !bool(p.Flags.UpdateRecursive) {
+					_, _ = fmt.Fprintf(stderr, "diff: refusing to update directory contents without UpdateRecursive\n")
+					return fmt.Errorf("refusing to update directory contents without UpdateRecursive")
+				}
+				if bool(p.Flags.Reverse) {
+					return p.compareDirectories(stdout, stderr, file2Path, file1Path)
+				}
+				return p.compareDirectories(stdout, stderr, file1Path, file2Path)
+			case dir1:
+				file1Path = filepath.Join(file1Path, filepath.Base(file2Path))
+			case dir2:
+				file2Path = filepath.Join(file2Path, filepath.Base(file1Path))
+			}
+		}
+
+		// Update mode rewrites the first operand instead of printing a diff
+		if bool(p.Flags.UpdateFirst) {
+			return p.updateFirst(stdin, stdout, stderr, file1Path, file2Path)
+		}
+
+		// Chunked mode streams both files a window at a time
+		if p.Flags.Chunked > 0 {
+			return p.diffChunked(stdin, stdout, stderr, file1Path, file2Path)
+		}
+
+		// Normal and unified output can stream past the equal prefix
+		if p.Flags.streamsPrefix() {
+			return p.diffStreamed(stdin, stdout, stderr, file1Path, file2Path)
+		}
+
+		// Read both files. One that changed while it was read is still
+		// compared, and makes the comparison trouble.
+		lines1, err := p.readInputLines(stdin, 0, file1Path)
+		changed := p.changedTrouble(stderr, file1Path, err)
+		if err != nil && changed == nil {
+			return fileTrouble(stderr, file1Path, err)
+		}
+
+		lines2, err := p.readInputLines(stdin, 1, file2Path)
+		if trouble := p.changedTrouble(stderr, file2Path, err); trouble != nil {
+			changed = trouble
+		} else if err != nil {
+			return fileTrouble(stderr, file2Path, err)
+		}
+
+		// Operands are read in the order given, so stdin and Expected keep
+		// their sides, and only then swapped
+		if bool(p.Flags.Reverse) {
+			file1Path, file2Path = file2Path, file1Path
+			lines1, lines2 = lines2, lines1
+		}
+		if p.Flags.AssumeUnchanged != "" {
+			if err := p.verifyPatch(stdout, stderr, file1Path, file2Path, lines1, lines2); err != nil || changed == nil {
+				return err
+			}
+			return changed
+		}
+		err = p.diffLines(stdout, file1Path, file2Path, lines1, lines2)
+		if err != nil && !errors.Is(err, ErrFilesDiffer) {
+			_, _ = fmt.Fprintf(stderr, "diff: %v\n", err)
 			return err
 		}
+		if changed != nil {
+			return changed
+		}
+		return err
+	}
+}
 
-		// Check if files are identical
-		if areIdentical(lines1, lines2, bool(p.Flags.IgnoreCase), bool(p.Flags.IgnoreWhitespace)) {
-			// Files are identical, no output
-			return nil
+// diffLines compares two sets of lines and writes the result in the selected
+// format, plus every TeeFormat sink. The edit script is computed once and
+// shared by all formatters, and diagnostics wait for the output to be
+// written. It returns ErrFilesDiffer when the lines differ, or the error of
+// a failed template or TeeFormat sink.
+func (p command) diffLines(stdout io.Writer, file1Path, file2Path string, lines1, lines2 []string) error {
+	p.Flags.open = p.Flags.openEnds(file1Path, file2Path)
+	p.Flags.sequencer.beginOutput()
+	defer p.Flags.sequencer.endOutput()
+
+	// Templates render every comparison, identical or not
+	if p.Flags.template != nil {
+		if p.Flags.StatsHook != nil {
+			p.reportStats(file1Path, file2Path, lines1, lines2, p.compare(lines1, lines2))
+		}
+		if err := p.outputTemplate(stdout, file1Path, file2Path, lines1, lines2); err != nil {
+			return err
 		}
+		return verdict(!p.identical(lines1, lines2))
+	}
 
-		// Brief mode - just report that files differ
-		if bool(p.Flags.Brief) {
-			_, _ = fmt.Fprintf(stdout, "Files %s and %s differ\n", file1Path, file2Path)
-			return nil
+	// A cut-off comparison is announced so it is never taken for an exact one
+	if p.Flags.MaxCompareBytes > 0 && !p.structuredOutput() {
+		if longer := longerLines(lines1, int(p.Flags.MaxCompareBytes)) + longerLines(lines2, int(p.Flags.MaxCompareBytes)); longer > 0 {
+			_, _ = fmt.Fprintf(stdout, "Lines compared on their first %d bytes only: %d longer lines compared inexactly\n", p.Flags.MaxCompareBytes, longer)
 		}
+	}
+
+	// Check if files are identical
+	identical := p.identical(lines1, lines2)
+	var script []edit
+	if !identical && p.exceedsCount(lines1, lines2) {
+		p.Flags.overCount = true
+	} else if !identical || p.primaryFormat().showsUnchanged() || p.Flags.StatsHook != nil {
+		var settled bool
+		script, settled = p.compareSettled(lines1, lines2)
+		p.noteNotMinimal(file1Path, file2Path, settled)
+	}
+	p.reportStats(file1Path, file2Path, lines1, lines2, script)
+	p.logComparison(file1Path, file2Path, lines1, lines2, script, identical)
+	if identical {
+		p.writeIdentical(stdout, p.primaryFormat(), file1Path, file2Path, lines1, lines2, script)
+		return nil
+	}
 
-		// Perform diff and output
-		if bool(p.Flags.Unified) {
-			outputUnifiedDiff(stdout, file1Path, file2Path, lines1, lines2, int(p.Flags.UnifiedContext))
-		} else if bool(p.Flags.ContextDiff) {
-			outputContextDiff(stdout, file1Path, file2Path, lines1, lines2, int(p.Flags.ContextLines))
+	// Brief mode - just report that files differ
+	if bool(p.Flags.Brief) && !p.structuredOutput() {
+		if bool(p.Flags.WithSimilarity) {
+			_, _ = fmt.Fprintf(stdout, "Files %s and %s differ (%d%% of lines unchanged)\n", p.displayPath(file1Path), p.displayPath(file2Path), similarity(script, len(lines1), len(lines2)))
 		} else {
-			outputNormalDiff(stdout, lines1, lines2)
+			_, _ = fmt.Fprintf(stdout, "Files %s and %s differ\n", p.displayPath(file1Path), p.displayPath(file2Path))
+		}
+	} else if p.Flags.binaryPair(file1Path, file2Path) {
+		p.writeBinaryDiffer(stdout, file1Path, file2Path)
+	} else {
+		p.writeFormat(stdout, p.primaryFormat(), file1Path, file2Path, lines1, lines2, script)
+	}
+
+	for _, tee := range p.Flags.Tees {
+		w := &errWriter{w: tee.Writer}
+		p.writeFormat(w, tee.Format, file1Path, file2Path, lines1, lines2, script)
+		if w.err != nil {
+			return fmt.Errorf("%s output: %w", tee.Format, w.err)
 		}
+	}
 
-		return nil
+	return ErrFilesDiffer
+}
+
+// reportStats passes the statistics of the script comparing lines1 and
+// lines2 to StatsHook, counting hunks as unified output would
+func (p command) reportStats(file1Path, file2Path string, lines1, lines2 []string, script []edit) {
+	if p.Flags.StatsHook == nil {
+		return
+	}
+	before, after := p.Flags.unifiedContext()
+	hunks := p.hunks(script, lines1, lines2, before, after)
+	p.Flags.StatsHook(file1Path, file2Path, scriptStats(script, len(hunks)))
+}
+
+// writeIdentical renders a comparison of identical inputs in a single
+// format. There is no output beyond a zero count, an empty JSON result, or
+// the all-equal rendering of a format that shows unchanged lines, for which
+// script is required.
+func (p command) writeIdentical(w io.Writer, format Format, file1Path, file2Path string, lines1, lines2 []string, script []edit) {
+	switch {
+	case format == FormatCount || format == FormatEditDistance:
+		_, _ = fmt.Fprintln(w, 0)
+	case format.showsUnchanged() || format == FormatJSON:
+		p.writeFormat(w, format, file1Path, file2Path, lines1, lines2, script)
+	}
+}
+
+// primaryFormat is the format selected for stdout by the flags
+func (p command) primaryFormat() Format {
+	return p.Flags.primaryFormat()
+}
+
+// structuredOutput reports whether stdout carries a format that Brief does
+// not replace
+func (p command) structuredOutput() bool {
+	return p.Flags.structuredOutput()
+}
+
+// writeFormat renders a comparison in a single format
+func (p command) writeFormat(w io.Writer, format Format, file1Path, file2Path string, lines1, lines2 []string, script []edit) {
+	p.Flags.numbers = [2][]int{p.Flags.focusIndex[file1Path], p.Flags.focusIndex[file2Path]}
+	switch format {
+	case FormatUnified:
+		p.outputUnifiedDiff(w, file1Path, file2Path, lines1, lines2, script)
+	case FormatContext:
+		p.writeIndex(w, file1Path, file2Path)
+		if !bool(p.Flags.NoHeader) {
+			fmt.Fprintf(w, "*** %s\n", p.header(0, file1Path))
+			fmt.Fprintf(w, "--- %s\n", p.header(1, file2Path))
+		}
+		p.outputContextDiff(w, lines1, lines2, script)
+	case FormatCount:
+		_, _ = fmt.Fprintln(w, p.countChanges(script))
+	case FormatEditDistance:
+		_, _ = fmt.Fprintln(w, p.editDistance(lines1, lines2))
+	case FormatSideBySide:
+		p.outputSideBySide(w, lines1, lines2, script)
+	case FormatLineMap:
+		outputLineMap(w, script)
+	case FormatRawEvents:
+		outputRawEvents(w, lines1, lines2, script)
+	case FormatSideBySideTSV:
+		outputSideBySideTSV(w, lines1, lines2, script)
+	case FormatEd:
+		p.outputEdScript(w, file1Path, file2Path, lines1, lines2, script)
+	case FormatForwardEd:
+		p.outputForwardEdScript(w, file1Path, file2Path, lines1, lines2, script)
+	case FormatConflictMarkers:
+		outputConflictMarkers(w, p.displayPath(file1Path), p.displayPath(file2Path), lines1, lines2, script)
+	case FormatJSON:
+		p.outputJSON(w, file1Path, file2Path, lines1, lines2, script)
+	default:
+		p.outputNormalDiff(w, lines1, lines2, script)
+	}
+}
+
+// notice writes a non-fatal diagnostic to stderr unless Quiet is set, and
+// to Logger.
+// Fatal errors are always written directly and are not affected by Quiet.
+func (p command) notice(stderr io.Writer, format string, args ...any) {
+	if p.Flags.Logger != nil {
+		p.Flags.Logger.Warn("notice", "message", fmt.Sprintf(format, args...))
+	}
+	if bool(p.Flags.Quiet) {
+		return
+	}
+	_, _ = fmt.Fprintf(stderr, "diff: "+format+"\n", args...)
+}
+
+// openOperand opens a file operand. Operands of the form archive.zip//member
+// open a single member inside a zip archive.
+func openOperand(path string) (io.ReadCloser, error) {
+	if archive, member, ok := splitZipMember(path); ok {
+		return openZipMember(archive, member)
+	}
+	return os.Open(path)
+}
+
+// openInput opens one side of a two-operand comparison. The operand "-"
+// reads stdin unless it follows EndOfOptions, from its captured copy when
+// both sides read it, and the second side is the Expected content when it
+// is set.
+func (p command) openInput(stdin io.Reader, side int, path string) (io.ReadCloser, error) {
+	if side == 1 && p.Flags.Expected != nil {
+		return io.NopCloser(strings.NewReader(string(*p.Flags.Expected))), nil
+	}
+	if r := p.Flags.readers[side]; r != nil {
+		return io.NopCloser(r), nil
+	}
+	if p.readsStdin(side, path) {
+		if p.Flags.stdin != nil {
+			return p.Flags.stdin.open()
+		}
+		return io.NopCloser(stdin), nil
+	}
+	return openOperand(path)
+}
+
+// splitEndOfOptions removes the first EndOfOptions marker from the operands
+// and returns the index from which operands are literal file names
+func splitEndOfOptions(positional []string) ([]string, int) {
+	for i, arg := range positional {
+		if arg == EndOfOptions {
+			operands := append(append([]string{}, positional[:i]...), positional[i+1:]...)
+			return operands, i
+		}
+	}
+	return positional, len(positional)
+}
+
+// readInputLines reads all lines from one side of a two-operand comparison.
+// A file is checked for changes while it is read, as readFileLinesInto
+// checks it; stdin and Expected are not.
+func (p command) readInputLines(stdin io.Reader, side int, path string) ([]string, error) {
+	var buf lineBuffer
+	read := func() ([]string, error) {
+		file, err := p.openInput(stdin, side, path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return p.readNamed(&buf, path, file)
+	}
+	if !p.watchesInput(side, path) {
+		return read()
 	}
+	return p.readConsistent(path, read)
 }
 
 // readFileLines reads all lines from a file
-func readFileLines(path string) ([]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func (p command) readFileLines(path string) ([]string, error) {
+	var buf lineBuffer
+	return p.readFileLinesInto(&buf, path)
+}
+
+// readFileLinesInto reads all lines from a file using buf's storage,
+// reading it again under RetryOnChange when it changes while it is read
+func (p command) readFileLinesInto(buf *lineBuffer, path string) ([]string, error) {
+	if p.Flags.OpenHook != nil {
+		p.Flags.OpenHook(path)
 	}
-	defer file.Close()
+	return p.readConsistent(path, func() ([]string, error) {
+		file, err := openOperand(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return p.readNamed(buf, path, file)
+	})
+}
 
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+// readNamed reads all lines of the operand name from r using buf's storage,
+// remembering the hash of its bytes when ShowIndex is set. Under
+// CanonicalJSON the lines are those of the canonical form, and under
+// FocusLines only the matching lines are kept.
+func (p command) readNamed(buf *lineBuffer, name string, r io.Reader) ([]string, error) {
+	if _, err := buf.read(p, r); err != nil {
+		return nil, err
 	}
+	p.Flags.usage.recordRead(buf.data.Len(), buf)
+	if p.Flags.Logger != nil {
+		p.Flags.Logger.Debug("input read", "name", name, "bytes", buf.data.Len(), "lines", len(buf.lines))
+	}
+	p.finishRead(buf, name)
+	return buf.lines, nil
+}
+
+// finishRead prepares the lines of the operand name once they are in buf,
+// whatever they were read from: it takes the bytes for binary or not and
+// hashes them for ShowIndex, then replaces the lines by their canonical JSON
+// form, remembers where each line is for JSONDetail and whether the last one
+// is terminated, and keeps only those FocusLines matches
+func (p command) finishRead(buf *lineBuffer, name string) {
+	p.recordBinary(buf, name)
+	p.recordDigest(name, buf.data.Bytes())
+	p.canonicalize(buf, name)
+	p.recordLineRanges(buf, name)
+	p.recordTerminator(buf, name)
+	p.focusLines(buf, name)
+}
 
-	if err := scanner.Err(); err != nil {
+// readLines reads all lines from r. The input is read into a single buffer
+// and converted to one string, and every line is a substring of it, so a
+// file costs a handful of allocations however many lines it has. Lines are
+// split the way lineScanner splits them.
+func (p command) readLines(r io.Reader) ([]string, error) {
+	var buf lineBuffer
+	return buf.read(p, r)
+}
+
+// lineBuffer holds the storage for reading one file's lines. A sequential
+// walk keeps one per side and reuses it for every pair, so the read buffer
+// and line slice are allocated once rather than per file.
+type lineBuffer struct {
+	data  bytes.Buffer
+	lines []string
+}
+
+// read reads all lines of r into the buffer. The returned slice is
+// overwritten by the next read; the line strings themselves stay valid.
+func (b *lineBuffer) read(p command, r io.Reader) ([]string, error) {
+	b.data.Reset()
+	if f, ok := r.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			b.data.Grow(int(info.Size()) + bytes.MinRead)
+		}
+	}
+	if _, err := b.data.ReadFrom(r); err != nil {
 		return nil, err
 	}
+	b.lines = splitLines(b.lines[:0], b.data.String(), p.Flags.keepsCR())
+	p.stripOutput(b.lines)
+	return b.lines, nil
+}
 
-	return lines, nil
+// splitLines appends the lines of text, without their "\n" terminators, to
+// lines. A "\r" before the terminator, or at the end of an unterminated last
+// line, is dropped unless raw is set, matching bufio.ScanLines.
+func splitLines(lines []string, text string, raw bool) []string {
+	if text == "" {
+		return lines
+	}
+	n := strings.Count(text, "\n")
+	if !strings.HasSuffix(text, "\n") {
+		n++
+	}
+	lines = slices.Grow(lines, n)
+	for len(text) > 0 {
+		line := text
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			line, text = text[:i], text[i+1:]
+		} else {
+			text = ""
+		}
+		if !raw {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		lines = append(lines, line)
+	}
+	return lines
 }
 
-// areIdentical checks if two sets of lines are identical
-func areIdentical(lines1, lines2 []string, ignoreCase, ignoreWhitespace bool) bool {
-	if len(lines1) != len(lines2) {
-		return false
+// stripOutput removes the escape sequences from lines under StripANSI, so
+// they are printed without them
+func (p command) stripOutput(lines []string) {
+	if !bool(p.Flags.StripANSI) {
+		return
 	}
+	for i, line := range lines {
+		lines[i] = stripANSI(line)
+	}
+}
 
-	for i := range lines1 {
-		l1, l2 := lines1[i], lines2[i]
+// lineScanner splits r into lines. Lines end at "\n"; a "\r" before it is
+// dropped, except under RawBytes or PreserveEOL where it is kept as read.
+func (p command) lineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	if p.Flags.keepsCR() {
+		scanner.Split(scanRawLines)
+	}
+	return scanner
+}
 
-		if ignoreWhitespace {
-			l1 = strings.TrimSpace(l1)
-			l2 = strings.TrimSpace(l2)
+// scanRawLines is bufio.ScanLines without the carriage return removal
+func scanRawLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// normalizeLine applies the comparison-only normalizations to a line
+func normalizeLine(line string, ignoreCase, ignoreWhitespace bool) string {
+	if ignoreWhitespace {
+		line = strings.TrimSpace(line)
+	}
+
+	if ignoreCase {
+		line = strings.ToLower(line)
+	}
+
+	return line
+}
+
+// reportFirstDifference reads both files in lockstep and prints where they
+// first diverge. Only the current line of each file is held in memory.
+func (p command) reportFirstDifference(stdin io.Reader, stdout, stderr io.Writer, file1Path, file2Path string) error {
+	file1, err := p.openInput(stdin, 0, file1Path)
+	if err != nil {
+		return fileTrouble(stderr, file1Path, err)
+	}
+	defer file1.Close()
+
+	file2, err := p.openInput(stdin, 1, file2Path)
+	if err != nil {
+		return fileTrouble(stderr, file2Path, err)
+	}
+	defer file2.Close()
+
+	if bool(p.Flags.Reverse) {
+		file1Path, file2Path = file2Path, file1Path
+		file1, file2 = file2, file1
+	}
+	var open1, open2 bool
+	scanner1 := p.endScanner(file1, &open1)
+	scanner2 := p.endScanner(file2, &open2)
+	for line := 1; ; line++ {
+		more1, more2 := scanner1.Scan(), scanner2.Scan()
+
+		if !more1 || !more2 {
+			if err := scanner1.Err(); err != nil {
+				return fileTrouble(stderr, file1Path, err)
+			}
+			if err := scanner2.Err(); err != nil {
+				return fileTrouble(stderr, file2Path, err)
+			}
 		}
 
-		if ignoreCase {
-			l1 = strings.ToLower(l1)
-			l2 = strings.ToLower(l2)
+		switch {
+		case !more1 && !more2 && p.Flags.endsDiffer([2]bool{open1, open2}):
+			// Only one last line has a terminator
+			_, _ = fmt.Fprintf(stdout, "Files %s and %s first differ at line %d\n", p.displayPath(file1Path), p.displayPath(file2Path), line-1)
+			return ErrFilesDiffer
+		case !more1 && !more2:
+			// Files are identical, no output
+			return nil
+		case !more1:
+			_, _ = fmt.Fprintf(stdout, "Files %s and %s first differ: file %s ends early at line %d\n", p.displayPath(file1Path), p.displayPath(file2Path), p.displayPath(file1Path), line)
+			return ErrFilesDiffer
+		case !more2:
+			_, _ = fmt.Fprintf(stdout, "Files %s and %s first differ: file %s ends early at line %d\n", p.displayPath(file1Path), p.displayPath(file2Path), p.displayPath(file2Path), line)
+			return ErrFilesDiffer
 		}
 
-		if l1 != l2 {
-			return false
+		if !p.linesEqual(scanner1.Text(), scanner2.Text()) {
+			_, _ = fmt.Fprintf(stdout, "Files %s and %s first differ at line %d\n", p.displayPath(file1Path), p.displayPath(file2Path), line)
+			return ErrFilesDiffer
 		}
 	}
+}
 
-	return true
+// outputNormalDiff outputs in normal diff format: a command for each run of
+// changes, such as 3,5c3,4, 7a8,9 or 12,13d11, followed by the deleted lines,
+// a --- separator when lines are both deleted and inserted, and the inserted
+// lines
+func (p command) outputNormalDiff(w io.Writer, lines1, lines2 []string, script []edit) {
+	p.outputNormalDiffAt(w, lines1, lines2, script, 0, 0)
 }
 
-// outputNormalDiff outputs in normal diff format
-func outputNormalDiff(w io.Writer, lines1, lines2 []string) {
-	// Simple line-by-line comparison for normal format
-	maxLen := len(lines1)
-	if len(lines2) > maxLen {
-		maxLen = len(lines2)
-	}
+// outputNormalDiffAt outputs in normal diff format for lines1 and lines2
+// starting at lines off1 and off2 of their files
+func (p command) outputNormalDiffAt(w io.Writer, lines1, lines2 []string, script []edit, off1, off2 int) {
+	n1, n2 := len(lines1), len(lines2)
+	for _, h := range p.groupHunks(script, lines1, lines2, 0, 0, 0) {
+		command := 'c'
+		switch {
+		case h.countA == 0:
+			command = 'a'
+		case h.countB == 0:
+			command = 'd'
+		}
+		fmt.Fprintf(w, "%s%c%s\n", p.normalRange(0, h.startA+off1, h.countA), command, p.normalRange(1, h.startB+off2, h.countB))
 
-	for i := 0; i < maxLen; i++ {
-		if i >= len(lines1) {
-			fmt.Fprintf(w, "%da%d\n", len(lines1), i+1)
-			fmt.Fprintf(w, "> %s\n", lines2[i])
-		} else if i >= len(lines2) {
-			fmt.Fprintf(w, "%dd%d\n", i+1, len(lines2))
-			fmt.Fprintf(w, "< %s\n", lines1[i])
-		} else if lines1[i] != lines2[i] {
-			fmt.Fprintf(w, "%dc%d\n", i+1, i+1)
-			fmt.Fprintf(w, "< %s\n", lines1[i])
-			fmt.Fprintf(w, "---\n")
-			fmt.Fprintf(w, "> %s\n", lines2[i])
+		for _, e := range h.edits {
+			if e.kind == opDelete {
+				fmt.Fprintf(w, "< %s\n", lines1[e.a])
+				p.writeNoNewline(w, e, n1, n2)
+			}
+		}
+		if command == 'c' {
+			fmt.Fprintln(w, "---")
+		}
+		for _, e := range h.edits {
+			if e.kind == opInsert {
+				fmt.Fprintf(w, "> %s\n", lines2[e.b])
+				p.writeNoNewline(w, e, n1, n2)
+			}
 		}
 	}
 }
 
+// normalRange formats a range of count lines from the 0-based start on side
+// 0 or 1 for a normal diff command or a context diff hunk: the line itself
+// for one line, the first and last for more, and the line before an empty
+// range
+func (p command) normalRange(side, start, count int) string {
+	switch count {
+	case 0:
+		return strconv.Itoa(p.lineNumber(side, start))
+	case 1:
+		return strconv.Itoa(p.lineNumber(side, start+1))
+	default:
+		return fmt.Sprintf("%d,%d", p.lineNumber(side, start+1), p.lineNumber(side, start+count))
+	}
+}
+
 // outputUnifiedDiff outputs in unified diff format
-func outputUnifiedDiff(w io.Writer, file1, file2 string, lines1, lines2 []string, context int) {
-	fmt.Fprintf(w, "--- %s\n", file1)
-	fmt.Fprintf(w, "+++ %s\n", file2)
-
-	// Simple unified diff implementation
-	for i := 0; i < len(lines1) || i < len(lines2); i++ {
-		if i >= len(lines1) {
-			fmt.Fprintf(w, "+%s\n", lines2[i])
-		} else if i >= len(lines2) {
-			fmt.Fprintf(w, "-%s\n", lines1[i])
-		} else if lines1[i] != lines2[i] {
-			fmt.Fprintf(w, "-%s\n", lines1[i])
-			fmt.Fprintf(w, "+%s\n", lines2[i])
-		} else {
-			fmt.Fprintf(w, " %s\n", lines1[i])
+func (p command) outputUnifiedDiff(w io.Writer, file1, file2 string, lines1, lines2 []string, script []edit) {
+	p.outputUnifiedDiffAt(w, file1, file2, lines1, lines2, script, 0, 0)
+}
+
+// outputUnifiedDiffAt outputs in unified diff format for lines1 and lines2
+// starting at lines off1 and off2 of their files
+func (p command) outputUnifiedDiffAt(w io.Writer, file1, file2 string, lines1, lines2 []string, script []edit, off1, off2 int) {
+	before, after := p.Flags.unifiedContext()
+	if bool(p.Flags.FullContext) || p.Flags.UnifiedContext < 0 {
+		// Enough context to cover both files reduces the diff to one hunk
+		before, after = len(script), len(script)
+	}
+	hunks := p.hunks(script, lines1, lines2, before, after)
+	if len(hunks) == 0 {
+		return
+	}
+
+	p.writeIndex(w, file1, file2)
+	if !bool(p.Flags.NoHeader) {
+		fmt.Fprintf(w, "--- %s\n", p.header(0, file1))
+		fmt.Fprintf(w, "+++ %s\n", p.header(1, file2))
+	}
+
+	width := lineNumberWidth(max(len(lines1)+off1, len(lines2)+off2))
+	for _, h := range hunks {
+		p.writeUnifiedHunk(w, h, file2, lines1, lines2, off1, off2, width)
+	}
+}
+
+// writeUnifiedHunk prints one unified hunk. The hunk indexes lines1 and
+// lines2, which start at lines off1 and off2 of their files, so a chunk of
+// a file is printed with the file's line numbers. With Hyperlinks the
+// header links to the hunk's first line in file2. Context lines print the
+// old file's text, as GNU diff does, so a patch made under IgnoreCase or
+// IgnoreWhitespace still applies to file1 when the sides' context differs.
+func (p command) writeUnifiedHunk(w io.Writer, h hunk, file2 string, lines1, lines2 []string, off1, off2, width int) {
+	start1, start2 := p.rangeOrigin(0, h.startA+off1, h.countA), p.rangeOrigin(1, h.startB+off2, h.countB)
+	header := fmt.Sprintf("@@ -%s +%s @@", unifiedRange(start1, h.countA), unifiedRange(start2, h.countB))
+	fmt.Fprintf(w, "%s%s\n", p.hyperlink(file2, max(start2+1, 1), header), p.hunkSuffix(h, file2, lines1, start1, start2))
+	folds := p.folds(len(h.edits), func(i int) bool { return h.edits[i].kind == opEqual })
+	for i := 0; i < len(h.edits); i++ {
+		if len(folds) > 0 && folds[0].start == i {
+			fmt.Fprintln(w, foldMarker(folds[0].end-folds[0].start))
+			i = folds[0].end - 1
+			folds = folds[1:]
+			continue
 		}
+
+		e := h.edits[i]
+		if bool(p.Flags.ShowLineNumbers) {
+			numbered := e
+			numbered.a, numbered.b = p.lineNumber(0, e.a+off1+1)-1, p.lineNumber(1, e.b+off2+1)-1
+			fmt.Fprint(w, lineNumberColumns(numbered, width))
+		}
+		switch e.kind {
+		case opEqual:
+			fmt.Fprintf(w, " %s\n", lines1[e.a])
+		case opDelete:
+			fmt.Fprintf(w, "-%s\n", lines1[e.a])
+		case opInsert:
+			fmt.Fprintf(w, "+%s\n", lines2[e.b])
+		}
+		p.writeNoNewline(w, e, len(lines1), len(lines2))
+	}
+}
+
+// lineNumberWidth is the column width needed for line numbers up to n,
+// never narrower than five digits
+func lineNumberWidth(n int) int {
+	return max(5, len(strconv.Itoa(n)))
+}
+
+// lineNumberColumns formats the old and new line numbers of a unified diff
+// line followed by the " |" separator. The side without the line is blank.
+func lineNumberColumns(e edit, width int) string {
+	oldNum, newNum := "", ""
+	if e.kind != opInsert {
+		oldNum = strconv.Itoa(e.a + 1)
 	}
+	if e.kind != opDelete {
+		newNum = strconv.Itoa(e.b + 1)
+	}
+	return fmt.Sprintf("%*s %*s |", width, oldNum, width, newNum)
 }
 
-// outputContextDiff outputs in context diff format
-func outputContextDiff(w io.Writer, file1, file2 string, lines1, lines2 []string, context int) {
-	fmt.Fprintf(w, "*** %s\n", file1)
-	fmt.Fprintf(w, "--- %s\n", file2)
+// header is the file name shown in unified and context headers, followed by
+// the modification time when a TimestampFormat is selected. Operands that are
+// not files on disk, such as stdin, have no timestamp. A Label replaces both
+// for its operand, and stdin is shown as "standard input" without one. side
+// is the side the header is printed for, which is the other operand's under
+// Reverse.
+func (p command) header(side int, name string) string {
+	operand := side
+	if bool(p.Flags.Reverse) {
+		operand = 1 - side
+	}
+	var shown string
+	switch {
+	case operand < len(p.Flags.Labels):
+		shown = string(p.Flags.Labels[operand])
+	case p.readsStdin(operand, name):
+		shown = stdinLabel
+	default:
+		shown = p.hyperlink(name, 1, p.displayPath(name))
+	}
+	if p.Flags.canonical[name] {
+		shown += " (canonicalized)"
+	}
+	layout := p.Flags.TimestampFormat.layout()
+	if layout == "" || name == "-" || p.supplied(operand) || operand < len(p.Flags.Labels) {
+		return shown
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return shown
+	}
+	return shown + "\t" + info.ModTime().Format(layout)
+}
 
-	// Simple context diff implementation
-	for i := 0; i < len(lines1) || i < len(lines2); i++ {
-		if i >= len(lines1) {
-			fmt.Fprintf(w, "+ %s\n", lines2[i])
-		} else if i >= len(lines2) {
-			fmt.Fprintf(w, "- %s\n", lines1[i])
-		} else if lines1[i] != lines2[i] {
-			fmt.Fprintf(w, "! %s\n", lines1[i])
-			fmt.Fprintf(w, "! %s\n", lines2[i])
-		} else {
-			fmt.Fprintf(w, "  %s\n", lines1[i])
+// hunkSuffix is what follows the closing @@ of a unified hunk header: the
+// expanded HunkLabel when one is set, otherwise any SectionLabel
+func (p command) hunkSuffix(h hunk, file2 string, lines1 []string, start1, start2 int) string {
+	if p.Flags.HunkLabel == nil {
+		return p.sectionLabel(lines1, h.startA)
+	}
+	label := strings.NewReplacer(
+		"{oldstart}", strconv.Itoa(rangeStart(start1, h.countA)),
+		"{newstart}", strconv.Itoa(rangeStart(start2, h.countB)),
+		"{file}", p.displayPath(file2),
+	).Replace(string(*p.Flags.HunkLabel))
+	if label == "" {
+		return ""
+	}
+	return " " + label
+}
+
+// rangeStart is the first line number of a hunk range as unifiedRange
+// prints it
+func rangeStart(start, count int) int {
+	if count == 0 {
+		return start
+	}
+	return start + 1
+}
+
+// unifiedRange formats a hunk range the way GNU diff does: the count is
+// omitted when it is 1, and an empty range names the line before it
+func unifiedRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// outputContextDiff outputs the hunks of a context diff with ContextLines
+// of context: a *************** separator, the old lines of the hunk under
+// its *** range **** line, then the new lines under its --- range ---- line.
+// Changed lines are marked "! " in a run of changes that both deletes and
+// inserts, otherwise "- " or "+ ". A side the hunk changes nothing on has
+// only its range line. Each section prints its own file's text, context
+// included, and patch matches the old section against file1.
+func (p command) outputContextDiff(w io.Writer, lines1, lines2 []string, script []edit) {
+	n1, n2 := len(lines1), len(lines2)
+	context := int(p.Flags.ContextLines)
+	for _, h := range p.hunks(script, lines1, lines2, context, context) {
+		marks := contextMarks(h.edits)
+		fmt.Fprintln(w, "***************")
+		fmt.Fprintf(w, "*** %s ****\n", p.normalRange(0, h.startA, h.countA))
+		if slices.ContainsFunc(h.edits, func(e edit) bool { return e.kind == opDelete }) {
+			for i, e := range h.edits {
+				if e.kind != opInsert {
+					fmt.Fprintf(w, "%s%s\n", marks[i], lines1[e.a])
+					p.writeNoNewline(w, edit{kind: opDelete, a: e.a}, n1, n2)
+				}
+			}
+		}
+		fmt.Fprintf(w, "--- %s ----\n", p.normalRange(1, h.startB, h.countB))
+		if slices.ContainsFunc(h.edits, func(e edit) bool { return e.kind == opInsert }) {
+			for i, e := range h.edits {
+				if e.kind != opDelete {
+					fmt.Fprintf(w, "%s%s\n", marks[i], lines2[e.b])
+					p.writeNoNewline(w, edit{kind: opInsert, b: e.b}, n1, n2)
+				}
+			}
+		}
+	}
+}
+
+// contextMarks is the context diff prefix of each edit: "  " for unchanged
+// lines and, for the lines of a run of changes, "! " when the run both
+// deletes and inserts, otherwise "- " or "+ "
+func contextMarks(edits []edit) []string {
+	marks := make([]string, len(edits))
+	for i := 0; i < len(edits); {
+		if edits[i].kind == opEqual {
+			marks[i] = "  "
+			i++
+			continue
+		}
+		deleted, inserted, next := changeGroup(edits, i)
+		for ; i < next; i++ {
+			switch {
+			case len(deleted) > 0 && len(inserted) > 0:
+				marks[i] = "! "
+			case edits[i].kind == opDelete:
+				marks[i] = "- "
+			default:
+				marks[i] = "+ "
+			}
 		}
 	}
+	return marks
 }
@@ -1,8 +1,19 @@
 package command_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	gloo "github.com/gloo-foo/framework"
 	"github.com/gloo-foo/testable/assertion"
 	"github.com/gloo-foo/testable/run"
 	command "github.com/yupsh/diff"
@@ -10,25 +21,96 @@ import (
 
 func TestDiff_Basic(t *testing.T) {
 	result := run.Quick(command.Diff("testdata/a.txt", "testdata/b.txt"))
-	assertion.NoError(t, result.Err)
+	assertDiffers(t, result.Err)
 	// Should show differences between the files (diff produces multiple lines)
 }
 
 func TestDiff_Unified(t *testing.T) {
 	result := run.Quick(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified))
-	assertion.NoError(t, result.Err)
+	assertDiffers(t, result.Err)
 	// Unified diff format
 }
 
 func TestDiff_ContextDiff(t *testing.T) {
 	result := run.Quick(command.Diff("testdata/a.txt", "testdata/b.txt", command.ContextDiff))
-	assertion.NoError(t, result.Err)
+	assertDiffers(t, result.Err)
 	// Context diff format
 }
 
+func TestDiff_ContextHunks(t *testing.T) {
+	tests := []struct {
+		name, a, b string
+		context    int
+		want       string
+	}{
+		{"insertions only", "a\nb\nc\n", "a\nb\nc\nd\n", 3,
+			"***************\n*** 1,3 ****\n--- 1,4 ----\n  a\n  b\n  c\n+ d\n"},
+		{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\n3\n4\n5\n6\n7\n8\nnine\nten\n10\n", 1,
+			"***************\n*** 1,3 ****\n  1\n- 2\n  3\n--- 1,2 ----\n" +
+				"***************\n*** 8,10 ****\n  8\n! 9\n  10\n--- 7,10 ----\n  8\n! nine\n! ten\n  10\n"},
+		{"merged runs", "a\nb\nc\nd\n", "a\nB\nc\nx\nd\n", 1,
+			"***************\n*** 1,4 ****\n  a\n! b\n  c\n  d\n--- 1,5 ----\n  a\n! B\n  c\n+ x\n  d\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", tt.a)
+			b := writeFile(t, dir, "b", tt.b)
+			stdout, _, err := execute(command.Diff(a, b, command.ContextDiff, command.ContextLines(tt.context), command.NoHeader), "")
+			assertDiffers(t, err)
+			if stdout != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff_ZeroContext(t *testing.T) {
+	dir := t.TempDir()
+	oldText := "one\ntwo\nthree\nfour\n"
+	newText := "one\n2\nthree\nfour\nfive\n"
+	a := writeFile(t, dir, "a", oldText)
+	b := writeFile(t, dir, "b", newText)
+
+	patch, _, err := execute(command.Diff(a, b, command.Unified, command.UnifiedContext(0), command.NoHeader), "")
+	assertDiffers(t, err)
+	if want := "@@ -2 +2 @@\n-two\n+2\n@@ -4,0 +5 @@\n+five\n"; patch != want {
+		t.Errorf("got %q, want %q", patch, want)
+	}
+	got, err := command.Apply(splitLines(oldText), patch)
+	assertion.NoError(t, err)
+	if strings.Join(got, "\n") != strings.Join(splitLines(newText), "\n") {
+		t.Errorf("patched lines differ: %q", got)
+	}
+
+	stdout, _, _ := execute(command.Diff(a, b, command.ContextDiff, command.ContextLines(0), command.NoHeader), "")
+	if want := "***************\n*** 2 ****\n! two\n--- 2 ----\n! 2\n***************\n*** 4 ****\n--- 5 ----\n+ five\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// Zero in a Flags struct is the default of three lines unless it is
+	// marked as given
+	cmd, err := command.New([2]string{a, b}, command.Flags{Unified: command.Unified, NoHeader: command.NoHeader})
+	assertion.NoError(t, err)
+	stdout, _, _ = execute(cmd, "")
+	if !strings.HasPrefix(stdout, "@@ -1,4 +1,5 @@\n") {
+		t.Errorf("got %q", stdout)
+	}
+	cmd, err = command.New([2]string{a, b}, command.Flags{Unified: command.Unified, UnifiedContextSet: true, NoHeader: command.NoHeader})
+	assertion.NoError(t, err)
+	if stdout, _, _ = execute(cmd, ""); stdout != patch {
+		t.Errorf("got %q, want %q", stdout, patch)
+	}
+	cmd, err = command.New([2]string{a, b}, command.Flags{ContextDiff: command.ContextDiff, ContextLinesSet: true, NoHeader: command.NoHeader})
+	assertion.NoError(t, err)
+	if stdout, _, _ = execute(cmd, ""); !strings.HasPrefix(stdout, "***************\n*** 2 ****\n! two\n") {
+		t.Errorf("got %q", stdout)
+	}
+}
+
 func TestDiff_Brief(t *testing.T) {
 	result := run.Quick(command.Diff("testdata/a.txt", "testdata/b.txt", command.Brief))
-	assertion.NoError(t, result.Err)
+	assertDiffers(t, result.Err)
 	// Brief output
 }
 
@@ -38,8 +120,789 @@ func TestDiff_Identical(t *testing.T) {
 	// Same file should have no output
 }
 
+func TestDiff_NormalRanges(t *testing.T) {
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"change at line 1", "a\nb\nc\n", "x\nb\nc\n", "1c1\n< a\n---\n> x\n"},
+		{"ranged change", "1\n2\n3\n4\n5\n6\n", "1\n2\nthree\nfour\n6\n", "3,5c3,4\n< 3\n< 4\n< 5\n---\n> three\n> four\n"},
+		{"ranged add", "a\nb\n", "a\nx\ny\nb\n", "1a2,3\n> x\n> y\n"},
+		{"ranged delete", "a\nx\ny\nb\n", "a\nb\n", "2,3d1\n< x\n< y\n"},
+		{"add before first line", "b\n", "a\nb\n", "0a1\n> a\n"},
+		{"add after last line", "a\nb\n", "a\nb\nc\n", "2a3\n> c\n"},
+		{"delete last line", "a\nb\nc\n", "a\nb\n", "3d2\n< c\n"},
+		{"separate runs", "a\nb\nc\nd\ne\n", "A\nb\nc\nd\n", "1c1\n< a\n---\n> A\n5d4\n< e\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", tt.a)
+			b := writeFile(t, dir, "b", tt.b)
+			stdout, _, err := execute(command.Diff(a, b), "")
+			assertDiffers(t, err)
+			if stdout != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", stdout, tt.want)
+			}
+		})
+	}
+}
+
 func TestDiff_MissingFile(t *testing.T) {
 	result := run.Quick(command.Diff("nonexistent.txt", "testdata/a.txt"))
 	assertion.Error(t, result.Err)
 }
 
+// execute runs cmd with the given stdin and returns everything it wrote
+func execute(cmd gloo.Command, stdin string) (stdout, stderr string, err error) {
+	var out, errOut bytes.Buffer
+	err = cmd.Executor()(context.Background(), strings.NewReader(stdin), &out, &errOut)
+	return out.String(), errOut.String(), err
+}
+
+func TestDiff_QuietKeepsOutputAndStatus(t *testing.T) {
+	loudOut, _, loudErr := execute(command.Diff("testdata/a.txt", "testdata/b.txt"), "")
+	quietOut, quietStderr, quietErr := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Quiet), "")
+	if quietOut != loudOut {
+		t.Errorf("stdout changed under Quiet:\n%s\nwant:\n%s", quietOut, loudOut)
+	}
+	if (quietErr == nil) != (loudErr == nil) {
+		t.Errorf("status changed under Quiet: %v, want %v", quietErr, loudErr)
+	}
+	if quietStderr != "" {
+		t.Errorf("unexpected stderr: %q", quietStderr)
+	}
+}
+
+func TestDiff_QuietStillReportsFatalErrors(t *testing.T) {
+	_, stderr, err := execute(command.Diff("nonexistent.txt", "testdata/a.txt", command.Quiet), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "nonexistent.txt") {
+		t.Errorf("fatal error suppressed by Quiet, stderr = %q", stderr)
+	}
+}
+
+// writeFile creates a file with the given content inside dir and returns its path
+// assertDiffers fails the test unless err reports differences and nothing
+// went wrong
+func assertDiffers(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, command.ErrFilesDiffer) {
+		t.Errorf("got error %v, want ErrFilesDiffer", err)
+	}
+}
+
+// assertStatus fails the test unless err is the status of a comparison
+// that completed, reporting differences when differ is set
+func assertStatus(t *testing.T, err error, differ bool) {
+	t.Helper()
+	if differ {
+		assertDiffers(t, err)
+	} else {
+		assertion.NoError(t, err)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDiff_FirstDifferenceMiddle(t *testing.T) {
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.FirstDifference), "")
+	assertDiffers(t, err)
+	want := "Files testdata/a.txt and testdata/b.txt first differ at line 2\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_FirstDifferenceFirstLine(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\ntwo\n")
+	b := writeFile(t, dir, "b", "uno\ntwo\n")
+	stdout, _, err := execute(command.Diff(a, b, command.FirstDifference), "")
+	assertDiffers(t, err)
+	want := "Files " + a + " and " + b + " first differ at line 1\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_FirstDifferencePrefix(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\ntwo\n")
+	b := writeFile(t, dir, "b", "one\ntwo\nthree\n")
+	stdout, _, err := execute(command.Diff(a, b, command.FirstDifference), "")
+	assertDiffers(t, err)
+	want := "Files " + a + " and " + b + " first differ: file " + a + " ends early at line 3\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_FirstDifferenceHonorsNormalization(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "One\n  two\nthree\n")
+	b := writeFile(t, dir, "b", "one\ntwo  \nfour\n")
+	stdout, _, err := execute(command.Diff(a, b, command.FirstDifference, command.IgnoreCase, command.IgnoreWhitespace), "")
+	assertDiffers(t, err)
+	want := "Files " + a + " and " + b + " first differ at line 3\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_OutputPrefix(t *testing.T) {
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.OutputPrefix("    ")), "")
+	assertDiffers(t, err)
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("expected a unified diff, got %q", stdout)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "    ") {
+			t.Errorf("line %q is missing the prefix", line)
+		}
+	}
+}
+
+func TestDiff_OutputPrefixBrief(t *testing.T) {
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Brief, command.OutputPrefix("> ")), "")
+	assertDiffers(t, err)
+	want := "> Files testdata/a.txt and testdata/b.txt differ\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_OutputPrefixMachineReadable(t *testing.T) {
+	// A prefix would break what ed reads, so nothing is printed
+	stdout, stderr, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.EdScript, command.OutputPrefix("    ")), "")
+	assertion.Error(t, err)
+	if errors.Is(err, command.ErrFilesDiffer) || stdout != "" || !strings.Contains(stderr, "OutputPrefix cannot be combined with EdScript") {
+		t.Errorf("got %v, stdout %q, stderr %q", err, stdout, stderr)
+	}
+}
+
+func TestDiff_ExpectedMatches(t *testing.T) {
+	output := writeFile(t, t.TempDir(), "output.txt", "line1\nline2\n")
+	stdout, _, err := execute(command.Diff(output, command.Expected("line1\nline2\n")), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("expected no output, got %q", stdout)
+	}
+}
+
+func TestDiff_ExpectedWithoutTrailingNewline(t *testing.T) {
+	// Expected content ends as written, like any other input
+	output := writeFile(t, t.TempDir(), "output.txt", "line1\nline2\n")
+	stdout, _, err := execute(command.Diff(output, command.Expected("line1\nline2"), command.Unified), "")
+	assertDiffers(t, err)
+	want := "--- " + output + "\n+++ expected\n@@ -1,2 +1,2 @@\n line1\n-line2\n+line2\n\\ No newline at end of file\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	stdout, _, err = execute(command.Diff(output, command.Expected("line1\nline2"), command.Brief), "")
+	assertDiffers(t, err)
+	if want := "Files " + output + " and expected differ\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_ExpectedDiffers(t *testing.T) {
+	stdout, _, err := execute(command.Diff("testdata/a.txt", command.Expected("line 1\nline 2\n"), command.Unified), "")
+	assertDiffers(t, err)
+	if !strings.HasPrefix(stdout, "--- testdata/a.txt\n+++ expected\n") {
+		t.Errorf("unexpected headers:\n%s", stdout)
+	}
+}
+
+func TestDiff_ExpectedFromStdin(t *testing.T) {
+	stdout, _, err := execute(command.Diff("-", command.Expected("one\n"), command.IgnoreCase), "ONE\n")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("expected no output, got %q", stdout)
+	}
+
+	stdout, _, err = execute(command.Diff("-", command.Expected("one\n"), command.Brief), "two\n")
+	assertDiffers(t, err)
+	if stdout != "Files - and expected differ\n" {
+		t.Errorf("got %q", stdout)
+	}
+}
+
+// failingWriter rejects every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestDiff_TeeFormat(t *testing.T) {
+	unified, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified), "")
+	assertDiffers(t, err)
+	normal, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt"), "")
+	assertDiffers(t, err)
+
+	var tee bytes.Buffer
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.TeeFormat(command.FormatUnified, &tee)), "")
+	assertDiffers(t, err)
+	if stdout != normal {
+		t.Errorf("primary output changed by TeeFormat:\n%s", stdout)
+	}
+	if tee.String() != unified {
+		t.Errorf("tee output:\n%s\nwant:\n%s", tee.String(), unified)
+	}
+}
+
+func TestDiff_TeeFormatJSON(t *testing.T) {
+	var encoded bytes.Buffer
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.JSONDetail, command.TeeFormat(command.FormatJSON, &encoded)), "")
+	assertDiffers(t, err)
+
+	var decoded struct {
+		Identical bool
+		Stats     command.Stats
+		Hunks     []command.Hunk
+	}
+	assertion.NoError(t, json.Unmarshal(encoded.Bytes(), &decoded))
+	parsed, err := command.Parse(strings.NewReader(stdout))
+	assertion.NoError(t, err)
+	if len(parsed) != 1 {
+		t.Fatalf("parsed %d file diffs from:\n%s", len(parsed), stdout)
+	}
+	if decoded.Identical || len(decoded.Hunks) == 0 || decoded.Stats.Hunks != len(decoded.Hunks) {
+		t.Errorf("JSON sink: %s", encoded.String())
+	}
+	var inserted, deleted int
+	for i, h := range parsed[0].Hunks {
+		got := decoded.Hunks[min(i, len(decoded.Hunks)-1)]
+		if got.Detail == nil {
+			t.Errorf("hunk %d has no Detail under JSONDetail", i)
+		}
+		got.Detail = nil
+		if !reflect.DeepEqual(got, h) {
+			t.Errorf("hunk %d: JSON %+v, unified %+v", i, got, h)
+		}
+		for _, op := range h.Ops {
+			switch op.Kind {
+			case command.OpInsert:
+				inserted++
+			case command.OpDelete:
+				deleted++
+			}
+		}
+	}
+	if len(parsed[0].Hunks) != len(decoded.Hunks) || decoded.Stats.Insertions != inserted || decoded.Stats.Deletions != deleted {
+		t.Errorf("JSON sink %s does not match unified output:\n%s", encoded.String(), stdout)
+	}
+
+	// The sink renders what Run encodes
+	result, err := command.Run(context.Background(), command.FileSource("testdata/a.txt"), command.FileSource("testdata/b.txt"), command.Unified, command.JSONDetail)
+	assertion.NoError(t, err)
+	want, err := result.JSON()
+	assertion.NoError(t, err)
+	if encoded.String() != string(want)+"\n" || result.Text(command.FormatJSON) != encoded.String() {
+		t.Errorf("JSON sink:\n%s\nResult.JSON:\n%s", encoded.String(), want)
+	}
+}
+
+func TestDiff_TeeFormatWriteError(t *testing.T) {
+	_, stderr, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.TeeFormat(command.FormatContext, failingWriter{})), "")
+	assertion.Error(t, err)
+	if !strings.Contains(err.Error(), "context") || !strings.Contains(stderr, "context output") {
+		t.Errorf("error should identify the failing format: %v / %q", err, stderr)
+	}
+}
+
+func TestDiff_EndOfOptionsLiteralNames(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "-u", "one\n")
+	writeFile(t, dir, "-", "two\n")
+	t.Chdir(dir)
+
+	stdout, _, err := execute(command.Diff(command.EndOfOptions, "-u", "-"), "stdin must not be read\n")
+	assertDiffers(t, err)
+	if want := "1c1\n< one\n---\n> two\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	stdout, _, err = execute(command.Diff("-u", command.EndOfOptions, "-", command.Brief), "two\n")
+	assertDiffers(t, err)
+	if want := "Files -u and - differ\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_DashWithoutEndOfOptionsIsStdin(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "-", "file content\n")
+	t.Chdir(dir)
+
+	stdout, _, err := execute(command.Diff("-", command.Expected("stdin content\n")), "stdin content\n")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("expected stdin to be compared, got %q", stdout)
+	}
+}
+
+func TestDiff_BriefWithSimilarity(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(50, nil))
+	tests := []struct {
+		name, content, percent string
+	}{
+		{"seven changed", numberedLines(50, map[int]string{1: "x", 2: "x", 3: "x", 4: "x", 5: "x", 6: "x", 7: "x"}), "86"},
+		{"appended", numberedLines(50, nil) + "extra\n", "98"},
+		{"half removed", numberedLines(25, nil), "50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := writeFile(t, dir, tt.name, tt.content)
+			stdout, _, err := execute(command.Diff(a, b, command.Brief, command.WithSimilarity), "")
+			assertDiffers(t, err)
+			if want := "Files " + a + " and " + b + " differ (" + tt.percent + "% of lines unchanged)\n"; stdout != want {
+				t.Errorf("got %q, want %q", stdout, want)
+			}
+
+			stdout, _, err = execute(command.Diff(a, b, command.Brief), "")
+			assertDiffers(t, err)
+			if want := "Files " + a + " and " + b + " differ\n"; stdout != want {
+				t.Errorf("plain Brief changed: %q", stdout)
+			}
+		})
+	}
+}
+
+func TestDiff_TimestampFormat(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\n")
+	b := writeFile(t, dir, "b", "y\n")
+	stamp := time.Date(2024, 3, 9, 14, 5, 6, 123456789, time.FixedZone("", 5*3600))
+	for _, path := range []string{a, b} {
+		if err := os.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		format command.TimestampFormat
+		layout string
+	}{
+		{"traditional", command.TimestampTraditional, "2006-01-02 15:04:05.000000000 -0700"},
+		{"rfc3339", command.TimestampRFC3339, time.RFC3339},
+	}
+	for _, tt := range tests {
+		for _, style := range []any{command.Unified, command.ContextDiff} {
+			stdout, _, err := execute(command.Diff(a, b, style, tt.format), "")
+			assertDiffers(t, err)
+			header := strings.SplitN(stdout, "\n", 2)[0]
+			name, stampText, ok := strings.Cut(header[len("--- "):], "\t")
+			if !ok || name != a {
+				t.Fatalf("%s: header %q has no timestamp", tt.name, header)
+			}
+			parsed, err := time.Parse(tt.layout, stampText)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !parsed.Equal(stamp) {
+				t.Errorf("%s: timestamp %s, want %s", tt.name, parsed, stamp)
+			}
+		}
+	}
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	if strings.Contains(stdout, "\t") {
+		t.Errorf("timestamps shown without a TimestampFormat: %q", stdout)
+	}
+}
+
+func TestDiff_ForwardSlashPaths(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// A backslash is a separator on Windows and part of the name elsewhere;
+	// either way the file is opened by the path as given
+	a := writeFile(t, dir, `sub\a.txt`, "x\n")
+	b := writeFile(t, dir, `sub\b.txt`, "y\n")
+	slashed := func(path string) string { return strings.ReplaceAll(path, `\`, "/") }
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.ForwardSlashPaths), "")
+	assertDiffers(t, err)
+	if want := "--- " + slashed(a) + "\n+++ " + slashed(b) + "\n"; !strings.HasPrefix(stdout, want) {
+		t.Errorf("got %q, want prefix %q", stdout, want)
+	}
+
+	stdout, _, err = execute(command.Diff(a, b, command.Brief, command.ForwardSlashPaths), "")
+	assertDiffers(t, err)
+	if strings.Contains(stdout, `\`) {
+		t.Errorf("backslash left in brief message: %q", stdout)
+	}
+
+	stdout, _, err = execute(command.Diff(a, b, command.Brief, command.NativePathSeparators), "")
+	assertDiffers(t, err)
+	if want := "Files " + a + " and " + b + " differ\n"; stdout != want {
+		t.Errorf("NativePathSeparators changed the paths: %q", stdout)
+	}
+}
+
+func TestDiff_RawBytes(t *testing.T) {
+	dir := t.TempDir()
+	// Latin-1 bytes that are not valid UTF-8, and CRLF line ends
+	a := writeFile(t, dir, "a", "caf\xe9\r\nsame \xff\xfe\r\nold\r\n")
+	b := writeFile(t, dir, "b", "caf\xe9\r\nsame \xff\xfe\r\nnew \xe8\r\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.RawBytes), "")
+	assertDiffers(t, err)
+	if want := "3c3\n< old\r\n---\n> new \xe8\r\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// Bytes that differ only in an invalid sequence are different lines
+	c := writeFile(t, dir, "c", "caf\xe9\r\nsame \xff\xfd\r\nold\r\n")
+	stdout, _, err = execute(command.Diff(a, c, command.RawBytes, command.Unified), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stdout, "\n-same \xff\xfe\r\n+same \xff\xfd\r\n") {
+		t.Errorf("got %q", stdout)
+	}
+
+	stdout, _, err = execute(command.Diff(a, a, command.RawBytes), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("identical raw files reported differences: %q", stdout)
+	}
+}
+
+func TestDiff_RawBytesRejectsCaseFolding(t *testing.T) {
+	_, stderr, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.RawBytes, command.IgnoreCase), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "RawBytes cannot be combined with IgnoreCase") {
+		t.Errorf("got %q", stderr)
+	}
+
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "\xa0x\xe9 \n")
+	b := writeFile(t, dir, "b", "\xa0x\xe9\t\n")
+	stdout, _, err := execute(command.Diff(a, b, command.RawBytes, command.IgnoreWhitespace), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("ASCII whitespace should be ignored: %q", stdout)
+	}
+}
+
+func TestDiff_LineSplitting(t *testing.T) {
+	dir := t.TempDir()
+	// CRLF ends are dropped like bufio.ScanLines does, including on an
+	// unterminated last line, and lines longer than a scanner buffer work
+	long := strings.Repeat("x", 200_000)
+	a := writeFile(t, dir, "a", "one\r\ntwo\n"+long+"\nlast\r")
+	b := writeFile(t, dir, "b", "one\ntwo\r\n"+long+"y\nlast")
+
+	stdout, _, err := execute(command.Diff(a, b), "")
+	assertDiffers(t, err)
+	if want := "3c3\n< " + long + "\n---\n> " + long + "y\n"; stdout != want {
+		t.Errorf("got %d bytes of output, want the single long-line change", len(stdout))
+	}
+}
+
+func TestDiff_ReverseMatchesSwappedOperands(t *testing.T) {
+	for _, format := range []any{command.Unified, command.ContextDiff, command.Brief, command.FirstDifference, command.IgnoreCase} {
+		reversed, _, _ := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Reverse, format), "")
+		swapped, _, _ := execute(command.Diff("testdata/b.txt", "testdata/a.txt", format), "")
+		if reversed != swapped {
+			t.Errorf("%v: got:\n%s\nwant:\n%s", format, reversed, swapped)
+		}
+	}
+
+	// Expected stays the second operand's content and becomes the old side
+	output := writeFile(t, t.TempDir(), "output.txt", "line1\nline2\n")
+	stdout, _, _ := execute(command.Diff(output, command.Expected("line1\nchanged\n"), command.Reverse), "")
+	if want := "2c2\n< changed\n---\n> line2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	_, stderr, err := execute(command.Diff(output, "testdata/a.txt", command.Reverse, command.UpdateFirst), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "Reverse cannot be combined with UpdateFirst") {
+		t.Errorf("got %q", stderr)
+	}
+}
+
+func TestDiff_ShowIndex(t *testing.T) {
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.ShowIndex), "")
+	assertDiffers(t, err)
+	want := "index 327100718ed3f16d7e00140f013b5bd19dff74cf..5acf5218b51c6128c1c6d71b3a99015f3f3f7db8\n--- testdata/a.txt\n+++ testdata/b.txt\n"
+	if !strings.HasPrefix(stdout, want) {
+		t.Errorf("got:\n%s\nwant prefix:\n%s", stdout, want)
+	}
+
+	stdout, _, err = execute(command.Diff("testdata/a.txt", "-", command.ContextDiff, command.ShowIndex, command.IndexSHA256), "new\n")
+	assertDiffers(t, err)
+	want = "index 9379ac389d3f582a1b6a3e8b65666dd5181f32fad09f1d9aeb44ce4ff0964333..7aa7a5359173d05b63cfd682e3c38487f3cb4f7f1d60659fe59fab1505977d4c\n*** testdata/a.txt\n"
+	if !strings.HasPrefix(stdout, want) {
+		t.Errorf("got:\n%s\nwant prefix:\n%s", stdout, want)
+	}
+
+	// Formats without file headers have no index line
+	stdout, _, _ = execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.ShowIndex), "")
+	if strings.Contains(stdout, "index ") {
+		t.Errorf("normal format printed an index line:\n%s", stdout)
+	}
+}
+
+func TestDiff_EncodedOutputRoundTrips(t *testing.T) {
+	dir := t.TempDir()
+	changes := map[int]string{}
+	for i := 5; i <= 2000; i += 37 {
+		changes[i] = "changed & encoded = 100% " + strings.Repeat("é", i%7)
+	}
+	a := writeFile(t, dir, "a", numberedLines(2000, nil))
+	b := writeFile(t, dir, "b", numberedLines(2000, changes))
+
+	plain, _, _ := execute(command.Diff(a, b, command.Unified), "")
+	if strings.Count(plain, "@@ -") < 50 {
+		t.Fatalf("fixture should produce many hunks:\n%s", plain)
+	}
+	for _, encoding := range []command.EncodedOutput{command.EncodePercent, command.EncodeBase64} {
+		encoded, _, _ := execute(command.Diff(a, b, encoding), "")
+		if strings.Count(encoded, "\n") != 1 || !strings.HasSuffix(encoded, "\n") {
+			t.Errorf("encoding %d: output is not a single line", encoding)
+		}
+		if strings.ContainsAny(strings.TrimSuffix(encoded, "\n"), " /?&=") {
+			t.Errorf("encoding %d: output is not URL-safe", encoding)
+		}
+		decoded, err := command.DecodeDiff(encoded, encoding)
+		assertion.NoError(t, err)
+		if decoded != plain {
+			t.Errorf("encoding %d: decoded output differs from the unified diff", encoding)
+		}
+	}
+
+	for _, encoding := range []command.EncodedOutput{command.EncodePercent, command.EncodeBase64} {
+		encoded, _, _ := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Brief, encoding), "")
+		if decoded, _ := command.DecodeDiff(encoded, encoding); decoded != "Files testdata/a.txt and testdata/b.txt differ\n" {
+			t.Errorf("encoding %d: Brief decoded to %q", encoding, decoded)
+		}
+		identical, _, _ := execute(command.Diff("testdata/a.txt", "testdata/a.txt", encoding), "")
+		if identical != "" {
+			t.Errorf("encoding %d: identical files printed %q", encoding, identical)
+		}
+	}
+}
+
+func TestDiff_DecodeDiffRejectsMalformedInput(t *testing.T) {
+	_, err := command.DecodeDiff("%zz", command.EncodePercent)
+	assertion.Error(t, err)
+	_, err = command.DecodeDiff("!!!", command.EncodeBase64)
+	assertion.Error(t, err)
+}
+
+func TestDiff_NoHeader(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\ntwo\nthree\n")
+	b := writeFile(t, dir, "b", "one\n2\nthree\n")
+
+	stdout, _, _ := execute(command.Diff(a, b, command.Unified, command.NoHeader), "")
+	if want := "@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	stdout, _, _ = execute(command.Diff(a, b, command.ContextDiff, command.NoHeader), "")
+	if !strings.HasPrefix(stdout, "***************\n") || !strings.Contains(stdout, "! two\n") {
+		t.Errorf("context output should start with its first hunk:\n%s", stdout)
+	}
+
+	stdout, _, _ = execute(command.Diff(a, b, command.Unified, command.Chunked(100), command.NoHeader), "")
+	if !strings.HasPrefix(stdout, "@@ ") {
+		t.Errorf("chunked output should start with a hunk header:\n%s", stdout)
+	}
+
+	// Identical inputs still print nothing
+	stdout, _, err := execute(command.Diff(a, a, command.Unified, command.NoHeader), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("identical files printed %q", stdout)
+	}
+}
+
+func TestDiff_NoHeaderRejectsHeaderOptions(t *testing.T) {
+	for _, opt := range []any{command.TimestampRFC3339, command.ShowIndex} {
+		_, stderr, err := execute(command.Diff("a", "b", command.Unified, command.NoHeader, opt), "")
+		assertion.Error(t, err)
+		if !strings.Contains(stderr, "NoHeader cannot be combined") {
+			t.Errorf("%v: unexpected stderr %q", opt, stderr)
+		}
+	}
+}
+
+func TestNew_RejectsInvalidFlags(t *testing.T) {
+	for _, f := range []command.Flags{
+		{StripANSI: command.StripANSI},
+		{HunkFilters: []command.HunkFilter{"("}},
+		{Labels: []command.Label{"1", "2", "3"}},
+	} {
+		cmd, err := command.New([2]string{"testdata/a.txt", "testdata/b.txt"}, f)
+		if err == nil || cmd != nil {
+			t.Errorf("%+v: got %v, %v; want a construction error", f, cmd, err)
+		}
+	}
+}
+
+func TestNew_MatchesDiff(t *testing.T) {
+	files := [2]string{"testdata/a.txt", "testdata/b.txt"}
+	tests := []struct {
+		name string
+		opts []any
+		f    command.Flags
+	}{
+		{"normal", nil, command.Flags{}},
+		{"unified", []any{command.Unified, command.UnifiedContext(1)}, command.Flags{Unified: command.Unified, UnifiedContext: 1}},
+		{"context", []any{command.ContextDiff, command.Label("old")}, command.Flags{ContextDiff: command.ContextDiff, Labels: []command.Label{"old"}}},
+		{"brief", []any{command.Brief, command.IgnoreCase}, command.Flags{Brief: command.Brief, IgnoreCase: command.IgnoreCase}},
+		{"side by side", []any{command.SideBySide}, command.Flags{SideBySide: command.SideBySide}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := command.New(files, tt.f)
+			assertion.NoError(t, err)
+			got, gotErr, status := execute(cmd, "")
+			want, wantErr, wantStatus := execute(command.Diff(append([]any{files[0], files[1]}, tt.opts...)...), "")
+			if got != want || gotErr != wantErr || !errors.Is(status, wantStatus) {
+				t.Errorf("New printed %q, %q, %v; Diff printed %q, %q, %v", got, gotErr, status, want, wantErr, wantStatus)
+			}
+		})
+	}
+}
+
+func TestDiff_DiscardReportsThroughHooks(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, nil))
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{3: "changed 3", 20: "changed 20"}))
+
+	var got []command.Stats
+	hook := command.StatsHook(func(file1, file2 string, stats command.Stats) {
+		if file1 != a || file2 != b {
+			t.Errorf("hook called for %s and %s", file1, file2)
+		}
+		got = append(got, stats)
+	})
+	for _, format := range []any{command.Unified, command.Brief, command.SideBySide, command.CountOnly} {
+		got = nil
+		stdout, _, err := execute(command.Diff(a, b, command.Discard, hook, format), "")
+		assertDiffers(t, err)
+		if stdout != "" {
+			t.Errorf("%v: Discard wrote %q", format, stdout)
+		}
+		if want := (command.Stats{Insertions: 2, Deletions: 2, Hunks: 2}); len(got) != 1 || got[0] != want {
+			t.Errorf("%v: hook got %+v, want %+v", format, got, want)
+		}
+	}
+}
+
+func TestDiff_DiscardIdenticalAndDirectories(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	reported := map[string]command.Stats{}
+	hook := command.StatsHook(func(file1, file2 string, stats command.Stats) {
+		rel, _ := filepath.Rel(dir1, file1)
+		reported[filepath.ToSlash(rel)] = stats
+	})
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Discard, hook), "")
+	assertDiffers(t, err)
+	if stdout != "" {
+		t.Errorf("Discard wrote %q", stdout)
+	}
+	want := map[string]command.Stats{
+		"same.txt":       {},
+		"changed.txt":    {Insertions: 1, Deletions: 1, Hunks: 1},
+		"sub/nested.txt": {Insertions: 1, Deletions: 1, Hunks: 1},
+	}
+	if fmt.Sprint(reported) != fmt.Sprint(want) {
+		t.Errorf("hook got %v, want %v", reported, want)
+	}
+
+	stdout, _, err = execute(command.Diff(filepath.Join(dir1, "same.txt"), filepath.Join(dir2, "same.txt"), command.Discard), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("Discard wrote %q", stdout)
+	}
+}
+
+func TestDiff_DiscardKeepsEarlyExits(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(100, nil))
+	b := writeFile(t, dir, "b", numberedLines(100, map[int]string{1: "x", 2: "y", 50: "z"}))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Discard, command.FirstDifference), "")
+	assertDiffers(t, err)
+	if stdout != "" {
+		t.Errorf("Discard wrote %q", stdout)
+	}
+
+	examined := 0
+	progress := command.Progress(func(n int) { examined = n })
+	stdout, _, err = execute(command.Diff(a, b, command.Discard, command.CountOnly, command.CountThreshold(2), progress), "")
+	assertDiffers(t, err)
+	if stdout != "" || examined == 0 || examined >= 100 {
+		t.Errorf("threshold should stop the count early under Discard: examined %d, stdout %q", examined, stdout)
+	}
+}
+
+func TestDiff_RejectsUnknownParameters(t *testing.T) {
+	// An option of another command's type is not silently ignored
+	_, stderr, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", 42), "")
+	assertion.Error(t, err)
+	if errors.Is(err, command.ErrFilesDiffer) || !strings.Contains(stderr, "unknown parameter 42 of type int") {
+		t.Errorf("got %v, stderr %q", err, stderr)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\ntwo\n")
+	b := writeFile(t, dir, "b", "one\n2\n")
+	want, _, wantStatus := execute(command.Diff(a, b, command.Unified), "")
+
+	// A reader reads as the file of the same content would
+	for _, operands := range [][2]command.Operand{
+		{command.PathOperand(a), command.PathOperand(b)},
+		{command.ReaderOperand(a, strings.NewReader("one\ntwo\n")), command.PathOperand(b)},
+		{command.PathOperand(a), command.ReaderOperand(b, strings.NewReader("one\n2\n"))},
+	} {
+		cmd, err := command.NewWithOptions(operands[0], operands[1], command.Flags{Unified: command.Unified})
+		assertion.NoError(t, err)
+		got, _, status := execute(cmd, "")
+		if got != want || !errors.Is(status, wantStatus) {
+			t.Errorf("got %q, %v; want %q, %v", got, status, want, wantStatus)
+		}
+	}
+
+	// Path operands compare directories; a reader is never taken for one
+	sub1, sub2 := filepath.Join(dir, "x"), filepath.Join(dir, "y")
+	for _, sub := range []string{sub1, sub2} {
+		if err := os.Mkdir(sub, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, sub1, "f", "old\n")
+	writeFile(t, sub2, "f", "new\n")
+	cmd, err := command.NewWithOptions(command.PathOperand(sub1), command.PathOperand(sub2), command.Flags{Brief: command.Brief})
+	assertion.NoError(t, err)
+	if got, _, _ := execute(cmd, ""); got != "Files "+filepath.Join(sub1, "f")+" and "+filepath.Join(sub2, "f")+" differ\n" {
+		t.Errorf("got %q", got)
+	}
+	cmd, err = command.NewWithOptions(command.ReaderOperand(sub1, strings.NewReader("old\n")), command.PathOperand(filepath.Join(sub2, "f")), command.Flags{})
+	assertion.NoError(t, err)
+	if got, _, _ := execute(cmd, ""); got != "1c1\n< old\n---\n> new\n" {
+		t.Errorf("got %q", got)
+	}
+
+	_, err = command.NewWithOptions(command.ReaderOperand("r", strings.NewReader("")), command.PathOperand(b), command.Flags{UpdateFirst: command.UpdateFirst})
+	assertion.Error(t, err)
+}
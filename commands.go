@@ -0,0 +1,81 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	gloo "github.com/gloo-foo/framework"
+)
+
+// commandLabel names one side of a CommandPair: the explicit label, else
+// the command's String method, else its side
+func commandLabel(cmd gloo.Command, label, side string) string {
+	if label != "" {
+		return label
+	}
+	if s, ok := cmd.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return "command " + side
+}
+
+// compareCommands runs both commands of a CommandPair at once with empty
+// stdin, captures their stdout and diffs it. Their stderr goes to ours. The
+// first command to fail cancels the other, and its error, named by its
+// label, is the result.
+func (p command) compareCommands(ctx context.Context, stdout, stderr io.Writer) error {
+	cmds := []gloo.Command{p.Flags.Commands.A, p.Flags.Commands.B}
+	labels := []string{
+		commandLabel(cmds[0], p.Flags.Commands.LabelA, "A"),
+		commandLabel(cmds[1], p.Flags.Commands.LabelB, "B"),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	shared := &syncWriter{w: stderr}
+	outputs := make([]bytes.Buffer, 2)
+	var failure error
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i, cmd := range cmds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cmd.Executor()(ctx, strings.NewReader(""), &outputs[i], shared); err != nil {
+				once.Do(func() {
+					failure = fmt.Errorf("%s: %w", labels[i], err)
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if failure != nil {
+		_, _ = fmt.Fprintf(stderr, "diff: %v\n", failure)
+		return failure
+	}
+
+	var buf1, buf2 lineBuffer
+	lines1, err := p.readNamed(&buf1, labels[0], &outputs[0])
+	if err != nil {
+		return err
+	}
+	lines2, err := p.readNamed(&buf2, labels[1], &outputs[1])
+	if err != nil {
+		return err
+	}
+	if bool(p.Flags.Reverse) {
+		labels[0], labels[1] = labels[1], labels[0]
+		lines1, lines2 = lines2, lines1
+	}
+	err = p.diffLines(stdout, labels[0], labels[1], lines1, lines2)
+	if err != nil && !errors.Is(err, ErrFilesDiffer) {
+		_, _ = fmt.Fprintf(stderr, "diff: %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	gloo "github.com/gloo-foo/framework"
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// emit is a command that prints fixed text, optionally to stderr, and then
+// fails with err
+type emit struct {
+	name, stdout, stderr string
+	err                  error
+}
+
+func (e emit) String() string { return e.name }
+
+func (e emit) Executor() gloo.CommandExecutor {
+	return func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+		_, _ = io.WriteString(stdout, e.stdout)
+		_, _ = io.WriteString(stderr, e.stderr)
+		return e.err
+	}
+}
+
+// blocking is a command that waits until it is cancelled
+type blocking struct{}
+
+func (blocking) Executor() gloo.CommandExecutor {
+	return func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+func TestDiff_CompareCommands(t *testing.T) {
+	a := emit{name: "gen v1", stdout: "one\ntwo\nthree\n", stderr: "warming up\n"}
+	b := emit{name: "gen v2", stdout: "one\n2\nthree\n"}
+
+	stdout, stderr, err := execute(command.Diff(command.CompareCommands(a, b), command.Unified), "")
+	assertDiffers(t, err)
+	want := "--- gen v1\n+++ gen v2\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if stderr != "warming up\n" {
+		t.Errorf("stderr should be forwarded, got %q", stderr)
+	}
+
+	stdout, _, _ = execute(command.Diff(command.CompareCommands(a, b).Labeled("old", "new"), command.Brief), "")
+	if stdout != "Files old and new differ\n" {
+		t.Errorf("got %q", stdout)
+	}
+}
+
+func TestDiff_CompareCommandsFailure(t *testing.T) {
+	failing := emit{name: "broken", err: errors.New("exit status 2")}
+
+	stdout, stderr, err := execute(command.Diff(command.CompareCommands(blocking{}, failing), command.Unified), "")
+	assertion.Error(t, err)
+	if !strings.Contains(err.Error(), "broken: exit status 2") || !strings.Contains(stderr, "diff: broken: exit status 2") {
+		t.Errorf("error should name the failing side: %v / %q", err, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("a failed comparison should print no diff, got %q", stdout)
+	}
+}
+
+func TestDiff_CompareCommandsNoHeader(t *testing.T) {
+	a, b := emit{name: "gen-a", stdout: "one\ntwo\n"}, emit{name: "gen-b", stdout: "one\n2\n"}
+
+	stdout, _, _ := execute(command.Diff(command.CompareCommands(a, b), command.Unified, command.NoHeader), "")
+	if want := "@@ -1,2 +1,2 @@\n one\n-two\n+2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	_, stderr, err := execute(command.Diff(command.CompareCommands(a, b).Labeled("old", "new"), command.Unified, command.NoHeader), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "NoHeader cannot be combined with labels") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
@@ -0,0 +1,462 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// selectedFormats lists the formats whose flags are set, in precedence
+// order; the first is the one written to stdout
+func (f flags) selectedFormats() []Format {
+	var formats []Format
+	for _, candidate := range []struct {
+		set    bool
+		format Format
+	}{
+		{bool(f.EditDistance), FormatEditDistance},
+		{bool(f.CountOnly), FormatCount},
+		{bool(f.LineMap), FormatLineMap},
+		{bool(f.RawEvents), FormatRawEvents},
+		{bool(f.ConflictMarkers), FormatConflictMarkers},
+		{bool(f.SideBySideTSV), FormatSideBySideTSV},
+		{bool(f.EdScript), FormatEd},
+		{bool(f.ForwardEdScript), FormatForwardEd},
+		{bool(f.Unified), FormatUnified},
+		{bool(f.ContextDiff), FormatContext},
+		{bool(f.SideBySide), FormatSideBySide},
+	} {
+		if candidate.set {
+			formats = append(formats, candidate.format)
+		}
+	}
+	return formats
+}
+
+// primaryFormat is the format selected for stdout. EncodedOutput without a
+// format selected encodes a unified diff.
+func (f flags) primaryFormat() Format {
+	if formats := f.selectedFormats(); len(formats) > 0 {
+		return formats[0]
+	}
+	if f.EncodedOutput != EncodeNone {
+		return FormatUnified
+	}
+	return FormatNormal
+}
+
+// Validate reports options that cannot be combined. Patterns and templates
+// are checked when the command is built, which also runs Validate.
+func (f flags) Validate() error {
+	switch {
+	case len(f.Tees) > maxTees:
+		return fmt.Errorf("at most %d TeeFormat sinks are supported", maxTees)
+	case len(f.Labels) > 2:
+		return fmt.Errorf("at most two Label options are supported, one per operand")
+	case bool(f.RawBytes) && bool(f.IgnoreCase):
+		return fmt.Errorf("RawBytes cannot be combined with IgnoreCase, which needs Unicode case folding")
+	case bool(f.Reverse) && bool(f.UpdateFirst):
+		return fmt.Errorf("Reverse cannot be combined with UpdateFirst, which always rewrites the first operand")
+	case bool(f.StripANSI) && !bool(f.IgnoreANSI):
+		return fmt.Errorf("StripANSI requires IgnoreANSI")
+	case f.UnifiedExtent != nil && (f.UnifiedExtent.Before < 0 || f.UnifiedExtent.After < 0):
+		return fmt.Errorf("UnifiedContextBeforeAfter needs non-negative context, got %d and %d", f.UnifiedExtent.Before, f.UnifiedExtent.After)
+	case bool(f.CanonicalJSON) && bool(f.FirstDifference):
+		return fmt.Errorf("CanonicalJSON cannot be combined with FirstDifference, which compares the files as read")
+	case f.Focus != "" && bool(f.FirstDifference):
+		return fmt.Errorf("FocusLines cannot be combined with FirstDifference, which compares the files as read")
+	case bool(f.NoHeader) && (f.TimestampFormat != TimestampNone || bool(f.ShowIndex)):
+		return fmt.Errorf("NoHeader cannot be combined with TimestampFormat or ShowIndex, which belong to the headers")
+	case bool(f.NoHeader) && (len(f.Labels) > 0 || f.Commands != nil && (f.Commands.LabelA != "" || f.Commands.LabelB != "")):
+		return fmt.Errorf("NoHeader cannot be combined with labels, which name the sides in the headers")
+	case bool(f.IgnoreMatchAll) && len(f.IgnorePatterns) == 0:
+		return fmt.Errorf("IgnoreMatchAll requires IgnoreMatchingLines")
+	case bool(f.FocusKeepNumbers) && f.Focus == "":
+		return fmt.Errorf("FocusKeepNumbers requires FocusLines")
+	case f.Algorithm < AlgorithmDefault || f.Algorithm > AlgorithmHistogram:
+		return fmt.Errorf("unknown Algorithm %d", int(f.Algorithm))
+	case len(f.algorithms()) > 1:
+		return fmt.Errorf("%s cannot be combined with %s, which is a different algorithm", f.algorithms()[0].optionName(), f.algorithms()[1].optionName())
+	case f.RetryOnChange < 0:
+		return fmt.Errorf("RetryOnChange needs a non-negative number of retries, got %d", f.RetryOnChange)
+	case f.HorizonLines < 0:
+		return fmt.Errorf("HorizonLines needs a non-negative number of lines, got %d", f.HorizonLines)
+	case f.CostLimit < 0:
+		return fmt.Errorf("CostLimit needs a non-negative number of edit steps, got %d", f.CostLimit)
+	case f.InterHunkContext < 0:
+		return fmt.Errorf("InterHunkContext needs a non-negative number of lines, got %d", f.InterHunkContext)
+	case f.MaxMemory < 0:
+		return fmt.Errorf("MaxMemory needs a non-negative number of bytes, got %d", f.MaxMemory)
+	case f.Binary != nil && (f.Binary.SampleBytes <= 0 || f.Binary.MaxNULs < 0 || f.Binary.MaxNonText < 0 || f.Binary.MaxNonText > 1):
+		return fmt.Errorf("BinaryHeuristic needs a positive sample, a non-negative NUL count and a proportion between 0 and 1, got %d, %d and %g", f.Binary.SampleBytes, f.Binary.MaxNULs, f.Binary.MaxNonText)
+	}
+	if bool(f.PreserveEOL) {
+		switch {
+		case bool(f.CanonicalJSON) || f.Focus != "":
+			return fmt.Errorf("PreserveEOL cannot be combined with CanonicalJSON or FocusLines, which change the lines printed")
+		case f.Chunked > 0:
+			return fmt.Errorf("PreserveEOL cannot be combined with Chunked, whose windows end before the files do")
+		}
+	}
+	if f.OutputPrefix != "" {
+		// Output read by programs must be printed as is
+		switch {
+		case bool(f.JSONDetail):
+			return fmt.Errorf("OutputPrefix cannot be combined with JSONDetail, whose hunks are read as JSON")
+		case bool(f.LineMap) || bool(f.RawEvents) || bool(f.SideBySideTSV):
+			return fmt.Errorf("OutputPrefix cannot be combined with LineMap, RawEvents or SideBySideTSV, which print tab-separated records")
+		case bool(f.EdScript) || bool(f.ForwardEdScript):
+			return fmt.Errorf("OutputPrefix cannot be combined with EdScript or ForwardEdScript, which ed reads")
+		case f.Bundle != nil:
+			return fmt.Errorf("OutputPrefix cannot be combined with PatchBundle, which patch reads")
+		case f.EncodedOutput != EncodeNone:
+			return fmt.Errorf("OutputPrefix cannot be combined with EncodedOutput, which DecodeDiff reads")
+		}
+	}
+	if f.Bundle != nil {
+		// The patch must reproduce the second tree from the first as it is
+		// on disk
+		switch {
+		case !bool(f.Recursive):
+			return fmt.Errorf("PatchBundle requires Recursive")
+		case bool(f.UpdateFirst):
+			return fmt.Errorf("PatchBundle cannot be combined with UpdateFirst")
+		case bool(f.CanonicalJSON) || f.Focus != "":
+			return fmt.Errorf("PatchBundle cannot be combined with CanonicalJSON or FocusLines, which change the lines compared")
+		case f.Pairs != "" || f.Commands != nil:
+			return fmt.Errorf("PatchBundle cannot be combined with Pairs or CommandPair, which have no directory roots")
+		}
+	}
+	if f.AssumeUnchanged != "" {
+		switch {
+		case bool(f.UpdateFirst) || bool(f.FirstDifference):
+			return fmt.Errorf("AssumeUnchanged cannot be combined with UpdateFirst or FirstDifference, which print no patch")
+		case f.Chunked > 0:
+			return fmt.Errorf("AssumeUnchanged cannot be combined with Chunked")
+		case f.Pairs != "" || f.Commands != nil:
+			return fmt.Errorf("AssumeUnchanged cannot be combined with Pairs or CommandPair")
+		}
+	}
+	if f.HTMLReport != "" {
+		switch {
+		case !bool(f.Recursive):
+			return fmt.Errorf("HTMLReport requires Recursive")
+		case bool(f.UpdateFirst):
+			return fmt.Errorf("HTMLReport cannot be combined with UpdateFirst")
+		case f.Pairs != "" || f.Commands != nil:
+			return fmt.Errorf("HTMLReport cannot be combined with Pairs or CommandPair, which have no directory roots")
+		}
+	}
+	if f.HunkLabel != nil {
+		for _, placeholder := range linkPlaceholder.FindAllString(string(*f.HunkLabel), -1) {
+			if placeholder != "{oldstart}" && placeholder != "{newstart}" && placeholder != "{file}" {
+				return fmt.Errorf("hunk label %q has unknown placeholder %s", *f.HunkLabel, placeholder)
+			}
+		}
+	}
+	if err := f.Volatile.validate(); err != nil {
+		return err
+	}
+	if f.CSVIgnore != nil {
+		if err := f.CSVIgnore.validate(); err != nil {
+			return err
+		}
+	}
+	if f.Hyperlinks != "" {
+		if err := f.Hyperlinks.validate(); err != nil {
+			return err
+		}
+	}
+	if f.Chunked > 0 {
+		// Each of these needs the whole of a file, or output other than
+		// plain unified hunks
+		switch {
+		case f.primaryFormat() != FormatUnified:
+			return fmt.Errorf("Chunked requires Unified output")
+		case bool(f.FullContext) || f.UnifiedContext < 0:
+			return fmt.Errorf("Chunked cannot be combined with FullContext")
+		case bool(f.Brief):
+			return fmt.Errorf("Chunked cannot be combined with Brief")
+		case f.Template != "" || len(f.Tees) > 0:
+			return fmt.Errorf("Chunked cannot be combined with Template or TeeFormat")
+		case f.SectionLabel != nil || bool(f.ShowIndex):
+			return fmt.Errorf("Chunked cannot be combined with SectionLabel or ShowIndex")
+		case bool(f.CanonicalJSON) || f.Focus != "":
+			return fmt.Errorf("Chunked cannot be combined with CanonicalJSON or FocusLines")
+		}
+	}
+	return nil
+}
+
+// Describe reports the configuration in effect after defaults are applied:
+// the active format and any format flags it overrode, the context or width
+// it uses, the normalizations applied to compared lines, and the matching
+// algorithm. The GNU diff options of directory banners are included.
+func (f flags) Describe() string {
+	format := f.primaryFormat()
+	parts := []string{"format=" + strings.ReplaceAll(format.String(), " ", "-")}
+	if formats := f.selectedFormats(); len(formats) > 1 {
+		overridden := make([]string, len(formats)-1)
+		for i, other := range formats[1:] {
+			overridden[i] = strings.ReplaceAll(other.String(), " ", "-")
+		}
+		parts = append(parts, "overrides="+strings.Join(overridden, ","))
+	}
+
+	switch format {
+	case FormatUnified:
+		if bool(f.FullContext) || f.UnifiedContext < 0 {
+			parts = append(parts, "context=full")
+		} else {
+			parts = append(parts, "context="+f.unifiedContextText())
+		}
+	case FormatContext:
+		parts = append(parts, "context="+strconv.Itoa(int(f.ContextLines)))
+	case FormatSideBySide:
+		parts = append(parts, "width="+strconv.Itoa(int(f.Width)))
+	}
+	if f.InterHunkContext > 0 && format != FormatNormal && !format.isEd() {
+		parts = append(parts, "inter-hunk-context="+strconv.Itoa(int(f.InterHunkContext)))
+	}
+	if bool(f.Brief) && !f.structuredOutput() {
+		parts = append(parts, "brief")
+	}
+
+	var normalize []string
+	if bool(f.IgnoreCase) {
+		normalize = append(normalize, "case")
+	}
+	if bool(f.IgnoreWhitespace) {
+		normalize = append(normalize, "whitespace")
+	}
+	if bool(f.IgnoreANSI) {
+		normalize = append(normalize, "ansi")
+	}
+	if bool(f.CanonicalJSON) {
+		normalize = append(normalize, "json")
+	}
+	if len(f.Volatile) > 0 {
+		normalize = append(normalize, "volatile="+f.Volatile.names())
+	}
+	if f.CSVIgnore != nil {
+		normalize = append(normalize, "csv-columns="+f.CSVIgnore.columnList())
+	}
+	if f.Focus != "" {
+		normalize = append(normalize, fmt.Sprintf("focus=%q", f.Focus))
+	}
+	if len(f.IgnorePatterns) > 0 {
+		if bool(f.IgnoreMatchAll) {
+			normalize = append(normalize, "ignore-lines=all")
+		} else {
+			normalize = append(normalize, "ignore-lines=any")
+		}
+	}
+	if bool(f.IgnoreBlankLines) {
+		normalize = append(normalize, "blank-lines")
+	}
+	if f.MaxCompareBytes > 0 {
+		normalize = append(normalize, "prefix="+strconv.Itoa(int(f.MaxCompareBytes)))
+	}
+	if len(normalize) == 0 {
+		normalize = append(normalize, "none")
+	}
+	parts = append(parts, "normalize="+strings.Join(normalize, ","))
+	if bool(f.RawBytes) {
+		parts = append(parts, "raw-bytes")
+	}
+	if bool(f.PreserveEOL) {
+		parts = append(parts, "preserve-eol")
+	}
+	if bool(f.GNUCompat) {
+		parts = append(parts, "gnu-compat")
+	}
+	if bool(f.Text) {
+		parts = append(parts, "text")
+	} else if f.Binary != nil {
+		parts = append(parts, fmt.Sprintf("binary=%d,%d,%g", f.Binary.SampleBytes, f.Binary.MaxNULs, f.Binary.MaxNonText))
+	}
+
+	algorithm := f.algorithm().String()
+	if bool(f.SpeedLargeFiles) && f.algorithm() == AlgorithmDefault {
+		algorithm += ",speed-large-files"
+	}
+	if f.HorizonLines > 0 {
+		algorithm += ",horizon=" + strconv.Itoa(int(f.HorizonLines))
+	}
+	if f.CostLimit > 0 {
+		algorithm += ",cost-limit=" + strconv.Itoa(int(f.CostLimit))
+	}
+	if f.Chunked > 0 {
+		algorithm += ",chunked=" + strconv.Itoa(int(f.Chunked))
+	}
+	if bool(f.DetectMoves) {
+		algorithm += ",moves"
+	}
+	parts = append(parts, "algorithm="+algorithm)
+
+	if options := f.bannerOptions(); options != "" {
+		parts = append(parts, fmt.Sprintf("options=%q", options))
+	}
+	return strings.Join(parts, " ")
+}
+
+// unifiedContext is the context of unified hunks above and below each
+// change, which differ only under UnifiedContextBeforeAfter
+func (f flags) unifiedContext() (before, after int) {
+	if f.UnifiedExtent != nil {
+		return f.UnifiedExtent.Before, f.UnifiedExtent.After
+	}
+	return int(f.UnifiedContext), int(f.UnifiedContext)
+}
+
+// algorithms lists the algorithms named by Minimal, Algorithm and
+// Histogram, each once and in the order of their values; Validate rejects
+// more than one
+func (f flags) algorithms() []Algorithm {
+	var named []Algorithm
+	if bool(f.Minimal) {
+		named = append(named, AlgorithmMinimal)
+	}
+	if f.Algorithm != AlgorithmDefault && !slices.Contains(named, f.Algorithm) {
+		named = append(named, f.Algorithm)
+	}
+	if bool(f.Histogram) && !slices.Contains(named, AlgorithmHistogram) {
+		named = append(named, AlgorithmHistogram)
+	}
+	slices.Sort(named)
+	return named
+}
+
+// algorithm is the algorithm compare searches with
+func (f flags) algorithm() Algorithm {
+	if named := f.algorithms(); len(named) > 0 {
+		return named[0]
+	}
+	return AlgorithmDefault
+}
+
+// optionName names the option selecting the algorithm in errors
+func (a Algorithm) optionName() string {
+	switch a {
+	case AlgorithmMinimal:
+		return "Minimal"
+	case AlgorithmPatience:
+		return "Patience"
+	case AlgorithmHistogram:
+		return "Histogram"
+	default:
+		return a.String()
+	}
+}
+
+// horizonLines is how many lines of the common prefix and suffix compare
+// keeps in the range it analyzes, so a change can slide into them: as many
+// as the context printed around hunks, the way GNU diff does, and none for
+// formats without context, or HorizonLines when that is more
+func (f flags) horizonLines() int {
+	horizon := 0
+	switch f.primaryFormat() {
+	case FormatUnified:
+		if bool(f.FullContext) || f.UnifiedContext < 0 {
+			return math.MaxInt
+		}
+		before, after := f.unifiedContext()
+		horizon = max(before, after)
+	case FormatContext:
+		horizon = int(f.ContextLines)
+	}
+	return max(horizon, int(f.HorizonLines))
+}
+
+// unifiedContextText renders the unified context as "3", or as "1:5" when
+// it is asymmetric, a form GNU diff has no option for
+func (f flags) unifiedContextText() string {
+	before, after := f.unifiedContext()
+	if before == after {
+		return strconv.Itoa(before)
+	}
+	return strconv.Itoa(before) + ":" + strconv.Itoa(after)
+}
+
+// structuredOutput reports whether stdout carries a format that Brief does
+// not replace: a count, a distance, a line map, an event stream, a merged
+// file or a table
+func (f flags) structuredOutput() bool {
+	switch f.primaryFormat() {
+	case FormatCount, FormatEditDistance, FormatLineMap, FormatRawEvents, FormatConflictMarkers, FormatSideBySideTSV:
+		return true
+	default:
+		return false
+	}
+}
+
+// bannerOptions renders the flags in effect the way they would be given to
+// GNU diff, so a banner line reproduces the comparison it introduces
+func (f flags) bannerOptions() string {
+	var options []string
+	if bool(f.Recursive) {
+		options = append(options, "-r")
+	}
+	switch f.primaryFormat() {
+	case FormatUnified:
+		if bool(f.FullContext) || f.UnifiedContext < 0 {
+			options = append(options, "-U", "-1")
+		} else if f.UnifiedExtent == nil && f.UnifiedContext == 3 {
+			options = append(options, "-u")
+		} else {
+			options = append(options, "-U", f.unifiedContextText())
+		}
+	case FormatContext:
+		if f.ContextLines == 3 {
+			options = append(options, "-c")
+		} else {
+			options = append(options, "-C", strconv.Itoa(int(f.ContextLines)))
+		}
+	case FormatSideBySide:
+		options = append(options, "-y")
+	case FormatEd:
+		options = append(options, "-e")
+	case FormatForwardEd:
+		options = append(options, "-f")
+	}
+	if bool(f.IgnoreCase) {
+		options = append(options, "-i")
+	}
+	if bool(f.IgnoreWhitespace) {
+		options = append(options, "-w")
+	}
+	if bool(f.IgnoreBlankLines) {
+		options = append(options, "-B")
+	}
+	if f.algorithm() == AlgorithmMinimal {
+		options = append(options, "-d")
+	}
+	if bool(f.SpeedLargeFiles) {
+		options = append(options, "-H")
+	}
+	if f.HorizonLines > 0 {
+		options = append(options, "--horizon-lines="+strconv.Itoa(int(f.HorizonLines)))
+	}
+	if bool(f.Text) {
+		options = append(options, "-a")
+	}
+	return strings.Join(options, " ")
+}
+
+// Validate reports the first problem with the command's options, including
+// invalid patterns and templates
+func (p command) Validate() error {
+	return p.Flags.err
+}
+
+// Describe reports the configuration in effect; see flags.Describe
+func (p command) Describe() string {
+	return p.Flags.Describe()
+}
+
+// String shows the command with its operands and effective configuration
+func (p command) String() string {
+	return fmt.Sprintf("diff %s [%s]", strings.Join(p.Positional, " "), p.Describe())
+}
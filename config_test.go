@@ -0,0 +1,111 @@
+package command_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// inspectable is the introspection the command exposes beyond gloo.Command
+type inspectable interface {
+	Validate() error
+	Describe() string
+	String() string
+}
+
+func inspect(t *testing.T, params ...any) inspectable {
+	t.Helper()
+	cmd, ok := command.Diff(params...).(inspectable)
+	if !ok {
+		t.Fatal("command does not expose Validate, Describe and String")
+	}
+	return cmd
+}
+
+func TestDiff_Describe(t *testing.T) {
+	tests := []struct {
+		params []any
+		want   string
+	}{
+		{nil, "format=normal normalize=none algorithm=myers"},
+		{[]any{command.Unified, command.ContextDiff}, `format=unified overrides=context context=3 normalize=none algorithm=myers options="-u"`},
+		{[]any{command.ContextDiff, command.ContextLines(5), command.IgnoreCase}, `format=context context=5 normalize=case algorithm=myers options="-C 5 -i"`},
+		{[]any{command.Unified, command.FullContext, command.Recursive}, `format=unified context=full normalize=none algorithm=myers options="-r -U -1"`},
+		{[]any{command.SideBySide, command.Width(80), command.IgnoreWhitespace, command.MaxCompareBytes(40)}, `format=side-by-side width=80 normalize=whitespace,prefix=40 algorithm=myers options="-y -w"`},
+		{[]any{command.CountOnly, command.Brief, command.LineMap}, "format=count overrides=line-map normalize=none algorithm=myers"},
+		{[]any{command.Brief, command.RawBytes, command.DetectMoves}, "format=normal brief normalize=none raw-bytes algorithm=myers,moves"},
+		{[]any{command.Unified, command.Chunked(500)}, `format=unified context=3 normalize=none algorithm=myers,chunked=500 options="-u"`},
+		{[]any{command.Minimal, command.IgnoreCase}, `format=normal normalize=case algorithm=myers,minimal options="-i -d"`},
+		{[]any{command.Text, command.Recursive}, `format=normal normalize=none text algorithm=myers options="-r -a"`},
+		{[]any{command.Histogram, command.DetectMoves}, "format=normal normalize=none algorithm=histogram,moves"},
+		{[]any{command.AlgorithmPatience, command.Unified}, `format=unified context=3 normalize=none algorithm=patience options="-u"`},
+		{[]any{command.AlgorithmMinimal, command.Minimal}, `format=normal normalize=none algorithm=myers,minimal options="-d"`},
+		{[]any{command.SpeedLargeFiles, command.Unified}, `format=unified context=3 normalize=none algorithm=myers,speed-large-files options="-u -H"`},
+		{[]any{command.HorizonLines(8), command.ContextDiff}, `format=context context=3 normalize=none algorithm=myers,horizon=8 options="-c --horizon-lines=8"`},
+		{[]any{command.CostLimit(64), command.Unified}, `format=unified context=3 normalize=none algorithm=myers,cost-limit=64 options="-u"`},
+		{[]any{command.InterHunkContext(2), command.Unified}, `format=unified context=3 inter-hunk-context=2 normalize=none algorithm=myers options="-u"`},
+		{[]any{command.EdScript, command.InterHunkContext(2)}, `format=ed normalize=none algorithm=myers options="-e"`},
+		{[]any{command.ForwardEdScript, command.Unified}, `format=forward-ed overrides=unified normalize=none algorithm=myers options="-f"`},
+		{[]any{command.BinaryHeuristic(4096, 2, 0.1)}, "format=normal normalize=none binary=4096,2,0.1 algorithm=myers"},
+		{[]any{command.Unified, command.UnifiedContextBeforeAfter(1, 5)}, `format=unified context=1:5 normalize=none algorithm=myers options="-U 1:5"`},
+	}
+	for _, tt := range tests {
+		if got := inspect(t, tt.params...).Describe(); got != tt.want {
+			t.Errorf("%v:\ngot  %s\nwant %s", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestDiff_Validate(t *testing.T) {
+	tees := []any{command.Unified}
+	for range 5 {
+		tees = append(tees, command.TeeFormat(command.FormatCount, io.Discard))
+	}
+	tests := []struct {
+		params []any
+		want   string
+	}{
+		{[]any{command.Unified, command.IgnoreCase}, ""},
+		{[]any{command.RawBytes, command.IgnoreCase}, "RawBytes cannot be combined with IgnoreCase"},
+		{[]any{command.Reverse, command.UpdateFirst}, "Reverse cannot be combined with UpdateFirst"},
+		{[]any{command.Chunked(10)}, "Chunked requires Unified output"},
+		{[]any{command.Unified, command.Chunked(10), command.Brief}, "Chunked cannot be combined with Brief"},
+		{[]any{command.HunkFilter("(")}, "invalid hunk filter"},
+		{tees, "at most 4 TeeFormat sinks"},
+		{[]any{command.Histogram, command.Minimal}, "Minimal cannot be combined with Histogram"},
+		{[]any{command.Minimal, command.AlgorithmPatience}, "Minimal cannot be combined with Patience"},
+		{[]any{command.AlgorithmPatience, command.Histogram}, "Patience cannot be combined with Histogram"},
+		{[]any{command.AlgorithmHistogram, command.Histogram}, ""},
+		{[]any{command.Algorithm(7)}, "unknown Algorithm 7"},
+		{[]any{command.AssumeUnchanged("a.patch"), command.UpdateFirst}, "AssumeUnchanged cannot be combined with UpdateFirst"},
+		{[]any{command.BinaryHeuristic(0, 0, 1)}, "BinaryHeuristic needs a positive sample"},
+		{[]any{command.BinaryHeuristic(512, 0, 1.5)}, "a proportion between 0 and 1"},
+		{[]any{command.Unified, command.OutputPrefix("> ")}, ""},
+		{[]any{command.OutputPrefix("> "), command.JSONDetail}, "OutputPrefix cannot be combined with JSONDetail"},
+		{[]any{command.OutputPrefix("> "), command.SideBySideTSV}, "OutputPrefix cannot be combined with LineMap, RawEvents or SideBySideTSV"},
+		{[]any{command.OutputPrefix("> "), command.RawEvents}, "OutputPrefix cannot be combined with LineMap, RawEvents or SideBySideTSV"},
+		{[]any{command.OutputPrefix("> "), command.EdScript}, "OutputPrefix cannot be combined with EdScript or ForwardEdScript"},
+		{[]any{command.OutputPrefix("> "), command.ForwardEdScript}, "OutputPrefix cannot be combined with EdScript or ForwardEdScript"},
+		{[]any{command.OutputPrefix("> "), command.Recursive, command.PatchBundle(io.Discard)}, "OutputPrefix cannot be combined with PatchBundle"},
+		{[]any{command.OutputPrefix("> "), command.EncodedOutput(command.EncodeBase64)}, "OutputPrefix cannot be combined with EncodedOutput"},
+	}
+	for _, tt := range tests {
+		err := inspect(t, tt.params...).Validate()
+		switch {
+		case tt.want == "":
+			assertion.NoError(t, err)
+		case err == nil || !strings.Contains(err.Error(), tt.want):
+			t.Errorf("%v: got %v, want %q", tt.params, err, tt.want)
+		}
+	}
+}
+
+func TestDiff_String(t *testing.T) {
+	got := inspect(t, "a.txt", "b.txt", command.Unified).String()
+	if want := `diff a.txt b.txt [format=unified context=3 normalize=none algorithm=myers options="-u"]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package command
+
+import (
+	"fmt"
+	"io"
+)
+
+// outputConflictMarkers prints the files merged the way a conflicted merge
+// looks: common lines once, and every change group as the first file's lines
+// and the second file's lines between <<<<<<<, ======= and >>>>>>> markers
+// labelled with the file names
+func outputConflictMarkers(w io.Writer, file1, file2 string, lines1, lines2 []string, script []edit) {
+	for i := 0; i < len(script); {
+		if script[i].kind == opEqual {
+			_, _ = fmt.Fprintln(w, lines1[script[i].a])
+			i++
+			continue
+		}
+
+		var deleted, inserted []edit
+		deleted, inserted, i = changeGroup(script, i)
+
+		_, _ = fmt.Fprintf(w, "<<<<<<< %s\n", file1)
+		for _, e := range deleted {
+			_, _ = fmt.Fprintln(w, lines1[e.a])
+		}
+		_, _ = fmt.Fprintln(w, "=======")
+		for _, e := range inserted {
+			_, _ = fmt.Fprintln(w, lines2[e.b])
+		}
+		_, _ = fmt.Fprintf(w, ">>>>>>> %s\n", file2)
+	}
+}
@@ -0,0 +1,65 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_ConflictMarkers(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base", "a\nb\nc\nd\n")
+
+	tests := []struct {
+		name, content, want string
+	}{
+		{"change", "a\nB\nc\nd\n", "a\n<<<<<<< %1\nb\n=======\nB\n>>>>>>> %2\nc\nd\n"},
+		{"insert", "a\nb\nx\ny\nc\nd\n", "a\nb\n<<<<<<< %1\n=======\nx\ny\n>>>>>>> %2\nc\nd\n"},
+		{"delete", "a\nd\n", "a\n<<<<<<< %1\nb\nc\n=======\n>>>>>>> %2\nd\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := writeFile(t, dir, tt.name, tt.content)
+			stdout, _, err := execute(command.Diff(base, other, command.ConflictMarkers), "")
+			assertDiffers(t, err)
+			want := strings.NewReplacer("%1", base, "%2", other).Replace(tt.want)
+			if stdout != want {
+				t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+			}
+		})
+	}
+}
+
+func TestDiff_ConflictMarkersCommonText(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(20, map[int]string{5: "left 5"}))
+	b := writeFile(t, dir, "b", numberedLines(20, map[int]string{5: "right 5", 15: "right 15"}))
+
+	stdout, _, err := execute(command.Diff(a, b, command.ConflictMarkers), "")
+	assertDiffers(t, err)
+
+	// Dropping every marker block leaves exactly the common lines
+	var common []string
+	inside := false
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< "):
+			inside = true
+		case strings.HasPrefix(line, ">>>>>>> "):
+			inside = false
+		case !inside:
+			common = append(common, line)
+		}
+	}
+	want := strings.Split(strings.TrimSuffix(numberedLines(20, map[int]string{5: "", 15: ""}), "\n"), "\n")
+	var wantCommon []string
+	for _, line := range want {
+		if line != "" {
+			wantCommon = append(wantCommon, line)
+		}
+	}
+	if strings.Join(common, "\n") != strings.Join(wantCommon, "\n") {
+		t.Errorf("common text got:\n%s\nwant:\n%s", strings.Join(common, "\n"), strings.Join(wantCommon, "\n"))
+	}
+}
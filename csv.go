@@ -0,0 +1,71 @@
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// validate reports a missing delimiter or a column that is not 1-based
+func (c CSVColumns) validate() error {
+	if c.Delimiter == "" {
+		return fmt.Errorf("CSVIgnoreColumns needs a delimiter")
+	}
+	for _, col := range c.Columns {
+		if col < 1 {
+			return fmt.Errorf("CSVIgnoreColumns column %d is not 1-based", col)
+		}
+	}
+	return nil
+}
+
+// fields splits a line into its fields. A comma delimiter follows CSV
+// quoting, so a quoted field may hold commas; any other delimiter splits
+// the line wherever it occurs.
+func (c CSVColumns) fields(line string) ([]string, bool) {
+	if c.Delimiter != "," {
+		return strings.Split(line, c.Delimiter), true
+	}
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.FieldsPerRecord = -1
+	fields, err := reader.Read()
+	if err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// blankColumns is the comparison form of a CSV line with the ignored
+// columns emptied. A line that does not parse is compared as it is.
+func (c CSVColumns) blankColumns(line string) string {
+	fields, ok := c.fields(line)
+	if !ok {
+		return line
+	}
+	for _, col := range c.Columns {
+		if col <= len(fields) {
+			fields[col-1] = ""
+		}
+	}
+
+	// Fields are quoted so that delimiters inside them stay distinct from
+	// the ones between them
+	var key strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			key.WriteByte(',')
+		}
+		key.WriteString(strconv.Quote(field))
+	}
+	return key.String()
+}
+
+// columnList lists the ignored columns, as in "2,5"
+func (c CSVColumns) columnList() string {
+	cols := make([]string, len(c.Columns))
+	for i, col := range c.Columns {
+		cols[i] = strconv.Itoa(col)
+	}
+	return strings.Join(cols, ",")
+}
@@ -0,0 +1,81 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+const exportCSV = `row_id,name,city,generated_at
+1,"Smith, Jane",Berlin,2024-01-02T03:04:05Z
+2,Bob,"Paris, FR",2024-01-02T03:04:05Z
+`
+
+// regeneratedCSV is exportCSV exported again, with fresh row ids and
+// timestamps
+const regeneratedCSV = `row_id,name,city,generated_at
+17,"Smith, Jane",Berlin,2025-06-07T08:09:10Z
+18,Bob,"Paris, FR",2025-06-07T08:09:10Z
+`
+
+func TestDiff_CSVIgnoreColumnsIdentical(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.csv", exportCSV)
+	b := writeFile(t, dir, "b.csv", regeneratedCSV)
+
+	stdout, _, err := execute(command.Diff(a, b, command.CSVIgnoreColumns(",", 1, 4)), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("only ignored columns changed:\n%s", stdout)
+	}
+
+	// Quoted commas keep the columns aligned: column 3 is the city
+	stdout, _, _ = execute(command.Diff(a, b, command.CSVIgnoreColumns(",", 1, 3)), "")
+	if !strings.HasPrefix(stdout, "2,3c2,3\n") {
+		t.Errorf("generated_at should still differ:\n%s", stdout)
+	}
+}
+
+func TestDiff_CSVIgnoreColumnsReportsDataChange(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.csv", exportCSV)
+	b := writeFile(t, dir, "b.csv", strings.Replace(regeneratedCSV, `"Paris, FR"`, `"Lyon, FR"`, 1))
+
+	stdout, _, _ := execute(command.Diff(a, b, command.CSVIgnoreColumns(",", 1, 4)), "")
+	want := "3c3\n< 2,Bob,\"Paris, FR\",2024-01-02T03:04:05Z\n---\n> 18,Bob,\"Lyon, FR\",2025-06-07T08:09:10Z\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_CSVIgnoreColumnsOtherDelimiter(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.tsv", "1\tx\n2\ty\n")
+	b := writeFile(t, dir, "b.tsv", "7\tx\n8\ty\n")
+
+	stdout, _, _ := execute(command.Diff(a, b, command.CSVIgnoreColumns("\t", 1)), "")
+	if stdout != "" {
+		t.Errorf("only the ignored column changed:\n%s", stdout)
+	}
+}
+
+func TestDiff_CSVIgnoreColumnsUnparsableLine(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.csv", "1,\"open quote\n")
+	b := writeFile(t, dir, "b.csv", "2,\"open quote\n")
+
+	stdout, _, _ := execute(command.Diff(a, b, command.CSVIgnoreColumns(",", 1)), "")
+	if stdout == "" {
+		t.Error("a line that does not parse is compared whole")
+	}
+}
+
+func TestDiff_CSVIgnoreColumnsInvalid(t *testing.T) {
+	_, stderr, err := execute(command.Diff("a", "b", command.CSVIgnoreColumns(",", 0)), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "column 0 is not 1-based") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
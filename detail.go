@@ -0,0 +1,149 @@
+package command
+
+import "bytes"
+
+// ByteRange is a span of an input's bytes, from Start up to but not
+// including End. Line terminators belong to the line they end.
+type ByteRange struct {
+	Start, End int
+}
+
+// MoveLink ties the two halves of a block DetectMoves found moved: Lines
+// lines deleted at OldLine, in the hunk at index OldHunk, and inserted at
+// NewLine, in the hunk at index NewHunk. Both hunks list the link.
+type MoveLink struct {
+	MoveID           int
+	OldHunk, NewHunk int
+	OldLine, NewLine int
+	Lines            int
+}
+
+// HunkDetail is the metadata JSONDetail adds to a hunk. OldBytes and
+// NewBytes locate the hunk's lines in each input as it was read, or in its
+// canonical form under CanonicalJSON; an empty range sits at the end of the
+// line before it. Similarity scores how alike the deleted and inserted lines
+// are, from 0 for a pure insertion or deletion to 100.
+type HunkDetail struct {
+	OldBytes, NewBytes    ByteRange
+	Insertions, Deletions int
+	Similarity            int
+	Moves                 []MoveLink `json:",omitempty"`
+}
+
+// recordLineRanges remembers the byte range of every line read into buf for
+// name, under JSONDetail
+func (p command) recordLineRanges(buf *lineBuffer, name string) {
+	if p.Flags.lineRanges == nil {
+		return
+	}
+	data := buf.data.Bytes()
+	ranges := make([]ByteRange, 0, len(buf.lines))
+	for start := 0; start < len(data); {
+		end := len(data)
+		if i := bytes.IndexByte(data[start:], '\n'); i >= 0 {
+			end = start + i + 1
+		}
+		ranges = append(ranges, ByteRange{start, end})
+		start = end
+	}
+	p.Flags.lineRanges[name] = ranges
+}
+
+// hunkBytes is the byte range of count lines from start, given the ranges
+// of every line of the input
+func hunkBytes(ranges []ByteRange, start, count int) ByteRange {
+	switch {
+	case count > 0 && start+count <= len(ranges):
+		return ByteRange{ranges[start].Start, ranges[start+count-1].End}
+	case start > 0 && start <= len(ranges):
+		return ByteRange{ranges[start-1].End, ranges[start-1].End}
+	default:
+		return ByteRange{}
+	}
+}
+
+// attachDetails fills in the Detail of every exported hunk from the
+// internal hunks it was made from and the line ranges of both inputs
+func attachDetails(exported []Hunk, hunks []hunk, lines1, lines2 []string, ranges1, ranges2 []ByteRange) {
+	links := make(map[int]*MoveLink)
+	var order []int
+	for i, h := range hunks {
+		detail := &HunkDetail{
+			OldBytes: hunkBytes(ranges1, h.startA, h.countA),
+			NewBytes: hunkBytes(ranges2, h.startB, h.countB),
+		}
+		var deleted, inserted []string
+		for _, e := range h.edits {
+			switch e.kind {
+			case opDelete:
+				detail.Deletions++
+				deleted = append(deleted, lines1[e.a])
+			case opInsert:
+				detail.Insertions++
+				inserted = append(inserted, lines2[e.b])
+			default:
+				continue
+			}
+			if e.move == 0 {
+				continue
+			}
+			link, ok := links[e.move]
+			if !ok {
+				link = &MoveLink{MoveID: e.move}
+				links[e.move] = link
+				order = append(order, e.move)
+			}
+			if e.kind == opDelete {
+				if link.OldLine == 0 {
+					link.OldHunk, link.OldLine = i, e.a+1
+				}
+				link.Lines++
+			} else if link.NewLine == 0 {
+				link.NewHunk, link.NewLine = i, e.b+1
+			}
+		}
+		detail.Similarity = blockSimilarity(deleted, inserted)
+		exported[i].Detail = detail
+	}
+
+	for _, id := range order {
+		link := links[id]
+		exported[link.OldHunk].Detail.Moves = append(exported[link.OldHunk].Detail.Moves, *link)
+		if link.NewHunk != link.OldHunk {
+			exported[link.NewHunk].Detail.Moves = append(exported[link.NewHunk].Detail.Moves, *link)
+		}
+	}
+}
+
+// blockSimilarity compares the deleted and inserted lines of a hunk by the
+// byte pairs they share, counted with multiplicity: twice the shared pairs
+// over all pairs, as a percentage rounded down. Identical blocks score 100
+// and blocks with nothing in common, or an empty side, score 0.
+func blockSimilarity(deleted, inserted []string) int {
+	if len(deleted) == 0 || len(inserted) == 0 {
+		return 0
+	}
+	pairs := func(lines []string) (map[string]int, int) {
+		counts := make(map[string]int)
+		total := 0
+		for _, line := range lines {
+			text := line + "\n"
+			for i := 0; i+2 <= len(text); i++ {
+				counts[text[i:i+2]]++
+				total++
+			}
+		}
+		return counts, total
+	}
+	old, oldTotal := pairs(deleted)
+	changed, newTotal := pairs(inserted)
+	shared := 0
+	for pair, n := range old {
+		shared += min(n, changed[pair])
+	}
+	if oldTotal+newTotal == 0 {
+		// Only empty lines on both sides
+		return 100
+	}
+	return 2 * shared * 100 / (oldTotal + newTotal)
+}
@@ -0,0 +1,151 @@
+package command_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// detailedResult is Result.JSON decoded
+type detailedResult struct {
+	Identical bool
+	Stats     command.Stats
+	Hunks     []command.Hunk
+}
+
+func runDetailed(t *testing.T, a, b string, opts ...any) detailedResult {
+	t.Helper()
+	result, err := command.Run(context.Background(), command.FileSource(a), command.FileSource(b), append([]any{command.JSONDetail}, opts...)...)
+	assertion.NoError(t, err)
+	encoded, err := result.JSON()
+	assertion.NoError(t, err)
+	var decoded detailedResult
+	assertion.NoError(t, json.Unmarshal(encoded, &decoded))
+	return decoded
+}
+
+func TestRun_JSONDetailMatchesHunks(t *testing.T) {
+	dir := t.TempDir()
+	// CRLF terminators and a missing final newline keep the offsets honest
+	oldText := strings.ReplaceAll(numberedLines(30, map[int]string{3: "old 3", 20: "the twentieth line"}), "\n", "\r\n")
+	newText := strings.TrimSuffix(numberedLines(31, map[int]string{3: "new 3", 20: "the twentieth line, reworded"}), "\n")
+	a := writeFile(t, dir, "a", oldText)
+	b := writeFile(t, dir, "b", newText)
+
+	decoded := runDetailed(t, a, b)
+	if len(decoded.Hunks) != decoded.Stats.Hunks || len(decoded.Hunks) != 3 {
+		t.Fatalf("got %d hunks, stats %+v", len(decoded.Hunks), decoded.Stats)
+	}
+	insertions, deletions := 0, 0
+	for _, h := range decoded.Hunks {
+		detail := h.Detail
+		if detail == nil {
+			t.Fatalf("hunk %+v has no detail", h)
+		}
+		var oldLines, newLines []string
+		ins, del := 0, 0
+		for _, op := range h.Ops {
+			if op.Kind != command.OpInsert {
+				oldLines = append(oldLines, op.Text)
+			}
+			if op.Kind != command.OpDelete {
+				newLines = append(newLines, op.Text)
+			}
+			switch op.Kind {
+			case command.OpInsert:
+				ins++
+			case command.OpDelete:
+				del++
+			}
+		}
+		if detail.Insertions != ins || detail.Deletions != del {
+			t.Errorf("hunk at %d counts %d+ %d-, ops have %d+ %d-", h.OldStart, detail.Insertions, detail.Deletions, ins, del)
+		}
+		insertions += detail.Insertions
+		deletions += detail.Deletions
+
+		gotOld := oldText[detail.OldBytes.Start:detail.OldBytes.End]
+		if want := strings.Join(oldLines, "\r\n") + "\r\n"; gotOld != want {
+			t.Errorf("old bytes %+v hold %q, want %q", detail.OldBytes, gotOld, want)
+		}
+		gotNew := newText[detail.NewBytes.Start:detail.NewBytes.End]
+		if want := strings.Join(newLines, "\n"); strings.TrimSuffix(gotNew, "\n") != want {
+			t.Errorf("new bytes %+v hold %q, want %q", detail.NewBytes, gotNew, want)
+		}
+	}
+	if insertions != decoded.Stats.Insertions || deletions != decoded.Stats.Deletions {
+		t.Errorf("hunk counts %d+ %d- disagree with stats %+v", insertions, deletions, decoded.Stats)
+	}
+
+	// A reworded line is similar to the one it replaces; an appended one is
+	// not paired with anything
+	if similarity := decoded.Hunks[1].Detail.Similarity; similarity < 50 || similarity == 100 {
+		t.Errorf("similarity of a reworded line is %d", similarity)
+	}
+	if similarity := decoded.Hunks[2].Detail.Similarity; similarity != 0 {
+		t.Errorf("similarity of a pure insertion is %d", similarity)
+	}
+}
+
+func TestRun_JSONDetailEmptyRange(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "")
+	b := writeFile(t, dir, "b", "one\ntwo\n")
+
+	decoded := runDetailed(t, a, b)
+	detail := decoded.Hunks[0].Detail
+	if detail.OldBytes != (command.ByteRange{}) || detail.NewBytes != (command.ByteRange{Start: 0, End: 8}) {
+		t.Errorf("unexpected ranges %+v %+v", detail.OldBytes, detail.NewBytes)
+	}
+}
+
+func TestRun_JSONDetailMoves(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", moveFixture("header", "function", "middle", "footer"))
+	b := writeFile(t, dir, "b", moveFixture("header", "middle", "function", "new", "footer"))
+
+	decoded := runDetailed(t, a, b, command.DetectMoves)
+	var links []command.MoveLink
+	for _, h := range decoded.Hunks {
+		links = append(links, h.Detail.Moves...)
+	}
+	found := false
+	for _, link := range links {
+		if decoded.Hunks[link.OldHunk].Ops == nil || decoded.Hunks[link.NewHunk].Ops == nil {
+			t.Fatalf("link %+v names a missing hunk", link)
+		}
+		moved := 0
+		for _, op := range decoded.Hunks[link.OldHunk].Ops {
+			if op.Kind == command.OpDelete && op.MoveID == link.MoveID {
+				if moved == 0 && op.OldLine != link.OldLine {
+					t.Errorf("link %+v starts at old line %d", link, op.OldLine)
+				}
+				moved++
+			}
+		}
+		if moved != link.Lines {
+			t.Errorf("link %+v covers %d deleted lines", link, moved)
+		}
+		if link.OldLine == 6 && link.NewLine == 36 && link.Lines == 20 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("the moved function is not linked: %+v", links)
+	}
+}
+
+func TestRun_JSONWithoutDetail(t *testing.T) {
+	result, err := command.Run(context.Background(),
+		command.LinesSource("a", []string{"x"}), command.LinesSource("b", []string{"y"}))
+	assertion.NoError(t, err)
+	encoded, err := result.JSON()
+	assertion.NoError(t, err)
+	if strings.Contains(string(encoded), "Detail") {
+		t.Errorf("plain JSON should not change: %s", encoded)
+	}
+}
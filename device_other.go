@@ -0,0 +1,17 @@
+//go:build !unix
+
+package command
+
+import "os"
+
+// deviceOf reports no device where the platform does not expose one, which
+// turns OneFileSystem into a no-op
+func deviceOf(os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// identityOf reports no identity where the platform does not expose one,
+// which leaves symlink cycles undetected
+func identityOf(os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
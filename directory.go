@@ -0,0 +1,663 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// entryKind classifies a directory entry for pairing
+type entryKind int
+
+const (
+	kindMissing entryKind = iota
+	kindRegular
+	kindDirectory
+	kindOther
+)
+
+func (k entryKind) String() string {
+	switch k {
+	case kindRegular:
+		return "regular file"
+	case kindDirectory:
+		return "directory"
+	default:
+		return "special file"
+	}
+}
+
+// statKind reports what kind of entry lives at path, following symlinks
+func statKind(path string) (entryKind, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return kindMissing, err
+	}
+	switch {
+	case info.Mode().IsRegular():
+		return kindRegular, nil
+	case info.IsDir():
+		return kindDirectory, nil
+	default:
+		return kindOther, nil
+	}
+}
+
+// isDirectory reports whether path names a directory
+func isDirectory(path string) bool {
+	kind, err := statKind(path)
+	return err == nil && kind == kindDirectory
+}
+
+// walkState accumulates the outcome of a directory comparison
+type walkState struct {
+	trouble   error
+	aborted   error
+	failed    int
+	differ    int
+	onlyLeft  int
+	onlyRight int
+
+	// mismatched counts pairs whose entries are of different kinds, such
+	// as a file and a directory
+	mismatched int
+
+	// left and right are reused to read every file pair of the walk
+	left, right lineBuffer
+
+	// cache holds verdicts from earlier runs when CacheFile is set; pair
+	// names are relative to root, or full paths when root is empty
+	cache *pairCache
+	root  string
+
+	// roots are the two directories compared, which PatchBundle names
+	// entries relative to, and trees are where their entries are found
+	roots [2]string
+	trees [2]tree
+
+	// devices are the devices of the two roots under OneFileSystem, when
+	// known
+	devices      [2]uint64
+	devicesKnown bool
+
+	// ancestors are the directories being descended into on each side,
+	// from the root down, which a symlink back up the tree repeats
+	ancestors [2][]fileID
+
+	// stats and onlyIn collect the rows and Only-in messages of TreeStat,
+	// which are printed once the walk is done
+	stats  []statRow
+	onlyIn []string
+
+	// report collects the HTMLReport, when it is set
+	report *htmlReport
+
+	// pairs receives the outcome of each entry of a CompareTrees walk, and
+	// returns false to stop it
+	pairs func(PairResult, error) bool
+}
+
+// fail records a problem with the entry at path without stopping the
+// comparison
+func (s *walkState) fail(path string, err error) {
+	s.trouble = &FileError{Path: path, Err: err}
+	s.failed++
+}
+
+// changes counts the differences found so far: differing files, entries
+// on one side only and entries of different kinds
+func (s *walkState) changes() int {
+	return s.differ + s.onlyLeft + s.onlyRight + s.mismatched
+}
+
+// result is the outcome of a walk that completed: the last problem with an
+// entry, or ErrFilesDiffer when anything differed
+func (s *walkState) result() error {
+	if s.trouble != nil {
+		return s.trouble
+	}
+	return verdict(s.changes() > 0)
+}
+
+// tree is the tree of the first directory when left is set, otherwise that
+// of the second
+func (s *walkState) tree(left bool) tree {
+	if left {
+		return s.trees[0]
+	}
+	return s.trees[1]
+}
+
+// abort records an error that stops the comparison
+func (s *walkState) abort(err error) {
+	s.aborted = err
+}
+
+// emit passes the outcome of the entry at path to the pairs of a
+// CompareTrees walk, and stops the walk when they want no more
+func (s *walkState) emit(path string, kind PairKind, err error) {
+	s.yield(PairResult{Path: s.relative(path), Kind: kind}, err)
+}
+
+// yield records a result in the HTMLReport and passes it to a CompareTrees
+// consumer, and aborts the walk when it stops ranging
+func (s *walkState) yield(result PairResult, err error) {
+	if s.aborted != nil {
+		return
+	}
+	if s.report != nil {
+		s.report.add(result)
+	}
+	if s.pairs != nil && !s.pairs(result, err) {
+		s.abort(errStopped)
+	}
+}
+
+// emitFile emits the outcome of a file pair compared since the walk had
+// differ differing pairs, failed failures and trouble. A file that changed
+// while it was read is still classified, with its trouble.
+func (s *walkState) emitFile(path string, differ, failed int, trouble error) {
+	if s.failed > failed {
+		s.emit(path, PairError, s.trouble)
+		return
+	}
+	kind := PairIdentical
+	if s.differ > differ {
+		kind = PairDiffer
+	}
+	if s.trouble != trouble {
+		s.emit(path, kind, s.trouble)
+		return
+	}
+	s.emit(path, kind, nil)
+}
+
+// relative names path relative to the root it lies under, with forward
+// slashes. The closest root wins when one root lies inside the other.
+func (s *walkState) relative(path string) string {
+	best := filepath.ToSlash(path)
+	for _, root := range s.roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if rel = filepath.ToSlash(rel); len(rel) < len(best) {
+			best = rel
+		}
+	}
+	return best
+}
+
+// walk runs compare with the state shared by the pairs of a comparison,
+// named relative to root, then saves the cache, writes the index of the
+// HTMLReport and prints the TreeStat summary. A comparison that aborted is reported and returns its error.
+func (p command) walk(stdout, stderr io.Writer, root string, compare func(state *walkState)) (*walkState, error) {
+	state := &walkState{root: root}
+	if p.Flags.CacheFile != "" {
+		state.cache = p.loadPairCache()
+	}
+	if p.Flags.HTMLReport != "" {
+		report, err := p.newHTMLReport()
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "diff: %v\n", err)
+			return state, err
+		}
+		state.report = report
+	}
+	compare(state)
+	if state.cache != nil && state.aborted == nil {
+		if err := state.cache.save(); err != nil {
+			p.notice(stderr, "%s: %v", p.Flags.CacheFile, err)
+		}
+	}
+	if state.report != nil && state.aborted == nil {
+		if err := p.writeHTMLReport(state); err != nil {
+			state.abort(err)
+		}
+	}
+	if state.aborted != nil {
+		_, _ = fmt.Fprintf(stderr, "diff: %v\n", state.aborted)
+		return state, state.aborted
+	}
+	if bool(p.Flags.TreeStat) {
+		p.writeTreeStat(stdout, state.stats, state.onlyIn)
+	}
+	return state, nil
+}
+
+// device reports the device of the file at path
+func (p command) device(path string) (uint64, bool) {
+	if p.Flags.DeviceID != nil {
+		return p.Flags.DeviceID(path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	return deviceOf(info)
+}
+
+// enterRoots records the devices of the roots of a directory comparison
+// for OneFileSystem. Trees not on disk have no devices, and OneFileSystem
+// keeps nothing out of them.
+func (p command) enterRoots(dir1, dir2 string, state *walkState) {
+	if !bool(p.Flags.OneFileSystem) || !state.trees[0].disk || !state.trees[1].disk {
+		return
+	}
+	dev1, ok1 := p.device(dir1)
+	dev2, ok2 := p.device(dir2)
+	state.devices, state.devicesKnown = [2]uint64{dev1, dev2}, ok1 && ok2
+}
+
+// crossesFileSystem reports whether OneFileSystem keeps the comparison out
+// of a pair of subdirectories, either of which lies on another device than
+// its root
+func (p command) crossesFileSystem(path1, path2 string, state *walkState) bool {
+	if !bool(p.Flags.OneFileSystem) || !state.devicesKnown {
+		return false
+	}
+	dev1, ok1 := p.device(path1)
+	dev2, ok2 := p.device(path2)
+	return ok1 && dev1 != state.devices[0] || ok2 && dev2 != state.devices[1]
+}
+
+// fileID identifies a file by its device and inode
+type fileID struct {
+	dev, ino uint64
+}
+
+// enter records path as the innermost directory being descended into on
+// one side, unless it is one of the directories already being descended
+// into, which only a symlink back up the tree can cause. Directories whose
+// identity is unknown are always entered, as are those of a tree not on
+// disk.
+func (s *walkState) enter(side int, path string) bool {
+	if !s.trees[side].disk {
+		return true
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	id, ok := identityOf(info)
+	if !ok {
+		return true
+	}
+	if slices.Contains(s.ancestors[side], id) {
+		return false
+	}
+	s.ancestors[side] = append(s.ancestors[side], id)
+	return true
+}
+
+// compareDirectories compares two directories. Without Recursive only the
+// immediate entries are compared and common subdirectories are reported
+// rather than descended into.
+func (p command) compareDirectories(stdout, stderr io.Writer, dir1, dir2 string) error {
+	state, err := p.walk(stdout, stderr, dir1, func(state *walkState) {
+		state.roots = [2]string{dir1, dir2}
+		state.trees = [2]tree{diskTree(dir1), diskTree(dir2)}
+		p.enterRoots(dir1, dir2, state)
+		p.compareDirectoryLevel(stdout, stderr, dir1, dir2, state)
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.Flags.color && bool(p.Flags.Brief) {
+		summary := fmt.Sprintf("%d differ, %d only in %s, %d only in %s", state.differ, state.onlyLeft, p.displayPath(dir1), state.onlyRight, p.displayPath(dir2))
+		_, _ = fmt.Fprintln(stdout, p.paint(sgrBold, summary))
+	}
+	return state.result()
+}
+
+// reportOnlyIn prints an entry found below one side only and adds it to
+// the PatchBundle
+func (p command) reportOnlyIn(stdout, stderr io.Writer, dir, name string, left bool, state *walkState) {
+	message := fmt.Sprintf("Only in %s: %s", p.displayPath(dir), p.displayPath(name))
+	result := PairResult{Path: state.relative(filepath.Join(dir, name)), Kind: PairOnlyRight}
+	if left {
+		state.onlyLeft++
+		result.Kind = PairOnlyLeft
+	} else {
+		state.onlyRight++
+	}
+	if bool(p.Flags.OnlyInDetails) {
+		// An entry that cannot be examined is still reported, without them
+		if entry, err := state.tree(left).describe(filepath.Join(dir, name)); err == nil {
+			message += " (" + entry.String() + ")"
+			result.Entry = &entry
+		}
+	}
+	defer state.yield(result, nil)
+	p.bundleOnlyIn(stderr, filepath.Join(dir, name), left, state)
+	if bool(p.Flags.TreeStat) {
+		state.onlyIn = append(state.onlyIn, message)
+		return
+	}
+	if bool(p.Flags.Brief) {
+		if left {
+			message = p.paint(sgrRed, message)
+		} else {
+			message = p.paint(sgrGreen, message)
+		}
+	}
+	_, _ = fmt.Fprintln(stdout, message)
+}
+
+// compareDirectoryLevel compares the entries of one directory level,
+// descending into common subdirectories when Recursive is set. A level that
+// repeats a directory being descended into on either side is a symlink
+// cycle, which is reported and skipped.
+func (p command) compareDirectoryLevel(stdout, stderr io.Writer, dir1, dir2 string, state *walkState) {
+	depth1, depth2 := len(state.ancestors[0]), len(state.ancestors[1])
+	defer func() {
+		state.ancestors[0], state.ancestors[1] = state.ancestors[0][:depth1], state.ancestors[1][:depth2]
+	}()
+	for side, dir := range [2]string{dir1, dir2} {
+		if !state.enter(side, dir) {
+			p.notice(stderr, "not descending into %s: symlink cycle detected", dir)
+			return
+		}
+	}
+
+	names1, err := state.trees[0].readDirNames(dir1)
+	if err != nil {
+		p.notice(stderr, "%s: %v", dir1, err)
+		state.fail(dir1, err)
+		state.emit(dir1, PairError, state.trouble)
+		return
+	}
+	names2, err := state.trees[1].readDirNames(dir2)
+	if err != nil {
+		p.notice(stderr, "%s: %v", dir2, err)
+		state.fail(dir2, err)
+		state.emit(dir2, PairError, state.trouble)
+		return
+	}
+
+	p.warnCaseCollisions(stderr, dir1, names1)
+	p.warnCaseCollisions(stderr, dir2, names2)
+
+	if bool(p.Flags.IgnoreNameCase) {
+		for _, pair := range pairFoldedNames(names1, names2) {
+			if state.aborted != nil {
+				return
+			}
+			switch {
+			case pair.right == "":
+				p.reportOnlyIn(stdout, stderr, dir1, pair.left, true, state)
+			case pair.left == "":
+				p.reportOnlyIn(stdout, stderr, dir2, pair.right, false, state)
+			default:
+				p.comparePair(stdout, stderr, filepath.Join(dir1, pair.left), filepath.Join(dir2, pair.right), state)
+			}
+		}
+		return
+	}
+
+	_ = mergeSorted(names1, names2, func(name string, in1, in2 bool) error {
+		if state.aborted != nil {
+			return state.aborted
+		}
+		switch {
+		case !in2:
+			p.reportOnlyIn(stdout, stderr, dir1, name, true, state)
+		case !in1:
+			p.reportOnlyIn(stdout, stderr, dir2, name, false, state)
+		default:
+			p.comparePair(stdout, stderr, filepath.Join(dir1, name), filepath.Join(dir2, name), state)
+		}
+		return nil
+	})
+}
+
+// warnCaseCollisions warns about entries of dir whose names differ only in
+// case, which a case-insensitive file system cannot hold side by side
+func (p command) warnCaseCollisions(stderr io.Writer, dir string, names []string) {
+	for _, group := range foldGroups(names) {
+		if len(group) > 1 {
+			p.notice(stderr, "%s: names differ only in case: %s", dir, strings.Join(group, ", "))
+		}
+	}
+}
+
+// foldGroups groups sorted names that are equal under case folding,
+// ordered by their folded form; each group keeps the sorted order
+func foldGroups(names []string) [][]string {
+	groups := make(map[string][]string)
+	var keys []string
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], name)
+	}
+	slices.Sort(keys)
+	sorted := make([][]string, len(keys))
+	for i, key := range keys {
+		sorted[i] = groups[key]
+	}
+	return sorted
+}
+
+// namePair is one pairing of directory entries under IgnoreFileNameCase; an
+// empty name marks an entry found on the other side only
+type namePair struct {
+	left, right string
+}
+
+// pairFoldedNames pairs the sorted entry names of two directories ignoring
+// case. Within each group of names equal under case folding, names present
+// on both sides pair first, then the rest pair in lexicographic order, and
+// whatever is left over is found on one side only.
+func pairFoldedNames(names1, names2 []string) []namePair {
+	groups1, groups2 := make(map[string][]string), make(map[string][]string)
+	var keys []string
+	for _, group := range foldGroups(names1) {
+		key := strings.ToLower(group[0])
+		groups1[key] = group
+		keys = append(keys, key)
+	}
+	for _, group := range foldGroups(names2) {
+		key := strings.ToLower(group[0])
+		groups2[key] = group
+		if _, ok := groups1[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	var pairs []namePair
+	for _, key := range keys {
+		var rest1, rest2 []string
+		for _, name := range groups1[key] {
+			if slices.Contains(groups2[key], name) {
+				pairs = append(pairs, namePair{name, name})
+			} else {
+				rest1 = append(rest1, name)
+			}
+		}
+		for _, name := range groups2[key] {
+			if !slices.Contains(groups1[key], name) {
+				rest2 = append(rest2, name)
+			}
+		}
+		for len(rest1) > 0 && len(rest2) > 0 {
+			pairs = append(pairs, namePair{rest1[0], rest2[0]})
+			rest1, rest2 = rest1[1:], rest2[1:]
+		}
+		for _, name := range rest1 {
+			pairs = append(pairs, namePair{left: name})
+		}
+		for _, name := range rest2 {
+			pairs = append(pairs, namePair{right: name})
+		}
+	}
+	return pairs
+}
+
+// comparePair compares two entries found at the same relative path
+func (p command) comparePair(stdout, stderr io.Writer, path1, path2 string, state *walkState) {
+	kind1, err := state.trees[0].kind(path1)
+	if err != nil {
+		p.notice(stderr, "%s: %v", path1, err)
+		state.fail(path1, err)
+		state.emit(path1, PairError, state.trouble)
+		return
+	}
+	kind2, err := state.trees[1].kind(path2)
+	if err != nil {
+		p.notice(stderr, "%s: %v", path2, err)
+		state.fail(path2, err)
+		state.emit(path2, PairError, state.trouble)
+		return
+	}
+
+	switch {
+	case kind1 == kindDirectory && kind2 == kindDirectory:
+		switch {
+		case !bool(p.Flags.Recursive):
+			_, _ = fmt.Fprintf(stdout, "Common subdirectories: %s and %s\n", p.displayPath(path1), p.displayPath(path2))
+		case p.crossesFileSystem(path1, path2, state):
+			p.notice(stderr, "%s and %s: not descending into another file system", path1, path2)
+		default:
+			p.compareDirectoryLevel(stdout, stderr, path1, path2, state)
+		}
+	case kind1 == kindRegular && kind2 == kindRegular:
+		differ, failed, trouble := state.differ, state.failed, state.trouble
+		p.compareFilePair(stdout, stderr, path1, path2, state)
+		state.emitFile(path1, differ, failed, trouble)
+	case kind1 == kindOther && kind2 == kindOther:
+		p.notice(stderr, "%s: not a regular file", path1)
+	default:
+		state.mismatched++
+		_, _ = fmt.Fprintf(stdout, "File %s is a %s while file %s is a %s\n", p.displayPath(path1), kind1, p.displayPath(path2), kind2)
+		state.emit(path1, PairDiffer, nil)
+	}
+}
+
+// compareFilePair diffs two regular files found during a directory comparison
+func (p command) compareFilePair(stdout, stderr io.Writer, path1, path2 string, state *walkState) {
+	if p.Flags.Logger != nil {
+		defer p.logPair(path1, path2, time.Now())
+	}
+	if bool(p.Flags.UpdateFirst) {
+		content, err := os.ReadFile(path2)
+		if err == nil {
+			err = p.updateIfDifferent(stdout, path1, path2, content)
+		}
+		if err != nil {
+			p.notice(stderr, "%s: %v", path1, err)
+			state.fail(path1, err)
+		}
+		return
+	}
+
+	// A cached verdict settles identical pairs, and differing pairs when
+	// only the verdict is printed
+	var key string
+	var sig1, sig2 fileSignature
+	if state.cache != nil {
+		var err error
+		key = cacheKey(state.root, path1)
+		if sig1, sig2, err = state.signatures(path1, path2); err == nil {
+			if identical, ok := state.cache.lookup(key, sig1, sig2); ok && (identical || bool(p.Flags.Brief) && !bool(p.Flags.TreeStat) && p.Flags.Bundle == nil && state.report == nil) {
+				state.cache.record(key, sig1, sig2, identical)
+				if !identical {
+					state.differ++
+					_, _ = fmt.Fprintln(stdout, p.paint(sgrYellow, fmt.Sprintf("Files %s and %s differ", p.displayPath(path1), p.displayPath(path2))))
+				}
+				return
+			}
+		}
+	}
+
+	// A file that changed while it was read is still compared, and makes
+	// the comparison trouble
+	changed := false
+	lines1, err := state.trees[0].readLines(p, &state.left, path1)
+	if trouble := p.changedTrouble(stderr, path1, err); trouble != nil {
+		state.trouble, changed = trouble, true
+	} else if err != nil {
+		p.notice(stderr, "%s: %v", path1, err)
+		state.fail(path1, err)
+		return
+	}
+	lines2, err := state.trees[1].readLines(p, &state.right, path2)
+	if trouble := p.changedTrouble(stderr, path2, err); trouble != nil {
+		state.trouble, changed = trouble, true
+	} else if err != nil {
+		p.notice(stderr, "%s: %v", path2, err)
+		state.fail(path2, err)
+		return
+	}
+
+	p.Flags.open = p.Flags.openEnds(path1, path2)
+	identical := p.identical(lines1, lines2)
+	if state.cache != nil && !changed {
+		state.cache.record(key, sig1, sig2, identical)
+	}
+	binary := p.Flags.binaryPair(path1, path2)
+	if identical || bool(p.Flags.TreeStat) || bool(p.Flags.Brief) || p.Flags.Bundle != nil || binary {
+		// diffLines logs the comparisons it prints
+		p.logComparison(path1, path2, lines1, lines2, nil, identical)
+	}
+	if identical {
+		if p.Flags.StatsHook != nil {
+			p.reportStats(path1, path2, lines1, lines2, p.compare(lines1, lines2))
+		}
+		return
+	}
+	state.differ++
+	p.bundlePair(state, path1, path2, lines1, lines2)
+	p.reportPair(stderr, state, path1, path2, lines1, lines2, binary)
+
+	// TreeStat needs the counts of the edit script, never its text
+	if bool(p.Flags.TreeStat) {
+		script := p.compare(lines1, lines2)
+		p.reportStats(path1, path2, lines1, lines2, script)
+		stats := scriptStats(script, 0)
+		state.stats = append(state.stats, statRow{name: cacheKey(state.root, path1), insertions: stats.Insertions, deletions: stats.Deletions})
+		return
+	}
+	if bool(p.Flags.Brief) || p.Flags.Bundle != nil {
+		if p.Flags.StatsHook != nil {
+			p.reportStats(path1, path2, lines1, lines2, p.compare(lines1, lines2))
+		}
+		_, _ = fmt.Fprintln(stdout, p.paint(sgrYellow, fmt.Sprintf("Files %s and %s differ", p.displayPath(path1), p.displayPath(path2))))
+		return
+	}
+	if binary {
+		// GNU diff prints no banner for binary files
+		if p.Flags.StatsHook != nil {
+			p.reportStats(path1, path2, lines1, lines2, p.compare(lines1, lines2))
+		}
+		p.writeBinaryDiffer(stdout, path1, path2)
+		return
+	}
+	p.writeBanner(stdout, path1, path2)
+	if err := p.diffLines(stdout, path1, path2, lines1, lines2); err != nil && !errors.Is(err, ErrFilesDiffer) {
+		state.abort(err)
+	}
+}
+
+// writeBanner introduces the diff of one pair with a "diff <options> a b"
+// line, unless NoBanner is set
+func (p command) writeBanner(w io.Writer, path1, path2 string) {
+	if bool(p.Flags.NoBanner) {
+		return
+	}
+	name1, name2 := p.hyperlink(path1, 1, p.displayPath(path1)), p.hyperlink(path2, 1, p.displayPath(path2))
+	if options := p.Flags.bannerOptions(); options != "" {
+		_, _ = fmt.Fprintf(w, "diff %s %s %s\n", options, name1, name2)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "diff %s %s\n", name1, name2)
+}
@@ -0,0 +1,446 @@
+package command_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// writeTree creates files below dir from slash-separated relative paths
+func writeTree(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Dir(path), filepath.Base(path), content)
+	}
+	return dir
+}
+
+// fixtureTrees builds two small trees that differ at the top level and below it
+func fixtureTrees(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	dir1 := writeTree(t, filepath.Join(root, "dir1"), map[string]string{
+		"same.txt":       "same\n",
+		"changed.txt":    "old\n",
+		"only1.txt":      "left\n",
+		"sub/nested.txt": "nested old\n",
+	})
+	dir2 := writeTree(t, filepath.Join(root, "dir2"), map[string]string{
+		"same.txt":       "same\n",
+		"changed.txt":    "new\n",
+		"only2.txt":      "right\n",
+		"sub/nested.txt": "nested new\n",
+	})
+	return dir1, dir2
+}
+
+func TestDiff_DirectoriesNonRecursive(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	stdout, _, err := execute(command.Diff(dir1, dir2), "")
+	assertDiffers(t, err)
+	want := "diff " + dir1 + "/changed.txt " + dir2 + "/changed.txt\n" +
+		"1c1\n< old\n---\n> new\n" +
+		"Only in " + dir1 + ": only1.txt\n" +
+		"Only in " + dir2 + ": only2.txt\n" +
+		"Common subdirectories: " + dir1 + "/sub and " + dir2 + "/sub\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if strings.Contains(stdout, "nested") {
+		t.Errorf("non-recursive comparison descended into a subdirectory:\n%s", stdout)
+	}
+}
+
+func TestDiff_DirectoriesRecursive(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief), "")
+	assertDiffers(t, err)
+	want := "Files " + dir1 + "/changed.txt and " + dir2 + "/changed.txt differ\n" +
+		"Only in " + dir1 + ": only1.txt\n" +
+		"Only in " + dir2 + ": only2.txt\n" +
+		"Files " + dir1 + "/sub/nested.txt and " + dir2 + "/sub/nested.txt differ\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_FileAgainstDirectory(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	stdout, _, err := execute(command.Diff(filepath.Join(dir1, "changed.txt"), dir2), "")
+	assertDiffers(t, err)
+	if stdout != "1c1\n< old\n---\n> new\n" {
+		t.Errorf("got %q", stdout)
+	}
+}
+
+func TestDiff_DirectoriesColoredBriefSummary(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.ColorAlways), "")
+	assertDiffers(t, err)
+	want := "\x1b[33mFiles " + dir1 + "/changed.txt and " + dir2 + "/changed.txt differ\x1b[0m\n" +
+		"\x1b[31mOnly in " + dir1 + ": only1.txt\x1b[0m\n" +
+		"\x1b[32mOnly in " + dir2 + ": only2.txt\x1b[0m\n" +
+		"\x1b[33mFiles " + dir1 + "/sub/nested.txt and " + dir2 + "/sub/nested.txt differ\x1b[0m\n" +
+		"\x1b[1m2 differ, 1 only in " + dir1 + ", 1 only in " + dir2 + "\x1b[0m\n"
+	if stdout != want {
+		t.Errorf("got:\n%q\nwant:\n%q", stdout, want)
+	}
+}
+
+func TestDiff_DirectoriesColorNeverIsPlain(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	plain, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief), "")
+	assertDiffers(t, err)
+	never, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.ColorNever), "")
+	assertDiffers(t, err)
+	auto, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.ColorAuto), "")
+	assertDiffers(t, err)
+	if never != plain || auto != plain {
+		t.Errorf("color off should not change output:\nplain %q\nnever %q\nauto  %q", plain, never, auto)
+	}
+}
+
+func TestDiff_DirectoryBanners(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Unified, command.UnifiedContext(5), command.IgnoreCase), "")
+	assertDiffers(t, err)
+	for _, name := range []string{"changed.txt", "sub/nested.txt"} {
+		banner := "diff -r -U 5 -i " + dir1 + "/" + name + " " + dir2 + "/" + name + "\n"
+		if strings.Count(stdout, banner) != 1 {
+			t.Errorf("expected one banner %q, got:\n%s", banner, stdout)
+		}
+	}
+	if strings.Count(stdout, "diff -r") != 2 {
+		t.Errorf("expected exactly two banners, got:\n%s", stdout)
+	}
+
+	stdout, _, err = execute(command.Diff(dir1, dir2, command.Recursive, command.Unified, command.NoBanner), "")
+	assertDiffers(t, err)
+	if strings.Contains(stdout, "diff ") {
+		t.Errorf("NoBanner still printed banners:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "+++ "+dir2+"/sub/nested.txt\n") {
+		t.Errorf("NoBanner dropped the diff itself:\n%s", stdout)
+	}
+}
+
+// manyFileTrees builds two trees of n small files where every third file differs
+func manyFileTrees(tb testing.TB, n int) (string, string) {
+	tb.Helper()
+	root := tb.TempDir()
+	for _, side := range []string{"left", "right"} {
+		for i := 0; i < n; i++ {
+			dir := filepath.Join(root, side, fmt.Sprintf("d%02d", i%20))
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				tb.Fatal(err)
+			}
+			// Sizes vary so a reused buffer would show stale lines
+			content := strings.Repeat(fmt.Sprintf("file %d line\n", i), 1+(i*7)%13)
+			if side == "right" && i%3 == 0 {
+				content = fmt.Sprintf("changed %d\n", i) + content[:len(content)/2]
+			}
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%04d.txt", i)), []byte(content), 0o644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return filepath.Join(root, "left"), filepath.Join(root, "right")
+}
+
+func BenchmarkDiff_ManySmallFiles(b *testing.B) {
+	left, right := manyFileTrees(b, 2000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := execute(command.Diff(left, right, command.Recursive, command.Unified), ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDiff_DirectoryPairsMatchSeparateRuns(t *testing.T) {
+	left, right := manyFileTrees(t, 120)
+
+	stdout, _, err := execute(command.Diff(left, right, command.Recursive, command.Unified, command.NoBanner), "")
+	assertDiffers(t, err)
+
+	// Every pair read through the walk's reused buffers must produce exactly
+	// what a fresh two-file run produces
+	var want strings.Builder
+	var names []string
+	err = filepath.WalkDir(left, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(left, path)
+			names = append(names, rel)
+		}
+		return err
+	})
+	assertion.NoError(t, err)
+	for _, name := range names {
+		single, _, err := execute(command.Diff(filepath.Join(left, name), filepath.Join(right, name), command.Unified), "")
+		assertStatus(t, err, single != "")
+		want.WriteString(single)
+	}
+	if stdout != want.String() {
+		t.Errorf("walk output differs from separate runs:\n%s\nwant:\n%s", stdout, want.String())
+	}
+	if !strings.Contains(stdout, "+changed 0\n") {
+		t.Fatalf("fixture produced no differences:\n%s", stdout)
+	}
+}
+
+func TestDiff_DirectoriesReverse(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	for _, opts := range [][]any{{command.Recursive}, {command.Recursive, command.Brief, command.Unified}} {
+		reversed, _, _ := execute(command.Diff(append([]any{dir1, dir2, command.Reverse}, opts...)...), "")
+		swapped, _, _ := execute(command.Diff(append([]any{dir2, dir1}, opts...)...), "")
+		if reversed != swapped {
+			t.Errorf("got:\n%s\nwant:\n%s", reversed, swapped)
+		}
+	}
+}
+
+func TestDiff_DirectoriesShowIndex(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	stdout, _, _ := execute(command.Diff(dir1, dir2, command.Unified, command.ShowIndex), "")
+	want := "diff -u " + dir1 + "/changed.txt " + dir2 + "/changed.txt\n" +
+		"index 281bac2b704617e807850e07e54bae3469f6a2e7..389cc6b7ae5a659383eab5dfc253764eccf84732\n" +
+		"--- " + dir1 + "/changed.txt\n"
+	if !strings.HasPrefix(stdout, want) {
+		t.Errorf("got:\n%s\nwant prefix:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_TreeStat(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	writeFile(t, dir1, "grown.txt", "a\n")
+	writeFile(t, dir2, "grown.txt", "a\nb\nc\n")
+
+	stdout, _, _ := execute(command.Diff(dir1, dir2, command.Recursive, command.TreeStat), "")
+	want := " changed.txt    | 2 +-\n" +
+		" grown.txt      | 2 ++\n" +
+		" sub/nested.txt | 2 +-\n" +
+		"Only in " + dir1 + ": only1.txt\n" +
+		"Only in " + dir2 + ": only2.txt\n" +
+		" 3 files changed, 4 insertions(+), 2 deletions(-)\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_TreeStatScalesBars(t *testing.T) {
+	root := t.TempDir()
+	dir1 := writeTree(t, filepath.Join(root, "a"), map[string]string{
+		"big.txt":   "",
+		"small.txt": "x\n",
+		"same.txt":  "same\n",
+	})
+	dir2 := writeTree(t, filepath.Join(root, "b"), map[string]string{
+		"big.txt":   strings.Repeat("line\n", 100),
+		"small.txt": "y\n",
+		"same.txt":  "same\n",
+	})
+
+	stdout, _, _ := execute(command.Diff(dir1, dir2, command.TreeStat, command.Width(40)), "")
+	// 40 columns less the name, count and separators leave a 23-column bar
+	want := " big.txt   | 100 " + strings.Repeat("+", 23) + "\n" +
+		" small.txt |   2 +-\n" +
+		" 2 files changed, 101 insertions(+), 1 deletion(-)\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_OneFileSystem(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	writeTree(t, filepath.Join(dir1, "mnt"), map[string]string{"remote.txt": "left\n"})
+	writeTree(t, filepath.Join(dir2, "mnt"), map[string]string{"remote.txt": "right\n"})
+
+	// mnt on the left is a mount point
+	devices := command.DeviceID(func(path string) (uint64, bool) {
+		if strings.HasPrefix(path, filepath.Join(dir1, "mnt")) {
+			return 2, true
+		}
+		return 1, true
+	})
+	stdout, stderr, _ := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.OneFileSystem, devices), "")
+	if strings.Contains(stdout, "remote.txt") || !strings.Contains(stdout, "nested.txt") {
+		t.Errorf("only the other file system should be skipped:\n%s", stdout)
+	}
+	if want := "diff: " + filepath.Join(dir1, "mnt") + " and " + filepath.Join(dir2, "mnt") + ": not descending into another file system\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+
+	stdout, _, _ = execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, devices), "")
+	if !strings.Contains(stdout, "remote.txt") {
+		t.Errorf("without OneFileSystem every directory is compared:\n%s", stdout)
+	}
+
+	// Devices that cannot be told apart never stop the walk
+	unknown := command.DeviceID(func(string) (uint64, bool) { return 0, false })
+	stdout, _, _ = execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.OneFileSystem, unknown), "")
+	if !strings.Contains(stdout, "remote.txt") {
+		t.Errorf("unknown devices should be ignored:\n%s", stdout)
+	}
+
+	// The real device lookup finds both trees on one file system
+	stdout, _, _ = execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.OneFileSystem), "")
+	if !strings.Contains(stdout, "remote.txt") {
+		t.Errorf("a single file system should be walked whole:\n%s", stdout)
+	}
+}
+
+func TestDiff_SymlinkCycle(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	writeTree(t, filepath.Join(dir1, "a"), map[string]string{"file.txt": "a\n"})
+	writeTree(t, filepath.Join(dir2, "a"), map[string]string{"file.txt": "a\n"})
+	for _, dir := range []string{dir1, dir2} {
+		// loop leads back to the root of its tree, shared to a directory
+		// beside it, which is compared a second time
+		if err := os.Symlink("..", filepath.Join(dir, "a", "loop")); err != nil {
+			t.Skip("symlinks unsupported:", err)
+		}
+		if err := os.Symlink("sub", filepath.Join(dir, "shared")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief), "")
+	assertDiffers(t, err)
+	var fileErr *command.FileError
+	if errors.As(err, &fileErr) {
+		t.Errorf("a cycle is no trouble: %v", err)
+	}
+	if want := "diff: not descending into " + filepath.Join(dir1, "a", "loop") + ": symlink cycle detected\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+	for _, name := range []string{"changed.txt", "only1.txt", "sub/nested.txt", "shared/nested.txt"} {
+		if !strings.Contains(stdout, name) {
+			t.Errorf("the rest of the tree should be compared, missing %s:\n%s", name, stdout)
+		}
+	}
+	if strings.Contains(stdout, "loop/") {
+		t.Errorf("the cycle was descended into:\n%s", stdout)
+	}
+}
+
+func TestDiff_QuietRecursive(t *testing.T) {
+	// A file without read permission is readable by root, but a dangling
+	// symlink is unreadable to anyone
+	dir1, dir2 := fixtureTrees(t)
+	secret := writeFile(t, dir1, "secret.txt", "hidden\n")
+	writeFile(t, dir2, "secret.txt", "hidden\n")
+	if err := os.Chmod(secret, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(secret, 0o644) })
+	if err := os.Symlink(filepath.Join(t.TempDir(), "missing"), filepath.Join(dir1, "dangling")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	writeFile(t, dir2, "dangling", "x\n")
+
+	loudOut, loudErr, loud := execute(command.Diff(dir1, dir2, command.Recursive), "")
+	quietOut, quietErr, quiet := execute(command.Diff(dir1, dir2, command.Recursive, command.Quiet), "")
+	var fileErr *command.FileError
+	if !errors.As(loud, &fileErr) || quiet == nil || quiet.Error() != loud.Error() {
+		t.Errorf("got %v and %v under Quiet, want the trouble of an unreadable entry", loud, quiet)
+	}
+	if quietOut != loudOut || !strings.Contains(loudOut, "changed.txt") {
+		t.Errorf("got stdout:\n%s\nunder Quiet, want:\n%s", quietOut, loudOut)
+	}
+	wants := []string{"diff: " + filepath.Join(dir1, "dangling") + ": "}
+	if os.Geteuid() != 0 {
+		wants = append(wants, "diff: "+secret+": ")
+	}
+	for _, want := range wants {
+		if !strings.Contains(loudErr, want) {
+			t.Errorf("missing %q in stderr %q", want, loudErr)
+		}
+	}
+	if quietErr != "" {
+		t.Errorf("got stderr %q under Quiet", quietErr)
+	}
+}
+
+// caseTrees builds a left tree holding two names that differ only in case
+// and a right tree holding one of them spelled a third way
+func caseTrees(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	dir1 := writeTree(t, filepath.Join(root, "dir1"), map[string]string{
+		"Readme.md": "upper\n",
+		"readme.md": "lower\n",
+		"other.txt": "same\n",
+	})
+	dir2 := writeTree(t, filepath.Join(root, "dir2"), map[string]string{
+		"README.md": "lower\n",
+		"readme.md": "lower\n",
+		"other.txt": "same\n",
+	})
+	return dir1, dir2
+}
+
+func TestDiff_NamesDifferingOnlyInCase(t *testing.T) {
+	dir1, dir2 := caseTrees(t)
+
+	stdout, stderr, _ := execute(command.Diff(dir1, dir2, command.Brief), "")
+	want := "diff: " + dir1 + ": names differ only in case: Readme.md, readme.md\n" +
+		"diff: " + dir2 + ": names differ only in case: README.md, readme.md\n"
+	if stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+	want = "Only in " + dir2 + ": README.md\nOnly in " + dir1 + ": Readme.md\n"
+	if stdout != want {
+		t.Errorf("exact names should pair:\ngot  %q\nwant %q", stdout, want)
+	}
+}
+
+func TestDiff_IgnoreFileNameCasePairsDeterministically(t *testing.T) {
+	dir1, dir2 := caseTrees(t)
+
+	// readme.md pairs with its exact match, leaving Readme.md for README.md
+	stdout, stderr, _ := execute(command.Diff(dir1, dir2, command.Brief, command.IgnoreFileNameCase), "")
+	want := "Files " + filepath.Join(dir1, "Readme.md") + " and " + filepath.Join(dir2, "README.md") + " differ\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "Readme.md, readme.md") {
+		t.Errorf("collision warning missing: %q", stderr)
+	}
+
+	for range 5 {
+		again, _, _ := execute(command.Diff(dir1, dir2, command.Brief, command.IgnoreFileNameCase), "")
+		if again != stdout {
+			t.Fatalf("pairing changed between runs:\n%s\nwant:\n%s", again, stdout)
+		}
+	}
+
+	// Without exact matches the names pair in lexicographic order
+	root := t.TempDir()
+	left := writeTree(t, filepath.Join(root, "left"), map[string]string{"A.txt": "1\n", "a.txt": "2\n"})
+	right := writeTree(t, filepath.Join(root, "right"), map[string]string{"A.TXT": "1\n", "a.TXT": "x\n", "A.Txt": "3\n"})
+	stdout, _, _ = execute(command.Diff(left, right, command.Brief, command.IgnoreFileNameCase), "")
+	want = "Files " + filepath.Join(left, "a.txt") + " and " + filepath.Join(right, "A.Txt") + " differ\n" +
+		"Only in " + right + ": a.TXT\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// outputEdScript outputs an ed script that turns lines1 into lines2, as GNU
+// diff -e does: a command for each run of changes, such as 5c, 3a or 7,9d,
+// the last run first, followed for a and c by the new lines ended by a lone
+// "." as writeEdText writes them. ed terminates every line it writes, so a
+// script for a file without a final newline comes with a note of it on
+// stderr, as from GNU diff.
+func (p command) outputEdScript(w io.Writer, file1Path, file2Path string, lines1, lines2 []string, script []edit) {
+	hunks := p.groupHunks(script, lines1, lines2, 0, 0, 0)
+	for i := len(hunks) - 1; i >= 0; i-- {
+		command := edCommand(hunks[i])
+		fmt.Fprintf(w, "%s%c\n", p.normalRange(0, hunks[i].startA, hunks[i].countA), command)
+		if command != 'd' {
+			writeEdText(w, hunks[i], lines2)
+		}
+	}
+	p.noteEdNewlines(hunks, file1Path, file2Path)
+}
+
+// outputForwardEdScript outputs the changes as GNU diff -f does: like an ed
+// script, but in forward order with the command before the lines of the
+// first file it applies to, such as c5 7, a3 or d9
+func (p command) outputForwardEdScript(w io.Writer, file1Path, file2Path string, lines1, lines2 []string, script []edit) {
+	hunks := p.groupHunks(script, lines1, lines2, 0, 0, 0)
+	for _, h := range hunks {
+		command := edCommand(h)
+		fmt.Fprintf(w, "%c%s\n", command, strings.ReplaceAll(p.normalRange(0, h.startA, h.countA), ",", " "))
+		if command != 'd' {
+			writeEdText(w, h, lines2)
+		}
+	}
+	p.noteEdNewlines(hunks, file1Path, file2Path)
+}
+
+// edCommand is the ed command of a hunk: a when it only inserts, d when it
+// only deletes and c otherwise
+func edCommand(h hunk) rune {
+	switch {
+	case h.countA == 0:
+		return 'a'
+	case h.countB == 0:
+		return 'd'
+	}
+	return 'c'
+}
+
+// writeEdText writes the inserted lines of h, ended by a lone ".". A line
+// that is just "." would end the text, so it is written as "..", the text
+// ended, the extra dot removed with s/.// and the rest appended after it.
+// GNU diff -f writes such a line as is, which its readers cannot tell from
+// the end of the text.
+func writeEdText(w io.Writer, h hunk, lines2 []string) {
+	appending := true
+	for _, e := range h.edits {
+		if e.kind != opInsert {
+			continue
+		}
+		if !appending {
+			fmt.Fprintln(w, "a")
+			appending = true
+		}
+		if lines2[e.b] == "." {
+			fmt.Fprint(w, "..\n.\ns/.//\n")
+			appending = false
+			continue
+		}
+		fmt.Fprintln(w, lines2[e.b])
+	}
+	if appending {
+		fmt.Fprintln(w, ".")
+	}
+}
+
+// noteEdNewlines notes on stderr each file without a final newline when
+// hunks were printed for it as an ed script
+func (p command) noteEdNewlines(hunks []hunk, file1Path, file2Path string) {
+	if p.Flags.stderr == nil || len(hunks) == 0 {
+		return
+	}
+	for side, path := range [2]string{file1Path, file2Path} {
+		if p.Flags.open[side] {
+			p.notice(p.Flags.stderr, "%s: No newline at end of file", p.displayPath(path))
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package command_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// edCommand matches the commands of the ed scripts EdScript prints
+var edCommand = regexp.MustCompile(`^(\d+)(?:,(\d+))?([acd])$|^a$`)
+
+// applyEd runs script on lines the way ed would, knowing only the commands
+// EdScript prints: a, c and d on a line or range, the text they append
+// ended by a lone ".", and s/.// on the current line
+func applyEd(lines []string, script string) ([]string, error) {
+	lines = slices.Clone(lines)
+	current := len(lines)
+	text := strings.Split(strings.TrimSuffix(script, "\n"), "\n")
+	if script == "" {
+		text = nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == "s/.//" {
+			if current < 1 || lines[current-1] == "" {
+				return nil, fmt.Errorf("line %d: nothing to substitute", i+1)
+			}
+			lines[current-1] = lines[current-1][1:]
+			continue
+		}
+		m := edCommand.FindStringSubmatch(text[i])
+		if m == nil {
+			return nil, fmt.Errorf("line %d: unknown command %q", i+1, text[i])
+		}
+		first, last, command := current, current, "a"
+		if m[1] != "" {
+			first, _ = strconv.Atoi(m[1])
+			last, command = first, m[3]
+			if m[2] != "" {
+				last, _ = strconv.Atoi(m[2])
+			}
+		}
+		if first > last || last > len(lines) || (command != "a" && first < 1) {
+			return nil, fmt.Errorf("line %d: range %d,%d of %d lines", i+1, first, last, len(lines))
+		}
+		at := last
+		if command != "a" {
+			lines = slices.Delete(lines, first-1, last)
+			at = first - 1
+			current = min(first, len(lines))
+		}
+		if command == "d" {
+			continue
+		}
+		current = at
+		for i++; i < len(text) && text[i] != "."; i++ {
+			lines = slices.Insert(lines, current, text[i])
+			current++
+		}
+		if i == len(text) {
+			return nil, fmt.Errorf("text of %q is not ended", m[0])
+		}
+	}
+	return lines, nil
+}
+
+func TestDiff_EdScript(t *testing.T) {
+	// The changes come last first, and a lone dot has the standard
+	// workaround. A bare "a" appends after the line the dot was put on.
+	a := []string{"one", "two", "three", "four", "five"}
+	b := []string{"zero", "one", ".", "three", "3.5", "five", "."}
+	result, err := command.Run(context.Background(), command.LinesSource("a", a), command.LinesSource("b", b), command.EdScript)
+	assertion.NoError(t, err)
+	want := "5a\n..\n.\ns/.//\n4c\n3.5\n.\n2c\n..\n.\ns/.//\n0a\nzero\n.\n"
+	if got := result.Text(command.FormatEd); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	script := "2c\nx\n..\n.\ns/.//\na\ny\n.\n1d\n"
+	if got, err := applyEd([]string{"p", "q", "r"}, script); err != nil || !slices.Equal(got, []string{"x", ".", "y", "r"}) {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	// Identical inputs need no commands
+	result, err = command.Run(context.Background(), command.LinesSource("a", a), command.LinesSource("b", a), command.EdScript)
+	assertion.NoError(t, err)
+	if got := result.Text(command.FormatEd); got != "" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestDiff_EdScriptRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(5, 0))
+	dots := func(lines []string) []string {
+		for i := range lines {
+			if r.IntN(5) == 0 {
+				lines[i] = "."
+			}
+		}
+		return lines
+	}
+	for i := 0; i < 500; i++ {
+		a := dots(randomLines(r, 30))
+		b := dots(mutate(r, a))
+		if r.IntN(4) == 0 {
+			b = dots(randomLines(r, 30))
+		}
+		for _, opts := range [][]any{{command.EdScript}, {command.EdScript, command.Minimal}, {command.EdScript, command.Histogram}} {
+			result, err := command.Run(context.Background(), command.LinesSource("a", a), command.LinesSource("b", b), opts...)
+			assertion.NoError(t, err)
+			script := result.Text(command.FormatEd)
+			got, err := applyEd(a, script)
+			if err != nil || !slices.Equal(got, b) {
+				t.Fatalf("%v: %q with\n%s\ngave %q, %v, want %q", opts, a, script, got, err, b)
+			}
+		}
+	}
+}
+
+func TestDiff_ForwardEdScript(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{"add", []string{"a", "b"}, []string{"x", "a", "b", "y", "z"}, "a0\nx\n.\na2\ny\nz\n.\n"},
+		{"delete", []string{"a", "b", "c", "d", "e"}, []string{"a", "e"}, "d2 4\n"},
+		{"change", []string{"a", "b", "c", "d"}, []string{"a", "B", "C", "D", "d"}, "c2 3\nB\nC\nD\n.\n"},
+		{"groups", []string{"a", "b", "c", "d", "e", "f"}, []string{"b", "c", ".", "e", "f", "g"}, "d1\nc4\n..\n.\ns/.//\na6\ng\n.\n"},
+	}
+	for _, tt := range tests {
+		result, err := command.Run(context.Background(), command.LinesSource("a", tt.a), command.LinesSource("b", tt.b), command.ForwardEdScript)
+		assertion.NoError(t, err)
+		if got := result.Text(command.FormatForwardEd); got != tt.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	// The commands are those of the ed script, in the other order and
+	// with their lines after the letter
+	r := rand.New(rand.NewPCG(7, 0))
+	block := regexp.MustCompile(`(?m)^([acd])(\d+)(?: (\d+))?$`)
+	for i := 0; i < 200; i++ {
+		a := randomLines(r, 30)
+		b := mutate(r, a)
+		result, err := command.Run(context.Background(), command.LinesSource("a", a), command.LinesSource("b", b))
+		assertion.NoError(t, err)
+		texts := block.Split(result.Text(command.FormatForwardEd), -1)
+		commands := block.FindAllStringSubmatch(result.Text(command.FormatForwardEd), -1)
+		var ed strings.Builder
+		for j := len(commands) - 1; j >= 0; j-- {
+			ed.WriteString(commands[j][2])
+			if commands[j][3] != "" {
+				ed.WriteString("," + commands[j][3])
+			}
+			ed.WriteString(commands[j][1] + texts[j+1])
+		}
+		if want := result.Text(command.FormatEd); ed.String() != want {
+			t.Fatalf("%q and %q: got\n%s\nwant\n%s", a, b, ed.String(), want)
+		}
+	}
+}
+
+func TestDiff_EdScriptNoNewlineAtEnd(t *testing.T) {
+	// A script cannot remove the newline, which is no difference of its
+	// own and is noted whenever a script is printed
+	stdout, stderr, err := execute(command.Diff("testdata/gnu/newline-removed/a", "testdata/gnu/newline-removed/b", command.EdScript), "")
+	assertion.NoError(t, err)
+	if stdout != "" || stderr != "" {
+		t.Errorf("got %q and %q", stdout, stderr)
+	}
+
+	stdout, stderr, err = execute(command.Diff("testdata/gnu/no-newline/a", "testdata/gnu/no-newline/b", command.EdScript), "")
+	assertStatus(t, err, true)
+	if stdout != "2c\nc\n.\n" {
+		t.Errorf("got %q", stdout)
+	}
+	want := "diff: testdata/gnu/no-newline/a: No newline at end of file\ndiff: testdata/gnu/no-newline/b: No newline at end of file\n"
+	if stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+
+	_, stderr, _ = execute(command.Diff("testdata/gnu/no-newline/a", "testdata/gnu/no-newline/b", command.EdScript, command.Quiet), "")
+	if stderr != "" {
+		t.Errorf("got %q under Quiet", stderr)
+	}
+}
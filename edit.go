@@ -0,0 +1,542 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// asciiSpace is the whitespace RawBytes comparisons ignore
+const asciiSpace = " \t\n\v\f\r"
+
+// opKind identifies what an edit does to a line
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// edit is one line of an edit script. a is the zero-based line index in the
+// first file and b the index in the second; for an insert a is the index of
+// the next line of the first file, for a delete b is the index of the next
+// line of the second file. move is non-zero on both halves of a block that
+// DetectMoves found deleted in one place and inserted in another.
+type edit struct {
+	kind opKind
+	a, b int
+	move int
+}
+
+// hunk is a run of edits with the surrounding context lines included
+type hunk struct {
+	edits          []edit
+	startA, countA int
+	startB, countB int
+}
+
+// compare builds the edit script turning lines1 into lines2. The common
+// prefix and suffix are matched first, less the horizon lines next to the
+// changes, the suffix only among the lines the prefix leaves so that the
+// two never overlap; when one side is used up by them the remainder is a
+// single insertion or deletion and no further matching is needed, which is
+// the common case for appended or truncated logs. Otherwise the remaining
+// lines are compared with Myers' algorithm the way GNU diff compares them
+// (see analyze), or with the patience or histogram algorithm the way git
+// does (see patienceDiff and histogramDiff) when Algorithm selects them, and
+// each run of changes becomes the deletion of the old lines followed by the
+// insertion of the new ones.
+func (p command) compare(lines1, lines2 []string) []edit {
+	script, _ := p.compareSettled(lines1, lines2)
+	return script
+}
+
+// compareSettled builds the edit script as compare does, and reports
+// whether a split of the search ran out of its CostLimit and settled for a
+// good split, so the script may change more lines than needed
+func (p command) compareSettled(lines1, lines2 []string) ([]edit, bool) {
+	if p.Flags.CompareHook != nil {
+		p.Flags.CompareHook()
+	}
+	last1, last2 := p.openLast(len(lines1), len(lines2))
+	equal := func(i, j int) bool {
+		return (i == last1) == (j == last2) && p.linesEqual(lines1[i], lines2[j])
+	}
+	horizon := p.Flags.horizonLines()
+	prefix := 0
+	for prefix < len(lines1) && prefix < len(lines2) && equal(prefix, prefix) {
+		prefix++
+	}
+	prefix -= min(prefix, horizon)
+	suffix := 0
+	for suffix < len(lines1)-prefix && suffix < len(lines2)-prefix &&
+		equal(len(lines1)-1-suffix, len(lines2)-1-suffix) {
+		suffix++
+	}
+	suffix -= min(suffix, horizon)
+	end1, end2 := len(lines1)-suffix, len(lines2)-suffix
+
+	script := make([]edit, 0, len(lines1)+len(lines2)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		script = append(script, edit{kind: opEqual, a: i, b: i})
+	}
+
+	algorithm := "myers"
+	cost := &searchCost{limit: int(p.Flags.CostLimit), usage: p.Flags.usage}
+	switch {
+	case prefix == end1:
+		// Pure insertion: append, prepend, or lines added in the middle
+		algorithm = "insertion"
+		for j := prefix; j < end2; j++ {
+			script = append(script, edit{kind: opInsert, a: prefix, b: j})
+		}
+	case prefix == end2:
+		// Pure deletion: truncation, or lines removed from the middle
+		algorithm = "deletion"
+		for i := prefix; i < end1; i++ {
+			script = append(script, edit{kind: opDelete, a: i, b: prefix})
+		}
+	default:
+		classes1, classes2, count := p.lineClasses(lines1[prefix:end1], lines2[prefix:end2])
+		count = separateOpenEnds(classes1, classes2, count, last1-prefix, last2-prefix)
+		var changed1, changed2 marks
+		chosen := p.Flags.algorithm()
+		algorithm = chosen.String()
+		switch chosen {
+		case AlgorithmHistogram:
+			changed1, changed2 = histogramDiff(classes1, classes2, count, cost)
+		case AlgorithmPatience:
+			changed1, changed2 = patienceDiff(classes1, classes2, count, cost)
+		default:
+			changed1, changed2 = analyze(classes1, classes2, count, chosen == AlgorithmMinimal, bool(p.Flags.SpeedLargeFiles), cost)
+		}
+		script = scriptFromMarks(script, changed1, changed2, end1-prefix, end2-prefix, prefix)
+	}
+	if p.Flags.Logger != nil {
+		p.Flags.Logger.Debug("compare", "algorithm", algorithm, "lines1", len(lines1), "lines2", len(lines2), "prefix", prefix, "suffix", suffix)
+	}
+
+	for k := 0; k < suffix; k++ {
+		script = append(script, edit{kind: opEqual, a: end1 + k, b: end2 + k})
+	}
+
+	if bool(p.Flags.DetectMoves) {
+		p.detectMoves(script, lines1, lines2, int(p.Flags.MoveMinLines))
+	}
+	return script, cost.settled > 0
+}
+
+// noteNotMinimal notes on stderr that the script printed for a pair may not
+// be minimal when its search settled
+func (p command) noteNotMinimal(file1Path, file2Path string, settled bool) {
+	if settled && p.Flags.stderr != nil {
+		p.notice(p.Flags.stderr, "%s and %s: diff may not be minimal", p.displayPath(file1Path), p.displayPath(file2Path))
+	}
+}
+
+// editDistance is the number of inserted plus deleted lines in a minimal
+// edit script turning lines1 into lines2, found with the greedy algorithm of
+// Myers' "An O(ND) Difference Algorithm" without recording the script itself
+func (p command) editDistance(lines1, lines2 []string) int {
+	d, _ := p.editDistanceWithin(lines1, lines2, len(lines1)+len(lines2))
+	return d
+}
+
+// editDistanceWithin is the editDistance of lines1 and lines2 when it is at
+// most limit, and limit+1 otherwise: the search stops once it has taken
+// more than limit edit steps, so it takes time proportional to limit times
+// the length of the inputs. examined is how many lines of an edit script
+// the search got through.
+func (p command) editDistanceWithin(lines1, lines2 []string, limit int) (distance, examined int) {
+	n, m := len(lines1), len(lines2)
+	if n == 0 || m == 0 {
+		if n+m > limit {
+			return limit + 1, 0
+		}
+		return n + m, n + m
+	}
+
+	// v[k+offset] is the furthest x reached on diagonal k = x - y
+	offset := limit + 1
+	v := make([]int, 2*offset+2)
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset]
+			} else {
+				x = v[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && p.linesEqual(lines1[x], lines2[y]) {
+				x++
+				y++
+			}
+			v[k+offset] = x
+			examined = max(examined, (min(x, n)+min(y, m)+d)/2)
+			if x >= n && y >= m {
+				return d, examined
+			}
+		}
+	}
+	return limit + 1, examined
+}
+
+// exceedsCount reports whether lines1 and lines2 differ in more lines than
+// CountThreshold when CountOnly is all that is printed of them. The search
+// stops once it has taken more edit steps than the threshold, which no
+// script can then stay within, so no script is built; Progress learns how
+// far the search got.
+func (p command) exceedsCount(lines1, lines2 []string) bool {
+	threshold := int(p.Flags.CountThreshold)
+	if threshold <= 0 || p.primaryFormat() != FormatCount || len(p.Flags.Tees) > 0 || p.Flags.StatsHook != nil || bool(p.Flags.Brief) {
+		return false
+	}
+	distance, examined := p.editDistanceWithin(lines1, lines2, threshold)
+	if distance <= threshold {
+		return false
+	}
+	if p.Flags.Progress != nil {
+		p.Flags.Progress(examined)
+	}
+	return true
+}
+
+// normalizes reports whether any option makes compareKey differ from the
+// line itself
+func (f flags) normalizes() bool {
+	return bool(f.IgnoreCase) || bool(f.IgnoreWhitespace) || bool(f.IgnoreANSI) || len(f.volatile) > 0 ||
+		f.CSVIgnore != nil || f.MaxCompareBytes > 0
+}
+
+// linesEqual compares two lines under the active normalizations
+func (p command) linesEqual(line1, line2 string) bool {
+	if !p.Flags.normalizes() {
+		return line1 == line2
+	}
+	return p.compareKey(line1) == p.compareKey(line2)
+}
+
+// compareKey is the form of a line that takes part in comparisons: its
+// first MaxCompareBytes bytes, under the active normalizations. IgnoreANSI
+// strips escape sequences, IgnoreVolatile masks volatile content and
+// CSVIgnoreColumns blanks columns before the prefix is taken, so only the
+// text that is compared counts towards it.
+func (p command) compareKey(line string) string {
+	if bool(p.Flags.IgnoreANSI) {
+		line = stripANSI(line)
+	}
+	if len(p.Flags.volatile) > 0 {
+		line = p.maskVolatile(line)
+	}
+	if p.Flags.CSVIgnore != nil {
+		line = p.Flags.CSVIgnore.blankColumns(line)
+	}
+	if n := int(p.Flags.MaxCompareBytes); n > 0 && len(line) > n {
+		line = line[:n]
+	}
+	if !bool(p.Flags.IgnoreCase) && !bool(p.Flags.IgnoreWhitespace) {
+		return line
+	}
+	if bool(p.Flags.IgnoreWhitespace) && bool(p.Flags.GNUCompat) {
+		// GNU diff -w ignores white space anywhere in the line
+		return normalizeLine(removeSpace(line, bool(p.Flags.RawBytes)), bool(p.Flags.IgnoreCase), false)
+	}
+	if bool(p.Flags.RawBytes) {
+		// Only ASCII whitespace is trimmed; multi-byte sequences are never
+		// interpreted
+		return strings.Trim(line, asciiSpace)
+	}
+	return normalizeLine(line, bool(p.Flags.IgnoreCase), bool(p.Flags.IgnoreWhitespace))
+}
+
+// removeSpace removes every white space character from line. Under raw
+// only ASCII white space is removed and multi-byte sequences are never
+// interpreted.
+func removeSpace(line string, raw bool) string {
+	if !raw {
+		return strings.Join(strings.Fields(line), "")
+	}
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if strings.IndexByte(asciiSpace, line[i]) < 0 {
+			b.WriteByte(line[i])
+		}
+	}
+	return b.String()
+}
+
+// longerLines counts the lines longer than n bytes
+func longerLines(lines []string, n int) int {
+	count := 0
+	for _, line := range lines {
+		if len(line) > n {
+			count++
+		}
+	}
+	return count
+}
+
+// identical reports whether two sets of lines are equal line by line, or
+// differ only in changes that IgnoreMatchingLines and IgnoreBlankLines
+// ignore
+func (p command) identical(lines1, lines2 []string) bool {
+	if p.equalLines(lines1, lines2) {
+		return true
+	}
+	return p.Flags.ignoresLines() && !p.reportsChanges(p.compare(lines1, lines2), lines1, lines2)
+}
+
+// equalLines reports whether two sets of lines are equal line by line and
+// end alike, both with a terminator or both without
+func (p command) equalLines(lines1, lines2 []string) bool {
+	if len(lines1) != len(lines2) || p.Flags.endsDiffer(p.Flags.open) {
+		return false
+	}
+	for i := range lines1 {
+		if !p.linesEqual(lines1[i], lines2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hunks groups the changes of script into the hunks that are printed, with
+// before and after lines of context, merging hunks across gaps of up to
+// InterHunkContext lines
+func (p command) hunks(script []edit, lines1, lines2 []string, before, after int) []hunk {
+	return p.groupHunks(script, lines1, lines2, before, after, int(p.Flags.InterHunkContext))
+}
+
+// groupHunks groups the changes of script into hunks as buildHunks does.
+// Under IgnoreMatchingLines and IgnoreBlankLines an ignored run of changes
+// only joins the hunk above it when it falls within that hunk's trailing
+// context, as in GNU diff, and hunks holding ignored changes only are
+// dropped.
+func (p command) groupHunks(script []edit, lines1, lines2 []string, before, after, inter int) []hunk {
+	var ignored func(i int) bool
+	if p.Flags.ignoresLines() {
+		ignored = func(i int) bool {
+			deleted, inserted, _ := changeGroup(script, i)
+			return p.ignoredGroup(deleted, inserted, lines1, lines2)
+		}
+	}
+	return p.filterHunks(buildHunks(script, before, after, inter, ignored), lines1, lines2)
+}
+
+// buildHunks groups the changes of an edit script into hunks carrying up to
+// before unchanged lines above each change and after lines below it.
+// Changes whose context would touch or overlap, or leave no more than inter
+// lines between them, are merged into a single hunk, except that a run of
+// changes for which ignored, when not nil, reports true only joins when it
+// is within after lines.
+func buildHunks(script []edit, before, after, inter int, ignored func(i int) bool) []hunk {
+	var hunks []hunk
+
+	for i := 0; i < len(script); {
+		if script[i].kind == opEqual {
+			i++
+			continue
+		}
+
+		start := max(i-before, 0)
+
+		// Extend the hunk while the next change is within reach of the context
+		end := i
+		for end < len(script) {
+			if script[end].kind != opEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(script) && script[run].kind == opEqual {
+				run++
+			}
+			reach := before + after + inter
+			if ignored != nil && run < len(script) && ignored(run) {
+				reach = after
+			}
+			if run == len(script) || run-end > reach {
+				end = min(end+after, run)
+				break
+			}
+			end = run
+		}
+
+		hunks = append(hunks, newHunk(script[start:end]))
+		i = end
+	}
+
+	return hunks
+}
+
+// newHunk computes the line ranges covered by a slice of an edit script
+func newHunk(edits []edit) hunk {
+	h := hunk{edits: edits}
+	first := edits[0]
+	h.startA, h.startB = first.a, first.b
+	for _, e := range edits {
+		switch e.kind {
+		case opEqual:
+			h.countA++
+			h.countB++
+		case opDelete:
+			h.countA++
+		case opInsert:
+			h.countB++
+		}
+	}
+	return h
+}
+
+// countChanges counts the inserted and deleted lines of an edit script.
+// With a CountThreshold the count stops as soon as it exceeds the threshold,
+// so any result above the threshold means "more than the threshold"; a pair
+// exceedsCount found over it has no script to count.
+func (p command) countChanges(script []edit) int {
+	threshold := int(p.Flags.CountThreshold)
+	if p.Flags.overCount {
+		return threshold + 1
+	}
+	count := 0
+	for i, e := range script {
+		if p.Flags.Progress != nil {
+			p.Flags.Progress(i + 1)
+		}
+		if e.kind == opEqual {
+			continue
+		}
+		count++
+		if threshold > 0 && count > threshold {
+			break
+		}
+	}
+	return count
+}
+
+// similarity is the percentage of lines the edit script keeps unchanged,
+// relative to the longer file. It is rounded down so files that differ never
+// report 100%.
+func similarity(script []edit, len1, len2 int) int {
+	unchanged := 0
+	for _, e := range script {
+		if e.kind == opEqual {
+			unchanged++
+		}
+	}
+	return unchanged * 100 / max(len1, len2, 1)
+}
+
+// hasChanges reports whether an edit script contains any insertion or deletion
+func hasChanges(script []edit) bool {
+	for _, e := range script {
+		if e.kind != opEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// compileHunkFilters compiles the HunkFilter patterns
+func compileHunkFilters(patterns []HunkFilter) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(string(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hunk filter %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// compileSectionLabel compiles the pattern of a SectionLabel option
+func compileSectionLabel(label SectionPattern) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(label.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid section label %q: %v", label.Pattern, err)
+	}
+	if label.Group < 0 || label.Group > re.NumSubexp() {
+		return nil, fmt.Errorf("section label %q has no group %d", label.Pattern, label.Group)
+	}
+	return re, nil
+}
+
+// sectionLabel is the " label" suffix of a hunk header for a hunk starting
+// at line start of lines1, taken from the nearest matching line above it.
+// There is no suffix without a SectionLabel, without a match above the hunk,
+// or when the group captured nothing.
+func (p command) sectionLabel(lines1 []string, start int) string {
+	if p.Flags.section == nil {
+		return ""
+	}
+	for i := min(start, len(lines1)) - 1; i >= 0; i-- {
+		match := p.Flags.section.FindStringSubmatch(lines1[i])
+		if match == nil {
+			continue
+		}
+		if label := match[p.Flags.SectionLabel.Group]; label != "" {
+			return " " + label
+		}
+		return ""
+	}
+	return ""
+}
+
+// filterHunks keeps only the hunks with at least one inserted or deleted
+// line matching one of the HunkFilter patterns, and drops the hunks whose
+// changes IgnoreMatchingLines and IgnoreBlankLines all ignore. Without either every hunk is
+// kept.
+func (p command) filterHunks(hunks []hunk, lines1, lines2 []string) []hunk {
+	if len(p.Flags.hunkFilters) == 0 && !p.Flags.ignoresLines() {
+		return hunks
+	}
+
+	var kept []hunk
+	for _, h := range hunks {
+		if len(p.Flags.hunkFilters) > 0 && !hunkMatches(h, lines1, lines2, p.Flags.hunkFilters) {
+			continue
+		}
+		if p.Flags.ignoresLines() && !p.reportsChanges(h.edits, lines1, lines2) {
+			continue
+		}
+		kept = append(kept, h)
+	}
+	return kept
+}
+
+// hunkMatches reports whether any changed line of h matches any pattern
+func hunkMatches(h hunk, lines1, lines2 []string, patterns []*regexp.Regexp) bool {
+	for _, e := range h.edits {
+		var line string
+		switch e.kind {
+		case opDelete:
+			line = lines1[e.a]
+		case opInsert:
+			line = lines2[e.b]
+		default:
+			continue
+		}
+		for _, re := range patterns {
+			if re.MatchString(line) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// changeGroup splits the run of changes starting at script[i] into its
+// deletions and insertions, and returns the index of the edit after the run
+func changeGroup(script []edit, i int) (deleted, inserted []edit, next int) {
+	for i < len(script) && script[i].kind != opEqual {
+		if script[i].kind == opDelete {
+			deleted = append(deleted, script[i])
+		} else {
+			inserted = append(inserted, script[i])
+		}
+		i++
+	}
+	return deleted, inserted, i
+}
@@ -0,0 +1,1078 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// numberedLines returns "line 1\n" through "line n\n" with the given replacements
+func numberedLines(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if line, ok := replace[i]; ok {
+			b.WriteString(line + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestDiff_UnifiedHunks(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, nil))
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{3: "changed 3", 20: "changed 20"}))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	want := "--- " + a + "\n+++ " + b + "\n" +
+		"@@ -1,6 +1,6 @@\n line 1\n line 2\n-line 3\n+changed 3\n line 4\n line 5\n line 6\n" +
+		"@@ -17,7 +17,7 @@\n line 17\n line 18\n line 19\n-line 20\n+changed 20\n line 21\n line 22\n line 23\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_UnifiedMergesNearbyChanges(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(12, nil))
+	b := writeFile(t, dir, "b", numberedLines(12, map[int]string{3: "changed 3", 9: "changed 9"}))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	if strings.Count(stdout, "@@ -1,12 +1,12 @@") != 1 {
+		t.Errorf("expected a single merged hunk, got:\n%s", stdout)
+	}
+}
+
+func TestDiff_HunkFilter(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, map[int]string{3: "password=old"}))
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{3: "password=new", 20: "changed 20"}))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.HunkFilter("password|secret")), "")
+	assertDiffers(t, err)
+	want := "--- " + a + "\n+++ " + b + "\n" +
+		"@@ -1,6 +1,6 @@\n line 1\n line 2\n-password=old\n+password=new\n line 4\n line 5\n line 6\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	stdout, _, err = execute(command.Diff(a, b, command.Unified, command.HunkFilter("nomatch"), command.HunkFilter("changed")), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stdout, "@@ -17,7 +17,7 @@") || strings.Contains(stdout, "password") {
+		t.Errorf("filters should OR together and keep only the second hunk:\n%s", stdout)
+	}
+}
+
+func TestDiff_HunkFilterNoMatch(t *testing.T) {
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.HunkFilter("nomatch")), "")
+	assertDiffers(t, err)
+	if stdout != "" {
+		t.Errorf("expected no output, got %q", stdout)
+	}
+}
+
+func TestDiff_HunkFilterInvalid(t *testing.T) {
+	_, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.HunkFilter("(")), "")
+	assertion.Error(t, err)
+}
+
+func TestDiff_FullContext(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, map[int]string{3: "changed 3"}))
+	b := writeFile(t, dir, "b", numberedLines(32, map[int]string{20: "changed 20"}))
+
+	for _, option := range []any{command.FullContext, command.UnifiedContext(-1)} {
+		stdout, _, err := execute(command.Diff(a, b, command.Unified, option), "")
+		assertDiffers(t, err)
+		lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+		if lines[2] != "@@ -1,30 +1,32 @@" || strings.Count(stdout, "@@ ") != 1 {
+			t.Fatalf("expected a single hunk spanning both files, got:\n%s", stdout)
+		}
+
+		// Every line of either file appears exactly once as context or change
+		oldLines, newLines := 0, 0
+		for _, line := range lines[3:] {
+			switch line[0] {
+			case ' ':
+				oldLines++
+				newLines++
+			case '-':
+				oldLines++
+			case '+':
+				newLines++
+			}
+		}
+		if oldLines != 30 || newLines != 32 {
+			t.Errorf("hunk covers %d old and %d new lines, want 30 and 32", oldLines, newLines)
+		}
+	}
+}
+
+func TestDiff_FullContextEmptyFile(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "")
+	b := writeFile(t, dir, "b", "one\ntwo\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.FullContext), "")
+	assertDiffers(t, err)
+	want := "--- " + a + "\n+++ " + b + "\n@@ -0,0 +1,2 @@\n+one\n+two\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_AppendTruncatePrepend(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base", "a\nb\nc\nd\n")
+	appended := writeFile(t, dir, "appended", "a\nb\nc\nd\ne\nf\n")
+	prepended := writeFile(t, dir, "prepended", "x\ny\na\nb\nc\nd\n")
+
+	tests := []struct {
+		name, file1, file2, want string
+	}{
+		{"append", base, appended, "@@ -2,3 +2,5 @@\n b\n c\n d\n+e\n+f\n"},
+		{"truncate", appended, base, "@@ -2,5 +2,3 @@\n b\n c\n d\n-e\n-f\n"},
+		{"prepend", base, prepended, "@@ -1,3 +1,5 @@\n+x\n+y\n a\n b\n c\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := execute(command.Diff(tt.file1, tt.file2, command.Unified), "")
+			assertDiffers(t, err)
+			want := "--- " + tt.file1 + "\n+++ " + tt.file2 + "\n" + tt.want
+			if stdout != want {
+				t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+			}
+		})
+	}
+}
+
+func TestDiff_InsertedLineIsOneHunk(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(500, nil))
+	top := writeFile(t, dir, "top", "new first line\n"+numberedLines(500, nil))
+	middle := writeFile(t, dir, "middle", strings.Replace(numberedLines(500, nil), "line 251\n", "inserted\nline 251\n", 1))
+
+	tests := []struct {
+		name, file2 string
+		opts        []any
+		want        string
+	}{
+		{"top", top, nil, "0a1\n> new first line\n"},
+		{"middle", middle, nil, "250a251\n> inserted\n"},
+		{"top unified", top, []any{command.Unified, command.NoHeader}, "@@ -1,3 +1,4 @@\n+new first line\n line 1\n line 2\n line 3\n"},
+		{"identical", a, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := execute(command.Diff(append([]any{a, tt.file2}, tt.opts...)...), "")
+			assertStatus(t, err, tt.want != "")
+			if stdout != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", stdout, tt.want)
+			}
+		})
+	}
+
+	// The same files the other way round delete the line
+	stdout, _, err := execute(command.Diff(middle, a), "")
+	assertDiffers(t, err)
+	if stdout != "251d250\n< inserted\n" {
+		t.Errorf("got:\n%s", stdout)
+	}
+}
+
+func TestDiff_CompareShapes(t *testing.T) {
+	repeated := func(n int, replace map[int]string) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			if line, ok := replace[i]; ok {
+				b.WriteString(line)
+				continue
+			}
+			b.WriteString([]string{"}\n", "\n", "return nil\n"}[i%3])
+		}
+		return b.String()
+	}
+	unique := func(prefix string, n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "%s %d\n", prefix, i)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name, a, b string
+		want       command.Stats
+	}{
+		{"both empty", "", "", command.Stats{}},
+		{"empty first", "", "x\ny\n", command.Stats{Insertions: 2, Hunks: 1}},
+		{"empty second", "x\ny\n", "", command.Stats{Deletions: 2, Hunks: 1}},
+		{"all unique", unique("old", 20_000), unique("new", 20_000), command.Stats{Insertions: 20_000, Deletions: 20_000, Hunks: 1}},
+		{"repetition", repeated(30_000, nil), repeated(30_000, map[int]string{9_000: "changed\n", 21_000: "changed\n"}),
+			command.Stats{Insertions: 2, Deletions: 2, Hunks: 2}},
+		{"repetition inserted", repeated(30_000, nil), repeated(30_000, map[int]string{15_000: "inserted\n}\n"}),
+			command.Stats{Insertions: 1, Hunks: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", tt.a)
+			b := writeFile(t, dir, "b", tt.b)
+
+			var got command.Stats
+			hook := command.StatsHook(func(_, _ string, stats command.Stats) { got = stats })
+			start := time.Now()
+			_, _, err := execute(command.Diff(a, b, command.Unified, command.Discard, hook), "")
+			assertStatus(t, err, tt.want != command.Stats{})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("comparison took %s", elapsed)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkDiff_AppendOnlyLog(b *testing.B) {
+	dir := b.TempDir()
+	var log strings.Builder
+	for i := 0; i < 5_000_000; i++ {
+		fmt.Fprintf(&log, "log line %d some text here\n", i)
+	}
+	yesterday := log.String()
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&log, "appended %d\n", i)
+	}
+	file1 := filepath.Join(dir, "yesterday.log")
+	file2 := filepath.Join(dir, "today.log")
+	if err := os.WriteFile(file1, []byte(yesterday), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(file2, []byte(log.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, format := range []struct {
+		name string
+		opts []any
+	}{
+		{"normal", nil},
+		{"unified", []any{command.Unified}},
+	} {
+		b.Run(format.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, _, err := execute(command.Diff(append([]any{file1, file2}, format.opts...)...), ""); !errors.Is(err, command.ErrFilesDiffer) {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDiff_TrimmedEnds(t *testing.T) {
+	tests := []struct {
+		name, a, b     string
+		want           string
+		algorithm      string
+		prefix, suffix int64
+	}{
+		// Only the changed middle reaches the algorithm
+		{"middle change", numberedLines(1000, nil), numberedLines(1000, map[int]string{500: "changed"}), "500c500\n< line 500\n---\n> changed\n", "myers", 499, 500},
+		// The suffix never reaches back into the prefix, so the lines
+		// deleted from the middle are found after it, as GNU diff finds them
+		{"deleted from the middle", "a\nb\nb\nc\n", "a\nb\nc\n", "3d2\n< b\n", "deletion", 2, 1},
+		{"repeated block deleted", "a\nb\nc\nb\nc\n", "a\nb\nc\n", "4,5d3\n< b\n< c\n", "deletion", 3, 0},
+		{"strict prefix", "x\nx\n", "x\nx\nx\n", "2a3\n> x\n", "insertion", 2, 0},
+		{"strict suffix", "b\nc\n", "a\nb\nc\n", "0a1\n> a\n", "insertion", 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", tt.a)
+			b := writeFile(t, dir, "b", tt.b)
+			var log recorder
+			stdout, _, err := execute(command.Diff(a, b, command.Logger(slog.New(&log))), "")
+			assertDiffers(t, err)
+			if stdout != tt.want {
+				t.Errorf("got %q, want %q", stdout, tt.want)
+			}
+			compares := log.events("compare")
+			if len(compares) != 1 {
+				t.Fatalf("got %v", compares)
+			}
+			c := compares[0]
+			if c["algorithm"].String() != tt.algorithm || c["prefix"].Int64() != tt.prefix || c["suffix"].Int64() != tt.suffix {
+				t.Errorf("got %v, want %s with prefix %d and suffix %d", c, tt.algorithm, tt.prefix, tt.suffix)
+			}
+		})
+	}
+}
+
+func TestDiff_HorizonLines(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "a\na\nc\nc\n")
+	b := writeFile(t, dir, "b", "a\nc\nc\nb\nc\n")
+
+	// Trimmed right up to the change the insertion is found before the
+	// common last line; a line of horizon lets it end with the file, as
+	// GNU diff --horizon-lines finds it
+	tests := []struct {
+		opts []any
+		want string
+	}{
+		{nil, "2d1 3a3,4"},
+		{[]any{command.HorizonLines(1)}, "2d1 4a4,5"},
+		{[]any{command.Unified, command.UnifiedContext(0)}, "@@ -2 +1,0 @@ @@ -3,0 +3,2 @@"},
+		{[]any{command.Unified, command.UnifiedContext(0), command.HorizonLines(2)}, "@@ -2 +1,0 @@ @@ -4,0 +4,2 @@"},
+		// The context is kept whatever the horizon asks for
+		{[]any{command.Unified, command.UnifiedContext(1), command.HorizonLines(0)}, "@@ -1,4 +1,5 @@"},
+	}
+	for _, tt := range tests {
+		stdout, _, err := execute(command.Diff(append([]any{a, b}, tt.opts...)...), "")
+		assertDiffers(t, err)
+		var commands []string
+		for _, line := range strings.Split(stdout, "\n") {
+			if strings.HasPrefix(line, "@@") || line != "" && line[0] >= '0' && line[0] <= '9' {
+				commands = append(commands, line)
+			}
+		}
+		if got := strings.Join(commands, " "); got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.opts, got, tt.want)
+		}
+	}
+
+	_, _, err := execute(command.Diff(a, b, command.HorizonLines(-1)), "")
+	assertion.Error(t, err)
+}
+
+func TestDiff_InterHunkContext(t *testing.T) {
+	dir := t.TempDir()
+	lines := make([]string, 30)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	a := writeFile(t, dir, "a", strings.Join(lines, "\n")+"\n")
+	// changed rewrites the given lines of a
+	changed := func(name string, numbers ...int) string {
+		out := slices.Clone(lines)
+		for _, n := range numbers {
+			out[n-1] = "changed"
+		}
+		return writeFile(t, dir, name, strings.Join(out, "\n")+"\n")
+	}
+	// Line 5 and the second change have 6 lines of context between them
+	// and a gap of 1, 2 or 3 lines
+	below, equal, above := changed("below", 5, 13), changed("equal", 5, 14), changed("above", 5, 15)
+	zero := changed("zero", 5, 8)
+
+	tests := []struct {
+		b    string
+		opts []any
+		want string
+	}{
+		{below, []any{command.Unified}, "@@ -2,7 +2,7 @@ @@ -10,7 +10,7 @@"},
+		{below, []any{command.Unified, command.InterHunkContext(2)}, "@@ -2,15 +2,15 @@"},
+		{equal, []any{command.Unified, command.InterHunkContext(2)}, "@@ -2,16 +2,16 @@"},
+		{above, []any{command.Unified, command.InterHunkContext(2)}, "@@ -2,7 +2,7 @@ @@ -12,7 +12,7 @@"},
+		{equal, []any{command.ContextDiff, command.InterHunkContext(2)}, "*** 2,17 **** --- 2,17 ----"},
+		{above, []any{command.ContextDiff, command.InterHunkContext(2)}, "*** 2,8 **** --- 2,8 ---- *** 12,18 **** --- 12,18 ----"},
+		// Without context the gap is the lines between the changes
+		{zero, []any{command.Unified, command.UnifiedContext(0), command.InterHunkContext(2)}, "@@ -5,4 +5,4 @@"},
+		{zero, []any{command.Unified, command.UnifiedContext(0), command.InterHunkContext(1)}, "@@ -5 +5 @@ @@ -8 +8 @@"},
+		// Normal format has no hunks to merge
+		{zero, []any{command.InterHunkContext(2)}, "5c5 8c8"},
+	}
+	for _, tt := range tests {
+		stdout, _, err := execute(command.Diff(append([]any{a, tt.b}, tt.opts...)...), "")
+		assertDiffers(t, err)
+		var headers []string
+		for _, line := range strings.Split(stdout, "\n") {
+			if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "*** ") && strings.HasSuffix(line, " ****") ||
+				strings.HasPrefix(line, "--- ") && strings.HasSuffix(line, " ----") || line != "" && line[0] >= '0' && line[0] <= '9' {
+				headers = append(headers, line)
+			}
+		}
+		if got := strings.Join(headers, " "); got != tt.want {
+			t.Errorf("%s %v: got %s, want %s", filepath.Base(tt.b), tt.opts, got, tt.want)
+		}
+	}
+
+	// Result.Hunks are merged as the unified hunks are
+	result, err := command.Run(context.Background(), command.FileSource(a), command.FileSource(equal), command.InterHunkContext(2))
+	assertion.NoError(t, err)
+	if len(result.Hunks) != 1 || result.Hunks[0].OldLines != 16 {
+		t.Errorf("got %+v", result.Hunks)
+	}
+
+	_, _, err = execute(command.Diff(a, below, command.InterHunkContext(-1)), "")
+	assertion.Error(t, err)
+}
+
+// BenchmarkDiff_MiddleChange compares a million lines that differ in three
+// lines in the middle, and the same with the first and last lines changed
+// too, which leaves nothing to trim and the whole files to the algorithm
+func BenchmarkDiff_MiddleChange(b *testing.B) {
+	const n = 1_000_000
+	old := make([]string, n)
+	for i := range old {
+		old[i] = fmt.Sprintf("line %d", i)
+	}
+	changed := func(lines ...int) []string {
+		out := slices.Clone(old)
+		for _, i := range lines {
+			out[i] = "changed"
+		}
+		return out
+	}
+	for _, bench := range []struct {
+		name    string
+		changed []string
+	}{
+		{"trimmed", changed(n/2-1, n/2, n/2+1)},
+		{"untrimmed", changed(0, n/2-1, n/2, n/2+1, n-1)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := command.Run(context.Background(), command.LinesSource("a", old), command.LinesSource("b", bench.changed)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDiff_Minimal(t *testing.T) {
+	// Among lines that match nothing, the braces repeated below are taken
+	// as changed unless the script has to be minimal
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "u4\n}\nu28\n"+strings.Repeat("}\n", 17))
+	b := writeFile(t, dir, "b", strings.Repeat("}\n", 7)+"u20\n"+strings.Repeat("}\n", 4)+
+		"u4\nu24\nu20\nu6\n}\nu15\nu13\nu6\nu1\n}\n}\nu9\nu2\n"+strings.Repeat("}\n", 4))
+
+	var heuristic, minimal command.Stats
+	_, _, err := execute(command.Diff(a, b, command.Discard, command.StatsHook(func(_, _ string, s command.Stats) { heuristic = s })), "")
+	assertDiffers(t, err)
+	_, _, err = execute(command.Diff(a, b, command.Discard, command.Minimal, command.StatsHook(func(_, _ string, s command.Stats) { minimal = s })), "")
+	assertDiffers(t, err)
+	if heuristic.Hunks != 2 || minimal.Hunks != 1 {
+		t.Errorf("got %d hunks by default and %d minimal, want 2 and 1", heuristic.Hunks, minimal.Hunks)
+	}
+	if got, want := minimal.Insertions+minimal.Deletions, heuristic.Insertions+heuristic.Deletions; got >= want {
+		t.Errorf("the minimal script changes %d lines, the default %d", got, want)
+	}
+
+	// Every format shares the script; these are the commands of GNU diff -d
+	stdout, _, _ := execute(command.Diff(a, b, command.Minimal), "")
+	if got, want := normalCommands(stdout), "1d0 3d1 9a8 13a13,16 14a18,21 16a24,25"; got != want {
+		t.Errorf("got commands %s, want %s", got, want)
+	}
+}
+
+// normalCommands returns the command lines of normal diff output
+func normalCommands(stdout string) string {
+	var commands []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if line != "" && line[0] >= '0' && line[0] <= '9' {
+			commands = append(commands, line)
+		}
+	}
+	return strings.Join(commands, " ")
+}
+
+func TestDiff_Histogram(t *testing.T) {
+	tests := []struct {
+		name, a, b       string
+		myers, histogram string
+	}{
+		// The unique line anchors the split, and the braces are moved
+		{"rare line", "d\n}\n\n\n\n}\n\n}\n", "}\n\n\n\n}\n\n}\nd\n", "1d0 8a8", "0a1,7 2,8d8"},
+		// No line is unique, and the rarest still keep the blocks whole
+		{"no unique line", "b\n}\nb\n}\n}\na\n}\na\n", "a\n}\na\n}\n}\nb\n}\nb\n", "1c1 3c3 6c6 8c8", "1,5d0 8a4,8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", tt.a)
+			b := writeFile(t, dir, "b", tt.b)
+			stdout, _, err := execute(command.Diff(a, b), "")
+			assertDiffers(t, err)
+			if got := normalCommands(stdout); got != tt.myers {
+				t.Errorf("Myers: got %s, want %s", got, tt.myers)
+			}
+			// These are the hunks of git diff --histogram
+			stdout, _, err = execute(command.Diff(a, b, command.Histogram), "")
+			assertDiffers(t, err)
+			if got := normalCommands(stdout); got != tt.histogram {
+				t.Errorf("histogram: got %s, want %s", got, tt.histogram)
+			}
+			patch, _, _ := execute(command.Diff(a, b, command.Histogram, command.Unified), "")
+			got, err := command.Apply(splitLines(tt.a), patch)
+			assertion.NoError(t, err)
+			if strings.Join(got, "\n") != strings.Join(splitLines(tt.b), "\n") {
+				t.Errorf("patched lines differ:\n%s", patch)
+			}
+		})
+	}
+}
+
+func TestDiff_Patience(t *testing.T) {
+	dir := t.TempDir()
+	// Two blocks swap places, and their braces are all alike
+	a := writeFile(t, dir, "a", "a\n}\n}\nb\n}\n")
+	b := writeFile(t, dir, "b", "b\n}\na\n}\n}\n")
+
+	stdout, _, err := execute(command.Diff(a, b), "")
+	assertDiffers(t, err)
+	if got, want := normalCommands(stdout), "1c1 2a3 4d4"; got != want {
+		t.Errorf("Myers: got %s, want %s", got, want)
+	}
+	// The unique lines keep each block whole, as in git diff --patience
+	// with the insertion shifted up the way GNU diff shifts it
+	for _, opt := range []any{command.AlgorithmPatience, command.Algorithm(command.AlgorithmPatience)} {
+		stdout, _, err = execute(command.Diff(a, b, opt), "")
+		assertDiffers(t, err)
+		if got, want := normalCommands(stdout), "1,3d0 4a2,4"; got != want {
+			t.Errorf("patience: got %s, want %s", got, want)
+		}
+	}
+	patch, _, _ := execute(command.Diff(a, b, command.AlgorithmPatience, command.Unified), "")
+	got, err := command.Apply([]string{"a", "}", "}", "b", "}"}, patch)
+	assertion.NoError(t, err)
+	if strings.Join(got, ",") != "b,},a,},}" {
+		t.Errorf("got %v from:\n%s", got, patch)
+	}
+
+	// Selecting a second algorithm, or one that does not exist, fails
+	for _, opts := range [][]any{{a, b, command.AlgorithmPatience, command.Minimal}, {a, b, command.Algorithm(9)}} {
+		_, _, err = execute(command.Diff(opts...), "")
+		assertion.Error(t, err)
+	}
+}
+
+// repetitiveLines returns n lines of code-like text from a small
+// vocabulary, so that most lines occur hundreds of times
+func repetitiveLines(n int, edit func(i int) string) []string {
+	vocabulary := []string{"{", "}", "", "return nil", "if err != nil {", "x++", "\tbreak"}
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = vocabulary[(i*7+i/13)%len(vocabulary)]
+		if edit != nil {
+			if line := edit(i); line != "" {
+				lines[i] = line
+			}
+		}
+	}
+	return lines
+}
+
+func BenchmarkDiff_Histogram(b *testing.B) {
+	const n = 50000
+	old := repetitiveLines(n, func(i int) string {
+		if i%997 == 0 {
+			return fmt.Sprintf("func f%d() {", i)
+		}
+		return ""
+	})
+	changed := repetitiveLines(n, func(i int) string {
+		switch {
+		case i%997 == 0:
+			return fmt.Sprintf("func f%d() {", i)
+		case i%1499 == 0:
+			return fmt.Sprintf("changed %d", i)
+		}
+		return ""
+	})
+	for _, algorithm := range []struct {
+		name string
+		opt  any
+	}{{"myers", command.NoHistogram}, {"histogram", command.Histogram}} {
+		b.Run(algorithm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := command.Run(context.Background(), command.LinesSource("a", old), command.LinesSource("b", changed), algorithm.opt); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// scatteredChanges returns n pseudo-random lines from a vocabulary of
+// alpha, and a copy in which, in every dens-th run of run lines, about half
+// of the lines are deleted and half of those replaced
+func scatteredChanges(n, alpha, dens, run int, seed uint64) (old, changed []string) {
+	random := func(k int) int {
+		seed = seed*6364136223846793005 + 1442695040888963407
+		return int((seed >> 33) % uint64(k))
+	}
+	for i := 0; i < n; i++ {
+		line := fmt.Sprintf("l%d", random(alpha))
+		old = append(old, line)
+		if i/run%dens == 0 && random(2) == 0 {
+			if random(2) == 0 {
+				changed = append(changed, fmt.Sprintf("m%d", random(alpha)))
+			}
+			continue
+		}
+		changed = append(changed, line)
+	}
+	return old, changed
+}
+
+func TestDiff_SpeedLargeFiles(t *testing.T) {
+	old, changed := scatteredChanges(7257, 4117, 7, 21, 61)
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", strings.Join(old, "\n")+"\n")
+	b := writeFile(t, dir, "b", strings.Join(changed, "\n")+"\n")
+
+	// Once the search has taken 200 steps it splits at a long run of
+	// matching lines, which here places one change differently, as GNU
+	// diff -H does
+	commands := map[command.SpeedLargeFilesFlag]string{}
+	for _, speed := range []command.SpeedLargeFilesFlag{command.NoSpeedLargeFiles, command.SpeedLargeFiles} {
+		stdout, _, err := execute(command.Diff(a, b, speed), "")
+		assertDiffers(t, err)
+		commands[speed] = normalCommands(stdout)
+	}
+	for speed, want := range map[command.SpeedLargeFilesFlag]string{
+		command.NoSpeedLargeFiles: " 3833,3834c3700 3836,3839c3702,3703 ",
+		command.SpeedLargeFiles:   " 3833,3837c3700 3839c3702,3703 ",
+	} {
+		if !strings.Contains(commands[speed], want) {
+			t.Errorf("SpeedLargeFiles(%v): want commands %s", speed, want)
+		}
+	}
+	if strings.Count(commands[command.SpeedLargeFiles], " ") != strings.Count(commands[command.NoSpeedLargeFiles], " ") {
+		t.Errorf("got a different number of commands")
+	}
+
+	// The script still applies
+	patch, _, _ := execute(command.Diff(a, b, command.SpeedLargeFiles, command.Unified), "")
+	got, err := command.Apply(old, patch)
+	assertion.NoError(t, err)
+	if !slices.Equal(got, changed) {
+		t.Errorf("the patch does not reproduce the new side")
+	}
+
+	// Minimal overrides it
+	minimal, _, _ := execute(command.Diff(a, b, command.Minimal), "")
+	both, _, _ := execute(command.Diff(a, b, command.Minimal, command.SpeedLargeFiles), "")
+	if both != minimal {
+		t.Errorf("SpeedLargeFiles changed the minimal script")
+	}
+}
+
+// BenchmarkDiff_SpeedLargeFiles compares two files of about 100MB that
+// differ in runs of lines scattered through them
+func BenchmarkDiff_SpeedLargeFiles(b *testing.B) {
+	old, changed := scatteredChanges(10_000_000, 10_000_000, 50, 20, 1)
+	for _, search := range []struct {
+		name string
+		opt  any
+	}{{"default", command.NoSpeedLargeFiles}, {"speed-large-files", command.SpeedLargeFiles}} {
+		b.Run(search.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := command.Run(context.Background(), command.LinesSource("a", old), command.LinesSource("b", changed), search.opt); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDiff_CountOnly(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base", "a\nb\nc\nd\n")
+	tests := []struct {
+		name, content, want string
+	}{
+		{"identical", "a\nb\nc\nd\n", "0\n"},
+		{"adds", "a\nb\nc\nd\ne\nf\n", "2\n"},
+		{"deletes", "a\nd\n", "2\n"},
+		{"changes", "a\nB\nc\nD\n", "4\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := writeFile(t, dir, tt.name, tt.content)
+			stdout, _, err := execute(command.Diff(base, other, command.CountOnly), "")
+			assertStatus(t, err, tt.want != "0\n")
+			if stdout != tt.want {
+				t.Errorf("got %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff_CountOnlyThresholdStopsEarly(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(100, nil))
+	b := writeFile(t, dir, "b", numberedLines(100, map[int]string{1: "x", 2: "y", 50: "z"}))
+
+	examined := 0
+	progress := command.Progress(func(n int) { examined = n })
+
+	stdout, _, err := execute(command.Diff(a, b, command.CountOnly, progress), "")
+	assertDiffers(t, err)
+	if stdout != "6\n" {
+		t.Errorf("got %q, want full count 6", stdout)
+	}
+	full := examined
+
+	stdout, _, err = execute(command.Diff(a, b, command.CountOnly, command.CountThreshold(2), progress), "")
+	assertDiffers(t, err)
+	if stdout != "3\n" {
+		t.Errorf("got %q, want 3 (first count above the threshold)", stdout)
+	}
+	if examined >= full {
+		t.Errorf("threshold did not stop counting early: examined %d of %d", examined, full)
+	}
+
+	// The search stops once it passes the threshold, before any script is
+	// built, and a count within the threshold is exact
+	compared := 0
+	hook := command.CompareHook(func() { compared++ })
+	stdout, _, err = execute(command.Diff(a, b, command.CountOnly, command.CountThreshold(2), hook), "")
+	assertDiffers(t, err)
+	if stdout != "3\n" || compared != 0 {
+		t.Errorf("got %q after %d comparisons", stdout, compared)
+	}
+	stdout, _, err = execute(command.Diff(a, b, command.CountOnly, command.CountThreshold(6), hook), "")
+	assertDiffers(t, err)
+	if stdout != "6\n" || compared != 1 {
+		t.Errorf("got %q after %d comparisons, want the full count", stdout, compared)
+	}
+
+	// Inputs with nothing in common are settled in time linear in their
+	// length
+	dir = t.TempDir()
+	many1 := writeFile(t, dir, "a", strings.Repeat("a\n", 200_000))
+	many2 := writeFile(t, dir, "b", strings.Repeat("b\n", 200_000))
+	start := time.Now()
+	stdout, _, err = execute(command.Diff(many1, many2, command.CountOnly, command.CountThreshold(10), progress), "")
+	assertDiffers(t, err)
+	if stdout != "11\n" || examined > 20 {
+		t.Errorf("got %q after examining %d lines", stdout, examined)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s", elapsed)
+	}
+}
+
+func TestDiff_ShowLineNumbers(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(6, nil))
+	b := writeFile(t, dir, "b", numberedLines(6, map[int]string{4: "changed 4"})+"added\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.ShowLineNumbers), "")
+	assertDiffers(t, err)
+	want := "--- " + a + "\n+++ " + b + "\n" +
+		"@@ -1,6 +1,7 @@\n" +
+		"    1     1 | line 1\n" +
+		"    2     2 | line 2\n" +
+		"    3     3 | line 3\n" +
+		"    4       |-line 4\n" +
+		"          4 |+changed 4\n" +
+		"    5     5 | line 5\n" +
+		"    6     6 | line 6\n" +
+		"          7 |+added\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_EditDistance(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", "0\n"},
+		{"both empty", "", "", "0\n"},
+		{"empty first", "", "a\nb\nc\n", "3\n"},
+		{"empty second", "a\nb\n", "", "2\n"},
+		{"one change", "a\nb\nc\n", "a\nB\nc\n", "2\n"},
+		{"shifted", "a\nb\nc\nd\ne\n", "x\na\nb\nc\nd\ne\n", "1\n"},
+		// Positional matching sees every line change; dropping a and adding e is minimal
+		{"rotated", "a\nb\nc\nd\n", "b\nc\nd\ne\n", "2\n"},
+		{"interleaved", "a\nb\nc\nd\n", "b\na\nd\nc\n", "4\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := writeFile(t, dir, tt.name+".a", tt.a)
+			b := writeFile(t, dir, tt.name+".b", tt.b)
+			stdout, _, err := execute(command.Diff(a, b, command.EditDistance), "")
+			assertStatus(t, err, tt.want != "0\n")
+			if stdout != tt.want {
+				t.Errorf("got %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff_MaxCompareBytes(t *testing.T) {
+	dir := t.TempDir()
+	blob := strings.Repeat("QUJD", 64)
+	a := writeFile(t, dir, "a", "id 1\n"+blob+"tail-a\nend\n")
+	b := writeFile(t, dir, "b", "id 2\n"+blob+"tail-b\nend\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.MaxCompareBytes(64)), "")
+	assertDiffers(t, err)
+	want := "Lines compared on their first 64 bytes only: 2 longer lines compared inexactly\n" +
+		"--- " + a + "\n+++ " + b + "\n@@ -1,3 +1,3 @@\n-id 1\n+id 2\n " + blob + "tail-a\n end\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	stdout, _, err = execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stdout, "-"+blob+"tail-a\n") || strings.Contains(stdout, "first 64 bytes") {
+		t.Errorf("exact comparison should see the tails differ:\n%s", stdout)
+	}
+}
+
+func TestDiff_PathologicalInputStaysBounded(t *testing.T) {
+	dir := t.TempDir()
+	var alternating, shifted, changed strings.Builder
+	for i := 0; i < 20_000; i++ {
+		alternating.WriteString([]string{"A\n", "B\n"}[i%2])
+		shifted.WriteString([]string{"B\n", "A\n"}[i%2])
+		if i == 10_000 {
+			changed.WriteString("C\n")
+		} else {
+			changed.WriteString([]string{"A\n", "B\n"}[i%2])
+		}
+	}
+	a := writeFile(t, dir, "a", alternating.String())
+	b := writeFile(t, dir, "b", shifted.String())
+	c := writeFile(t, dir, "c", changed.String())
+
+	start := time.Now()
+	_, _, err := execute(command.Diff(a, c, command.Unified), "")
+	assertDiffers(t, err)
+	linear := time.Since(start)
+
+	start = time.Now()
+	patch, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	if elapsed := time.Since(start); elapsed > 50*linear+2*time.Second {
+		t.Errorf("pathological input took %s against %s for a single change", elapsed, linear)
+	}
+
+	rebuilt, err := command.Apply(splitLines(alternating.String()), patch)
+	assertion.NoError(t, err)
+	if strings.Join(rebuilt, "\n")+"\n" != shifted.String() {
+		t.Errorf("applying the diff does not reconstruct the second file")
+	}
+}
+
+func TestDiff_CostLimit(t *testing.T) {
+	// Lines from a small alphabet give the search many equally good paths,
+	// and a small limit makes its splits settle
+	r := rand.New(rand.NewPCG(11, 0))
+	var lines1, lines2 strings.Builder
+	for i := 0; i < 2000; i++ {
+		lines1.WriteString(string(rune('a'+r.IntN(4))) + "\n")
+		lines2.WriteString(string(rune('a'+r.IntN(4))) + "\n")
+	}
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", lines1.String())
+	b := writeFile(t, dir, "b", lines2.String())
+
+	exact, stderr, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	if stderr != "" {
+		t.Errorf("got %q from the default limit", stderr)
+	}
+	coarse, stderr, err := execute(command.Diff(a, b, command.Unified, command.CostLimit(8)), "")
+	assertDiffers(t, err)
+	if want := "diff: " + a + " and " + b + ": diff may not be minimal\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+	if strings.Count(coarse, "\n") <= strings.Count(exact, "\n") {
+		t.Errorf("the limited script has %d lines against %d", strings.Count(coarse, "\n"), strings.Count(exact, "\n"))
+	}
+	rebuilt, err := command.Apply(splitLines(lines1.String()), coarse)
+	assertion.NoError(t, err)
+	if strings.Join(rebuilt, "\n")+"\n" != lines2.String() {
+		t.Errorf("applying the limited diff does not reconstruct the second file")
+	}
+
+	// The windows of Chunked note a pair once, after their own notices
+	_, stderr, err = execute(command.Diff(a, b, command.Unified, command.CostLimit(8), command.Chunked(200)), "")
+	assertDiffers(t, err)
+	if strings.Count(stderr, "may not be minimal") != 1 || !strings.HasSuffix(stderr, ": diff may not be minimal\n") {
+		t.Errorf("got %q under Chunked", stderr)
+	}
+
+	// Quiet keeps the output and status without the notice, and Minimal
+	// never settles
+	quiet, stderr, err := execute(command.Diff(a, b, command.Unified, command.CostLimit(8), command.Quiet), "")
+	assertDiffers(t, err)
+	if quiet != coarse || stderr != "" {
+		t.Errorf("got %q under Quiet", stderr)
+	}
+	minimal, stderr, err := execute(command.Diff(a, b, command.Unified, command.CostLimit(8), command.Minimal), "")
+	assertDiffers(t, err)
+	if stderr != "" || strings.Count(minimal, "\n") > strings.Count(exact, "\n") {
+		t.Errorf("got %q and %d lines under Minimal", stderr, strings.Count(minimal, "\n"))
+	}
+
+	_, _, err = execute(command.Diff(a, b, command.CostLimit(-1)), "")
+	assertion.Error(t, err)
+}
+
+func TestDiff_SectionLabelINI(t *testing.T) {
+	dir := t.TempDir()
+	ini := func(core, remote string) string {
+		return "[core]\nname=" + core + "\n" + strings.Repeat("pad=1\n", 8) +
+			"[remote \"origin\"]\n" + strings.Repeat("pad=2\n", 8) + "url=" + remote + "\n"
+	}
+	a := writeFile(t, dir, "a.ini", ini("old", "old"))
+	b := writeFile(t, dir, "b.ini", ini("new", "new"))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.SectionLabel(`^\[(.+)\]$`, 1)), "")
+	assertDiffers(t, err)
+	// The first hunk starts at the section line itself, so nothing above
+	// it labels the hunk
+	for _, header := range []string{"@@ -1,5 +1,5 @@\n", "@@ -17,4 +17,4 @@ remote \"origin\"\n"} {
+		if !strings.Contains(stdout, header) {
+			t.Errorf("missing %q in:\n%s", header, stdout)
+		}
+	}
+}
+
+func TestDiff_SectionLabelMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	doc := func(intro, usage string) string {
+		return "# Title\n" + strings.Repeat("text\n", 4) + intro + "\n" + strings.Repeat("text\n", 4) +
+			"## Usage\n" + strings.Repeat("more\n", 6) + usage + "\n"
+	}
+	a := writeFile(t, dir, "a.md", doc("old intro", "old usage"))
+	b := writeFile(t, dir, "b.md", doc("new intro", "new usage"))
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.SectionLabel(`^#+ (.+)`, 1)), "")
+	assertDiffers(t, err)
+	for _, header := range []string{"@@ -3,7 +3,7 @@ Title\n", "@@ -15,4 +15,4 @@ Usage\n"} {
+		if !strings.Contains(stdout, header) {
+			t.Errorf("missing %q in:\n%s", header, stdout)
+		}
+	}
+
+	// An empty capture omits the suffix
+	stdout, _, _ = execute(command.Diff(a, b, command.Unified, command.SectionLabel(`^#+ (X*)`, 1)), "")
+	if !strings.Contains(stdout, "@@ -15,4 +15,4 @@\n") {
+		t.Errorf("empty capture should leave the header bare:\n%s", stdout)
+	}
+}
+
+func TestDiff_SectionLabelInvalid(t *testing.T) {
+	_, _, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.SectionLabel("(", 1)), "")
+	assertion.Error(t, err)
+	_, _, err = execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Unified, command.SectionLabel("^x", 1)), "")
+	assertion.Error(t, err)
+}
+
+func TestDiff_HunkLabel(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, map[int]string{3: "changed 3"}))
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{20: "changed 20"}))
+	section := command.SectionLabel(`^(line 1)$`, 1)
+
+	tests := []struct {
+		label command.HunkLabel
+		want  []string
+	}{
+		{"static", []string{"@@ -1,6 +1,6 @@ static\n", "@@ -17,7 +17,7 @@ static\n"}},
+		{"{file}:{oldstart}->{newstart}", []string{"@@ -1,6 +1,6 @@ " + b + ":1->1\n", "@@ -17,7 +17,7 @@ " + b + ":17->17\n"}},
+		{"", []string{"@@ -1,6 +1,6 @@\n", "@@ -17,7 +17,7 @@\n"}},
+	}
+	for _, tt := range tests {
+		stdout, _, err := execute(command.Diff(a, b, command.Unified, section, tt.label), "")
+		assertDiffers(t, err)
+		for _, want := range tt.want {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("label %q: missing %q in:\n%s", tt.label, want, stdout)
+			}
+		}
+	}
+
+	// Without a HunkLabel the SectionLabel applies
+	stdout, _, _ := execute(command.Diff(a, b, command.Unified, section), "")
+	if !strings.Contains(stdout, "@@ -17,7 +17,7 @@ line 1\n") {
+		t.Errorf("section label missing:\n%s", stdout)
+	}
+
+	_, _, err := execute(command.Diff(a, b, command.Unified, command.HunkLabel("{line}")), "")
+	assertion.Error(t, err)
+}
+
+func TestDiff_UnifiedContextBeforeAfter(t *testing.T) {
+	dir := t.TempDir()
+	original := numberedLines(30, nil)
+	a := writeFile(t, dir, "a", original)
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{2: "changed 2", 28: "changed 28"}))
+
+	stdout, _, _ := execute(command.Diff(a, b, command.Unified, command.UnifiedContextBeforeAfter(1, 5)), "")
+	want := "--- " + a + "\n+++ " + b + "\n" +
+		"@@ -1,7 +1,7 @@\n line 1\n-line 2\n+changed 2\n line 3\n line 4\n line 5\n line 6\n line 7\n" +
+		"@@ -27,4 +27,4 @@\n line 27\n-line 28\n+changed 28\n line 29\n line 30\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	patched, err := command.Apply(splitLines(original), stdout)
+	assertion.NoError(t, err)
+	if got := strings.Join(patched, "\n") + "\n"; got != numberedLines(30, map[int]string{2: "changed 2", 28: "changed 28"}) {
+		t.Errorf("patch does not round-trip:\n%s", got)
+	}
+
+	// A later UnifiedContext makes the context symmetric again
+	stdout, _, _ = execute(command.Diff(a, b, command.Unified, command.UnifiedContextBeforeAfter(1, 5), command.UnifiedContext(2)), "")
+	if !strings.Contains(stdout, "@@ -1,4 +1,4 @@\n") {
+		t.Errorf("expected symmetric context:\n%s", stdout)
+	}
+}
+
+func TestDiff_UnifiedContextBeforeAfterMerging(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, nil))
+
+	// Six unchanged lines between the changes are covered by one line above
+	// the second change and five below the first
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{10: "changed 10", 17: "changed 17"}))
+	stdout, _, _ := execute(command.Diff(a, b, command.Unified, command.UnifiedContextBeforeAfter(1, 5)), "")
+	if strings.Count(stdout, "@@ -") != 1 || !strings.Contains(stdout, "@@ -9,14 +9,14 @@\n") {
+		t.Errorf("changes should merge into one hunk:\n%s", stdout)
+	}
+
+	c := writeFile(t, dir, "c", numberedLines(30, map[int]string{10: "changed 10", 18: "changed 18"}))
+	stdout, _, _ = execute(command.Diff(a, c, command.Unified, command.UnifiedContextBeforeAfter(1, 5)), "")
+	if !strings.Contains(stdout, "@@ -9,7 +9,7 @@\n") || !strings.Contains(stdout, "@@ -17,7 +17,7 @@\n") {
+		t.Errorf("changes should stay in separate hunks:\n%s", stdout)
+	}
+}
+
+func TestDiff_UnifiedContextBeforeAfterInvalid(t *testing.T) {
+	_, stderr, err := execute(command.Diff("a", "b", command.UnifiedContextBeforeAfter(-1, 2)), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "non-negative context") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
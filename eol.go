@@ -0,0 +1,136 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// noNewlineMarker follows the last line of a file that has no terminator in
+// a patch, as in GNU diff output
+const noNewlineMarker = `\ No newline at end of file`
+
+// keepsCR reports whether a carriage return before a newline stays part of
+// its line: under RawBytes, where every byte is kept, and under PreserveEOL,
+// where it is the rest of the line's terminator
+func (f flags) keepsCR() bool {
+	return bool(f.RawBytes) || bool(f.PreserveEOL)
+}
+
+// comparesEnds reports whether the terminator of a last line takes part in
+// comparisons. IgnoreWhitespace takes a missing newline for whitespace, as
+// GNU diff -w does, unless PreserveEOL is set. An ed script cannot remove a
+// newline, so it never compares them.
+func (f flags) comparesEnds() bool {
+	if f.primaryFormat().isEd() {
+		return false
+	}
+	return !bool(f.IgnoreWhitespace) || bool(f.PreserveEOL)
+}
+
+// endsDiffer reports whether inputs whose last lines have the open ends
+// given differ for that alone
+func (f flags) endsDiffer(open [2]bool) bool {
+	return open[0] != open[1] && f.comparesEnds()
+}
+
+// recordTerminator remembers whether the last line read into buf for name
+// has no terminator. Under CanonicalJSON and FocusLines the lines compared
+// are not the lines read, and their ends are not recorded.
+func (p command) recordTerminator(buf *lineBuffer, name string) {
+	if p.Flags.unterminated == nil || bool(p.Flags.CanonicalJSON) || p.Flags.Focus != "" {
+		return
+	}
+	data := buf.data.Bytes()
+	p.Flags.unterminated[name] = len(data) > 0 && data[len(data)-1] != '\n'
+}
+
+// openEnds is whether the last lines of name1 and name2 have no terminator,
+// as recorded when they were read
+func (f flags) openEnds(name1, name2 string) [2]bool {
+	return [2]bool{f.unterminated[name1], f.unterminated[name2]}
+}
+
+// endScanner splits r into lines as lineScanner does, also setting *open
+// after every line to whether that line has no terminator, for inputs read
+// a line at a time
+func (p command) endScanner(r io.Reader, open *bool) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	split := bufio.ScanLines
+	if p.Flags.keepsCR() {
+		split = scanRawLines
+	}
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token != nil && advance > 0 {
+			*open = data[advance-1] != '\n'
+		}
+		return advance, token, err
+	})
+	return scanner
+}
+
+// openLast is the index of the unterminated last line of each side of a
+// comparison of n1 and n2 lines, or -1 where the last line is terminated or
+// its terminator is not compared. Such a line only matches the other side's
+// unterminated last line, so the patch carries the terminator over.
+func (p command) openLast(n1, n2 int) (last1, last2 int) {
+	last1, last2 = -1, -1
+	if p.Flags.comparesEnds() {
+		if p.Flags.open[0] {
+			last1 = n1 - 1
+		}
+		if p.Flags.open[1] {
+			last2 = n2 - 1
+		}
+	}
+	return last1, last2
+}
+
+// separateOpenEnds moves the unterminated last lines at last1 and last2
+// among classes1 and classes2, where they are there at all, into classes of
+// their own, shared when the lines are equal, and returns the new count
+func separateOpenEnds(classes1, classes2 []int, count, last1, last2 int) int {
+	class1 := -1
+	if last1 >= 0 && last1 < len(classes1) {
+		class1, classes1[last1] = classes1[last1], count
+		count++
+	}
+	if last2 >= 0 && last2 < len(classes2) {
+		if class1 >= 0 && classes2[last2] == class1 {
+			classes2[last2] = classes1[last1]
+		} else {
+			classes2[last2] = count
+			count++
+		}
+	}
+	return count
+}
+
+// writeNoNewline writes the marker after a patch line when the line it
+// printed is the unterminated last line of its side. Unchanged lines are
+// printed from the first side.
+func (p command) writeNoNewline(w io.Writer, e edit, n1, n2 int) {
+	last1 := p.Flags.open[0] && e.kind != opInsert && e.a == n1-1
+	last2 := p.Flags.open[1] && e.kind == opInsert && e.b == n2-1
+	if last1 || last2 {
+		_, _ = fmt.Fprintln(w, noNewlineMarker)
+	}
+}
+
+// openEndMarker is the marker of a side-by-side row, which for a changed
+// row is a backslash when only its left line is the unterminated last line of its
+// file and "/" when only its right line is, as in GNU diff -y
+func (f flags) openEndMarker(row sideBySideRow, n1, n2 int) byte {
+	if row.marker != '|' {
+		return row.marker
+	}
+	left, right := f.open[0] && row.lineL == n1, f.open[1] && row.lineR == n2
+	switch {
+	case left && !right:
+		return '\\'
+	case right && !left:
+		return '/'
+	}
+	return row.marker
+}
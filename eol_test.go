@@ -0,0 +1,213 @@
+package command_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// eolFixtures are file pairs whose lines end in CRLF, LF or nothing at all
+var eolFixtures = []struct {
+	name, a, b string
+}{
+	{"crlf", "one\r\ntwo\r\nthree\r\nfour\r\n", "one\r\n2\r\nthree\r\nfour\r\nfive\r\n"},
+	{"mixed", "one\r\ntwo\nthree\r\nfour\nfive\r\n", "one\ntwo\nthree\r\n4\r\nfive\r\n"},
+	{"terminator only", "one\r\ntwo\r\n", "one\ntwo\n"},
+	{"final line dropped newline", "one\ntwo\nthree\n", "one\ntwo\nthree"},
+	{"final line gained newline", "one\ntwo\nthree", "one\ntwo\nthree\n"},
+	{"final line changed", "one\r\ntwo", "one\r\nzwei"},
+	{"appended after final line", "one\ntwo", "one\ntwo\nthree\r\n"},
+	{"emptied", "one\r\ntwo", ""},
+}
+
+func TestDiff_PreserveEOLRoundTrip(t *testing.T) {
+	for _, tt := range eolFixtures {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", tt.a)
+			b := writeFile(t, dir, "b", tt.b)
+
+			stdout, _, err := execute(command.Diff(a, b, command.Unified, command.PreserveEOL), "")
+			assertDiffers(t, err)
+			patched, err := command.ApplyBytes([]byte(tt.a), stdout)
+			assertion.NoError(t, err)
+			if string(patched) != tt.b {
+				t.Errorf("patch turns %q into %q, want %q:\n%q", tt.a, patched, tt.b, stdout)
+			}
+		})
+	}
+}
+
+func TestDiff_PreserveEOLLines(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\r\ntwo\r\nthree")
+	b := writeFile(t, dir, "b", "one\r\ntwo\nthree")
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified, command.NoHeader, command.PreserveEOL), "")
+	assertDiffers(t, err)
+	// Prefix characters are followed by the line exactly as read
+	want := "@@ -1,3 +1,3 @@\n one\r\n-two\r\n+two\n three\n" + `\ No newline at end of file` + "\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// Without PreserveEOL terminators take no part in the comparison
+	_, _, err = execute(command.Diff(a, b, command.Unified), "")
+	assertion.NoError(t, err)
+
+	stdout, _, err = execute(command.Diff(a, b, command.ContextDiff, command.NoHeader, command.PreserveEOL), "")
+	assertDiffers(t, err)
+	want = "***************\n*** 1,3 ****\n  one\r\n! two\r\n  three\n" + `\ No newline at end of file` + "\n" +
+		"--- 1,3 ----\n  one\r\n! two\n  three\n" + `\ No newline at end of file` + "\n"
+	if stdout != want {
+		t.Errorf("context output lost the terminators: got %q, want %q", stdout, want)
+	}
+}
+
+func TestRun_PreserveEOL(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\r\ny")
+	b := writeFile(t, dir, "b", "x\r\ny\r\n")
+
+	result, err := command.Run(context.Background(), command.FileSource(a), command.FileSource(b), command.PreserveEOL)
+	assertion.NoError(t, err)
+	if result.Identical || result.Stats.Insertions != 1 || result.Stats.Deletions != 1 {
+		t.Fatalf("a newline added to the last line is a change: %+v", result.Stats)
+	}
+	original, err := os.ReadFile(a)
+	assertion.NoError(t, err)
+	patched, err := command.ApplyBytes(original, result.Text(command.FormatUnified))
+	assertion.NoError(t, err)
+	if string(patched) != "x\r\ny\r\n" {
+		t.Errorf("got %q", patched)
+	}
+}
+
+func TestDiff_PreserveEOLInvalid(t *testing.T) {
+	for _, opt := range []any{command.CanonicalJSON, command.FocusLines("x"), command.Chunked(10)} {
+		_, stderr, err := execute(command.Diff("a", "b", command.PreserveEOL, opt), "")
+		assertion.Error(t, err)
+		if !strings.Contains(stderr, "PreserveEOL cannot be combined") {
+			t.Errorf("%v: unexpected stderr %q", opt, stderr)
+		}
+	}
+}
+
+func TestDiff_NoNewlineAtEnd(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\ntwo\nthree")
+	b := writeFile(t, dir, "b", "one\ntwo\nthree\n")
+	marker := `\ No newline at end of file` + "\n"
+
+	// The last line is a change, with the marker after the unterminated
+	// side, whether the prefix is streamed, read whole or in windows
+	unified := "@@ -2,2 +2,2 @@\n two\n-three\n" + marker + "+three\n"
+	tests := []struct {
+		opts []any
+		want string
+	}{
+		{nil, "3c3\n< three\n" + marker + "---\n> three\n"},
+		{[]any{command.Unified, command.UnifiedContext(1)}, unified},
+		{[]any{command.Unified, command.UnifiedContext(1), command.DetectMoves}, unified},
+		{[]any{command.Unified, command.UnifiedContext(1), command.Chunked(10)}, unified},
+		{[]any{command.Unified, command.UnifiedContext(0)}, "@@ -3 +3 @@\n-three\n" + marker + "+three\n"},
+		{[]any{command.ContextDiff, command.ContextLines(0)}, "***************\n*** 3 ****\n! three\n" + marker + "--- 3 ----\n! three\n"},
+		{[]any{command.Brief}, "Files " + a + " and " + b + " differ\n"},
+		{[]any{command.FirstDifference}, "Files " + a + " and " + b + " first differ at line 3\n"},
+	}
+	for _, tt := range tests {
+		stdout, _, err := execute(command.Diff(append([]any{a, b, command.NoHeader}, tt.opts...)...), "")
+		assertDiffers(t, err)
+		if !strings.HasSuffix(stdout, tt.want) || strings.Count(stdout, "@@") > 2 {
+			t.Errorf("%v: got %q, want %q", tt.opts, stdout, tt.want)
+		}
+	}
+
+	// An unterminated last line only matches the other side's
+	c := writeFile(t, dir, "c", "three\none\ntwo\nthree")
+	stdout, _, err := execute(command.Diff(b, c), "")
+	assertDiffers(t, err)
+	if want := "0a1\n> three\n3c4\n< three\n---\n> three\n" + marker; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// The patch restores the bytes either way
+	for _, pair := range [][2]string{{a, b}, {b, a}, {b, c}} {
+		stdout, _, err := execute(command.Diff(pair[0], pair[1], command.Unified), "")
+		assertDiffers(t, err)
+		original, _ := os.ReadFile(pair[0])
+		want, _ := os.ReadFile(pair[1])
+		patched, err := command.ApplyBytes(original, stdout)
+		assertion.NoError(t, err)
+		if string(patched) != string(want) {
+			t.Errorf("patch turns %q into %q, want %q", original, patched, want)
+		}
+	}
+
+	// A missing newline is white space to IgnoreWhitespace; Expected
+	// content ends as written, like a file
+	_, _, err = execute(command.Diff(a, b, command.IgnoreWhitespace), "")
+	assertion.NoError(t, err)
+	_, _, err = execute(command.Diff(b, command.Expected("one\ntwo\nthree")), "")
+	assertDiffers(t, err)
+	for _, opt := range []any{command.Unified, command.Brief, command.FirstDifference} {
+		_, _, err = execute(command.Diff(a, writeFile(t, dir, "d", "one\ntwo\nthree"), opt), "")
+		assertion.NoError(t, err)
+	}
+}
+
+func TestRun_NoNewlineAtEnd(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\ny")
+	b := writeFile(t, dir, "b", "x\ny\n")
+
+	result, err := command.Run(context.Background(), command.FileSource(a), command.FileSource(b))
+	assertion.NoError(t, err)
+	if result.Identical || result.Stats.Insertions != 1 || result.Stats.Deletions != 1 {
+		t.Errorf("a newline added to the last line is a change: %+v", result.Stats)
+	}
+	diff := parseOne(t, result.Text(command.FormatUnified))
+	if !diff.OldNoNewline || diff.NewNoNewline {
+		t.Errorf("got %+v", diff)
+	}
+}
+
+func TestDiff_OnlyFinalNewlineDiffers(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "a\nb")
+	b := writeFile(t, dir, "b", "a\nb\n")
+	marker := `\ No newline at end of file` + "\n"
+
+	tests := []struct {
+		file1, file2 string
+		opts         []any
+		want         string
+	}{
+		{a, b, []any{command.Brief}, "Files " + a + " and " + b + " differ\n"},
+		{a, b, nil, "2c2\n< b\n" + marker + "---\n> b\n"},
+		{b, a, nil, "2c2\n< b\n---\n> b\n" + marker},
+		{a, b, []any{command.Unified, command.NoHeader}, "@@ -1,2 +1,2 @@\n a\n-b\n" + marker + "+b\n"},
+		{b, a, []any{command.Unified, command.NoHeader}, "@@ -1,2 +1,2 @@\n a\n-b\n+b\n" + marker},
+		// Side by side marks the side without the newline, as GNU diff -y
+		{a, b, []any{command.SideBySide, command.Width(20)}, "a           a\nb        \\  b\n"},
+		{b, a, []any{command.SideBySide, command.Width(20)}, "a           a\nb        /  b\n"},
+	}
+	for _, tt := range tests {
+		stdout, _, err := execute(command.Diff(append([]any{tt.file1, tt.file2}, tt.opts...)...), "")
+		assertDiffers(t, err)
+		if stdout != tt.want {
+			t.Errorf("%s %s %v: got %q, want %q", filepath.Base(tt.file1), filepath.Base(tt.file2), tt.opts, stdout, tt.want)
+		}
+	}
+
+	// The same files both ways are identical
+	for _, file := range []string{a, b} {
+		_, _, err := execute(command.Diff(file, file, command.Brief), "")
+		assertion.NoError(t, err)
+	}
+}
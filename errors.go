@@ -0,0 +1,58 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrFilesDiffer is returned by the Executor when the comparison found
+// differences and nothing went wrong, where GNU diff exits with status 1.
+// The differences have been written in full by then. Any other error is
+// trouble, GNU diff's status 2.
+var ErrFilesDiffer = errors.New("files differ")
+
+// ErrChangedWhileRead is the error of a file whose size or modification
+// time kept changing while it was read, after the retries of RetryOnChange.
+// The Executor warns about it and still compares the lines of the last
+// read, then reports the comparison as trouble.
+var ErrChangedWhileRead = errors.New("changed while being read")
+
+// ErrPatchStale is returned under AssumeUnchanged when the operands now
+// differ in other ways than the stored patch records
+var ErrPatchStale = errors.New("patch is stale")
+
+// ErrPatchObsolete is returned under AssumeUnchanged when the operands of a
+// stored patch that changes them have become identical
+var ErrPatchObsolete = errors.New("files now identical")
+
+// FileError is an I/O problem with one operand, which names the file in
+// its message the way diff prints it on stderr
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// fileTrouble reports an I/O problem with path on stderr and returns it as
+// a FileError
+func fileTrouble(stderr io.Writer, path string, err error) error {
+	_, _ = fmt.Fprintf(stderr, "diff: %s: %v\n", path, err)
+	return &FileError{Path: path, Err: err}
+}
+
+// verdict is the result of a comparison that completed: ErrFilesDiffer when
+// differ is set, nil otherwise
+func verdict(differ bool) error {
+	if differ {
+		return ErrFilesDiffer
+	}
+	return nil
+}
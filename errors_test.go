@@ -0,0 +1,157 @@
+package command_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	command "github.com/yupsh/diff"
+)
+
+// status classifies an error the way GNU diff's exit status does
+func status(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, command.ErrFilesDiffer):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func TestDiff_ExitStatusMatrix(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\ntwo\n")
+	b := writeFile(t, dir, "b", "one\n2\n")
+	same := writeFile(t, dir, "same", "one\ntwo\n")
+	missing := filepath.Join(dir, "missing")
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, sub, "a", "one\n2\n")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		operands []string
+		status   int
+		stdout   string
+		stderr   string
+	}{
+		{"identical", context.Background(), []string{a, same}, 0, "", ""},
+		{"differing", context.Background(), []string{a, b}, 1,
+			"--- " + a + "\n+++ " + b + "\n@@ -1,2 +1,2 @@\n one\n-two\n+2\n", ""},
+		{"missing file", context.Background(), []string{a, missing}, 2,
+			"", "diff: " + missing + ": open " + missing + ": no such file or directory\n"},
+		{"directory operand", context.Background(), []string{a, sub}, 1,
+			"--- " + a + "\n+++ " + filepath.Join(sub, "a") + "\n@@ -1,2 +1,2 @@\n one\n-two\n+2\n", ""},
+		{"cancelled context", cancelled, []string{a, b}, 2, "", "diff: context canceled\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			params := []any{command.Unified}
+			for _, operand := range tt.operands {
+				params = append(params, operand)
+			}
+			err := command.Diff(params...).Executor()(tt.ctx, strings.NewReader(""), &stdout, &stderr)
+			if got := status(err); got != tt.status {
+				t.Errorf("status %d (%v), want %d", got, err, tt.status)
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("stdout:\n%s\nwant:\n%s", stdout.String(), tt.stdout)
+			}
+			if stderr.String() != tt.stderr {
+				t.Errorf("stderr %q, want %q", stderr.String(), tt.stderr)
+			}
+
+			// Run reaches the same verdict and renders the same text
+			second := tt.operands[1]
+			if tt.name == "directory operand" {
+				second = filepath.Join(sub, "a")
+			}
+			result, runErr := command.Run(tt.ctx, command.FileSource(tt.operands[0]), command.FileSource(second), command.Unified)
+			switch {
+			case tt.status == 2:
+				if runErr == nil {
+					t.Errorf("Run succeeded where the command failed with %v", err)
+				}
+			case runErr != nil:
+				t.Errorf("Run failed: %v", runErr)
+			case result.Identical != (tt.status == 0):
+				t.Errorf("Run reports identical %t for status %d", result.Identical, tt.status)
+			case result.Text(command.FormatUnified) != tt.stdout:
+				t.Errorf("Run text:\n%s\nwant:\n%s", result.Text(command.FormatUnified), tt.stdout)
+			}
+		})
+	}
+}
+
+func TestDiff_FileError(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	_, _, err := execute(command.Diff("testdata/a.txt", missing), "")
+	var fileErr *command.FileError
+	if !errors.As(err, &fileErr) || fileErr.Path != missing {
+		t.Fatalf("got %v, want a FileError for %s", err, missing)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FileError should unwrap to the I/O error, got %v", fileErr.Err)
+	}
+
+	_, runErr := command.Run(context.Background(), command.FileSource(missing), command.FileSource("testdata/a.txt"))
+	if !errors.As(runErr, &fileErr) || fileErr.Path != missing {
+		t.Errorf("Run: got %v, want a FileError for %s", runErr, missing)
+	}
+
+	// A problem inside a directory walk is trouble even when files differ
+	left, right := t.TempDir(), t.TempDir()
+	writeFile(t, left, "changed", "a\n")
+	writeFile(t, right, "changed", "b\n")
+	if err := os.Symlink(missing, filepath.Join(left, "dangling")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink(missing, filepath.Join(right, "dangling")); err != nil {
+		t.Skip(err)
+	}
+	stdout, _, err := execute(command.Diff(left, right, command.Brief), "")
+	if !errors.As(err, &fileErr) {
+		t.Errorf("got %v, want a FileError", err)
+	}
+	if !strings.Contains(stdout, "differ") {
+		t.Errorf("differences should still be reported:\n%s", stdout)
+	}
+}
+
+func TestDiff_DirectoryStatus(t *testing.T) {
+	left, right := t.TempDir(), t.TempDir()
+	writeFile(t, left, "same", "a\n")
+	writeFile(t, right, "same", "a\n")
+	_, _, err := execute(command.Diff(left, right), "")
+	assertStatus(t, err, false)
+
+	writeFile(t, right, "extra", "b\n")
+	_, _, err = execute(command.Diff(left, right), "")
+	assertStatus(t, err, true)
+}
+
+func TestDiff_OperandCount(t *testing.T) {
+	_, stderr, err := execute(command.Diff("testdata/a.txt"), "")
+	if status(err) != 2 || stderr != "diff: missing operand after 'testdata/a.txt'\n" {
+		t.Errorf("missing operand: %v, stderr %q", err, stderr)
+	}
+
+	_, stderr, err = execute(command.Diff("testdata/a.txt", "testdata/b.txt", "testdata/a.txt"), "")
+	if status(err) != 2 || stderr != "diff: extra operand 'testdata/a.txt'\n" {
+		t.Errorf("extra operand: %v, stderr %q", err, stderr)
+	}
+}
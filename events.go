@@ -0,0 +1,33 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// eventEscaper escapes the characters that would break the one record per
+// line, tab separated event syntax
+var eventEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// outputRawEvents writes one record per edit operation, for consumers that
+// post-process the comparison rather than read a patch. The syntax is stable:
+//
+//	=<TAB>old<TAB>new    line old of file1 is kept as line new of file2
+//	-<TAB>old<TAB>text   line old of file1 is deleted
+//	+<TAB>new<TAB>text   text is inserted as line new of file2
+//
+// Line numbers are one-based. In text a backslash, tab, newline and carriage
+// return are written as \\, \t, \n and \r.
+func outputRawEvents(w io.Writer, lines1, lines2 []string, script []edit) {
+	for _, e := range script {
+		switch e.kind {
+		case opEqual:
+			_, _ = fmt.Fprintf(w, "=\t%d\t%d\n", e.a+1, e.b+1)
+		case opDelete:
+			_, _ = fmt.Fprintf(w, "-\t%d\t%s\n", e.a+1, eventEscaper.Replace(lines1[e.a]))
+		case opInsert:
+			_, _ = fmt.Fprintf(w, "+\t%d\t%s\n", e.b+1, eventEscaper.Replace(lines2[e.b]))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package command_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// replayEvents rebuilds the second file from the first and a RawEvents stream
+func replayEvents(t *testing.T, old []string, events string) []string {
+	t.Helper()
+	unescape := strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+	var rebuilt []string
+	for _, record := range strings.Split(strings.TrimSuffix(events, "\n"), "\n") {
+		fields := strings.SplitN(record, "\t", 3)
+		if len(fields) != 3 {
+			t.Fatalf("malformed record %q", record)
+		}
+		switch fields[0] {
+		case "=":
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			rebuilt = append(rebuilt, old[n-1])
+		case "+":
+			rebuilt = append(rebuilt, unescape.Replace(fields[2]))
+		case "-":
+		default:
+			t.Fatalf("unknown record %q", record)
+		}
+	}
+	return rebuilt
+}
+
+func TestDiff_RawEventsReplay(t *testing.T) {
+	dir := t.TempDir()
+	oldText := "keep\nold\tvalue\nback\\slash\nkeep too\n"
+	newText := "keep\nnew\tvalue\nback\\slash\\n\nkeep too\nadded\n"
+	a := writeFile(t, dir, "a", oldText)
+	b := writeFile(t, dir, "b", newText)
+
+	stdout, _, err := execute(command.Diff(a, b, command.RawEvents), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stdout, "+\t2\tnew\\tvalue\n") || !strings.Contains(stdout, "+\t3\tback\\\\slash\\\\n\n") {
+		t.Errorf("content not escaped:\n%s", stdout)
+	}
+
+	old := strings.Split(strings.TrimSuffix(oldText, "\n"), "\n")
+	rebuilt := strings.Join(replayEvents(t, old, stdout), "\n") + "\n"
+	if rebuilt != newText {
+		t.Errorf("replay got %q, want %q", rebuilt, newText)
+	}
+}
+
+func TestDiff_RawEventsIdentical(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\ny\n")
+
+	stdout, _, err := execute(command.Diff(a, a, command.RawEvents), "")
+	assertion.NoError(t, err)
+	if stdout != "=\t1\t1\n=\t2\t2\n" {
+		t.Errorf("got %q", stdout)
+	}
+}
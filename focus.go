@@ -0,0 +1,69 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compileFocus compiles the FocusLines pattern
+func compileFocus(pattern FocusLines) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(string(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid focus pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// focusLines drops the lines read into buf that FocusLines does not match.
+// Under FocusKeepNumbers the original index of every kept line is
+// remembered for name, and under JSONDetail only the byte ranges of the kept
+// lines are.
+func (p command) focusLines(buf *lineBuffer, name string) {
+	if p.Flags.focus == nil {
+		return
+	}
+	var index []int
+	ranges := p.Flags.lineRanges[name]
+	kept, keptRanges := buf.lines[:0], ranges[:0]
+	for i, line := range buf.lines {
+		if p.Flags.focus.MatchString(line) {
+			kept = append(kept, line)
+			if p.Flags.focusIndex != nil {
+				index = append(index, i)
+			}
+			if ranges != nil {
+				keptRanges = append(keptRanges, ranges[i])
+			}
+		}
+	}
+	if ranges != nil {
+		p.Flags.lineRanges[name] = keptRanges
+	}
+	clear(buf.lines[len(kept):])
+	buf.lines = kept
+	if p.Flags.focusIndex != nil {
+		p.Flags.focusIndex[name] = index
+	}
+}
+
+// lineNumber maps the 1-based number of a compared line of side 0 or 1 to
+// the number it is reported as: its line in the original file under
+// FocusKeepNumbers, otherwise the number itself. Number 0, the position
+// before the first line, stays 0.
+func (p command) lineNumber(side, n int) int {
+	index := p.Flags.numbers[side]
+	if index == nil || n <= 0 || n > len(index) {
+		return n
+	}
+	return index[n-1] + 1
+}
+
+// rangeOrigin maps the 0-based start of a hunk range of count lines on
+// side 0 or 1 the way lineNumber maps line numbers. An empty range is named
+// after the line before it, which is mapped instead.
+func (p command) rangeOrigin(side, start, count int) int {
+	if count == 0 {
+		return p.lineNumber(side, start)
+	}
+	return p.lineNumber(side, start+1) - 1
+}
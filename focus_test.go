@@ -0,0 +1,83 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+const focusLog1 = "INFO start\nERROR disk full\nINFO retry\nINFO retry\nERROR timeout\nINFO done\n"
+
+// focusLog2 changes the second ERROR line and every INFO line
+const focusLog2 = "INFO boot\nERROR disk full\nINFO wait\nERROR timeout after 5s\nINFO stop\n"
+
+func TestDiff_FocusLinesFilteredNumbers(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.log", focusLog1)
+	b := writeFile(t, dir, "b.log", focusLog2)
+
+	stdout, _, _ := execute(command.Diff(a, b, command.FocusLines("^ERROR")), "")
+	if want := "2c2\n< ERROR timeout\n---\n> ERROR timeout after 5s\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	stdout, _, _ = execute(command.Diff(a, b, command.FocusLines("^ERROR"), command.Unified), "")
+	want := "--- " + a + "\n+++ " + b + "\n@@ -1,2 +1,2 @@\n ERROR disk full\n-ERROR timeout\n+ERROR timeout after 5s\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_FocusKeepNumbers(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.log", focusLog1)
+	b := writeFile(t, dir, "b.log", focusLog2)
+	focus := []any{command.FocusLines("^ERROR"), command.FocusKeepNumbers}
+
+	stdout, _, _ := execute(command.Diff(append([]any{a, b}, focus...)...), "")
+	if want := "5c4\n< ERROR timeout\n---\n> ERROR timeout after 5s\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// Hunks start at the original line of their first kept line
+	stdout, _, _ = execute(command.Diff(append([]any{a, b, command.Unified}, focus...)...), "")
+	want := "--- " + a + "\n+++ " + b + "\n@@ -2,2 +2,2 @@\n ERROR disk full\n-ERROR timeout\n+ERROR timeout after 5s\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	// An empty range is named after the original line before it
+	c := writeFile(t, dir, "c.log", "INFO start\nERROR disk full\nINFO retry\n")
+	stdout, _, _ = execute(command.Diff(append([]any{c, a}, focus...)...), "")
+	if want := "2a5\n> ERROR timeout\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_FocusLinesIdenticalAfterFiltering(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.log", focusLog1)
+	b := writeFile(t, dir, "b.log", strings.ReplaceAll(focusLog1, "INFO", "DEBUG"))
+
+	stdout, _, err := execute(command.Diff(a, b, command.FocusLines("^ERROR")), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("only unfocused lines changed:\n%s", stdout)
+	}
+}
+
+func TestDiff_FocusLinesInvalid(t *testing.T) {
+	_, stderr, err := execute(command.Diff("a", "b", command.FocusLines("(")), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "invalid focus pattern") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+
+	_, stderr, err = execute(command.Diff("a", "b", command.FocusKeepNumbers), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "FocusKeepNumbers requires FocusLines") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
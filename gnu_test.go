@@ -0,0 +1,84 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	command "github.com/yupsh/diff"
+)
+
+// gnuFlagSets are the flag sets of the GNU compatibility corpus, named as
+// in testdata/gnu/generate.sh, which records the output of GNU diff for
+// each of them
+var gnuFlagSets = map[string][]any{
+	"normal":                     nil,
+	"unified":                    {command.Unified},
+	"unified-0":                  {command.Unified, command.UnifiedContext(0)},
+	"unified-1":                  {command.Unified, command.UnifiedContext(1)},
+	"unified-5":                  {command.Unified, command.UnifiedContext(5)},
+	"context":                    {command.ContextDiff},
+	"context-0":                  {command.ContextDiff, command.ContextLines(0)},
+	"context-1":                  {command.ContextDiff, command.ContextLines(1)},
+	"brief":                      {command.Brief},
+	"ignore-case":                {command.IgnoreCase},
+	"ignore-whitespace":          {command.IgnoreWhitespace},
+	"ignore-case-unified":        {command.IgnoreCase, command.Unified},
+	"ignore-blank-lines":         {command.IgnoreBlankLines},
+	"ignore-blank-lines-unified": {command.IgnoreBlankLines, command.Unified},
+	"ed":                         {command.EdScript},
+	"forward-ed":                 {command.ForwardEdScript},
+	"recursive":                  {command.Recursive},
+	"recursive-brief":            {command.Recursive, command.Brief},
+}
+
+// gnuGaps are the corpus outputs the formatters do not match yet, keyed by
+// flag set, by case, or by case/set, with the reason. Fixing a formatter
+// removes its entries.
+var gnuGaps = map[string]string{}
+
+func TestDiff_GNUCompatCorpus(t *testing.T) {
+	outputs, err := filepath.Glob("testdata/gnu/*/*.out")
+	if err != nil || len(outputs) == 0 {
+		t.Fatalf("no corpus outputs: %v", err)
+	}
+	for _, output := range outputs {
+		dir, file := filepath.Split(output)
+		set := file[:len(file)-len(".out")]
+		opts, ok := gnuFlagSets[set]
+		if !ok {
+			t.Fatalf("%s: unknown flag set %s", output, set)
+		}
+		want, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Base(dir)
+		t.Run(name+"/"+set, func(t *testing.T) {
+			for _, key := range []string{set, name, name + "/" + set} {
+				if reason, gap := gnuGaps[key]; gap {
+					t.Skip(reason)
+				}
+			}
+			t.Chdir(dir)
+			stdout, _, err := execute(command.Diff(append([]any{"a", "b", command.GNUCompat}, opts...)...), "")
+			assertStatus(t, err, len(want) > 0)
+			if stdout != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+			}
+		})
+	}
+}
+
+func TestDiff_GNUCompatIgnoresAllWhitespace(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "a b\n\tc\n")
+	b := writeFile(t, dir, "b", "a  b\nc \n")
+
+	_, _, err := execute(command.Diff(a, b, command.IgnoreWhitespace), "")
+	assertStatus(t, err, true)
+	_, _, err = execute(command.Diff(a, b, command.IgnoreWhitespace, command.GNUCompat), "")
+	assertStatus(t, err, false)
+	_, _, err = execute(command.Diff(a, b, command.IgnoreWhitespace, command.GNUCompat, command.RawBytes), "")
+	assertStatus(t, err, false)
+}
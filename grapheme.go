@@ -0,0 +1,187 @@
+package command
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// graphemeClass is the Grapheme_Cluster_Break property of a character in
+// UAX #29, with Extended_Pictographic as a class of its own
+type graphemeClass uint8
+
+const (
+	graphemeOther graphemeClass = iota
+	graphemeCR
+	graphemeLF
+	graphemeControl
+	graphemeExtend
+	graphemeZWJ
+	graphemeRegionalIndicator
+	graphemePrepend
+	graphemeSpacingMark
+	graphemeL
+	graphemeV
+	graphemeT
+	graphemeLV
+	graphemeLVT
+	graphemePictographic
+)
+
+// prependedMarks are the characters of class Prepend: prepended
+// concatenation marks and the few letters written before the consonant
+// they follow in speech
+var prependedMarks = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x0600, 0x0605, 1}, {0x06DD, 0x06DD, 1}, {0x070F, 0x070F, 1},
+		{0x0890, 0x0891, 1}, {0x08E2, 0x08E2, 1}, {0x0D4E, 0x0D4E, 1},
+	},
+	R32: []unicode.Range32{
+		{0x110BD, 0x110BD, 1}, {0x110CD, 0x110CD, 1}, {0x111C2, 0x111C3, 1},
+		{0x1193F, 0x1193F, 1}, {0x11941, 0x11941, 1}, {0x11A3A, 0x11A3A, 1},
+		{0x11A84, 0x11A89, 1}, {0x11D46, 0x11D46, 1}, {0x11F02, 0x11F02, 1},
+	},
+}
+
+// pictographs are the Extended_Pictographic characters: the emoji and the
+// symbols an emoji sequence may join, with the unassigned code points of
+// the emoji blocks reserved for them
+var pictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00A9, 0x00A9, 1}, {0x00AE, 0x00AE, 1}, {0x203C, 0x203C, 1},
+		{0x2049, 0x2049, 1}, {0x2122, 0x2122, 1}, {0x2139, 0x2139, 1},
+		{0x2194, 0x2199, 1}, {0x21A9, 0x21AA, 1}, {0x231A, 0x231B, 1},
+		{0x2328, 0x2328, 1}, {0x2388, 0x2388, 1}, {0x23CF, 0x23CF, 1},
+		{0x23E9, 0x23F3, 1}, {0x23F8, 0x23FA, 1}, {0x24C2, 0x24C2, 1},
+		{0x25AA, 0x25AB, 1}, {0x25B6, 0x25B6, 1}, {0x25C0, 0x25C0, 1},
+		{0x25FB, 0x25FE, 1}, {0x2600, 0x2605, 1}, {0x2607, 0x2612, 1},
+		{0x2614, 0x2685, 1}, {0x2690, 0x2705, 1}, {0x2708, 0x2712, 1},
+		{0x2714, 0x2714, 1}, {0x2716, 0x2716, 1}, {0x271D, 0x271D, 1},
+		{0x2721, 0x2721, 1}, {0x2728, 0x2728, 1}, {0x2733, 0x2734, 1},
+		{0x2744, 0x2744, 1}, {0x2747, 0x2747, 1}, {0x274C, 0x274C, 1},
+		{0x274E, 0x274E, 1}, {0x2753, 0x2755, 1}, {0x2757, 0x2757, 1},
+		{0x2763, 0x2767, 1}, {0x2795, 0x2797, 1}, {0x27A1, 0x27A1, 1},
+		{0x27B0, 0x27B0, 1}, {0x27BF, 0x27BF, 1}, {0x2934, 0x2935, 1},
+		{0x2B05, 0x2B07, 1}, {0x2B1B, 0x2B1C, 1}, {0x2B50, 0x2B50, 1},
+		{0x2B55, 0x2B55, 1}, {0x3030, 0x3030, 1}, {0x303D, 0x303D, 1},
+		{0x3297, 0x3297, 1}, {0x3299, 0x3299, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1F000, 0x1F0FF, 1}, {0x1F10D, 0x1F10F, 1}, {0x1F12F, 0x1F12F, 1},
+		{0x1F16C, 0x1F171, 1}, {0x1F17E, 0x1F17F, 1}, {0x1F18E, 0x1F18E, 1},
+		{0x1F191, 0x1F19A, 1}, {0x1F1AD, 0x1F1E5, 1}, {0x1F201, 0x1F20F, 1},
+		{0x1F21A, 0x1F21A, 1}, {0x1F22F, 0x1F22F, 1}, {0x1F232, 0x1F23A, 1},
+		{0x1F23C, 0x1F23F, 1}, {0x1F249, 0x1F3FA, 1}, {0x1F400, 0x1F53D, 1},
+		{0x1F546, 0x1F64F, 1}, {0x1F680, 0x1F6FF, 1}, {0x1F774, 0x1F77F, 1},
+		{0x1F7D5, 0x1F7FF, 1}, {0x1F80C, 0x1F80F, 1}, {0x1F848, 0x1F84F, 1},
+		{0x1F85A, 0x1F85F, 1}, {0x1F888, 0x1F88F, 1}, {0x1F8AE, 0x1F8FF, 1},
+		{0x1F90C, 0x1F93A, 1}, {0x1F93C, 0x1F945, 1}, {0x1F947, 0x1FAFF, 1},
+		{0x1FC00, 0x1FFFD, 1},
+	},
+}
+
+// graphemeClassOf classifies r for grapheme cluster boundaries
+func graphemeClassOf(r rune) graphemeClass {
+	switch {
+	case r == '\r':
+		return graphemeCR
+	case r == '\n':
+		return graphemeLF
+	case r == 0x200D:
+		return graphemeZWJ
+	case r >= 0x1F1E6 && r <= 0x1F1FF:
+		return graphemeRegionalIndicator
+	case r >= 0x1100 && r <= 0x115F || r >= 0xA960 && r <= 0xA97C:
+		return graphemeL
+	case r >= 0x1160 && r <= 0x11A7 || r >= 0xD7B0 && r <= 0xD7C6:
+		return graphemeV
+	case r >= 0x11A8 && r <= 0x11FF || r >= 0xD7CB && r <= 0xD7FB:
+		return graphemeT
+	case r >= 0xAC00 && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return graphemeLV
+		}
+		return graphemeLVT
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Other_Grapheme_Extend) ||
+		r == 0x200C || r >= 0x1F3FB && r <= 0x1F3FF || r >= 0xE0020 && r <= 0xE007F:
+		// Emoji modifiers and tags extend the emoji before them
+		return graphemeExtend
+	case unicode.Is(prependedMarks, r):
+		return graphemePrepend
+	case unicode.In(r, unicode.Cc, unicode.Cf, unicode.Zl, unicode.Zp) || r == utf8.RuneError:
+		return graphemeControl
+	case unicode.Is(unicode.Mc, r) || r == 0x0E33 || r == 0x0EB3:
+		return graphemeSpacingMark
+	case unicode.Is(pictographs, r):
+		return graphemePictographic
+	}
+	return graphemeOther
+}
+
+// graphemeBreaks marks the offsets of s where a grapheme cluster starts or
+// ends, per the extended grapheme clusters of UAX #29 without the rule for
+// Indic conjuncts, which keeps such conjuncts apart at their viramas. The
+// result has an entry for every offset from 0 to len(s). A byte that is not
+// valid UTF-8 is a cluster of its own.
+func graphemeBreaks(s string) []bool {
+	breaks := make([]bool, len(s)+1)
+	breaks[0], breaks[len(s)] = true, true
+	var prev graphemeClass
+	// regional counts the regional indicators in a row before the current
+	// character, and emoji whether the characters since the last
+	// pictograph only extend it, so a ZWJ after them joins the next one
+	regional, emoji, joined := 0, false, false
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		class := graphemeClassOf(r)
+		if i > 0 {
+			breaks[i] = graphemeBreak(prev, class, regional, joined)
+		}
+		joined = class == graphemeZWJ && emoji
+		switch class {
+		case graphemePictographic:
+			emoji = true
+		case graphemeExtend:
+		default:
+			emoji = false
+		}
+		if class == graphemeRegionalIndicator {
+			regional++
+		} else {
+			regional = 0
+		}
+		prev = class
+		i += size
+	}
+	return breaks
+}
+
+// graphemeBreak reports whether a grapheme cluster boundary lies between a
+// character of class prev and one of class next. regional is the count of
+// regional indicators in a row ending with prev, and joined whether prev is
+// a ZWJ following a pictograph and its extenders.
+func graphemeBreak(prev, next graphemeClass, regional int, joined bool) bool {
+	switch {
+	case prev == graphemeCR && next == graphemeLF:
+		return false
+	case prev == graphemeCR || prev == graphemeLF || prev == graphemeControl:
+		return true
+	case next == graphemeCR || next == graphemeLF || next == graphemeControl:
+		return true
+	case prev == graphemeL && (next == graphemeL || next == graphemeV || next == graphemeLV || next == graphemeLVT):
+		return false
+	case (prev == graphemeLV || prev == graphemeV) && (next == graphemeV || next == graphemeT):
+		return false
+	case (prev == graphemeLVT || prev == graphemeT) && next == graphemeT:
+		return false
+	case next == graphemeExtend || next == graphemeZWJ || next == graphemeSpacingMark:
+		return false
+	case prev == graphemePrepend:
+		return false
+	case joined && next == graphemePictographic:
+		return false
+	case prev == graphemeRegionalIndicator && next == graphemeRegionalIndicator:
+		// Flags pair the indicators from the start of the run
+		return regional%2 == 0
+	}
+	return true
+}
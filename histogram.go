@@ -0,0 +1,143 @@
+package command
+
+// maxChainLength bounds how often a line may occur in the old side of a
+// region for the histogram search to match on it, as in JGit. A region
+// whose common lines all occur more often is compared with Myers'
+// algorithm instead.
+const maxChainLength = 64
+
+// span is a region of both sides, [a0, a1) of the old and [b0, b1) of the
+// new
+type span struct {
+	a0, a1, b0, b1 int
+}
+
+// histogram finds a script between two sequences of line classes with the
+// histogram algorithm git and JGit use: each region is split around its
+// longest run of common lines that contains the fewest occurrences of a
+// line in the old side, and the parts on either side are compared the same
+// way. Lines that are unique on the old side are preferred like patience
+// diff prefers them, but when no line is unique the rarest ones still
+// anchor the split.
+type histogram struct {
+	a, b               []int
+	changedA, changedB marks
+
+	// first is the first position+1 of each class in the old side of the
+	// region being searched, next the position+1 of the next line of the
+	// same class after each, and occurs the occurrences of each class
+	first, next, occurs []int
+
+	// cost is shared by the fallback searches
+	cost *searchCost
+}
+
+// histogramDiff marks the lines of classes1 and classes2 that an edit
+// script deletes and inserts, found with the histogram algorithm. The
+// changed runs are then shifted to where GNU diff puts them.
+func histogramDiff(classes1, classes2 []int, count int, cost *searchCost) (marks, marks) {
+	h := &histogram{
+		a: classes1, b: classes2,
+		changedA: newMarks(len(classes1)), changedB: newMarks(len(classes2)),
+		first: make([]int, count), next: make([]int, len(classes1)), occurs: make([]int, count),
+		cost: cost,
+	}
+	regions := []span{{0, len(classes1), 0, len(classes2)}}
+	for len(regions) > 0 {
+		r := regions[len(regions)-1]
+		regions = regions[:len(regions)-1]
+		if r.a0 == r.a1 || r.b0 == r.b1 {
+			h.change(r)
+			continue
+		}
+		lcs, common := h.longestCommon(r)
+		switch {
+		case lcs.a1 > lcs.a0:
+			regions = append(regions, span{r.a0, lcs.a0, r.b0, lcs.b0}, span{lcs.a1, r.a1, lcs.b1, r.b1})
+		case common:
+			h.fallback(r)
+		default:
+			h.change(r)
+		}
+	}
+	shiftBoundaries(h.changedA, h.changedB, classes1)
+	shiftBoundaries(h.changedB, h.changedA, classes2)
+	return h.changedA, h.changedB
+}
+
+// change marks every line of the region changed
+func (h *histogram) change(r span) {
+	for i := r.a0; i < r.a1; i++ {
+		h.changedA.set(i, true)
+	}
+	for j := r.b0; j < r.b1; j++ {
+		h.changedB.set(j, true)
+	}
+}
+
+// fallback marks the changes of a region whose common lines are all too
+// frequent for the histogram search with Myers' algorithm
+func (h *histogram) fallback(r span) {
+	changedA, changedB := analyze(h.a[r.a0:r.a1], h.b[r.b0:r.b1], len(h.occurs), false, false, h.cost)
+	for i := r.a0; i < r.a1; i++ {
+		h.changedA.set(i, changedA.at(i-r.a0))
+	}
+	for j := r.b0; j < r.b1; j++ {
+		h.changedB.set(j, changedB.at(j-r.b0))
+	}
+}
+
+// longestCommon returns the run of common lines of the region to split it
+// around: the one whose rarest line occurs the fewest times in the old
+// side, the longest of those; it is empty when no line occurs at most
+// maxChainLength times. common reports whether the sides have any line in
+// common at all.
+func (h *histogram) longestCommon(r span) (lcs span, common bool) {
+	for i := r.a1 - 1; i >= r.a0; i-- {
+		c := h.a[i]
+		h.next[i], h.first[c] = h.first[c], i+1
+		h.occurs[c]++
+	}
+	defer func() {
+		for i := r.a0; i < r.a1; i++ {
+			h.first[h.a[i]], h.occurs[h.a[i]] = 0, 0
+		}
+	}()
+
+	low := maxChainLength + 1
+	for j := r.b0; j < r.b1; {
+		c := h.b[j]
+		nextJ := j + 1
+		if h.occurs[c] > 0 {
+			common = true
+		}
+		if h.occurs[c] == 0 || h.occurs[c] > low || h.occurs[c] > maxChainLength {
+			j = nextJ
+			continue
+		}
+		for pos := h.first[c]; pos != 0; {
+			as, bs := pos-1, j
+			ae, be := as+1, bs+1
+			rarest := h.occurs[c]
+			for as > r.a0 && bs > r.b0 && h.a[as-1] == h.b[bs-1] {
+				as, bs = as-1, bs-1
+				rarest = min(rarest, h.occurs[h.a[as]])
+			}
+			for ae < r.a1 && be < r.b1 && h.a[ae] == h.b[be] {
+				rarest = min(rarest, h.occurs[h.a[ae]])
+				ae, be = ae+1, be+1
+			}
+			nextJ = max(nextJ, be)
+			if lcs.a1-lcs.a0 < ae-as || rarest < low {
+				lcs, low = span{as, ae, bs, be}, rarest
+			}
+			// Occurrences inside the run would only find it again
+			pos = h.next[pos-1]
+			for pos != 0 && pos-1 < ae {
+				pos = h.next[pos-1]
+			}
+		}
+		j = nextJ
+	}
+	return lcs, common
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compileIgnorePatterns compiles the IgnoreMatchingLines patterns
+func compileIgnorePatterns(patterns []IgnoreMatchingLines) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(string(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// ignoresLines reports whether some changed lines may be ignored, under
+// IgnoreMatchingLines or IgnoreBlankLines
+func (f flags) ignoresLines() bool {
+	return len(f.ignorePatterns) > 0 || bool(f.IgnoreBlankLines)
+}
+
+// ignoredLine reports whether a changed line may be ignored: it is blank
+// under IgnoreBlankLines, or it matches any IgnoreMatchingLines pattern, or
+// every one of them under IgnoreMatchAll
+func (p command) ignoredLine(line string) bool {
+	if bool(p.Flags.IgnoreBlankLines) && p.compareKey(line) == "" {
+		return true
+	}
+	if len(p.Flags.ignorePatterns) == 0 {
+		return false
+	}
+	all := bool(p.Flags.IgnoreMatchAll)
+	for _, re := range p.Flags.ignorePatterns {
+		matched := re.MatchString(line)
+		if matched && !all {
+			return true
+		}
+		if !matched && all {
+			return false
+		}
+	}
+	return all
+}
+
+// ignoredGroup reports whether every line of a run of changes may be
+// ignored, which makes the whole run ignored
+func (p command) ignoredGroup(deleted, inserted []edit, lines1, lines2 []string) bool {
+	for _, e := range deleted {
+		if !p.ignoredLine(lines1[e.a]) {
+			return false
+		}
+	}
+	for _, e := range inserted {
+		if !p.ignoredLine(lines2[e.b]) {
+			return false
+		}
+	}
+	return true
+}
+
+// reportsChanges reports whether edits hold a run of changes that is not
+// ignored under IgnoreMatchingLines or IgnoreBlankLines
+func (p command) reportsChanges(edits []edit, lines1, lines2 []string) bool {
+	for i := 0; i < len(edits); {
+		if edits[i].kind == opEqual {
+			i++
+			continue
+		}
+		deleted, inserted, next := changeGroup(edits, i)
+		if !p.ignoredGroup(deleted, inserted, lines1, lines2) {
+			return true
+		}
+		i = next
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// generatedHeader1 and generatedHeader2 differ in a header whose lines
+// match different patterns
+const generatedHeader1 = "// Code generated by gen v1. DO NOT EDIT.\n// Source: api.proto\npackage api\n\nconst Version = 1\n"
+const generatedHeader2 = "// Code generated by gen v2. DO NOT EDIT.\n// Source: api_v2.proto\npackage api\n\nconst Version = 1\n"
+
+var headerPatterns = []any{command.IgnoreMatchingLines(`^// Code generated`), command.IgnoreMatchingLines(`^// Source:`)}
+
+func TestDiff_IgnoreMatchingLinesAny(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.go", generatedHeader1)
+	b := writeFile(t, dir, "b.go", generatedHeader2)
+
+	for _, format := range []any{command.Unified, command.Brief, command.NoBrief} {
+		stdout, _, err := execute(command.Diff(append([]any{a, b, format}, headerPatterns...)...), "")
+		assertion.NoError(t, err)
+		if stdout != "" {
+			t.Errorf("%v: every changed line matches a pattern:\n%s", format, stdout)
+		}
+	}
+}
+
+func TestDiff_IgnoreMatchAll(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.go", generatedHeader1)
+	b := writeFile(t, dir, "b.go", generatedHeader2)
+
+	// No line matches both patterns
+	stdout, _, _ := execute(command.Diff(append([]any{a, b, command.Unified, command.IgnoreMatchAll}, headerPatterns...)...), "")
+	if !strings.Contains(stdout, "-// Source: api.proto\n") {
+		t.Errorf("lines matching only one pattern should be reported:\n%s", stdout)
+	}
+
+	// Lines matching every pattern are still ignored
+	stdout, _, _ = execute(command.Diff(a, b, command.Unified, command.IgnoreMatchAll,
+		command.IgnoreMatchingLines(`^//`), command.IgnoreMatchingLines(`generated|Source`)), "")
+	if stdout != "" {
+		t.Errorf("lines matching both patterns should be ignored:\n%s", stdout)
+	}
+}
+
+func TestDiff_IgnoreMatchingLinesMixedHunk(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.go", generatedHeader1)
+	b := writeFile(t, dir, "b.go", strings.Replace(generatedHeader2, "Version = 1", "Version = 2", 1))
+
+	stdout, _, _ := execute(command.Diff(append([]any{a, b, command.Unified}, headerPatterns...)...), "")
+	want := "--- " + a + "\n+++ " + b + "\n@@ -1,5 +1,5 @@\n" +
+		"-// Code generated by gen v1. DO NOT EDIT.\n-// Source: api.proto\n" +
+		"+// Code generated by gen v2. DO NOT EDIT.\n+// Source: api_v2.proto\n" +
+		" package api\n \n-const Version = 1\n+const Version = 2\n"
+	if stdout != want {
+		t.Errorf("a hunk with a reported change is printed whole:\ngot:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	// Normal output prints only the reported lines
+	stdout, _, _ = execute(command.Diff(append([]any{a, b}, headerPatterns...)...), "")
+	if want := "5c5\n< const Version = 1\n---\n> const Version = 2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_IgnoreMatchingLinesInvalid(t *testing.T) {
+	_, stderr, err := execute(command.Diff("a", "b", command.IgnoreMatchingLines(`^ok`), command.IgnoreMatchingLines(`(`)), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, `invalid ignore pattern "("`) {
+		t.Errorf("the error should name the pattern: %q", stderr)
+	}
+
+	_, stderr, err = execute(command.Diff("a", "b", command.IgnoreMatchAll), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "IgnoreMatchAll requires IgnoreMatchingLines") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
+
+// blankBase is the first side of the IgnoreBlankLines tests
+const blankBase = "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+
+func TestDiff_IgnoreBlankLines(t *testing.T) {
+	for _, test := range []struct {
+		name, b, want string
+
+		// patched is the first side with the reported hunks applied
+		patched string
+	}{{
+		// A blank line next to a change is printed with it
+		name:    "adjacent",
+		b:       "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n\n",
+		want:    "@@ -9,4 +9,5 @@\n 9\n 10\n 11\n-12\n+twelve\n+\n",
+		patched: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n\n",
+	}, {
+		// A blank line within the trailing context joins the hunk
+		name:    "within",
+		b:       "1\n2\n3\n4\n5\n6\n7\n8\n9\nten\n11\n12\n\n",
+		want:    "@@ -7,6 +7,7 @@\n 7\n 8\n 9\n-10\n+ten\n 11\n 12\n+\n",
+		patched: "1\n2\n3\n4\n5\n6\n7\n8\n9\nten\n11\n12\n\n",
+	}, {
+		// A blank line beyond it is not reported
+		name:    "apart",
+		b:       "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n\n",
+		want:    "@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n",
+		patched: "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a", blankBase)
+			b := writeFile(t, dir, "b", test.b)
+
+			stdout, _, err := execute(command.Diff(a, b, command.Unified, command.IgnoreBlankLines, command.NoHeader), "")
+			assertDiffers(t, err)
+			if stdout != test.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", stdout, test.want)
+			}
+			patched, err := command.Apply(splitLines(blankBase), stdout)
+			assertion.NoError(t, err)
+			if got := strings.Join(patched, "\n") + "\n"; got != test.patched {
+				t.Errorf("patched:\n%s\nwant:\n%s", got, test.patched)
+			}
+		})
+	}
+}
+
+func TestDiff_IgnoreBlankLinesOnly(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", blankBase)
+	b := writeFile(t, dir, "b", blankBase+"\n\n")
+
+	for _, format := range []any{command.Unified, command.Brief, command.NoBrief} {
+		stdout, _, err := execute(command.Diff(a, b, format, command.IgnoreBlankLines), "")
+		assertion.NoError(t, err)
+		if stdout != "" {
+			t.Errorf("%v: only blank lines changed:\n%s", format, stdout)
+		}
+	}
+	if describe := inspect(t, command.IgnoreBlankLines).Describe(); !strings.Contains(describe, "normalize=blank-lines") {
+		t.Errorf("Describe should list blank-lines: %s", describe)
+	}
+}
@@ -0,0 +1,30 @@
+// Package reference is a slow, obviously correct differ that tests check
+// the engine against. It computes the longest common subsequence of two
+// line sequences by dynamic programming over every pair of lines, in
+// O(N·M) time and memory, so it is only suitable for small inputs.
+package reference
+
+// Cost is the number of lines a minimal edit script from a to b deletes
+// and inserts, with lines matched by equal
+func Cost(a, b []string, equal func(x, y string) bool) int {
+	return len(a) + len(b) - 2*commonLines(a, b, equal)
+}
+
+// commonLines is the length of the longest common subsequence of a and b.
+// lcs[i][j] holds it for the suffixes a[i:] and b[j:].
+func commonLines(a, b []string, equal func(x, y string) bool) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if equal(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs[0][0]
+}
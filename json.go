@@ -0,0 +1,57 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// canonicalJSONIndent indents the canonical form of CanonicalJSON inputs
+const canonicalJSONIndent = "  "
+
+// canonicalJSON re-marshals a single JSON document with sorted object keys
+// and canonicalJSONIndent indentation. Numbers keep their original text.
+func canonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", canonicalJSONIndent)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// canonicalize replaces the lines read into buf with the canonical form of
+// the JSON document they hold, under CanonicalJSON. An input that is not
+// JSON is compared as it is, with a notice.
+func (p command) canonicalize(buf *lineBuffer, name string) {
+	if !bool(p.Flags.CanonicalJSON) {
+		return
+	}
+	canonical, err := canonicalJSON(buf.data.Bytes())
+	if err != nil {
+		if p.Flags.stderr != nil {
+			p.notice(p.Flags.stderr, "%s: not JSON, compared as is: %v", name, err)
+		}
+		return
+	}
+	buf.data.Reset()
+	buf.data.Write(canonical)
+	buf.lines = splitLines(buf.lines[:0], buf.data.String(), bool(p.Flags.RawBytes))
+	p.stripOutput(buf.lines)
+	if p.Flags.canonical != nil {
+		p.Flags.canonical[name] = true
+	}
+}
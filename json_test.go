@@ -0,0 +1,69 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+const configJSON = `{"name": "svc", "replicas": 3, "ports": [80, 443], "env": {"LOG": "info", "REGION": "eu"}}`
+
+// reorderedJSON is configJSON with its keys in another order and laid out
+// over several lines
+const reorderedJSON = `{
+	"env": {"REGION": "eu", "LOG": "info"},
+	"ports": [80, 443],
+	"replicas": 3,
+	"name": "svc"
+}
+`
+
+func TestDiff_CanonicalJSONReorderedKeys(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.json", configJSON)
+	b := writeFile(t, dir, "b.json", reorderedJSON)
+
+	stdout, stderr, err := execute(command.Diff(a, b, command.CanonicalJSON, command.Unified), "")
+	assertion.NoError(t, err)
+	if stdout != "" || stderr != "" {
+		t.Errorf("reordered documents should compare equal:\nstdout %q\nstderr %q", stdout, stderr)
+	}
+}
+
+func TestDiff_CanonicalJSONChangedValue(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.json", configJSON)
+	b := writeFile(t, dir, "b.json", strings.Replace(reorderedJSON, `"replicas": 3`, `"replicas": 5`, 1))
+
+	stdout, _, _ := execute(command.Diff(a, b, command.CanonicalJSON, command.Unified, command.UnifiedContext(1)), "")
+	want := "--- " + a + " (canonicalized)\n+++ " + b + " (canonicalized)\n" +
+		"@@ -10,3 +10,3 @@\n   ],\n-  \"replicas\": 3\n+  \"replicas\": 5\n }\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_CanonicalJSONFallsBackForOtherInput(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.txt", "not json\n")
+	b := writeFile(t, dir, "b.json", "{\"a\": 1}\n")
+
+	stdout, stderr, _ := execute(command.Diff(a, b, command.CanonicalJSON, command.Unified), "")
+	if !strings.HasPrefix(stderr, "diff: "+a+": not JSON, compared as is: ") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+	want := "--- " + a + "\n+++ " + b + " (canonicalized)\n" +
+		"@@ -1 +1,3 @@\n-not json\n+{\n+  \"a\": 1\n+}\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	// Trailing content after the document is not JSON either
+	c := writeFile(t, dir, "c.json", "{\"a\": 1} {\"b\": 2}\n")
+	_, stderr, _ = execute(command.Diff(b, c, command.CanonicalJSON), "")
+	if !strings.Contains(stderr, c+": not JSON") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
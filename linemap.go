@@ -0,0 +1,21 @@
+package command
+
+import (
+	"fmt"
+	"io"
+)
+
+// outputLineMap prints, for every line of the second file, its line number
+// and the number of the matching line in the first file, tab separated.
+// Inserted lines have no counterpart and map to "+". Deleted lines have no
+// line in the second file and are not listed.
+func outputLineMap(w io.Writer, script []edit) {
+	for _, e := range script {
+		switch e.kind {
+		case opEqual:
+			_, _ = fmt.Fprintf(w, "%d\t%d\n", e.b+1, e.a+1)
+		case opInsert:
+			_, _ = fmt.Fprintf(w, "%d\t+\n", e.b+1)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package command_test
+
+import (
+	"testing"
+
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_LineMap(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base", "a\nb\nc\nd\n")
+
+	tests := []struct {
+		name, content, want string
+	}{
+		{"leading insertion", "x\ny\na\nb\nc\nd\n", "1\t+\n2\t+\n3\t1\n4\t2\n5\t3\n6\t4\n"},
+		{"middle deletion", "a\nd\n", "1\t1\n2\t4\n"},
+		{"trailing change", "a\nb\nc\nD\ne\n", "1\t1\n2\t2\n3\t3\n4\t+\n5\t+\n"},
+		{"identical", "a\nb\nc\nd\n", "1\t1\n2\t2\n3\t3\n4\t4\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := writeFile(t, dir, tt.name, tt.content)
+			stdout, _, err := execute(command.Diff(base, other, command.LineMap), "")
+			assertStatus(t, err, tt.content != "a\nb\nc\nd\n")
+			if stdout != tt.want {
+				t.Errorf("got %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package command
+
+import (
+	"errors"
+	"time"
+)
+
+// logComparison logs a comparison with the hunks unified output would
+// print, when Logger is set. A nil script of differing lines is computed.
+func (p command) logComparison(file1, file2 string, lines1, lines2 []string, script []edit, identical bool) {
+	if p.Flags.Logger == nil {
+		return
+	}
+	if identical {
+		p.Flags.Logger.Info("compared", "file1", file1, "file2", file2, "identical", true)
+		return
+	}
+	if script == nil {
+		script = p.compare(lines1, lines2)
+	}
+	before, after := p.Flags.unifiedContext()
+	stats := scriptStats(script, len(p.hunks(script, lines1, lines2, before, after)))
+	p.Flags.Logger.Info("compared", "file1", file1, "file2", file2, "identical", false,
+		"hunks", stats.Hunks, "insertions", stats.Insertions, "deletions", stats.Deletions)
+}
+
+// logPair logs the time taken by a file pair of a directory walk since
+// start, when Logger is set
+func (p command) logPair(path1, path2 string, start time.Time) {
+	if p.Flags.Logger == nil {
+		return
+	}
+	p.Flags.Logger.Info("pair", "file1", path1, "file2", path2, "elapsed", time.Since(start))
+}
+
+// logError logs the error a run ends with, when Logger is set. Finding
+// differences is no error.
+func (p command) logError(err error) {
+	if p.Flags.Logger == nil || err == nil || errors.Is(err, ErrFilesDiffer) {
+		return
+	}
+	p.Flags.Logger.Error("failed", "error", err)
+}
@@ -0,0 +1,137 @@
+package command_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// recorder is a slog handler keeping every record it is given
+type recorder struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (r *recorder) Enabled(context.Context, slog.Level) bool { return true }
+func (r *recorder) WithAttrs([]slog.Attr) slog.Handler       { return r }
+func (r *recorder) WithGroup(string) slog.Handler            { return r }
+
+func (r *recorder) Handle(_ context.Context, record slog.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+	return nil
+}
+
+// events returns the attributes of the records with message msg, by key
+func (r *recorder) events(msg string) []map[string]slog.Value {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []map[string]slog.Value
+	for _, record := range r.records {
+		if record.Message != msg {
+			continue
+		}
+		attrs := map[string]slog.Value{"level": slog.StringValue(record.Level.String())}
+		record.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		events = append(events, attrs)
+	}
+	return events
+}
+
+func TestDiff_Logger(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+	for _, dir := range []string{dir1, dir2} {
+		if err := os.Symlink(missing, filepath.Join(dir, "dangling")); err != nil {
+			t.Skip("symlinks unsupported:", err)
+		}
+	}
+
+	var log recorder
+	// The dangling link makes the walk trouble
+	_, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Logger(slog.New(&log))), "")
+	assertion.Error(t, err)
+
+	// Each of the three file pairs is read on both sides
+	reads := log.events("input read")
+	if len(reads) != 6 {
+		t.Fatalf("got %d input events, want 6", len(reads))
+	}
+	for _, read := range reads {
+		if read["level"].String() != "DEBUG" || read["bytes"].Int64() == 0 || read["lines"].Int64() != 1 {
+			t.Errorf("unexpected input event %v", read)
+		}
+	}
+	for _, compare := range log.events("compare") {
+		if compare["algorithm"].String() != "myers" {
+			t.Errorf("one-line changes are compared with Myers' algorithm: %v", compare)
+		}
+	}
+
+	compared := log.events("compared")
+	if len(compared) != 3 {
+		t.Fatalf("got %d comparison events, want 3", len(compared))
+	}
+	differing := 0
+	for _, event := range compared {
+		if !event["identical"].Bool() {
+			differing++
+			if event["hunks"].Int64() != 1 || event["insertions"].Int64() != 1 || event["deletions"].Int64() != 1 {
+				t.Errorf("unexpected comparison event %v", event)
+			}
+		}
+	}
+	if differing != 2 {
+		t.Errorf("got %d differing pairs, want 2", differing)
+	}
+
+	pairs := log.events("pair")
+	if len(pairs) != 3 {
+		t.Fatalf("got %d pair events, want 3", len(pairs))
+	}
+	for _, pair := range pairs {
+		if pair["level"].String() != "INFO" || pair["elapsed"].Kind() != slog.KindDuration || pair["elapsed"].Duration() < 0 || pair["elapsed"].Duration() > time.Minute {
+			t.Errorf("unexpected pair event %v", pair)
+		}
+	}
+
+	notices := log.events("notice")
+	if len(notices) != 1 || notices[0]["level"].String() != "WARN" {
+		t.Errorf("the dangling link should be noticed: %v", notices)
+	}
+
+	failed := log.events("failed")
+	if len(failed) != 1 || failed[0]["level"].String() != "ERROR" {
+		t.Errorf("the run ends in trouble: %v", failed)
+	}
+}
+
+func TestDiff_LoggerOperandError(t *testing.T) {
+	var log recorder
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\n")
+	_, _, _ = execute(command.Diff(a, filepath.Join(dir, "missing"), command.Logger(slog.New(&log))), "")
+	if failed := log.events("failed"); len(failed) != 1 {
+		t.Errorf("got %v", failed)
+	}
+
+	// Differences are no error
+	b := writeFile(t, dir, "b", "y\n")
+	var differ recorder
+	_, _, err := execute(command.Diff(a, b, command.Unified, command.Logger(slog.New(&differ))), "")
+	assertDiffers(t, err)
+	if failed := differ.events("failed"); len(failed) != 0 {
+		t.Errorf("got %v", failed)
+	}
+}
@@ -0,0 +1,55 @@
+package command
+
+// defaultMoveMinLines is the smallest block DetectMoves reports, so that
+// single braces and blank lines are not flagged as moved
+const defaultMoveMinLines = 3
+
+// detectMoves marks blocks of lines that the script deletes in one place and
+// inserts verbatim in another. Both halves of a block get the same non-zero
+// move id. Blocks are matched greedily, longest first from each deleted line,
+// and only blocks of at least minLines consecutive lines count.
+func (p command) detectMoves(script []edit, lines1, lines2 []string, minLines int) {
+	var deleted, inserted []int
+	insertedByText := make(map[string][]int)
+	for i, e := range script {
+		switch e.kind {
+		case opDelete:
+			deleted = append(deleted, i)
+		case opInsert:
+			insertedByText[p.compareKey(lines2[e.b])] = append(insertedByText[p.compareKey(lines2[e.b])], len(inserted))
+			inserted = append(inserted, i)
+		}
+	}
+
+	moveID := 0
+	for d := 0; d < len(deleted); {
+		best, bestLen := 0, 0
+		for _, in := range insertedByText[p.compareKey(lines1[script[deleted[d]].a])] {
+			n := 0
+			for d+n < len(deleted) && in+n < len(inserted) {
+				del, ins := script[deleted[d+n]], script[inserted[in+n]]
+				if ins.move != 0 || !p.linesEqual(lines1[del.a], lines2[ins.b]) {
+					break
+				}
+				if n > 0 && (del.a != script[deleted[d+n-1]].a+1 || ins.b != script[inserted[in+n-1]].b+1) {
+					break
+				}
+				n++
+			}
+			if n > bestLen {
+				best, bestLen = in, n
+			}
+		}
+
+		if bestLen < minLines {
+			d++
+			continue
+		}
+		moveID++
+		for k := 0; k < bestLen; k++ {
+			script[deleted[d+k]].move = moveID
+			script[inserted[best+k]].move = moveID
+		}
+		d += bestLen
+	}
+}
@@ -0,0 +1,108 @@
+package command_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	command "github.com/yupsh/diff"
+)
+
+// moveFixture builds a file from named blocks of generated lines
+func moveFixture(blocks ...string) string {
+	sizes := map[string]int{"header": 5, "function": 20, "middle": 30, "new": 4, "footer": 5}
+	var b strings.Builder
+	for _, name := range blocks {
+		for i := 1; i <= sizes[name]; i++ {
+			fmt.Fprintf(&b, "%s %d\n", name, i)
+		}
+	}
+	return b.String()
+}
+
+// moveTemplate prints kind, line number, move id and text for every change
+var moveTemplate = command.Template(`{{range .Hunks}}{{range .Ops}}{{if eq .Kind "delete"}}- {{.OldLine}} {{.MoveID}} {{.Text}}
+{{else if eq .Kind "insert"}}+ {{.NewLine}} {{.MoveID}} {{.Text}}
+{{end}}{{end}}{{end}}`)
+
+func TestDiff_DetectMoves(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", moveFixture("header", "function", "middle", "footer"))
+	b := writeFile(t, dir, "b", moveFixture("header", "middle", "function", "new", "footer"))
+
+	stdout, _, err := execute(command.Diff(a, b, command.DetectMoves, moveTemplate), "")
+	assertDiffers(t, err)
+
+	movedFrom, movedTo := map[string]string{}, map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		var kind, id, text string
+		var lineNo, n int
+		if _, err := fmt.Sscanf(line, "%s %d %s %s %d", &kind, &lineNo, &id, &text, &n); err != nil {
+			t.Fatalf("unexpected line %q: %v", line, err)
+		}
+		if text == "new" {
+			if id != "0" {
+				t.Errorf("new block flagged as moved: %q", line)
+			}
+			continue
+		}
+		if text != "function" {
+			continue
+		}
+		if id == "0" {
+			t.Errorf("moved function line not flagged: %q", line)
+		}
+		if kind == "-" {
+			movedFrom[fmt.Sprint(n)] = id
+		} else {
+			movedTo[fmt.Sprint(n)] = id
+		}
+	}
+	if len(movedFrom) != 20 || len(movedTo) != 20 {
+		t.Fatalf("expected 20 lines on each side of the move, got %d and %d:\n%s", len(movedFrom), len(movedTo), stdout)
+	}
+	for n, id := range movedFrom {
+		if movedTo[n] != id {
+			t.Errorf("function line %s has move id %s at the old place and %s at the new", n, id, movedTo[n])
+		}
+	}
+
+	// Without DetectMoves nothing is annotated
+	stdout, _, err = execute(command.Diff(a, b, moveTemplate), "")
+	assertDiffers(t, err)
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		if strings.Fields(line)[2] != "0" {
+			t.Fatalf("move id without DetectMoves: %q", line)
+		}
+	}
+}
+
+func TestDiff_DetectMovesMinimumBlock(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "}\nx\ny\nz\n")
+	b := writeFile(t, dir, "b", "x\ny\n}\nw\n")
+
+	for _, tt := range []struct {
+		min  command.MoveMinLines
+		want string
+	}{{0, "0"}, {1, "1"}} {
+		stdout, _, err := execute(command.Diff(a, b, command.DetectMoves, tt.min, moveTemplate), "")
+		assertDiffers(t, err)
+		if !strings.Contains(stdout, "- 1 "+tt.want+" }\n") {
+			t.Errorf("MoveMinLines(%d): got:\n%s", tt.min, stdout)
+		}
+	}
+}
+
+func TestDiff_DetectMovesSideBySideColor(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", moveFixture("function", "new"))
+	b := writeFile(t, dir, "b", moveFixture("new", "function"))
+
+	// The longer function block stays in place and the new block moves
+	stdout, _, err := execute(command.Diff(a, b, command.DetectMoves, command.SideBySide, command.ColorAlways), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stdout, "\x1b[35mnew 1\x1b[0m") || !strings.Contains(stdout, "\x1b[36mnew 1\x1b[0m") {
+		t.Errorf("moved lines not in the move colors:\n%q", stdout)
+	}
+}
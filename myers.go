@@ -0,0 +1,590 @@
+package command
+
+// minTooExpensive is the least number of edit steps a middle snake search
+// takes before it settles for a good split instead of the best one, as GNU
+// diff does; the bound grows with the square root of the input size
+const minTooExpensive = 4096
+
+// searchCost is what the searches of one comparison share: the CostLimit
+// of their splits, where their cost is added for ResourceStats, and how many
+// splits settled for a good split
+type searchCost struct {
+	limit   int
+	usage   *ResourceUsage
+	settled int
+}
+
+// snakeLimit is how long a run of matching lines SpeedLargeFiles insists on
+// before it takes a diagonal that is well ahead for the middle of a split,
+// as GNU diff's SNAKE_LIMIT
+const snakeLimit = 20
+
+// lineClasses numbers lines by their compare key: equal lines of either
+// side share a class, so the search compares integers
+func (p command) lineClasses(lines1, lines2 []string) (classes1, classes2 []int, count int) {
+	ids := make(map[string]int, len(lines1))
+	number := func(lines []string) []int {
+		classes := make([]int, len(lines))
+		for i, line := range lines {
+			key := p.compareKey(line)
+			id, ok := ids[key]
+			if !ok {
+				id = len(ids)
+				ids[key] = id
+			}
+			classes[i] = id
+		}
+		return classes
+	}
+	classes1, classes2 = number(lines1), number(lines2)
+	return classes1, classes2, len(ids)
+}
+
+// marks flags the changed lines of one side. It has a false sentinel at
+// each end, so marks.at(-1) and marks.at(len) can be read.
+type marks []bool
+
+func newMarks(n int) marks              { return make(marks, n+2) }
+func (m marks) at(i int) bool           { return m[i+1] }
+func (m marks) set(i int, changed bool) { m[i+1] = changed }
+
+// myers finds a short edit script between two sequences of line classes,
+// after the lines that cannot match are set aside, with the linear space
+// divide-and-conquer form of Myers' O(ND) algorithm
+type myers struct {
+	// a and b are the classes of the lines searched, and indexA and
+	// indexB their positions among all lines
+	a, b           []int
+	indexA, indexB []int
+
+	changedA, changedB marks
+
+	// fwd and bwd hold the furthest x reached on each diagonal by the
+	// forward and backward searches, offset so diagonal -len(b)-1 is 0
+	fwd, bwd     []int
+	offset       int
+	tooExpensive int
+
+	// steps counts the edit steps taken by every split, and settled the
+	// splits that reached tooExpensive and settled for a good split
+	steps, settled int
+
+	// heuristic is SpeedLargeFiles: splits are taken early wherever a
+	// diagonal has made much more progress than edit steps
+	heuristic bool
+}
+
+// analyze marks the lines of classes1 and classes2 that an edit script
+// deletes and inserts. Lines with no equal line on the other side are
+// marked first, with lines repeated so often that they confuse the search
+// when they sit among those; the search runs on the rest. The changed runs
+// are then shifted to where GNU diff puts them. When minimal is set no line
+// is set aside and the search never settles for a good split, so the script
+// changes as few lines as possible. heuristic is GNU diff's
+// --speed-large-files, which minimal overrides. A split settles after the
+// limit of cost when one is set, and the search adds its cost there.
+func analyze(classes1, classes2 []int, count int, minimal, heuristic bool, cost *searchCost) (marks, marks) {
+	m := &myers{changedA: newMarks(len(classes1)), changedB: newMarks(len(classes2)), heuristic: heuristic}
+	m.a, m.indexA, m.b, m.indexB = discardConfusing(classes1, classes2, count, minimal, m.changedA, m.changedB)
+
+	diags := len(m.a) + len(m.b) + 3
+	m.fwd, m.bwd = make([]int, diags), make([]int, diags)
+	m.offset = len(m.b) + 1
+	m.tooExpensive = 1
+	for n := diags; n != 0; n >>= 2 {
+		m.tooExpensive <<= 1
+	}
+	m.tooExpensive = max(minTooExpensive, m.tooExpensive)
+	if cost.limit > 0 {
+		m.tooExpensive = cost.limit
+	}
+
+	m.compareSeq(0, len(m.a), 0, len(m.b), minimal)
+	cost.usage.recordSearch(m.steps, m.tooExpensive, m.settled)
+	cost.settled += m.settled
+	shiftBoundaries(m.changedA, m.changedB, classes1)
+	shiftBoundaries(m.changedB, m.changedA, classes2)
+	return m.changedA, m.changedB
+}
+
+// discardConfusing marks the lines of each side that match no line of the
+// other, and those matching many that lie within a run of such lines, and
+// returns the classes and positions of the lines left to search. This is
+// GNU diff's discard_confusing_lines, which GNU diff -d skips: when minimal
+// is set every line is searched.
+func discardConfusing(classes1, classes2 []int, count int, minimal bool, changed1, changed2 marks) (a, indexA, b, indexB []int) {
+	counts := [2][]int{make([]int, count), make([]int, count)}
+	for _, c := range classes1 {
+		counts[0][c]++
+	}
+	for _, c := range classes2 {
+		counts[1][c]++
+	}
+
+	sides := [2][]int{classes1, classes2}
+	changed := [2]marks{changed1, changed2}
+	var kept [2][]int
+	var index [2][]int
+	for f, classes := range sides {
+		// 1 discards a line, 2 discards it provisionally
+		discards := make([]byte, len(classes))
+		many := 5
+		for tem := len(classes) / 64; ; {
+			if tem >>= 2; tem <= 0 {
+				break
+			}
+			many *= 2
+		}
+		for i, c := range classes {
+			switch n := counts[1-f][c]; {
+			case minimal:
+			case n == 0:
+				discards[i] = 1
+			case n > many:
+				discards[i] = 2
+			}
+		}
+		settleProvisional(discards)
+
+		for i, c := range classes {
+			if discards[i] == 0 {
+				kept[f] = append(kept[f], c)
+				index[f] = append(index[f], i)
+			} else {
+				changed[f].set(i, true)
+			}
+		}
+	}
+	return kept[0], index[0], kept[1], index[1]
+}
+
+// settleProvisional keeps provisional discards only inside runs of
+// discards that start and end with a definite one, and then only where they
+// are few and not near the ends of the run
+func settleProvisional(discards []byte) {
+	end := len(discards)
+	for i := 0; i < end; i++ {
+		if discards[i] == 2 {
+			discards[i] = 0
+			continue
+		}
+		if discards[i] == 0 {
+			continue
+		}
+
+		// The run of discardable lines from i, without provisional ones at
+		// its end
+		j, provisional := i, 0
+		for ; j < end && discards[j] != 0; j++ {
+			if discards[j] == 2 {
+				provisional++
+			}
+		}
+		for j > i && discards[j-1] == 2 {
+			j--
+			discards[j] = 0
+			provisional--
+		}
+		length := j - i
+
+		if provisional*4 > length {
+			for k := i; k < j; k++ {
+				if discards[k] == 2 {
+					discards[k] = 0
+				}
+			}
+			i = j - 1
+			continue
+		}
+
+		// minimum is about the square root of length/4; a run of that many
+		// provisional lines is cancelled
+		minimum := 1
+		for tem := length >> 2; ; {
+			if tem >>= 2; tem <= 0 {
+				break
+			}
+			minimum <<= 1
+		}
+		minimum++
+		for k, consec := 0, 0; k < length; k++ {
+			switch {
+			case discards[i+k] != 2:
+				consec = 0
+			case minimum == consec+1:
+				consec++
+				k -= consec
+			case minimum < consec+1:
+				consec++
+				discards[i+k] = 0
+			default:
+				consec++
+			}
+		}
+
+		// Provisional lines before three definite ones in a row, or before
+		// the first definite one at least eight lines in, are cancelled, at
+		// each end of the run
+		for k, consec := 0, 0; k < length; k++ {
+			if k >= 8 && discards[i+k] == 1 {
+				break
+			}
+			switch discards[i+k] {
+			case 2:
+				consec = 0
+				discards[i+k] = 0
+			case 0:
+				consec = 0
+			default:
+				consec++
+			}
+			if consec == 3 {
+				break
+			}
+		}
+		i += length - 1
+		for k, consec := 0, 0; k < length; k++ {
+			if k >= 8 && discards[i-k] == 1 {
+				break
+			}
+			switch discards[i-k] {
+			case 2:
+				consec = 0
+				discards[i-k] = 0
+			case 0:
+				consec = 0
+			default:
+				consec++
+			}
+			if consec == 3 {
+				break
+			}
+		}
+	}
+}
+
+// compareSeq marks the changes between a[xoff:xlim] and b[yoff:ylim]. Once
+// minimal is set the search below never settles for a good split.
+func (m *myers) compareSeq(xoff, xlim, yoff, ylim int, minimal bool) {
+	for xoff < xlim && yoff < ylim && m.a[xoff] == m.b[yoff] {
+		xoff++
+		yoff++
+	}
+	for xoff < xlim && yoff < ylim && m.a[xlim-1] == m.b[ylim-1] {
+		xlim--
+		ylim--
+	}
+
+	switch {
+	case xoff == xlim:
+		for ; yoff < ylim; yoff++ {
+			m.changedB.set(m.indexB[yoff], true)
+		}
+	case yoff == ylim:
+		for ; xoff < xlim; xoff++ {
+			m.changedA.set(m.indexA[xoff], true)
+		}
+	default:
+		xmid, ymid, loMinimal, hiMinimal := m.split(xoff, xlim, yoff, ylim, minimal)
+		m.compareSeq(xoff, xmid, yoff, ymid, loMinimal)
+		m.compareSeq(xmid, xlim, ymid, ylim, hiMinimal)
+	}
+}
+
+// split finds the middle snake of a[xoff:xlim] and b[yoff:ylim] by running
+// the search forward from the start and backward from the end until the two
+// meet. Unless minimal is set, a search that has taken tooExpensive steps
+// stops at the diagonal that got furthest instead, and only the half it
+// reached is then searched minimally.
+func (m *myers) split(xoff, xlim, yoff, ylim int, minimal bool) (xmid, ymid int, loMinimal, hiMinimal bool) {
+	fd, bd, off := m.fwd, m.bwd, m.offset
+	dmin, dmax := xoff-ylim, xlim-yoff
+	fmid, bmid := xoff-yoff, xlim-ylim
+	fmin, fmax, bmin, bmax := fmid, fmid, bmid, bmid
+	odd := (fmid-bmid)&1 != 0
+
+	fd[off+fmid] = xoff
+	bd[off+bmid] = xlim
+	for c := 1; ; c++ {
+		m.steps++
+		bigSnake := false
+
+		// Extend the forward search by an edit step on each diagonal
+		if fmin > dmin {
+			fmin--
+			fd[off+fmin-1] = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			fd[off+fmax+1] = -1
+		} else {
+			fmax--
+		}
+		for d := fmax; d >= fmin; d -= 2 {
+			tlo, thi := fd[off+d-1], fd[off+d+1]
+			x0 := tlo + 1
+			if tlo < thi {
+				x0 = thi
+			}
+			x, y := x0, x0-d
+			for x < xlim && y < ylim && m.a[x] == m.b[y] {
+				x++
+				y++
+			}
+			bigSnake = bigSnake || x-x0 >= snakeLimit
+			fd[off+d] = x
+			if odd && bmin <= d && d <= bmax && bd[off+d] <= x {
+				return x, y, true, true
+			}
+		}
+
+		// And the backward search
+		if bmin > dmin {
+			bmin--
+			bd[off+bmin-1] = int(^uint(0) >> 1)
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			bd[off+bmax+1] = int(^uint(0) >> 1)
+		} else {
+			bmax--
+		}
+		for d := bmax; d >= bmin; d -= 2 {
+			tlo, thi := bd[off+d-1], bd[off+d+1]
+			x0 := thi - 1
+			if tlo < thi {
+				x0 = tlo
+			}
+			x, y := x0, x0-d
+			for xoff < x && yoff < y && m.a[x-1] == m.b[y-1] {
+				x--
+				y--
+			}
+			bigSnake = bigSnake || x0-x >= snakeLimit
+			bd[off+d] = x
+			if !odd && fmin <= d && d <= fmax && x <= fd[off+d] {
+				return x, y, true, true
+			}
+		}
+
+		if minimal {
+			continue
+		}
+		if m.heuristic && c > 200 && bigSnake {
+			if xmid, ymid, loMinimal, hiMinimal, ok := m.snakeSplit(xoff, xlim, yoff, ylim, fmin, fmax, bmin, bmax, c); ok {
+				return xmid, ymid, loMinimal, hiMinimal
+			}
+		}
+		if c < m.tooExpensive {
+			continue
+		}
+
+		// Settle for the forward diagonal furthest along, or the backward
+		// one, whichever got further
+		m.settled++
+		fxybest, fxbest := -1, 0
+		for d := fmax; d >= fmin; d -= 2 {
+			x := min(fd[off+d], xlim)
+			y := x - d
+			if ylim < y {
+				x, y = ylim+d, ylim
+			}
+			if fxybest < x+y {
+				fxybest, fxbest = x+y, x
+			}
+		}
+		bxybest, bxbest := int(^uint(0)>>1), 0
+		for d := bmax; d >= bmin; d -= 2 {
+			x := max(xoff, bd[off+d])
+			y := x - d
+			if y < yoff {
+				x, y = yoff+d, yoff
+			}
+			if x+y < bxybest {
+				bxybest, bxbest = x+y, x
+			}
+		}
+		if (xlim+ylim)-bxybest < fxybest-(xoff+yoff) {
+			return fxbest, fxybest - fxbest, true, false
+		}
+		return bxbest, bxybest - bxbest, false, true
+	}
+}
+
+// snakeSplit looks, after c edit steps of a split, for the diagonal that has
+// made the most progress compared with the steps it took, provided that is
+// a lot and it ends in snakeLimit matching lines, to split there as if the
+// searches had met. The forward diagonals are tried before the backward
+// ones. This is GNU diff's heuristic for --speed-large-files, which keeps
+// the search linear in the size of inputs with a small density of changes.
+func (m *myers) snakeSplit(xoff, xlim, yoff, ylim, fmin, fmax, bmin, bmax, c int) (xmid, ymid int, loMinimal, hiMinimal, ok bool) {
+	fd, bd, off := m.fwd, m.bwd, m.offset
+	fmid, bmid := xoff-yoff, xlim-ylim
+	best := 0
+	for d := fmax; d >= fmin; d -= 2 {
+		dd := d - fmid
+		x := fd[off+d]
+		y := x - d
+		v := (x - xoff) + (y - yoff) - dd
+		if v <= 12*(c+max(dd, -dd)) || v <= best || x < xoff+snakeLimit || x >= xlim || y < yoff+snakeLimit || y >= ylim {
+			continue
+		}
+		// The diagonal must end in a long enough snake
+		k := 1
+		for k < snakeLimit && m.a[x-k] == m.b[y-k] {
+			k++
+		}
+		if k == snakeLimit && m.a[x-k] == m.b[y-k] {
+			best, xmid, ymid = v, x, y
+		}
+	}
+	if best > 0 {
+		return xmid, ymid, true, false, true
+	}
+
+	for d := bmax; d >= bmin; d -= 2 {
+		dd := d - bmid
+		x := bd[off+d]
+		y := x - d
+		v := (xlim - x) + (ylim - y) - dd
+		if v <= 12*(c+max(dd, -dd)) || v <= best || x <= xoff || x > xlim-snakeLimit || y <= yoff || y > ylim-snakeLimit {
+			continue
+		}
+		k := 0
+		for k < snakeLimit-1 && m.a[x+k] == m.b[y+k] {
+			k++
+		}
+		if k == snakeLimit-1 && m.a[x+k] == m.b[y+k] {
+			best, xmid, ymid = v, x, y
+		}
+	}
+	if best > 0 {
+		return xmid, ymid, false, true, true
+	}
+	return 0, 0, false, false, false
+}
+
+// shiftBoundaries moves each run of changed lines of one side, among lines
+// of equal classes, so that it merges with neighbouring runs where it can,
+// lies as far down as it can otherwise, and then moves back up to line up
+// with a run of changes on the other side. This is GNU diff's
+// shift_boundaries, and keeps the output the same as its.
+func shiftBoundaries(changed, other marks, classes []int) {
+	i, j, end := 0, 0, len(classes)
+	for {
+		// Find the start of the next run, keeping j at the matching point
+		// of the other side
+		for i < end && !changed.at(i) {
+			for other.at(j) {
+				j++
+			}
+			j++
+			i++
+		}
+		if i == end {
+			return
+		}
+		start := i
+
+		// And its end
+		i++
+		for changed.at(i) {
+			i++
+		}
+		for other.at(j) {
+			j++
+		}
+
+		corresponding := end
+		for {
+			runLength := i - start
+
+			// Move the run up while the line above it equals its last
+			// line, merging it with runs above
+			for start > 0 && classes[start-1] == classes[i-1] {
+				start--
+				changed.set(start, true)
+				i--
+				changed.set(i, false)
+				for changed.at(start - 1) {
+					start--
+				}
+				j--
+				for other.at(j) {
+					j--
+				}
+			}
+
+			// corresponding is the last end of the run at which it lines
+			// up with a run of the other side, or end if there is none
+			corresponding = end
+			if other.at(j - 1) {
+				corresponding = i
+			}
+
+			// Then down while its first line equals the line below it,
+			// merging it with runs below
+			for i != end && classes[start] == classes[i] {
+				changed.set(start, false)
+				start++
+				changed.set(i, true)
+				i++
+				for changed.at(i) {
+					i++
+				}
+				j++
+				for other.at(j) {
+					corresponding = i
+					j++
+				}
+			}
+			if runLength == i-start {
+				break
+			}
+		}
+
+		// Move the merged run back up to line up with the other side
+		for corresponding < i {
+			start--
+			changed.set(start, true)
+			i--
+			changed.set(i, false)
+			j--
+			for other.at(j) {
+				j--
+			}
+		}
+	}
+}
+
+// scriptFromMarks builds the edit script of lines1 and lines2 from their
+// changed lines, each run of changes a deletion followed by an insertion
+func scriptFromMarks(script []edit, changed1, changed2 marks, n1, n2, from int) []edit {
+	i, j := 0, 0
+	for i < n1 || j < n2 {
+		if i < n1 && j < n2 && !changed1.at(i) && !changed2.at(j) {
+			script = append(script, edit{kind: opEqual, a: from + i, b: from + j})
+			i++
+			j++
+			continue
+		}
+		start1, start2 := i, j
+		for i < n1 && changed1.at(i) {
+			i++
+		}
+		for j < n2 && changed2.at(j) {
+			j++
+		}
+		for k := start1; k < i; k++ {
+			script = append(script, edit{kind: opDelete, a: from + k, b: from + start2})
+		}
+		for k := start2; k < j; k++ {
+			script = append(script, edit{kind: opInsert, a: from + i, b: from + k})
+		}
+	}
+	return script
+}
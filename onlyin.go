@@ -0,0 +1,99 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// maxOnlyInEntries bounds how many entries of a directory found on one side
+// only OnlyInDetails counts
+const maxOnlyInEntries = 1000
+
+// EntryInfo describes an entry found in one tree only
+type EntryInfo struct {
+	// Type is "file", "directory", "symlink" or "other"
+	Type string
+	// Size is the size of a file in bytes
+	Size int64
+	// Entries counts the entries of a directory, at most 1000; More
+	// reports that it has further entries that were not counted
+	Entries int
+	More    bool
+	// Target is where a symlink points
+	Target string
+}
+
+// String renders the details of an Only in message, such as "file, 5.2 GB"
+// or "directory, 1000+ entries"
+func (e EntryInfo) String() string {
+	switch e.Type {
+	case "file":
+		return "file, " + formatSize(e.Size)
+	case "directory":
+		count := strconv.Itoa(e.Entries)
+		if e.More {
+			count += "+"
+		}
+		if e.Entries == 1 && !e.More {
+			return "directory, 1 entry"
+		}
+		return "directory, " + count + " entries"
+	case "symlink":
+		return "symlink to " + e.Target
+	default:
+		return e.Type
+	}
+}
+
+// describeEntry examines the entry at path without following a symlink.
+// A directory is only read as far as maxOnlyInEntries+1 names.
+func describeEntry(path string) (EntryInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		return EntryInfo{Type: "file", Size: info.Size()}, nil
+	case mode&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return EntryInfo{}, err
+		}
+		return EntryInfo{Type: "symlink", Target: target}, nil
+	case mode.IsDir():
+		dir, err := os.Open(path)
+		if err != nil {
+			return EntryInfo{}, err
+		}
+		defer dir.Close()
+		// An empty directory reads as io.EOF
+		names, err := dir.Readdirnames(maxOnlyInEntries + 1)
+		if err != nil && err != io.EOF {
+			return EntryInfo{}, err
+		}
+		return EntryInfo{Type: "directory", Entries: min(len(names), maxOnlyInEntries), More: len(names) > maxOnlyInEntries}, nil
+	default:
+		return EntryInfo{Type: "other"}, nil
+	}
+}
+
+// formatSize renders a byte count in decimal units with one digit after
+// the point, such as "5.2 GB", and counts below a kilobyte exactly
+func formatSize(n int64) string {
+	if n < 1000 {
+		if n == 1 {
+			return "1 byte"
+		}
+		return strconv.FormatInt(n, 10) + " bytes"
+	}
+	units := []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+	size, unit := float64(n)/1000, 0
+	for size >= 999.95 && unit < len(units)-1 {
+		size, unit = size/1000, unit+1
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
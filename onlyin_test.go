@@ -0,0 +1,85 @@
+package command_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_OnlyInDetails(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	writeFile(t, dir1, "stub.bin", "ab")
+	big := writeFile(t, dir1, "big.bin", "")
+	assertion.NoError(t, os.Truncate(big, 5_200_000_000))
+	writeTree(t, filepath.Join(dir1, "small"), map[string]string{"a": "a\n", "b": "b\n"})
+	assertion.NoError(t, os.Mkdir(filepath.Join(dir2, "empty"), 0o755))
+	if err := os.Symlink("same.txt", filepath.Join(dir2, "link")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Brief, command.OnlyInDetails), "")
+	assertDiffers(t, err)
+	for _, want := range []string{
+		"Only in " + dir1 + ": big.bin (file, 5.2 GB)\n",
+		"Only in " + dir1 + ": only1.txt (file, 5 bytes)\n",
+		"Only in " + dir1 + ": small (directory, 2 entries)\n",
+		"Only in " + dir1 + ": stub.bin (file, 2 bytes)\n",
+		"Only in " + dir2 + ": empty (directory, 0 entries)\n",
+		"Only in " + dir2 + ": link (symlink to same.txt)\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+
+	// Without the flag the messages are GNU diff's
+	stdout, _, _ = execute(command.Diff(dir1, dir2, command.Brief), "")
+	if !strings.Contains(stdout, "Only in "+dir1+": big.bin\n") {
+		t.Errorf("got:\n%s", stdout)
+	}
+}
+
+func TestDiff_OnlyInDetailsEntryCap(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	full, capped := map[string]string{"full/extra": ""}, make(map[string]string)
+	for i := range 1000 {
+		full[fmt.Sprintf("full/%04d", i)] = ""
+		capped[fmt.Sprintf("capped/%04d", i)] = ""
+	}
+	writeTree(t, dir1, full)
+	writeTree(t, dir2, capped)
+
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Brief, command.OnlyInDetails), "")
+	assertDiffers(t, err)
+	over := "Only in " + dir1 + ": full (directory, 1000+ entries)\n"
+	under := "Only in " + dir2 + ": capped (directory, 1000 entries)\n"
+	if !strings.Contains(stdout, over) || !strings.Contains(stdout, under) {
+		t.Errorf("got:\n%s", stdout)
+	}
+}
+
+func TestCompareTrees_OnlyInDetails(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	writeTree(t, filepath.Join(dir2, "new"), map[string]string{"a": "a\n"})
+
+	entries := make(map[string]*command.EntryInfo)
+	for result, err := range command.CompareTrees(context.Background(), dir1, dir2, command.OnlyInDetails) {
+		assertion.NoError(t, err)
+		if result.Kind == command.PairOnlyLeft || result.Kind == command.PairOnlyRight {
+			entries[result.Path] = result.Entry
+		}
+	}
+	// The sizes are raw byte counts
+	if e := entries["only1.txt"]; e == nil || e.Type != "file" || e.Size != 5 {
+		t.Errorf("got %+v", e)
+	}
+	if e := entries["new"]; e == nil || e.Type != "directory" || e.Entries != 1 || e.More {
+		t.Errorf("got %+v", e)
+	}
+}
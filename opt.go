@@ -1,7 +1,133 @@
 package command
 
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+
+	gloo "github.com/gloo-foo/framework"
+)
+
+// EndOfOptions marks the end of options: every operand after it is a
+// literal file name, so "-" names a file rather than stdin
+const EndOfOptions = "--"
+
+// ContextLines is the context of context format hunks, and UnifiedContext
+// that of unified hunks, 3 lines when not given. Given as options, 0 asks
+// for hunks without context; a zero in a Flags struct is the default unless
+// ContextLinesSet or UnifiedContextSet marks it as given.
 type ContextLines int
 type UnifiedContext int
+type OutputPrefix string
+type Expected string
+type HunkFilter string
+
+// CountThreshold stops the count of CountOnly once more lines than this
+// differ, and prints one more than the threshold. When the count is all
+// that is printed of a pair, the search itself stops there.
+type CountThreshold int
+type Template string
+
+// InterHunkContext merges two hunks whose context leaves no more than n
+// unchanged lines between them, like git diff --inter-hunk-context, so
+// changes a few lines apart print as one hunk. The default of 0 merges
+// hunks whose context touches. Normal format has no context and is never
+// merged.
+type InterHunkContext int
+
+// CollapseUnchanged folds runs of more than n unchanged lines in outputs
+// that show every line, keeping n/2 lines next to the changes
+type CollapseUnchanged int
+
+// MaxCompareBytes compares only the first n bytes of every line
+type MaxCompareBytes int
+
+// MoveMinLines is the smallest block DetectMoves reports as moved
+type MoveMinLines int
+
+// Width is the total output width of side-by-side output
+type Width int
+
+// CompareHook is called every time an edit script is computed
+type CompareHook func()
+
+// OpenHook is called with the path of every file read in full
+type OpenHook func(path string)
+
+// StatsHook is called with the statistics of every file pair whose lines
+// are compared, identical or not. Pairs settled by a CacheFile verdict, and
+// comparisons streamed by Chunked or FirstDifference, are not reported.
+type StatsHook func(file1, file2 string, stats Stats)
+
+// DeviceID reports the device of the file at path, in place of the
+// platform's own, for OneFileSystem
+type DeviceID func(path string) (uint64, bool)
+
+// FileStat reports the file information of path in place of os.Stat for
+// the check that a file did not change while it was read
+type FileStat func(path string) (os.FileInfo, error)
+
+// RetryOnChange reads a file again, up to n more times, when its size or
+// modification time changed while it was read, as a log being written to
+// does. A file still changing after that is compared as last read, with a
+// warning, and makes the comparison trouble. Without retries the warning
+// follows the first changed read.
+type RetryOnChange int
+
+// MaxMemory bounds the bytes of standard input kept in memory when a
+// comparison reads it more than once, as when both operands are "-". The
+// rest is spilled to a temporary file in TMPDIR, which is removed when the
+// comparison ends. Zero keeps the default of 4MB.
+type MaxMemory int
+
+// CacheFile records the verdict of every file pair of a directory comparison
+// together with both files' size and modification time. A later comparison
+// under the same comparison flags reuses the verdict of pairs whose files
+// still match, without reading them. A file rewritten with the same size
+// within the filesystem's timestamp granularity is not noticed; NoCache
+// compares every pair in full and rewrites the cache.
+type CacheFile string
+
+// HorizonLines keeps at least n lines of the common prefix and suffix in
+// the range the comparison analyzes, like GNU diff --horizon-lines, so a
+// change next to them can still be placed where it reads best. The context
+// of unified and context output is always kept; this can only add to it.
+type HorizonLines int
+
+// CostLimit is how many edit steps a split of Myers' search may take before
+// it settles for a good split instead of the best one, in place of the
+// default that grows with the square root of the input size. A smaller
+// limit bounds the time adversarial inputs can take, at the price of
+// scripts that change more lines than needed, which is noted on stderr.
+// Minimal never settles.
+type CostLimit int
+
+// Chunked diffs two files in windows of this many lines per side, and the
+// line after each, cut at runs of equal lines, so memory stays bounded
+// however long the files are.
+// Changes are aligned within a window only, and a window without any run of
+// equal lines is replaced whole. Only plain unified output is supported.
+type Chunked int
+
+// ChunkProbe is called in Chunked mode with the number of lines buffered
+// after each window is read
+type ChunkProbe func(buffered int)
+
+// Pairs compares every left<TAB>right pair listed in a manifest file, one
+// pair per line, in place of operands. Blank lines and lines starting with
+// '#' are skipped, and relative paths are taken from the manifest's
+// directory.
+type Pairs string
+
+// Progress is called with the number of edit script lines examined so far
+type Progress func(examined int)
 
 type UnifiedFlag bool
 
@@ -38,6 +164,35 @@ const (
 	NoIgnoreWhitespace IgnoreWhitespaceFlag = false
 )
 
+// IgnoreANSIFlag compares lines without their terminal escape sequences, so
+// colored output compares equal to its plain twin
+type IgnoreANSIFlag bool
+
+const (
+	IgnoreANSI   IgnoreANSIFlag = true
+	NoIgnoreANSI IgnoreANSIFlag = false
+)
+
+// StripANSIFlag also removes the escape sequences from the printed lines
+// under IgnoreANSI
+type StripANSIFlag bool
+
+const (
+	StripANSI   StripANSIFlag = true
+	NoStripANSI StripANSIFlag = false
+)
+
+// CanonicalJSONFlag compares inputs that hold a JSON document in a
+// canonical form, with sorted object keys and uniform indentation. Headers
+// mark the canonicalized sides, as the output no longer patches the
+// originals.
+type CanonicalJSONFlag bool
+
+const (
+	CanonicalJSON   CanonicalJSONFlag = true
+	NoCanonicalJSON CanonicalJSONFlag = false
+)
+
 type SideBySideFlag bool
 
 const (
@@ -52,24 +207,945 @@ const (
 	NoRecursive RecursiveFlag = false
 )
 
+type QuietFlag bool
+
+const (
+	Quiet   QuietFlag = true
+	NoQuiet QuietFlag = false
+)
+
+type FirstDifferenceFlag bool
+
+const (
+	FirstDifference   FirstDifferenceFlag = true
+	NoFirstDifference FirstDifferenceFlag = false
+)
+
+type ZipFlag bool
+
+const (
+	Zip   ZipFlag = true
+	NoZip ZipFlag = false
+)
+
+type UpdateFirstFlag bool
+
+const (
+	UpdateFirst   UpdateFirstFlag = true
+	NoUpdateFirst UpdateFirstFlag = false
+)
+
+type UpdateRecursiveFlag bool
+
+const (
+	UpdateRecursive   UpdateRecursiveFlag = true
+	NoUpdateRecursive UpdateRecursiveFlag = false
+)
+
+type FullContextFlag bool
+
+const (
+	FullContext   FullContextFlag = true
+	NoFullContext FullContextFlag = false
+)
+
+type CountOnlyFlag bool
+
+const (
+	CountOnly   CountOnlyFlag = true
+	NoCountOnly CountOnlyFlag = false
+)
+
+type ShowLineNumbersFlag bool
+
+const (
+	ShowLineNumbers   ShowLineNumbersFlag = true
+	NoShowLineNumbers ShowLineNumbersFlag = false
+)
+
+type NoBannerFlag bool
+
+const (
+	NoBanner NoBannerFlag = true
+	Banner   NoBannerFlag = false
+)
+
+// NoHeaderFlag leaves out the file header lines of unified and context
+// output, so only hunks are printed
+type NoHeaderFlag bool
+
+const (
+	NoHeader NoHeaderFlag = true
+	Header   NoHeaderFlag = false
+)
+
+type LineMapFlag bool
+
+const (
+	LineMap   LineMapFlag = true
+	NoLineMap LineMapFlag = false
+)
+
+type RawEventsFlag bool
+
+const (
+	RawEvents   RawEventsFlag = true
+	NoRawEvents RawEventsFlag = false
+)
+
+type ConflictMarkersFlag bool
+
+const (
+	ConflictMarkers   ConflictMarkersFlag = true
+	NoConflictMarkers ConflictMarkersFlag = false
+)
+
+type SideBySideTSVFlag bool
+
+const (
+	SideBySideTSV   SideBySideTSVFlag = true
+	NoSideBySideTSV SideBySideTSVFlag = false
+)
+
+// EdScriptFlag prints an ed script that turns the first file into the
+// second, like GNU diff -e: the changes last first, so piping the script
+// into ed - file1 applies each before the line numbers of the ones above it
+// move. ed has no way to leave out a final newline, so the missing newline
+// at the end of a file is not a difference here.
+type EdScriptFlag bool
+
+const (
+	EdScript   EdScriptFlag = true
+	NoEdScript EdScriptFlag = false
+)
+
+// ForwardEdScriptFlag prints the changes like GNU diff -f: the commands of
+// an ed script in forward order, each letter before the lines it applies
+// to, as in c5 7 or a3. What it leaves unchanged is as for EdScript.
+type ForwardEdScriptFlag bool
+
+const (
+	ForwardEdScript   ForwardEdScriptFlag = true
+	NoForwardEdScript ForwardEdScriptFlag = false
+)
+
+type EditDistanceFlag bool
+
+const (
+	EditDistance   EditDistanceFlag = true
+	NoEditDistance EditDistanceFlag = false
+)
+
+// DiscardFlag compares as usual but writes nothing to stdout, so the result
+// is seen only in the returned error, on stderr and through hooks such as
+// StatsHook and Progress. Early exits such as FirstDifference and
+// CountThreshold still apply.
+type DiscardFlag bool
+
+const (
+	Discard   DiscardFlag = true
+	NoDiscard DiscardFlag = false
+)
+
+type WithSimilarityFlag bool
+
+const (
+	WithSimilarity    WithSimilarityFlag = true
+	WithoutSimilarity WithSimilarityFlag = false
+)
+
+type TimestampFormat int
+
+const (
+	TimestampNone TimestampFormat = iota
+	TimestampTraditional
+	TimestampRFC3339
+)
+
+// layout is the time layout headers use, empty when timestamps are off
+func (t TimestampFormat) layout() string {
+	switch t {
+	case TimestampTraditional:
+		return "2006-01-02 15:04:05.000000000 -0700"
+	case TimestampRFC3339:
+		return time.RFC3339Nano
+	default:
+		return ""
+	}
+}
+
+type ShowIndexFlag bool
+
+const (
+	ShowIndex   ShowIndexFlag = true
+	NoShowIndex ShowIndexFlag = false
+)
+
+// IndexHash selects the hash ShowIndex prints for each side
+type IndexHash int
+
+const (
+	IndexSHA1 IndexHash = iota
+	IndexSHA256
+)
+
+// digest is the hex hash of content
+func (h IndexHash) digest(content []byte) string {
+	switch h {
+	case IndexSHA256:
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:])
+	default:
+		sum := sha1.Sum(content)
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// SynchronizedOutputFlag orders diagnostics with output as if stdout and
+// stderr were the same writer, which is detected without it: a diagnostic
+// raised while the output of a file pair is being written follows that
+// output, and under EncodedOutput the encoded line, rather than landing
+// inside a hunk. Writes to either are serialized.
+type SynchronizedOutputFlag bool
+
+const (
+	SynchronizedOutput   SynchronizedOutputFlag = true
+	NoSynchronizedOutput SynchronizedOutputFlag = false
+)
+
+// EncodedOutput writes the whole of stdout as one encoded line, for
+// embedding in URLs or log fields; DecodeDiff reverses it. Without another
+// format selected, the encoded format is unified.
+type EncodedOutput int
+
+const (
+	EncodeNone EncodedOutput = iota
+	EncodePercent
+	EncodeBase64
+)
+
+// Hyperlinks makes file names and unified hunk headers OSC 8 hyperlinks
+// when output is colored. The value is a URI template in which {path} is the
+// file's absolute, slash-separated path and {line} a line number.
+type Hyperlinks string
+
+// FileLinks links to local files, for terminals that open them directly
+const FileLinks Hyperlinks = "file://{path}#{line}"
+
+type PathSeparators int
+
+const (
+	// DefaultPathSeparators prints forward slashes on Windows and paths
+	// unchanged elsewhere
+	DefaultPathSeparators PathSeparators = iota
+	ForwardSlashPaths
+	NativePathSeparators
+)
+
+type DetectMovesFlag bool
+
+const (
+	DetectMoves   DetectMovesFlag = true
+	NoDetectMoves DetectMovesFlag = false
+)
+
+type RawBytesFlag bool
+
+const (
+	RawBytes   RawBytesFlag = true
+	NoRawBytes RawBytesFlag = false
+)
+
+type NoCacheFlag bool
+
+const (
+	NoCache  NoCacheFlag = true
+	UseCache NoCacheFlag = false
+)
+
+// ReverseFlag compares the second operand against the first, as if the
+// operands had been given the other way round
+type ReverseFlag bool
+
+const (
+	Reverse   ReverseFlag = true
+	NoReverse ReverseFlag = false
+)
+
+// TreeStatFlag summarizes a directory comparison as one row per differing
+// file with its insertions and deletions drawn as a bar, instead of diffs
+type TreeStatFlag bool
+
+const (
+	TreeStat   TreeStatFlag = true
+	NoTreeStat TreeStatFlag = false
+)
+
+// OnlyInDetailsFlag adds the type and size of each entry found on one side
+// only to its message, as in "Only in dir1: data.bin (file, 5.2 GB)" or
+// "(directory, 312 entries)". Directories are counted up to 1000 entries and
+// reported as "1000+ entries" past that, so huge trees do not stall the
+// walk. CompareTrees gives the raw byte counts in PairResult.Entry.
+type OnlyInDetailsFlag bool
+
+const (
+	OnlyInDetails   OnlyInDetailsFlag = true
+	NoOnlyInDetails OnlyInDetailsFlag = false
+)
+
+// OneFileSystemFlag keeps a recursive comparison from descending into a
+// directory on a different device than its tree's root, like -x of du and
+// rsync. It has no effect where devices cannot be told apart.
+type OneFileSystemFlag bool
+
+const (
+	OneFileSystem   OneFileSystemFlag = true
+	NoOneFileSystem OneFileSystemFlag = false
+)
+
+// IgnoreFileNameCaseFlag pairs directory entries whose names differ only in
+// case. Names that collide this way on one side pair with an exact-case
+// match first, then in lexicographic order.
+type IgnoreFileNameCaseFlag bool
+
+const (
+	IgnoreFileNameCase   IgnoreFileNameCaseFlag = true
+	NoIgnoreFileNameCase IgnoreFileNameCaseFlag = false
+)
+
+type ColorMode int
+
+const (
+	ColorNever ColorMode = iota
+	ColorAuto
+	ColorAlways
+)
+
+type Format int
+
+const (
+	FormatNormal Format = iota
+	FormatUnified
+	FormatContext
+	FormatCount
+	FormatSideBySide
+	FormatLineMap
+	FormatRawEvents
+	FormatConflictMarkers
+	FormatEditDistance
+	FormatSideBySideTSV
+	FormatEd
+	FormatForwardEd
+	FormatJSON
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatUnified:
+		return "unified"
+	case FormatContext:
+		return "context"
+	case FormatCount:
+		return "count"
+	case FormatSideBySide:
+		return "side-by-side"
+	case FormatLineMap:
+		return "line map"
+	case FormatRawEvents:
+		return "raw events"
+	case FormatConflictMarkers:
+		return "conflict markers"
+	case FormatEditDistance:
+		return "edit distance"
+	case FormatSideBySideTSV:
+		return "side-by-side TSV"
+	case FormatEd:
+		return "ed"
+	case FormatForwardEd:
+		return "forward ed"
+	case FormatJSON:
+		return "JSON"
+	default:
+		return "normal"
+	}
+}
+
+// isEd reports whether the format is an ed script, forward or not
+func (f Format) isEd() bool {
+	return f == FormatEd || f == FormatForwardEd
+}
+
+// showsUnchanged reports whether the format renders identical inputs as
+// their unchanged lines rather than as nothing
+func (f Format) showsUnchanged() bool {
+	return f == FormatLineMap || f == FormatRawEvents || f == FormatConflictMarkers || f == FormatSideBySideTSV
+}
+
+// SectionPattern appends to each unified hunk header the Group capture of
+// the nearest line above the hunk in the first file that matches Pattern
+type SectionPattern struct {
+	Pattern string
+	Group   int
+}
+
+// SectionLabel labels hunks with capture group of pattern, such as the
+// section of an INI file with `^\[(.+)\]$` and group 1. Group 0 is the
+// whole match.
+func SectionLabel(pattern string, group int) SectionPattern {
+	return SectionPattern{Pattern: pattern, Group: group}
+}
+
+// CommandPair diffs the stdout of two commands, run concurrently, in place
+// of file operands. Labels name the sides in headers and errors and default
+// to each command's String method.
+type CommandPair struct {
+	A, B           gloo.Command
+	LabelA, LabelB string
+}
+
+// CompareCommands diffs the output of a against the output of b, the
+// in-process form of diff <(a) <(b)
+func CompareCommands(a, b gloo.Command) CommandPair {
+	return CommandPair{A: a, B: b}
+}
+
+// Labeled names the sides of the pair in headers and errors
+func (c CommandPair) Labeled(labelA, labelB string) CommandPair {
+	c.LabelA, c.LabelB = labelA, labelB
+	return c
+}
+
+// HunkLabel replaces what follows the closing @@ of unified hunk headers,
+// overriding SectionLabel. The placeholders {oldstart} and {newstart} are the
+// starts of the hunk's ranges as printed and {file} the second file's name;
+// an empty label leaves the headers bare.
+type HunkLabel string
+
+// VolatileKind names a kind of content that changes from run to run, such
+// as a timestamp in a log line
+type VolatileKind int
+
+const (
+	VolatileTimestamp VolatileKind = iota
+	VolatileUUID
+	VolatileAddress
+	VolatileHash
+	VolatileDuration
+)
+
+// VolatileKinds are compared with every match of their patterns replaced by
+// a placeholder of its kind; the printed lines keep the original text
+type VolatileKinds []VolatileKind
+
+// IgnoreVolatile ignores the given kinds of volatile content, matched by the
+// exported patterns such as TimestampPattern
+func IgnoreVolatile(kinds ...VolatileKind) VolatileKinds { return VolatileKinds(kinds) }
+
+// CSVColumns compares delimiter-separated lines with the given 1-based
+// columns blanked out. Printed lines keep every column.
+type CSVColumns struct {
+	Delimiter string
+	Columns   []int
+}
+
+// CSVIgnoreColumns ignores columns of lines split at delimiter, such as a
+// generated_at column of an export. With a comma delimiter fields follow CSV
+// quoting; lines that do not parse are compared whole.
+func CSVIgnoreColumns(delimiter string, cols ...int) CSVColumns {
+	return CSVColumns{Delimiter: delimiter, Columns: cols}
+}
+
+// FocusLines compares only the lines matching a regular expression; other
+// lines are dropped from both inputs before they are compared. Line numbers
+// count the kept lines unless FocusKeepNumbers is set.
+type FocusLines string
+
+// FocusKeepNumbersFlag reports the original line numbers of FocusLines
+// comparisons in unified and normal output
+type FocusKeepNumbersFlag bool
+
+const (
+	FocusKeepNumbers   FocusKeepNumbersFlag = true
+	NoFocusKeepNumbers FocusKeepNumbersFlag = false
+)
+
+// ContextExtent gives unified hunks Before lines of context above each
+// change and After lines below it
+type ContextExtent struct {
+	Before, After int
+}
+
+// UnifiedContextBeforeAfter sets asymmetric unified context, overriding an
+// earlier UnifiedContext; a later UnifiedContext makes it symmetric again
+func UnifiedContextBeforeAfter(before, after int) ContextExtent {
+	return ContextExtent{Before: before, After: after}
+}
+
+// IgnoreMatchingLines ignores runs of changes whose lines all match the
+// pattern, like GNU diff -I. Given several times, a line may be ignored when
+// it matches any of the patterns, or all of them under IgnoreMatchAll. A
+// hunk holding any other change is printed whole.
+type IgnoreMatchingLines string
+
+// IgnoreMatchAllFlag lets IgnoreMatchingLines ignore only lines matching
+// every pattern
+type IgnoreMatchAllFlag bool
+
+const (
+	IgnoreMatchAll IgnoreMatchAllFlag = true
+	IgnoreMatchAny IgnoreMatchAllFlag = false
+)
+
+// IgnoreBlankLinesFlag ignores runs of changes whose lines are all blank,
+// like GNU diff -B. A line is blank when what is compared of it is empty,
+// so under IgnoreWhitespace a line holding only white space is blank too.
+// A hunk holding any other change is printed whole, its blank-line changes
+// included: they are never folded into the context, so the ranges of the
+// hunk count the lines of each side and it still applies with patch.
+type IgnoreBlankLinesFlag bool
+
+const (
+	IgnoreBlankLines   IgnoreBlankLinesFlag = true
+	NoIgnoreBlankLines IgnoreBlankLinesFlag = false
+)
+
+// JSONDetailFlag adds a Detail to each hunk of a Result or FormatJSON: the byte range of
+// the hunk in each input, its insertion and deletion counts, how similar its
+// deleted and inserted lines are, and the moved blocks it takes part in
+type JSONDetailFlag bool
+
+const (
+	JSONDetail   JSONDetailFlag = true
+	NoJSONDetail JSONDetailFlag = false
+)
+
+// ResourceStatsFlag makes Run fill in Stats.Resources: the bytes and lines
+// read of each input, an estimate of the line data it held at once, the edit
+// steps the search took against its budget, and the time taken by each phase
+type ResourceStatsFlag bool
+
+const (
+	ResourceStats   ResourceStatsFlag = true
+	NoResourceStats ResourceStatsFlag = false
+)
+
+// PreserveEOLFlag keeps the line terminators of the inputs in generated
+// patches: a carriage return before a newline stays part of its line, so
+// CRLF and LF lines differ and unified and context output print them exactly
+// as read. A last line without a terminator differs from one with it and is
+// followed by "\ No newline at end of file" either way; PreserveEOL keeps
+// that so under IgnoreWhitespace too.
+// ApplyBytes applies such a patch byte for byte.
+type PreserveEOLFlag bool
+
+const (
+	PreserveEOL   PreserveEOLFlag = true
+	NoPreserveEOL PreserveEOLFlag = false
+)
+
+// MinimalFlag makes the comparison find an edit script with the fewest
+// changed lines, like GNU diff -d, however long that takes. Otherwise a
+// search between long, very different inputs settles for a good script once
+// it gets expensive, and lines repeated many times on the other side are
+// taken as changed when they lie among lines that match nothing.
+type MinimalFlag bool
+
+const (
+	Minimal   MinimalFlag = true
+	NoMinimal MinimalFlag = false
+)
+
+// SpeedLargeFilesFlag makes the search between large inputs with many small
+// changes scattered through them faster, like GNU diff -H: a split is taken
+// as soon as a run of matching lines is well ahead of the edit steps it
+// took, rather than where the searches meet. The script is still correct
+// and applies, but may change more lines than needed. Minimal overrides it,
+// and other algorithms than Myers' ignore it.
+type SpeedLargeFilesFlag bool
+
+const (
+	SpeedLargeFiles   SpeedLargeFilesFlag = true
+	NoSpeedLargeFiles SpeedLargeFilesFlag = false
+)
+
+// HistogramFlag compares with the histogram algorithm git offers as
+// diff.algorithm=histogram, which splits the inputs around their rarest
+// common lines. It keeps moved blocks and reordered functions apart where
+// Myers' algorithm matches their braces and blank lines, also when no line
+// is unique.
+type HistogramFlag bool
+
+const (
+	Histogram   HistogramFlag = true
+	NoHistogram HistogramFlag = false
+)
+
+// Algorithm selects how the comparison searches for an edit script.
+// AlgorithmDefault is Myers' algorithm with GNU diff's heuristics and
+// AlgorithmMinimal the same search without them, as Minimal selects.
+// AlgorithmPatience anchors the inputs on the lines that occur once on each
+// side, like git diff --patience, and AlgorithmHistogram is the algorithm
+// Histogram selects. Naming one algorithm through Algorithm and another
+// through Minimal or Histogram is an error.
+type Algorithm int
+
+const (
+	AlgorithmDefault Algorithm = iota
+	AlgorithmMinimal
+	AlgorithmPatience
+	AlgorithmHistogram
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmDefault:
+		return "myers"
+	case AlgorithmMinimal:
+		return "myers,minimal"
+	case AlgorithmPatience:
+		return "patience"
+	case AlgorithmHistogram:
+		return "histogram"
+	default:
+		return "Algorithm(" + strconv.Itoa(int(a)) + ")"
+	}
+}
+
+// DiffTextFlag makes CompareTrees render what the command prints for each
+// entry into its PairResult. Without it the pairs are only classified.
+type DiffTextFlag bool
+
+const (
+	WithDiffText    DiffTextFlag = true
+	WithoutDiffText DiffTextFlag = false
+)
+
+// TextFlag compares every input as text, like GNU diff -a, whatever the
+// binary heuristic makes of it
+type TextFlag bool
+
+const (
+	Text   TextFlag = true
+	NoText TextFlag = false
+)
+
+// BinaryCheck is the heuristic that takes an input for binary, which diff
+// then only reports as differing. The first SampleBytes bytes are examined,
+// and the input is binary when they hold more than MaxNULs NUL bytes or a
+// larger proportion than MaxNonText of control bytes other than white
+// space, backspace and escape, NULs included.
+type BinaryCheck struct {
+	SampleBytes int
+	MaxNULs     int
+	MaxNonText  float64
+}
+
+// BinaryHeuristic replaces the default check, GNU diff's, which takes an
+// input for binary when its first 8 KiB hold any NUL byte
+func BinaryHeuristic(sampleBytes, maxNULs int, maxNonText float64) BinaryCheck {
+	return BinaryCheck{SampleBytes: sampleBytes, MaxNULs: maxNULs, MaxNonText: maxNonText}
+}
+
+// Label names an operand in file headers in place of its name and
+// timestamp, like GNU diff --label. The first Label names the first operand
+// and a second one the second.
+type Label string
+
+// GNUCompatFlag makes output byte-compatible with GNU diff where it
+// otherwise deviates: IgnoreWhitespace ignores all white space rather than
+// only its leading and trailing runs
+type GNUCompatFlag bool
+
+const (
+	GNUCompat   GNUCompatFlag = true
+	NoGNUCompat GNUCompatFlag = false
+)
+
+// Tee renders the comparison in an additional format to its own writer
+type Tee struct {
+	Format Format
+	Writer io.Writer
+}
+
+func TeeFormat(format Format, w io.Writer) Tee { return Tee{Format: format, Writer: w} }
+
+// Bundle writes a recursive directory comparison as one patch to its own
+// writer, while stdout carries only the summary: the pairs that differ and
+// the entries found on one side only
+type Bundle struct {
+	Writer io.Writer
+}
+
+// PatchBundle writes the patch of a recursive directory comparison to w.
+// Every differing pair becomes a section with paths relative to the roots,
+// prefixed a/ and b/ and started by a "diff --git" line, that patch -p1 and
+// git apply accept. A file found on one side only is compared with an empty
+// file, so the patch creates or deletes it against /dev/null, with git's new
+// or deleted file mode. An empty file is created by its header alone; an
+// empty file that is deleted has no lines to patch and is left out.
+func PatchBundle(w io.Writer) Bundle { return Bundle{Writer: w} }
+
+// AssumeUnchanged checks a stored unified diff between the two file operands
+// instead of printing one: the diff of the operands as they are now is made
+// with the configured comparison flags and context, and compared with the
+// stored one by PatchID. The verdict is printed as "patch is still
+// accurate", "patch is stale (differences changed)" or "files now
+// identical", and returned as nil, ErrPatchStale or ErrPatchObsolete.
+type AssumeUnchanged string
+
+// HTMLReport writes a static HTML report of a recursive directory comparison
+// into the directory it names, as well as the usual output: an index.html
+// listing every entry with its status and changed lines, linking to a page
+// per differing text pair that shows its hunks side by side with the
+// changed part of each line highlighted. The pages embed their stylesheet
+// and load nothing else.
+type HTMLReport string
+
+// EventLog is where the command traces what it does, as structured events:
+// Debug events for every input read, with its size, and every edit script
+// computed, with the algorithm used; Info events for every comparison
+// made, with its hunk count, and every pair of a directory walk, with its
+// time; a Warn event for every notice printed and an Error event for the
+// error a run ends with
+type EventLog struct {
+	Logger *slog.Logger
+}
+
+// Logger sends the events of the command to l. Without it nothing is
+// logged.
+func Logger(l *slog.Logger) EventLog { return EventLog{Logger: l} }
+
 type flags struct {
-	ContextLines     ContextLines
-	UnifiedContext   UnifiedContext
-	Unified          UnifiedFlag
-	ContextDiff      ContextFlag
-	Brief            BriefFlag
-	IgnoreCase       IgnoreCaseFlag
-	IgnoreWhitespace IgnoreWhitespaceFlag
-	SideBySide       SideBySideFlag
-	Recursive        RecursiveFlag
-}
-
-func (c ContextLines) Configure(flags *flags)         { flags.ContextLines = c }
-func (u UnifiedContext) Configure(flags *flags)       { flags.UnifiedContext = u }
-func (u UnifiedFlag) Configure(flags *flags)          { flags.Unified = u }
-func (c ContextFlag) Configure(flags *flags)          { flags.ContextDiff = c }
-func (b BriefFlag) Configure(flags *flags)            { flags.Brief = b }
-func (i IgnoreCaseFlag) Configure(flags *flags)       { flags.IgnoreCase = i }
-func (i IgnoreWhitespaceFlag) Configure(flags *flags) { flags.IgnoreWhitespace = i }
-func (s SideBySideFlag) Configure(flags *flags)       { flags.SideBySide = s }
-func (r RecursiveFlag) Configure(flags *flags)        { flags.Recursive = r }
+	ContextLines       ContextLines
+	ContextLinesSet    bool
+	UnifiedContext     UnifiedContext
+	UnifiedContextSet  bool
+	UnifiedExtent      *ContextExtent
+	Unified            UnifiedFlag
+	ContextDiff        ContextFlag
+	Brief              BriefFlag
+	IgnoreCase         IgnoreCaseFlag
+	IgnoreWhitespace   IgnoreWhitespaceFlag
+	SideBySide         SideBySideFlag
+	Recursive          RecursiveFlag
+	Quiet              QuietFlag
+	FirstDifference    FirstDifferenceFlag
+	OutputPrefix       OutputPrefix
+	Zip                ZipFlag
+	Expected           *Expected
+	UpdateFirst        UpdateFirstFlag
+	UpdateRecursive    UpdateRecursiveFlag
+	HunkFilters        []HunkFilter
+	Color              ColorMode
+	Tees               []Tee
+	SectionLabel       *SectionPattern
+	Commands           *CommandPair
+	FullContext        FullContextFlag
+	CountOnly          CountOnlyFlag
+	CountThreshold     CountThreshold
+	Progress           Progress
+	Template           Template
+	Width              Width
+	ShowLineNumbers    ShowLineNumbersFlag
+	NoBanner           NoBannerFlag
+	NoHeader           NoHeaderFlag
+	LineMap            LineMapFlag
+	RawEvents          RawEventsFlag
+	ConflictMarkers    ConflictMarkersFlag
+	SideBySideTSV      SideBySideTSVFlag
+	EditDistance       EditDistanceFlag
+	EdScript           EdScriptFlag
+	ForwardEdScript    ForwardEdScriptFlag
+	WithSimilarity     WithSimilarityFlag
+	TimestampFormat    TimestampFormat
+	PathSeparators     PathSeparators
+	DetectMoves        DetectMovesFlag
+	MoveMinLines       MoveMinLines
+	Collapse           *CollapseUnchanged
+	CompareHook        CompareHook
+	MaxCompareBytes    MaxCompareBytes
+	RawBytes           RawBytesFlag
+	OpenHook           OpenHook
+	StatsHook          StatsHook
+	Discard            DiscardFlag
+	CacheFile          CacheFile
+	NoCache            NoCacheFlag
+	Reverse            ReverseFlag
+	ShowIndex          ShowIndexFlag
+	IndexHash          IndexHash
+	TreeStat           TreeStatFlag
+	EncodedOutput      EncodedOutput
+	SynchronizedOutput SynchronizedOutputFlag
+	Chunked            Chunked
+	HorizonLines       HorizonLines
+	CostLimit          CostLimit
+	InterHunkContext   InterHunkContext
+	ResourceStats      ResourceStatsFlag
+	ChunkProbe         ChunkProbe
+	Pairs              Pairs
+	Hyperlinks         Hyperlinks
+	HunkLabel          *HunkLabel
+	OneFileSystem      OneFileSystemFlag
+	DeviceID           DeviceID
+	IgnoreNameCase     IgnoreFileNameCaseFlag
+	IgnoreANSI         IgnoreANSIFlag
+	StripANSI          StripANSIFlag
+	Volatile           VolatileKinds
+	CanonicalJSON      CanonicalJSONFlag
+	CSVIgnore          *CSVColumns
+	Focus              FocusLines
+	FocusKeepNumbers   FocusKeepNumbersFlag
+	IgnorePatterns     []IgnoreMatchingLines
+	IgnoreMatchAll     IgnoreMatchAllFlag
+	IgnoreBlankLines   IgnoreBlankLinesFlag
+	Labels             []Label
+	GNUCompat          GNUCompatFlag
+	JSONDetail         JSONDetailFlag
+	Bundle             *Bundle
+	PreserveEOL        PreserveEOLFlag
+	RetryOnChange      RetryOnChange
+	MaxMemory          MaxMemory
+	FileStat           FileStat
+	Logger             *slog.Logger
+	Minimal            MinimalFlag
+	DiffText           DiffTextFlag
+	Histogram          HistogramFlag
+	Algorithm          Algorithm
+	OnlyInDetails      OnlyInDetailsFlag
+	HTMLReport         HTMLReport
+	AssumeUnchanged    AssumeUnchanged
+	SpeedLargeFiles    SpeedLargeFilesFlag
+	Text               TextFlag
+	Binary             *BinaryCheck
+
+	err            error
+	hunkFilters    []*regexp.Regexp
+	section        *regexp.Regexp
+	template       *template.Template
+	volatile       []volatileRule
+	color          bool
+	literalFrom    int
+	digests        map[string]string
+	canonical      map[string]bool
+	stderr         io.Writer
+	focus          *regexp.Regexp
+	ignorePatterns []*regexp.Regexp
+	focusIndex     map[string][]int
+	lineRanges     map[string][]ByteRange
+	unterminated   map[string]bool
+	binary         map[string]bool
+	open           [2]bool
+	usage          *ResourceUsage
+	sequencer      *outputSequencer
+	numbers        [2][]int
+	stdin          *stdinBuffer
+	readers        [2]io.Reader
+
+	// overCount is set for a pair found to differ in more lines than
+	// CountThreshold before any script was built
+	overCount bool
+}
+
+func (c ContextLines) Configure(flags *flags) { flags.ContextLines, flags.ContextLinesSet = c, true }
+func (u UnifiedContext) Configure(flags *flags) {
+	flags.UnifiedContext, flags.UnifiedExtent, flags.UnifiedContextSet = u, nil, true
+}
+func (o OutputPrefix) Configure(flags *flags)           { flags.OutputPrefix = o }
+func (e Expected) Configure(flags *flags)               { flags.Expected = &e }
+func (h HunkFilter) Configure(flags *flags)             { flags.HunkFilters = append(flags.HunkFilters, h) }
+func (c ColorMode) Configure(flags *flags)              { flags.Color = c }
+func (t Tee) Configure(flags *flags)                    { flags.Tees = append(flags.Tees, t) }
+func (s SectionPattern) Configure(flags *flags)         { flags.SectionLabel = &s }
+func (c CommandPair) Configure(flags *flags)            { flags.Commands = &c }
+func (f FullContextFlag) Configure(flags *flags)        { flags.FullContext = f }
+func (c CountOnlyFlag) Configure(flags *flags)          { flags.CountOnly = c }
+func (c CountThreshold) Configure(flags *flags)         { flags.CountThreshold = c }
+func (p Progress) Configure(flags *flags)               { flags.Progress = p }
+func (t Template) Configure(flags *flags)               { flags.Template = t }
+func (w Width) Configure(flags *flags)                  { flags.Width = w }
+func (u UnifiedFlag) Configure(flags *flags)            { flags.Unified = u }
+func (c ContextFlag) Configure(flags *flags)            { flags.ContextDiff = c }
+func (b BriefFlag) Configure(flags *flags)              { flags.Brief = b }
+func (i IgnoreCaseFlag) Configure(flags *flags)         { flags.IgnoreCase = i }
+func (i IgnoreWhitespaceFlag) Configure(flags *flags)   { flags.IgnoreWhitespace = i }
+func (s SideBySideFlag) Configure(flags *flags)         { flags.SideBySide = s }
+func (r RecursiveFlag) Configure(flags *flags)          { flags.Recursive = r }
+func (q QuietFlag) Configure(flags *flags)              { flags.Quiet = q }
+func (f FirstDifferenceFlag) Configure(flags *flags)    { flags.FirstDifference = f }
+func (z ZipFlag) Configure(flags *flags)                { flags.Zip = z }
+func (u UpdateFirstFlag) Configure(flags *flags)        { flags.UpdateFirst = u }
+func (u UpdateRecursiveFlag) Configure(flags *flags)    { flags.UpdateRecursive = u }
+func (s ShowLineNumbersFlag) Configure(flags *flags)    { flags.ShowLineNumbers = s }
+func (n NoBannerFlag) Configure(flags *flags)           { flags.NoBanner = n }
+func (n NoHeaderFlag) Configure(flags *flags)           { flags.NoHeader = n }
+func (l LineMapFlag) Configure(flags *flags)            { flags.LineMap = l }
+func (r RawEventsFlag) Configure(flags *flags)          { flags.RawEvents = r }
+func (c ConflictMarkersFlag) Configure(flags *flags)    { flags.ConflictMarkers = c }
+func (e EditDistanceFlag) Configure(flags *flags)       { flags.EditDistance = e }
+func (w WithSimilarityFlag) Configure(flags *flags)     { flags.WithSimilarity = w }
+func (t TimestampFormat) Configure(flags *flags)        { flags.TimestampFormat = t }
+func (s PathSeparators) Configure(flags *flags)         { flags.PathSeparators = s }
+func (d DetectMovesFlag) Configure(flags *flags)        { flags.DetectMoves = d }
+func (m MoveMinLines) Configure(flags *flags)           { flags.MoveMinLines = m }
+func (c CollapseUnchanged) Configure(flags *flags)      { flags.Collapse = &c }
+func (c CompareHook) Configure(flags *flags)            { flags.CompareHook = c }
+func (m MaxCompareBytes) Configure(flags *flags)        { flags.MaxCompareBytes = m }
+func (r RawBytesFlag) Configure(flags *flags)           { flags.RawBytes = r }
+func (o OpenHook) Configure(flags *flags)               { flags.OpenHook = o }
+func (c CacheFile) Configure(flags *flags)              { flags.CacheFile = c }
+func (n NoCacheFlag) Configure(flags *flags)            { flags.NoCache = n }
+func (r ReverseFlag) Configure(flags *flags)            { flags.Reverse = r }
+func (s ShowIndexFlag) Configure(flags *flags)          { flags.ShowIndex = s }
+func (h IndexHash) Configure(flags *flags)              { flags.IndexHash = h }
+func (t TreeStatFlag) Configure(flags *flags)           { flags.TreeStat = t }
+func (e EncodedOutput) Configure(flags *flags)          { flags.EncodedOutput = e }
+func (s SynchronizedOutputFlag) Configure(flags *flags) { flags.SynchronizedOutput = s }
+func (c Chunked) Configure(flags *flags)                { flags.Chunked = c }
+func (c ChunkProbe) Configure(flags *flags)             { flags.ChunkProbe = c }
+func (p Pairs) Configure(flags *flags)                  { flags.Pairs = p }
+func (s SideBySideTSVFlag) Configure(flags *flags)      { flags.SideBySideTSV = s }
+func (e EdScriptFlag) Configure(flags *flags)           { flags.EdScript = e }
+func (f ForwardEdScriptFlag) Configure(flags *flags)    { flags.ForwardEdScript = f }
+func (h Hyperlinks) Configure(flags *flags)             { flags.Hyperlinks = h }
+func (h HunkLabel) Configure(flags *flags)              { flags.HunkLabel = &h }
+func (o OneFileSystemFlag) Configure(flags *flags)      { flags.OneFileSystem = o }
+func (d DeviceID) Configure(flags *flags)               { flags.DeviceID = d }
+func (i IgnoreFileNameCaseFlag) Configure(flags *flags) { flags.IgnoreNameCase = i }
+func (i IgnoreANSIFlag) Configure(flags *flags)         { flags.IgnoreANSI = i }
+func (s StripANSIFlag) Configure(flags *flags)          { flags.StripANSI = s }
+func (v VolatileKinds) Configure(flags *flags)          { flags.Volatile = append(flags.Volatile, v...) }
+func (c CanonicalJSONFlag) Configure(flags *flags)      { flags.CanonicalJSON = c }
+func (c CSVColumns) Configure(flags *flags)             { flags.CSVIgnore = &c }
+func (f FocusLines) Configure(flags *flags)             { flags.Focus = f }
+func (f FocusKeepNumbersFlag) Configure(flags *flags)   { flags.FocusKeepNumbers = f }
+func (c ContextExtent) Configure(flags *flags)          { flags.UnifiedExtent = &c }
+func (i IgnoreMatchingLines) Configure(flags *flags) {
+	flags.IgnorePatterns = append(flags.IgnorePatterns, i)
+}
+func (i IgnoreMatchAllFlag) Configure(flags *flags)   { flags.IgnoreMatchAll = i }
+func (l Label) Configure(flags *flags)                { flags.Labels = append(flags.Labels, l) }
+func (g GNUCompatFlag) Configure(flags *flags)        { flags.GNUCompat = g }
+func (i IgnoreBlankLinesFlag) Configure(flags *flags) { flags.IgnoreBlankLines = i }
+func (j JSONDetailFlag) Configure(flags *flags)       { flags.JSONDetail = j }
+func (b Bundle) Configure(flags *flags)               { flags.Bundle = &b }
+func (s StatsHook) Configure(flags *flags)            { flags.StatsHook = s }
+func (d DiscardFlag) Configure(flags *flags)          { flags.Discard = d }
+func (p PreserveEOLFlag) Configure(flags *flags)      { flags.PreserveEOL = p }
+func (r RetryOnChange) Configure(flags *flags)        { flags.RetryOnChange = r }
+func (s FileStat) Configure(flags *flags)             { flags.FileStat = s }
+func (e EventLog) Configure(flags *flags)             { flags.Logger = e.Logger }
+func (m MinimalFlag) Configure(flags *flags)          { flags.Minimal = m }
+func (d DiffTextFlag) Configure(flags *flags)         { flags.DiffText = d }
+func (t TextFlag) Configure(flags *flags)             { flags.Text = t }
+func (b BinaryCheck) Configure(flags *flags)          { flags.Binary = &b }
+func (h HistogramFlag) Configure(flags *flags)        { flags.Histogram = h }
+func (a Algorithm) Configure(flags *flags)            { flags.Algorithm = a }
+func (o OnlyInDetailsFlag) Configure(flags *flags)    { flags.OnlyInDetails = o }
+func (h HTMLReport) Configure(flags *flags)           { flags.HTMLReport = h }
+func (a AssumeUnchanged) Configure(flags *flags)      { flags.AssumeUnchanged = a }
+func (s SpeedLargeFilesFlag) Configure(flags *flags)  { flags.SpeedLargeFiles = s }
+func (m MaxMemory) Configure(flags *flags)            { flags.MaxMemory = m }
+func (h HorizonLines) Configure(flags *flags)         { flags.HorizonLines = h }
+func (c CostLimit) Configure(flags *flags)            { flags.CostLimit = c }
+func (i InterHunkContext) Configure(flags *flags)     { flags.InterHunkContext = i }
+func (r ResourceStatsFlag) Configure(flags *flags)    { flags.ResourceStats = r }
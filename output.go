@@ -0,0 +1,173 @@
+package command
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// prefixWriter prepends a fixed prefix to every line written through it
+type prefixWriter struct {
+	w           io.Writer
+	prefix      []byte
+	atLineStart bool
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix), atLineStart: true}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if p.atLineStart {
+			if _, err := p.w.Write(p.prefix); err != nil {
+				return written, err
+			}
+			p.atLineStart = false
+		}
+
+		end := len(b)
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			end = i + 1
+			p.atLineStart = true
+		}
+
+		n, err := p.w.Write(b[:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[end:]
+	}
+	return written, nil
+}
+
+// errWriter remembers the first write error so a formatter that ignores
+// write results can be checked once it is done
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(b []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(b)
+	e.err = err
+	return n, err
+}
+
+// syncWriter serializes writes from concurrent goroutines
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
+
+// displayPath is a path as printed in output. Backslash separators become
+// forward slashes, by default on Windows only, so headers and messages stay
+// usable by patch and other cross-platform tools. Files are always opened
+// by their original path.
+func (p command) displayPath(path string) string {
+	native := p.Flags.PathSeparators == NativePathSeparators ||
+		(p.Flags.PathSeparators == DefaultPathSeparators && runtime.GOOS != "windows")
+	if native {
+		return path
+	}
+	return strings.ReplaceAll(path, `\`, "/")
+}
+
+// lineEncoder encodes everything written through it onto a single line,
+// ended by a newline on Close when anything was written. Both encodings work
+// chunk by chunk, so nothing is buffered beyond a partial base64 group.
+type lineEncoder struct {
+	w       io.Writer
+	base64  io.WriteCloser
+	written bool
+}
+
+func newLineEncoder(w io.Writer, encoding EncodedOutput) *lineEncoder {
+	e := &lineEncoder{w: w}
+	if encoding == EncodeBase64 {
+		e.base64 = base64.NewEncoder(base64.RawURLEncoding, w)
+	}
+	return e
+}
+
+func (e *lineEncoder) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	e.written = true
+	if e.base64 != nil {
+		return e.base64.Write(b)
+	}
+	if _, err := io.WriteString(e.w, url.QueryEscape(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close flushes the last base64 group and ends the line
+func (e *lineEncoder) Close() error {
+	if e.base64 != nil {
+		if err := e.base64.Close(); err != nil {
+			return err
+		}
+	}
+	if !e.written {
+		return nil
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+// DecodeDiff reverses EncodedOutput, returning the output as it would have
+// been printed without encoding. A trailing newline is ignored.
+func DecodeDiff(encoded string, encoding EncodedOutput) (string, error) {
+	encoded = strings.TrimSuffix(encoded, "\n")
+	switch encoding {
+	case EncodePercent:
+		return url.QueryUnescape(encoded)
+	case EncodeBase64:
+		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		return string(decoded), err
+	default:
+		return encoded, nil
+	}
+}
+
+// recordDigest remembers the hash of an operand's bytes for its index line.
+// Nothing is kept unless ShowIndex is set.
+func (p command) recordDigest(name string, content []byte) {
+	if p.Flags.digests != nil {
+		p.Flags.digests[name] = p.Flags.IndexHash.digest(content)
+	}
+}
+
+// writeIndex prints the "index <old>..<new>" line of ShowIndex ahead of the
+// file headers. A side that was never read hashes as empty content.
+func (p command) writeIndex(w io.Writer, file1, file2 string) {
+	if !bool(p.Flags.ShowIndex) {
+		return
+	}
+	digest := func(name string) string {
+		if d, ok := p.Flags.digests[name]; ok {
+			return d
+		}
+		return p.Flags.IndexHash.digest(nil)
+	}
+	_, _ = fmt.Fprintf(w, "index %s..%s\n", digest(file1), digest(file2))
+}
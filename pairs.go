@@ -0,0 +1,108 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestPair is one left<TAB>right line of a Pairs manifest
+type manifestPair struct {
+	left, right string
+}
+
+// readManifest parses a Pairs manifest
+func readManifest(path string) ([]manifestPair, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dir := filepath.Dir(path)
+	resolve := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+
+	var pairs []manifestPair
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if trimmed := strings.TrimSpace(text); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		left, right, ok := strings.Cut(text, "\t")
+		if !ok || left == "" || right == "" || strings.Contains(right, "\t") {
+			return nil, fmt.Errorf("%s:%d: want left<TAB>right", path, line)
+		}
+		pairs = append(pairs, manifestPair{left: resolve(left), right: resolve(right)})
+	}
+	return pairs, scanner.Err()
+}
+
+// comparePairs compares every pair of the Pairs manifest in order, the way
+// each would be compared on its own, and ends with a line counting the
+// pairs that were identical, differed or could not be compared. A pair
+// that cannot be compared is reported and the rest are still compared.
+func (p command) comparePairs(stdout, stderr io.Writer) error {
+	pairs, err := readManifest(string(p.Flags.Pairs))
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "diff: %v\n", err)
+		return err
+	}
+
+	identical, differ, failed := 0, 0, 0
+	state, err := p.walk(stdout, stderr, "", func(state *walkState) {
+		for _, pair := range pairs {
+			if state.aborted != nil {
+				return
+			}
+			changes, failures := state.changes(), state.failed
+			p.compareManifestPair(stdout, stderr, pair, state)
+			switch {
+			case state.failed > failures:
+				failed++
+			case state.changes() > changes:
+				differ++
+			default:
+				identical++
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(stdout, "%d %s: %d identical, %d differ, %d failed\n",
+		len(pairs), plural(len(pairs), "pair", "pairs"), identical, differ, failed)
+	return state.result()
+}
+
+// compareManifestPair compares one pair: two directories entry by entry, a
+// file against the file of the same name in a directory, or two files
+func (p command) compareManifestPair(stdout, stderr io.Writer, pair manifestPair, state *walkState) {
+	left, right := pair.left, pair.right
+	if bool(p.Flags.Reverse) {
+		left, right = right, left
+	}
+	dir1, dir2 := isDirectory(left), isDirectory(right)
+	switch {
+	case dir1 && dir2:
+		state.trees = [2]tree{diskTree(left), diskTree(right)}
+		p.enterRoots(left, right, state)
+		p.compareDirectoryLevel(stdout, stderr, left, right, state)
+		return
+	case dir1:
+		left = filepath.Join(left, filepath.Base(right))
+	case dir2:
+		right = filepath.Join(right, filepath.Base(left))
+	}
+	state.trees = [2]tree{diskTree(filepath.Dir(left)), diskTree(filepath.Dir(right))}
+	p.comparePair(stdout, stderr, left, right, state)
+}
@@ -0,0 +1,66 @@
+package command_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_Pairs(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	root := filepath.Dir(dir1)
+	writeFile(t, root, "left.txt", "one\ntwo\n")
+	writeFile(t, root, "right.txt", "one\n2\n")
+	manifest := writeFile(t, root, "pairs.tsv", "# release checks\n"+
+		"left.txt\tleft.txt\n"+
+		"\n"+
+		"left.txt\tright.txt\n"+
+		"missing.txt\tleft.txt\n"+
+		"dir1\tdir2\n")
+
+	stdout, stderr, err := execute(command.Diff(command.Pairs(manifest), command.Brief), "")
+	assertion.Error(t, err)
+	want := "Files " + filepath.Join(root, "left.txt") + " and " + filepath.Join(root, "right.txt") + " differ\n" +
+		"Files " + dir1 + "/changed.txt and " + dir2 + "/changed.txt differ\n" +
+		"Only in " + dir1 + ": only1.txt\n" +
+		"Only in " + dir2 + ": only2.txt\n" +
+		"Common subdirectories: " + dir1 + "/sub and " + dir2 + "/sub\n" +
+		"4 pairs: 1 identical, 2 differ, 1 failed\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if !strings.Contains(stderr, "missing.txt") {
+		t.Errorf("the missing pair should be reported, got %q", stderr)
+	}
+}
+
+func TestDiff_PairsBannersAndRecursion(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	root := filepath.Dir(dir1)
+	manifest := writeFile(t, root, "pairs.tsv", dir1+"/same.txt\t"+dir2+"/same.txt\ndir1\tdir2\n")
+
+	stdout, _, err := execute(command.Diff(command.Pairs(manifest), command.Recursive, command.Unified), "")
+	assertDiffers(t, err)
+	for _, want := range []string{
+		"diff -r -u " + dir1 + "/changed.txt " + dir2 + "/changed.txt\n",
+		"diff -r -u " + dir1 + "/sub/nested.txt " + dir2 + "/sub/nested.txt\n",
+		"2 pairs: 1 identical, 1 differ, 0 failed\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestDiff_PairsMalformedManifest(t *testing.T) {
+	manifest := writeFile(t, t.TempDir(), "pairs.tsv", "a.txt b.txt\n")
+
+	stdout, stderr, err := execute(command.Diff(command.Pairs(manifest)), "")
+	assertion.Error(t, err)
+	if stdout != "" || !strings.Contains(stderr, "pairs.tsv:1: want left<TAB>right") {
+		t.Errorf("got stdout %q, stderr %q", stdout, stderr)
+	}
+}
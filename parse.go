@@ -0,0 +1,465 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hunkHeader matches a unified hunk header such as "@@ -1,6 +1,7 @@"
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// contextOldRange and contextNewRange match the range lines of a context
+// hunk, such as "*** 1,6 ****" and "--- 1,7 ----"
+var (
+	contextOldRange = regexp.MustCompile(`^\*\*\* (\d+)(?:,(\d+))? \*\*\*\*$`)
+	contextNewRange = regexp.MustCompile(`^--- (\d+)(?:,(\d+))? ----$`)
+)
+
+// binaryNotice matches the line diff and git diff print for binary files
+var binaryNotice = regexp.MustCompile(`^Binary files (.+) and (.+) differ$`)
+
+// FileDiff is the part of a diff that changes one file, as Parse reads it.
+// OldName and NewName are the names on its header lines, and OldTime and
+// NewTime whatever follows a name after a tab, usually a timestamp. Binary
+// is set for a "Binary files ... differ" notice, which has no hunks.
+// OldNoNewline and NewNoNewline are set when a "\ No newline at end of
+// file" marker follows the last line of that side.
+type FileDiff struct {
+	OldName, NewName           string
+	OldTime, NewTime           string
+	Binary                     bool
+	OldNoNewline, NewNoNewline bool
+	Hunks                      []Hunk
+}
+
+// Parse reads unified or context diff text, as diff and git diff print it,
+// one FileDiff per file it changes. The hunks have the form Run gives them,
+// line numbers included. Lines outside hunks, such as banners, "diff --git"
+// and "index" lines, are skipped, and hunks without any header lines form a
+// single FileDiff. A malformed hunk is an error naming its line.
+func Parse(r io.Reader) ([]FileDiff, error) {
+	patches, err := parsePatch(r, false)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]FileDiff, len(patches))
+	for i, patch := range patches {
+		diffs[i] = patch.FileDiff
+		for _, h := range patch.hunks {
+			diffs[i].Hunks = append(diffs[i].Hunks, h.Hunk)
+		}
+	}
+	return diffs, nil
+}
+
+// patchHunk is a parsed hunk with the line of the diff its header is on
+type patchHunk struct {
+	Hunk
+	line int
+}
+
+// filePatch is the section of a diff that changes one file. Its hunks are
+// kept with their lines rather than in FileDiff.Hunks.
+type filePatch struct {
+	FileDiff
+	hunks []patchHunk
+}
+
+// patchParser reads a diff a line at a time, any line of which can be read
+// again by the next step
+type patchParser struct {
+	scanner *bufio.Scanner
+	keepEOL bool
+	line    string
+	lineNo  int
+	again   bool
+	patches []filePatch
+}
+
+// parsePatch splits a unified or context diff into per-file sections. With
+// keepEOL the text of every hunk line keeps its terminator, carriage return
+// and all, except where a "\ No newline at end of file" marker follows it.
+func parsePatch(r io.Reader, keepEOL bool) ([]filePatch, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+	if keepEOL {
+		scanner.Split(scanRawLines)
+	}
+	p := &patchParser{scanner: scanner, keepEOL: keepEOL}
+
+	// newHeader is the prefix of the header naming the new file while the
+	// one naming the old file was the last line
+	newHeader := ""
+	for p.next() {
+		line := p.header()
+		expected := newHeader
+		newHeader = ""
+		switch {
+		case expected != "" && strings.HasPrefix(line, expected):
+			patch := &p.patches[len(p.patches)-1]
+			patch.NewName, patch.NewTime = headerName(line)
+		case strings.HasPrefix(line, "@@ "):
+			if err := p.unifiedHunk(line); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "***************"):
+			if err := p.contextHunk(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "*** "):
+			var patch filePatch
+			patch.OldName, patch.OldTime = headerName(line)
+			p.patches = append(p.patches, patch)
+			// A unified header names the new file on "+++", a context one
+			// on "---"
+			newHeader = "+++ "
+			if line[0] == '*' {
+				newHeader = "--- "
+			}
+		default:
+			if m := binaryNotice.FindStringSubmatch(line); m != nil {
+				p.patches = append(p.patches, filePatch{FileDiff: FileDiff{OldName: m[1], NewName: m[2], Binary: true}})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p.patches, nil
+}
+
+// next reads the next line of the diff, or the current one again after
+// unread
+func (p *patchParser) next() bool {
+	if p.again {
+		p.again = false
+		return true
+	}
+	if !p.scanner.Scan() {
+		return false
+	}
+	p.lineNo++
+	p.line = p.scanner.Text()
+	return true
+}
+
+// unread has next return the current line again
+func (p *patchParser) unread() {
+	p.again = true
+}
+
+// header is the current line as a header or range line, without the
+// carriage return keepEOL leaves on it
+func (p *patchParser) header() string {
+	return strings.TrimSuffix(p.line, "\r")
+}
+
+// current is the section hunks are added to, one without header lines when
+// the diff has none
+func (p *patchParser) current() *filePatch {
+	if len(p.patches) == 0 {
+		p.patches = append(p.patches, filePatch{})
+	}
+	return &p.patches[len(p.patches)-1]
+}
+
+// text is the text of a hunk line after its marker of size n
+func (p *patchParser) text(line string, n int) string {
+	text := line[min(n, len(line)):]
+	if p.keepEOL {
+		text += "\n"
+	}
+	return text
+}
+
+// noNewline marks the side or sides of the last op of h as lacking a final
+// newline, for a "\ No newline at end of file" marker after it. Under
+// keepEOL the line loses its terminator.
+func (p *patchParser) noNewline(patch *filePatch, h *patchHunk) {
+	if len(h.Ops) == 0 {
+		return
+	}
+	last := &h.Ops[len(h.Ops)-1]
+	patch.OldNoNewline = patch.OldNoNewline || last.Kind != OpInsert
+	patch.NewNoNewline = patch.NewNoNewline || last.Kind != OpDelete
+	if p.keepEOL {
+		last.Text = strings.TrimSuffix(last.Text, "\n")
+	}
+}
+
+// headerName splits a "---", "+++" or "***" header line into the file name
+// and what follows it after a tab
+func headerName(line string) (name, rest string) {
+	name = line[len("--- "):]
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// unifiedHunk reads the hunk whose header is line
+func (p *patchParser) unifiedHunk(line string) error {
+	m := hunkHeader.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("line %d: malformed hunk header %q", p.lineNo, line)
+	}
+	patch := p.current()
+	h := patchHunk{line: p.lineNo}
+	h.OldStart, _ = strconv.Atoi(m[1])
+	h.OldLines = rangeCount(m[2])
+	h.NewStart, _ = strconv.Atoi(m[3])
+	h.NewLines = rangeCount(m[4])
+
+	remainingOld, remainingNew := h.OldLines, h.NewLines
+	for remainingOld > 0 || remainingNew > 0 {
+		if !p.next() {
+			return fmt.Errorf("hunk at line %d: diff ends before the hunk is complete", h.line)
+		}
+		if strings.HasPrefix(p.line, `\ `) {
+			p.noNewline(patch, &h)
+			continue
+		}
+		op, err := parseHunkLine(p.line, &h, &remainingOld, &remainingNew)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", p.lineNo, err)
+		}
+		op.Text = p.text(p.line, 1)
+		h.Ops = append(h.Ops, op)
+	}
+	// The marker of the last line follows the lines the header announced
+	if p.next() {
+		if strings.HasPrefix(p.line, `\ `) {
+			p.noNewline(patch, &h)
+		} else {
+			p.unread()
+		}
+	}
+	numberOps(&h.Hunk)
+	patch.hunks = append(patch.hunks, h)
+	return nil
+}
+
+// rangeCount parses the optional count of a hunk range, which defaults to 1
+func rangeCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// parseHunkLine parses one body line of a unified hunk and counts it
+// against the lines the header announced. An empty line is an empty context
+// line whose leading space was stripped.
+func parseHunkLine(line string, h *patchHunk, remainingOld, remainingNew *int) (Op, error) {
+	marker := byte(' ')
+	if line != "" {
+		marker = line[0]
+	}
+	switch marker {
+	case ' ':
+		if *remainingOld == 0 || *remainingNew == 0 {
+			return Op{}, fmt.Errorf("hunk at line %d has more lines than its header", h.line)
+		}
+		*remainingOld--
+		*remainingNew--
+		return Op{Kind: OpEqual}, nil
+	case '-':
+		if *remainingOld == 0 {
+			return Op{}, fmt.Errorf("hunk at line %d deletes more lines than its header", h.line)
+		}
+		*remainingOld--
+		return Op{Kind: OpDelete}, nil
+	case '+':
+		if *remainingNew == 0 {
+			return Op{}, fmt.Errorf("hunk at line %d inserts more lines than its header", h.line)
+		}
+		*remainingNew--
+		return Op{Kind: OpInsert}, nil
+	default:
+		return Op{}, fmt.Errorf("unexpected line %q in hunk at line %d", line, h.line)
+	}
+}
+
+// contextLine is one body line of a side of a context hunk: its two-byte
+// marker and its text
+type contextLine struct {
+	marker, text string
+	noNewline    bool
+}
+
+// contextHunk reads the context hunk after a "***************" line. A side
+// without changed lines has its lines left out; they are the context lines
+// of the other side.
+func (p *patchParser) contextHunk() error {
+	if !p.next() {
+		return fmt.Errorf("line %d: diff ends before the hunk is complete", p.lineNo)
+	}
+	oldRange := contextOldRange.FindStringSubmatch(p.header())
+	if oldRange == nil {
+		return fmt.Errorf("line %d: malformed hunk range %q", p.lineNo, p.header())
+	}
+	patch := p.current()
+	h := patchHunk{line: p.lineNo}
+	old, err := p.contextSide(h.line, oldRange, "- ")
+	if err != nil {
+		return err
+	}
+	if !p.next() {
+		return fmt.Errorf("hunk at line %d: diff ends before the hunk is complete", h.line)
+	}
+	newRange := contextNewRange.FindStringSubmatch(p.header())
+	if newRange == nil {
+		return fmt.Errorf("line %d: malformed hunk range %q", p.lineNo, p.header())
+	}
+	changed, err := p.contextSide(h.line, newRange, "+ ")
+	if err != nil {
+		return err
+	}
+
+	// Each run of changed lines deletes the old lines and then inserts the
+	// new ones, between the context lines both sides share
+	add := func(kind OpKind, line contextLine) {
+		h.Ops = append(h.Ops, Op{Kind: kind, Text: line.text})
+		if line.noNewline {
+			patch.OldNoNewline = patch.OldNoNewline || kind != OpInsert
+			patch.NewNoNewline = patch.NewNoNewline || kind != OpDelete
+		}
+	}
+	i, j := 0, 0
+	for i < len(old) || j < len(changed) {
+		for ; i < len(old) && old[i].marker != "  "; i++ {
+			add(OpDelete, old[i])
+		}
+		for ; j < len(changed) && changed[j].marker != "  "; j++ {
+			add(OpInsert, changed[j])
+		}
+		switch {
+		case i == len(old) && j == len(changed):
+		case len(old) == 0:
+			add(OpEqual, changed[j])
+			j++
+		case len(changed) == 0:
+			add(OpEqual, old[i])
+			i++
+		case i == len(old) || j == len(changed):
+			return fmt.Errorf("hunk at line %d has different context lines on its sides", h.line)
+		default:
+			// The old line is the one Run gives, as the normalizations may
+			// have let the sides differ
+			add(OpEqual, old[i])
+			if changed[j].noNewline {
+				patch.NewNoNewline = true
+			}
+			i, j = i+1, j+1
+		}
+	}
+
+	for _, op := range h.Ops {
+		if op.Kind != OpInsert {
+			h.OldLines++
+		}
+		if op.Kind != OpDelete {
+			h.NewLines++
+		}
+	}
+	if h.OldStart, err = contextStart(oldRange, h.OldLines); err != nil {
+		return fmt.Errorf("hunk at line %d: old %w", h.line, err)
+	}
+	if h.NewStart, err = contextStart(newRange, h.NewLines); err != nil {
+		return fmt.Errorf("hunk at line %d: new %w", h.line, err)
+	}
+	numberOps(&h.Hunk)
+	patch.hunks = append(patch.hunks, h)
+	return nil
+}
+
+// contextSide reads the body lines of one side of the context hunk at line
+// hunk, at most as many as its range holds; there are none when the side
+// has no changed lines. changed is the marker of the side's changed lines
+// besides "! ".
+func (p *patchParser) contextSide(hunk int, bounds []string, changed string) ([]contextLine, error) {
+	limit := 1
+	if bounds[2] != "" {
+		first, _ := strconv.Atoi(bounds[1])
+		last, _ := strconv.Atoi(bounds[2])
+		limit = last - first + 1
+	}
+	var lines []contextLine
+	for len(lines) < limit && p.next() {
+		marker := p.line[:min(2, len(p.line))]
+		switch {
+		case strings.HasPrefix(p.line, `\ `) && len(lines) > 0:
+			last := &lines[len(lines)-1]
+			last.noNewline = true
+			if p.keepEOL {
+				last.text = strings.TrimSuffix(last.text, "\n")
+			}
+			continue
+		case marker == "  " || marker == "! " || marker == changed:
+			lines = append(lines, contextLine{marker: marker, text: p.text(p.line, 2)})
+			continue
+		}
+		p.unread()
+		break
+	}
+	// The marker of the last line follows the lines the range holds
+	if len(lines) > 0 && p.next() {
+		if strings.HasPrefix(p.line, `\ `) {
+			lines[len(lines)-1].noNewline = true
+			if p.keepEOL {
+				lines[len(lines)-1].text = strings.TrimSuffix(lines[len(lines)-1].text, "\n")
+			}
+		} else {
+			p.unread()
+		}
+	}
+	if len(lines) > 0 && len(lines) != limit {
+		return nil, fmt.Errorf("line %d: hunk at line %d has %d lines on a side whose range holds %d", p.lineNo, hunk, len(lines), limit)
+	}
+	return lines, nil
+}
+
+// contextStart is the unified start of a context hunk range holding count
+// lines: a range of one number names the line before an empty side, the
+// way an empty unified range does
+func contextStart(bounds []string, count int) (int, error) {
+	first, _ := strconv.Atoi(bounds[1])
+	if bounds[2] == "" {
+		if count > 1 {
+			return 0, fmt.Errorf("range %s holds 1 line, not %d", bounds[1], count)
+		}
+		return first, nil
+	}
+	last, _ := strconv.Atoi(bounds[2])
+	if count != last-first+1 {
+		return 0, fmt.Errorf("range %s,%s holds %d lines, not %d", bounds[1], bounds[2], last-first+1, count)
+	}
+	return first, nil
+}
+
+// numberOps sets the line numbers of the ops of h from its ranges
+func numberOps(h *Hunk) {
+	oldLine, newLine := h.OldStart, h.NewStart
+	if h.OldLines == 0 {
+		oldLine++
+	}
+	if h.NewLines == 0 {
+		newLine++
+	}
+	for i := range h.Ops {
+		op := &h.Ops[i]
+		if op.Kind != OpInsert {
+			op.OldLine = oldLine
+			oldLine++
+		}
+		if op.Kind != OpDelete {
+			op.NewLine = newLine
+			newLine++
+		}
+	}
+}
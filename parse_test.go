@@ -0,0 +1,229 @@
+package command_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// parseOne parses text that must hold a single FileDiff
+func parseOne(t *testing.T, text string) command.FileDiff {
+	t.Helper()
+	diffs, err := command.Parse(strings.NewReader(text))
+	assertion.NoError(t, err)
+	if len(diffs) != 1 {
+		t.Fatalf("got %d file diffs from:\n%s", len(diffs), text)
+	}
+	return diffs[0]
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 0))
+	formats := []struct {
+		format command.Format
+		opts   []any
+	}{
+		{command.FormatUnified, []any{command.Unified}},
+		{command.FormatUnified, []any{command.Unified, command.UnifiedContext(0)}},
+		{command.FormatUnified, []any{command.Unified, command.UnifiedContextBeforeAfter(1, 4)}},
+		{command.FormatContext, []any{command.ContextDiff}},
+		{command.FormatContext, []any{command.ContextDiff, command.ContextLines(0), command.UnifiedContext(0)}},
+		{command.FormatUnified, []any{command.Unified, command.IgnoreCase}},
+		{command.FormatContext, []any{command.ContextDiff, command.IgnoreBlankLines}},
+	}
+
+	// The hunks Parse reads back from every rendering are those Run made
+	for i := 0; i < 500; i++ {
+		a := randomLines(r, 30)
+		b := mutate(r, a)
+		if r.IntN(4) == 0 {
+			b = randomLines(r, 30)
+		}
+		for _, f := range formats {
+			result, err := command.Run(context.Background(), command.LinesSource("a", a), command.LinesSource("b", b), f.opts...)
+			assertion.NoError(t, err)
+			text := result.Text(f.format)
+			diffs, err := command.Parse(strings.NewReader(text))
+			if err != nil {
+				t.Fatalf("%v: %v\n%s", f.opts, err, text)
+			}
+			if result.Identical {
+				if len(diffs) != 0 {
+					t.Fatalf("%v: got %v for identical inputs", f.opts, diffs)
+				}
+				continue
+			}
+			if len(diffs) != 1 || diffs[0].OldName != "a" || diffs[0].NewName != "b" {
+				t.Fatalf("%v: got %+v from:\n%s", f.opts, diffs, text)
+			}
+			if !reflect.DeepEqual(diffs[0].Hunks, result.Hunks) {
+				t.Fatalf("%v: got hunks\n%+v\nwant\n%+v\nfrom:\n%s", f.opts, diffs[0].Hunks, result.Hunks, text)
+			}
+		}
+	}
+}
+
+func TestParse_GNUCorpus(t *testing.T) {
+	sets := []string{"unified", "unified-0", "unified-1", "unified-5", "context", "context-0", "context-1", "ignore-case-unified"}
+	cases, err := filepath.Glob("testdata/gnu/*/a")
+	if err != nil || len(cases) == 0 {
+		t.Fatalf("no corpus cases: %v", err)
+	}
+	for _, a := range cases {
+		dir := filepath.Dir(a)
+		if info, err := os.Stat(a); err != nil || info.IsDir() {
+			continue
+		}
+		for _, set := range sets {
+			text, err := os.ReadFile(filepath.Join(dir, set+".out"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			diffs, err := command.Parse(strings.NewReader(string(text)))
+			if err != nil {
+				t.Fatalf("%s/%s: %v", dir, set, err)
+			}
+			if _, gap := gnuGaps[filepath.Base(dir)]; gap {
+				continue
+			}
+			// Result.Hunks carry the unified context whatever the format
+			opts := gnuFlagSets[set]
+			if lines, ok := strings.CutPrefix(set, "context-"); ok {
+				opts = append(opts, command.UnifiedContext(lines[0]-'0'))
+			}
+			result, err := command.Run(context.Background(), command.FileSource(a), command.FileSource(filepath.Join(dir, "b")), opts...)
+			assertion.NoError(t, err)
+			if result.Identical != (len(diffs) == 0) {
+				t.Fatalf("%s/%s: got %d file diffs", dir, set, len(diffs))
+			}
+			if result.Identical {
+				continue
+			}
+			if !reflect.DeepEqual(diffs[0].Hunks, result.Hunks) {
+				t.Errorf("%s/%s: got hunks\n%+v\nwant\n%+v", dir, set, diffs[0].Hunks, result.Hunks)
+			}
+		}
+	}
+
+	// Markers tell which side lacks the final newline, in either format
+	for _, set := range []string{"unified", "context"} {
+		text, _ := os.ReadFile("testdata/gnu/no-newline/" + set + ".out")
+		diff := parseOne(t, string(text))
+		if !diff.OldNoNewline || !diff.NewNoNewline || len(diff.Hunks) != 1 || len(diff.Hunks[0].Ops) != 3 {
+			t.Errorf("%s: got %+v", set, diff)
+		}
+	}
+	text, _ := os.ReadFile("testdata/gnu/newline-added/unified.out")
+	if diff := parseOne(t, string(text)); !diff.OldNoNewline || diff.NewNoNewline {
+		t.Errorf("got %+v", diff)
+	}
+}
+
+func TestParse_Git(t *testing.T) {
+	text, err := os.ReadFile("testdata/git/changes.patch")
+	assertion.NoError(t, err)
+	diffs, err := command.Parse(strings.NewReader(string(text)))
+	assertion.NoError(t, err)
+
+	// A change of mode alone has no header lines and no hunks
+	want := []struct {
+		old, new string
+		binary   bool
+		hunks    int
+	}{
+		{"a/data.bin", "b/data.bin", true, 0},
+		{"a/gone.txt", "/dev/null", false, 1},
+		{"a/main.go", "b/main.go", false, 2},
+		{"a/old-name.txt", "b/new-name.txt", false, 1},
+		{"/dev/null", "b/new.txt", false, 1},
+		{"a/tail.txt", "b/tail.txt", false, 1},
+	}
+	if len(diffs) != len(want) {
+		t.Fatalf("got %d file diffs: %+v", len(diffs), diffs)
+	}
+	for i, w := range want {
+		d := diffs[i]
+		if d.OldName != w.old || d.NewName != w.new || d.Binary != w.binary || len(d.Hunks) != w.hunks {
+			t.Errorf("file diff %d: got %+v", i, d)
+		}
+	}
+
+	// The function git names after a hunk header is no part of the hunk
+	second := diffs[2].Hunks[1]
+	if second.OldStart != 7 || second.NewStart != 7 || len(second.Ops) != 4 || second.Ops[1].Text != "\treturn 1" || second.Ops[1].OldLine != 8 {
+		t.Errorf("got %+v", second)
+	}
+	if !diffs[4].NewNoNewline || diffs[4].OldNoNewline || diffs[4].Hunks[0].OldStart != 0 {
+		t.Errorf("got %+v", diffs[4])
+	}
+	tail := diffs[5]
+	if !tail.OldNoNewline || tail.NewNoNewline {
+		t.Errorf("got %+v", tail)
+	}
+	if got := tail.Hunks[0].Ops; len(got) != 4 || got[1].Kind != command.OpDelete || got[1].Text != "two" || got[3].NewLine != 3 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestParse_Headers(t *testing.T) {
+	// Timestamps follow the names after a tab, and banners are skipped
+	diffs, err := command.Parse(strings.NewReader("diff -u x/one x/two\n" +
+		"--- x/one\t2026-10-14 12:00:00.000000000 +0000\n" +
+		"+++ x/two\t2026-10-14 12:30:00.000000000 +0000\n" +
+		"@@ -1 +1 @@\n-a\n+b\n" +
+		"Only in x: three\n" +
+		"*** my label\n--- other label\t2026-10-14\n***************\n*** 0 ****\n--- 1 ----\n+ new\n" +
+		"Binary files x/four and y/four differ\n" +
+		"@@ -2,0 +3 @@\n+c\n"))
+	assertion.NoError(t, err)
+	if len(diffs) != 3 {
+		t.Fatalf("got %+v", diffs)
+	}
+	if d := diffs[0]; d.OldName != "x/one" || d.NewName != "x/two" || d.OldTime != "2026-10-14 12:00:00.000000000 +0000" || d.NewTime != "2026-10-14 12:30:00.000000000 +0000" {
+		t.Errorf("got %+v", d)
+	}
+	context := command.Hunk{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 1, Ops: []command.Op{{Kind: command.OpInsert, NewLine: 1, Text: "new"}}}
+	if d := diffs[1]; d.OldName != "my label" || d.NewName != "other label" || d.NewTime != "2026-10-14" || !reflect.DeepEqual(d.Hunks, []command.Hunk{context}) {
+		t.Errorf("got %+v", d)
+	}
+	// Hunks after a binary notice have no header of their own and stay
+	// with it
+	if d := diffs[2]; !d.Binary || d.OldName != "x/four" || d.NewName != "y/four" || len(d.Hunks) != 1 {
+		t.Errorf("got %+v", d)
+	}
+
+	// Hunks without any header lines form one FileDiff
+	d := parseOne(t, "@@ -1,2 +1 @@\n a\n-b\n")
+	if d.OldName != "" || len(d.Hunks) != 1 || d.Hunks[0].Ops[1].OldLine != 2 {
+		t.Errorf("got %+v", d)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"header", "--- a\n+++ b\n@@ -x +1 @@\n", `line 3: malformed hunk header`},
+		{"unknown marker", "@@ -1,2 +1,2 @@\n a\n?b\n", `unexpected line "?b" in hunk at line 1`},
+		{"deletes too many", "@@ -1 +1,2 @@\n-a\n-b\n", "line 3: hunk at line 1 deletes more lines than its header"},
+		{"context past the end", "@@ -1,2 +1 @@\n+a\n a\n", "line 3: hunk at line 1 has more lines than its header"},
+		{"unfinished", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n", "hunk at line 3: diff ends before the hunk is complete"},
+		{"context range", "***************\n*** one ****\n", `line 2: malformed hunk range "*** one ****"`},
+		{"context without new range", "***************\n*** 1 ****\n- a\n", "hunk at line 2: diff ends"},
+		{"context range too long", "***************\n*** 1,3 ****\n- a\n--- 0 ----\n", "line 4: hunk at line 2 has 1 lines on a side whose range holds 3"},
+		{"context sides disagree", "***************\n*** 1,2 ****\n  a\n- b\n--- 1,3 ----\n  a\n  b\n+ c\n", "hunk at line 2 has different context lines on its sides"},
+	}
+	for _, tt := range tests {
+		_, err := command.Parse(strings.NewReader(tt.text))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
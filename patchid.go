@@ -0,0 +1,83 @@
+package command
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PatchID identifies the change a unified diff makes regardless of where
+// it makes it, in the spirit of git patch-id: the SHA-1 of its deleted and
+// inserted lines in order, with their white space removed. Hunk positions,
+// context lines and headers are left out, so diffs of the same change made
+// with other context or labels, or against a file that has since moved
+// around it, share an ID. A diff without changes has the empty ID.
+func PatchID(unifiedDiff string) (string, error) {
+	patches, err := parsePatch(strings.NewReader(unifiedDiff), false)
+	if err != nil {
+		return "", err
+	}
+	hash := sha1.New()
+	changes := 0
+	for _, patch := range patches {
+		for _, h := range patch.hunks {
+			for _, op := range h.Ops {
+				var sign string
+				switch op.Kind {
+				case OpDelete:
+					sign = "-"
+				case OpInsert:
+					sign = "+"
+				default:
+					continue
+				}
+				changes++
+				_, _ = io.WriteString(hash, sign+strings.Join(strings.Fields(op.Text), "")+"\n")
+			}
+		}
+	}
+	if changes == 0 {
+		return "", nil
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyPatch checks the patch AssumeUnchanged names against the diff of
+// the operands as they are now, by PatchID, and prints its verdict. It
+// returns nil when the patch still makes the same change, ErrPatchStale
+// when the differences changed, and ErrPatchObsolete when the files have
+// become identical.
+func (p command) verifyPatch(stdout, stderr io.Writer, file1Path, file2Path string, lines1, lines2 []string) error {
+	patchPath := string(p.Flags.AssumeUnchanged)
+	stored, err := os.ReadFile(patchPath)
+	if err != nil {
+		return fileTrouble(stderr, patchPath, err)
+	}
+	storedID, err := PatchID(string(stored))
+	if err != nil {
+		return fileTrouble(stderr, patchPath, err)
+	}
+
+	p.Flags.open = p.Flags.openEnds(file1Path, file2Path)
+	var current bytes.Buffer
+	p.writeBundleSection(&current, "", file1Path, file2Path, lines1, lines2)
+	currentID, err := PatchID(current.String())
+	if err != nil {
+		return err
+	}
+
+	message, result := "patch is still accurate", error(nil)
+	switch {
+	case currentID == storedID:
+	case p.identical(lines1, lines2):
+		message, result = "files now identical", ErrPatchObsolete
+	default:
+		message, result = "patch is stale (differences changed)", ErrPatchStale
+	}
+	_, _ = fmt.Fprintf(stdout, "%s: %s\n", p.displayPath(patchPath), message)
+	return result
+}
@@ -0,0 +1,70 @@
+package command_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestPatchID(t *testing.T) {
+	id, err := command.PatchID("--- a\n+++ b\n@@ -1,3 +1,3 @@\n x\n-old  line\n+new line\n y\n")
+	assertion.NoError(t, err)
+	if len(id) != 40 {
+		t.Fatalf("got %q", id)
+	}
+	// Positions, context, labels and white space leave the ID alone
+	for _, same := range []string{
+		"--- left\t2024-01-01\n+++ right\n@@ -7 +7 @@\n-old line\n+new\tline\n",
+		"@@ -10,2 +10,2 @@\n-oldline\n+new line\n z\n",
+	} {
+		if got, err := command.PatchID(same); err != nil || got != id {
+			t.Errorf("%q: got %q, %v, want %q", same, got, err, id)
+		}
+	}
+	for _, other := range []string{
+		"@@ -1 +1 @@\n-old line\n+newer line\n",
+		"@@ -1 +1 @@\n+new line\n-old line\n",
+	} {
+		if got, _ := command.PatchID(other); got == id {
+			t.Errorf("%q shares the ID", other)
+		}
+	}
+	if got, err := command.PatchID(""); err != nil || got != "" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestDiff_AssumeUnchanged(t *testing.T) {
+	const dir = "testdata/gnu/change/"
+	// The stored patches were made by GNU diff, with other context too
+	for _, patch := range []string{dir + "unified.out", dir + "unified-0.out"} {
+		stdout, _, err := execute(command.Diff(dir+"a", dir+"b", command.AssumeUnchanged(patch)), "")
+		assertion.NoError(t, err)
+		if stdout != patch+": patch is still accurate\n" {
+			t.Errorf("got %q", stdout)
+		}
+	}
+
+	changed, err := os.ReadFile(dir + "b")
+	assertion.NoError(t, err)
+	b := writeFile(t, t.TempDir(), "b", string(changed)+"appended\n")
+	stdout, _, err := execute(command.Diff(dir+"a", b, command.AssumeUnchanged(dir+"unified.out")), "")
+	if !errors.Is(err, command.ErrPatchStale) || stdout != dir+"unified.out: patch is stale (differences changed)\n" {
+		t.Errorf("got %q, %v", stdout, err)
+	}
+
+	stdout, _, err = execute(command.Diff(dir+"a", dir+"a", command.AssumeUnchanged(dir+"unified.out")), "")
+	if !errors.Is(err, command.ErrPatchObsolete) || stdout != dir+"unified.out: files now identical\n" {
+		t.Errorf("got %q, %v", stdout, err)
+	}
+
+	// A patch that cannot be read is trouble
+	_, _, err = execute(command.Diff(dir+"a", dir+"b", command.AssumeUnchanged(dir+"missing.out")), "")
+	var fileErr *command.FileError
+	if !errors.As(err, &fileErr) {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package command
+
+// patience finds a script between two sequences of line classes with the
+// patience algorithm git uses: the lines that occur exactly once on each
+// side of a region anchor it, the longest run of anchors in the same order
+// on both sides is kept, and the gaps between them are compared the same
+// way. A region without any unique common line is compared with Myers'
+// algorithm.
+type patience struct {
+	a, b               []int
+	changedA, changedB marks
+	count              int
+	cost               *searchCost
+}
+
+// patienceEntry is a line of the old side of a region, by class: where it
+// first occurs on each side, whether it occurs once on both, and the
+// anchor before it in the longest run being built
+type patienceEntry struct {
+	line1, line2 int
+	unique       bool
+	previous     *patienceEntry
+}
+
+// patienceDiff marks the lines of classes1 and classes2 that an edit script
+// deletes and inserts, found with the patience algorithm. The changed runs
+// are then shifted to where GNU diff puts them.
+func patienceDiff(classes1, classes2 []int, count int, cost *searchCost) (marks, marks) {
+	p := &patience{
+		a: classes1, b: classes2,
+		changedA: newMarks(len(classes1)), changedB: newMarks(len(classes2)),
+		count: count, cost: cost,
+	}
+	p.diff(span{0, len(classes1), 0, len(classes2)})
+	shiftBoundaries(p.changedA, p.changedB, classes1)
+	shiftBoundaries(p.changedB, p.changedA, classes2)
+	return p.changedA, p.changedB
+}
+
+// diff marks the changes of one region
+func (p *patience) diff(r span) {
+	if r.a0 == r.a1 || r.b0 == r.b1 {
+		p.change(r)
+		return
+	}
+	anchors, common := p.anchors(r)
+	switch {
+	case len(anchors) > 0:
+		p.walk(r, anchors)
+	case common:
+		p.fallback(r)
+	default:
+		p.change(r)
+	}
+}
+
+// anchors returns the longest run of lines unique on both sides of the
+// region that appear in the same order on both, by patience sorting the
+// unique lines in the order of the old side. common reports whether the
+// sides have any line in common at all.
+func (p *patience) anchors(r span) (anchors []*patienceEntry, common bool) {
+	entries := make(map[int]*patienceEntry)
+	var order []*patienceEntry
+	for i := r.a0; i < r.a1; i++ {
+		if entry, ok := entries[p.a[i]]; ok {
+			entry.unique = false
+			continue
+		}
+		entry := &patienceEntry{line1: i, line2: -1, unique: true}
+		entries[p.a[i]] = entry
+		order = append(order, entry)
+	}
+	for j := r.b0; j < r.b1; j++ {
+		entry, ok := entries[p.b[j]]
+		if !ok {
+			continue
+		}
+		common = true
+		if entry.line2 >= 0 {
+			entry.unique = false
+			continue
+		}
+		entry.line2 = j
+	}
+
+	// piles[k] is the entry ending the best run of length k+1 found so far
+	var piles []*patienceEntry
+	for _, entry := range order {
+		if !entry.unique || entry.line2 < 0 {
+			continue
+		}
+		left, right := -1, len(piles)
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if piles[middle].line2 > entry.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		if left >= 0 {
+			entry.previous = piles[left]
+		}
+		if left+1 == len(piles) {
+			piles = append(piles, entry)
+		} else {
+			piles[left+1] = entry
+		}
+	}
+	if len(piles) == 0 {
+		return nil, common
+	}
+	for entry := piles[len(piles)-1]; entry != nil; entry = entry.previous {
+		anchors = append(anchors, entry)
+	}
+	for i, j := 0, len(anchors)-1; i < j; i, j = i+1, j-1 {
+		anchors[i], anchors[j] = anchors[j], anchors[i]
+	}
+	return anchors, common
+}
+
+// walk grows each anchor into the run of common lines around it and
+// compares the gaps between the runs
+func (p *patience) walk(r span, anchors []*patienceEntry) {
+	line1, line2 := r.a0, r.b0
+	for k := 0; ; k++ {
+		next1, next2 := r.a1, r.b1
+		if k < len(anchors) {
+			next1, next2 = anchors[k].line1, anchors[k].line2
+			for next1 > line1 && next2 > line2 && p.a[next1-1] == p.b[next2-1] {
+				next1, next2 = next1-1, next2-1
+			}
+		}
+		for line1 < next1 && line2 < next2 && p.a[line1] == p.b[line2] {
+			line1, line2 = line1+1, line2+1
+		}
+		if next1 > line1 || next2 > line2 {
+			p.diff(span{line1, next1, line2, next2})
+		}
+		if k == len(anchors) {
+			return
+		}
+		for k+1 < len(anchors) && anchors[k+1].line1 == anchors[k].line1+1 && anchors[k+1].line2 == anchors[k].line2+1 {
+			k++
+		}
+		line1, line2 = anchors[k].line1+1, anchors[k].line2+1
+	}
+}
+
+// change marks every line of the region changed
+func (p *patience) change(r span) {
+	for i := r.a0; i < r.a1; i++ {
+		p.changedA.set(i, true)
+	}
+	for j := r.b0; j < r.b1; j++ {
+		p.changedB.set(j, true)
+	}
+}
+
+// fallback marks the changes of a region without unique common lines with
+// Myers' algorithm
+func (p *patience) fallback(r span) {
+	changedA, changedB := analyze(p.a[r.a0:r.a1], p.b[r.b0:r.b1], p.count, false, false, p.cost)
+	for i := r.a0; i < r.a1; i++ {
+		p.changedA.set(i, changedA.at(i-r.a0))
+	}
+	for j := r.b0; j < r.b1; j++ {
+		p.changedB.set(j, changedB.at(j-r.b0))
+	}
+}
@@ -0,0 +1,245 @@
+package command_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+	"github.com/yupsh/diff/internal/reference"
+)
+
+// propertyLines are the lines random inputs are drawn from: a few short
+// lines that repeat often, and near-duplicates of them that differ in case,
+// white space or escape sequences only, or look like diff syntax
+var propertyLines = []string{
+	"a", "b", "c", "}", "", "return nil",
+	"A", "a ", " a", "\ta", "B ", "return  nil", "Return nil",
+	"--- a", "+++ b", "@@ -1 +1 @@", `\ No newline at end of file`,
+	"\x1b[1ma\x1b[0m", "\x1b[31mb", "a\x1b[K", "\x1b]8;;x\x07a\x1b]8;;\x07",
+}
+
+// randomLines draws up to n lines, mostly from the first, most repeated
+// lines of propertyLines
+func randomLines(r *rand.Rand, n int) []string {
+	lines := make([]string, r.IntN(n+1))
+	for i := range lines {
+		if r.IntN(4) == 0 {
+			lines[i] = propertyLines[r.IntN(len(propertyLines))]
+		} else {
+			lines[i] = propertyLines[r.IntN(6)]
+		}
+	}
+	return lines
+}
+
+// mutate derives a near-duplicate of lines by deleting, inserting and
+// replacing a few of them
+func mutate(r *rand.Rand, lines []string) []string {
+	out := append([]string(nil), lines...)
+	for range r.IntN(4) {
+		switch i := r.IntN(len(out) + 1); {
+		case r.IntN(3) == 0 && i < len(out):
+			out = append(out[:i], out[i+1:]...)
+		case r.IntN(2) == 0 && i < len(out):
+			out[i] = propertyLines[r.IntN(len(propertyLines))]
+		default:
+			out = append(out[:i], append([]string{propertyLines[r.IntN(len(propertyLines))]}, out[i:]...)...)
+		}
+	}
+	return out
+}
+
+// ansiPattern matches the escape sequences propertyLines hold
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*[A-Za-z]|\x1b\\][^\x07]*\x07")
+
+// propertyNormalizations pairs each set of normalization flags with the
+// line equality it stands for. Under strip, lines are printed without
+// their escape sequences.
+var propertyNormalizations = []struct {
+	name  string
+	opts  []any
+	equal func(x, y string) bool
+	strip bool
+}{
+	{"exact", nil, func(x, y string) bool { return x == y }, false},
+	{"case", []any{command.IgnoreCase}, func(x, y string) bool { return strings.ToLower(x) == strings.ToLower(y) }, false},
+	{"whitespace", []any{command.IgnoreWhitespace}, func(x, y string) bool { return strings.TrimSpace(x) == strings.TrimSpace(y) }, false},
+	{"all-whitespace", []any{command.IgnoreWhitespace, command.GNUCompat}, func(x, y string) bool {
+		return strings.Join(strings.Fields(x), "") == strings.Join(strings.Fields(y), "")
+	}, false},
+	{"case-whitespace", []any{command.IgnoreCase, command.IgnoreWhitespace}, func(x, y string) bool {
+		return strings.ToLower(strings.TrimSpace(x)) == strings.ToLower(strings.TrimSpace(y))
+	}, false},
+	{"ansi", []any{command.IgnoreANSI}, func(x, y string) bool {
+		return ansiPattern.ReplaceAllString(x, "") == ansiPattern.ReplaceAllString(y, "")
+	}, false},
+	{"strip-case", []any{command.IgnoreANSI, command.StripANSI, command.IgnoreCase}, func(x, y string) bool {
+		return strings.ToLower(ansiPattern.ReplaceAllString(x, "")) == strings.ToLower(ansiPattern.ReplaceAllString(y, ""))
+	}, true},
+}
+
+// propertyContexts are the unified context settings random comparisons
+// are rendered with
+var propertyContexts = []any{
+	command.UnifiedContext(3),
+	command.UnifiedContext(1),
+	command.UnifiedContextBeforeAfter(0, 0),
+	command.UnifiedContextBeforeAfter(0, 2),
+	command.UnifiedContextBeforeAfter(2, 0),
+	command.UnifiedContextBeforeAfter(1, 4),
+	command.FullContext,
+}
+
+// propertyAlgorithms select the algorithms random comparisons are made with
+var propertyAlgorithms = []any{command.NoMinimal, command.Minimal, command.AlgorithmPatience, command.Histogram}
+
+// TestDiff_PropertyAgainstReference checks the engine on random inputs:
+// its unified diff and its hunks must turn a into b, streamed and chunked
+// output must agree with it, and its edit script can never be cheaper than
+// the minimal one the reference differ finds. The seed is logged so a
+// failure can be replayed.
+func TestDiff_PropertyAgainstReference(t *testing.T) {
+	iterations := 2000
+	if testing.Short() {
+		iterations = 200
+	}
+	seed := rand.Uint64()
+	t.Logf("seed %d", seed)
+	r := rand.New(rand.NewPCG(seed, 0))
+
+	for i := 0; i < iterations; i++ {
+		size := 12
+		if i%4 == 0 {
+			size = 60
+		}
+		a := randomLines(r, size)
+		b := mutate(r, a)
+		if r.IntN(4) == 0 {
+			b = randomLines(r, size)
+		}
+		for _, norm := range propertyNormalizations {
+			extent := propertyContexts[r.IntN(len(propertyContexts))]
+			opts := append([]any{command.Unified, extent, propertyAlgorithms[r.IntN(len(propertyAlgorithms))]}, norm.opts...)
+			result, err := command.Run(context.Background(), command.LinesSource("standard input", a), command.LinesSource("expected", b), opts...)
+			assertion.NoError(t, err)
+
+			patch := result.Text(command.FormatUnified)
+			// Patches hold the lines as printed
+			origin := a
+			if norm.strip {
+				origin = make([]string, len(a))
+				for i, line := range a {
+					origin[i] = ansiPattern.ReplaceAllString(line, "")
+				}
+			}
+			patched, err := command.Apply(origin, patch)
+			if err != nil {
+				t.Fatalf("%s: patch does not apply to a: %v\na: %q\nb: %q\n%s", norm.name, err, a, b, patch)
+			}
+			if !equalUnder(norm.equal, patched, b) {
+				t.Fatalf("%s: patch turns a into %q, want %q\na: %q\n%s", norm.name, patched, b, a, patch)
+			}
+
+			if replayed := replayHunks(a, result.Hunks); !equalUnder(norm.equal, replayed, b) {
+				t.Fatalf("%s: hunks turn a into %q, want %q\na: %q", norm.name, replayed, b, a)
+			}
+
+			// The command streams past the equal prefix, and must print
+			// what the buffered comparison renders
+			stdout, _, err := execute(command.Diff(append([]any{"-", command.Expected(joinLines(b))}, opts...)...), joinLines(a))
+			assertStatus(t, err, !result.Identical)
+			if stdout != patch {
+				t.Fatalf("%s: streamed output differs:\n%s\nwant:\n%s\na: %q\nb: %q", norm.name, stdout, patch, a, b)
+			}
+
+			// Chunked output aligns windows on its own, but is a patch
+			// all the same
+			if extent != command.FullContext {
+				chunked, _, err := execute(command.Diff(append([]any{"-", command.Expected(joinLines(b)), command.Chunked(1)}, opts...)...), joinLines(a))
+				assertStatus(t, err, !result.Identical)
+				if patched, err := command.Apply(origin, chunked); err != nil || !equalUnder(norm.equal, patched, b) {
+					t.Fatalf("%s: chunked patch turns a into %q (%v), want %q\na: %q\n%s", norm.name, patched, err, b, a, chunked)
+				}
+			}
+
+			stats := result.Stats
+			if stats.Insertions-stats.Deletions != len(b)-len(a) {
+				t.Fatalf("%s: %d insertions and %d deletions cannot turn %d lines into %d\na: %q\nb: %q",
+					norm.name, stats.Insertions, stats.Deletions, len(a), len(b), a, b)
+			}
+			minimal := reference.Cost(a, b, norm.equal)
+			if stats.Insertions+stats.Deletions < minimal {
+				t.Fatalf("%s: edit script of cost %d is below the minimal %d\na: %q\nb: %q",
+					norm.name, stats.Insertions+stats.Deletions, minimal, a, b)
+			}
+			if result.Identical != (minimal == 0) {
+				t.Fatalf("%s: Identical is %t for minimal cost %d\na: %q\nb: %q", norm.name, result.Identical, minimal, a, b)
+			}
+		}
+	}
+}
+
+// equalUnder reports whether got and want have the same lines under equal
+func equalUnder(equal func(x, y string) bool, got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !equal(got[i], want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// joinLines is the text of newline-terminated lines
+func joinLines(lines []string) string {
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(line)
+		text.WriteByte('\n')
+	}
+	return text.String()
+}
+
+// replayHunks applies the operations of hunks to a, copying the lines
+// between hunks from a. It returns nil when a hunk or an operation names a
+// line other than the one the replay is at.
+func replayHunks(a []string, hunks []command.Hunk) []string {
+	var out []string
+	next := 0
+	for _, h := range hunks {
+		start := h.OldStart - 1
+		if h.OldLines == 0 {
+			// An empty range starts at the line before it
+			start = h.OldStart
+		}
+		if start < next || start > len(a) {
+			return nil
+		}
+		out = append(out, a[next:start]...)
+		next = start
+		for _, op := range h.Ops {
+			if op.OldLine > 0 && op.OldLine != next+1 {
+				return nil
+			}
+			switch op.Kind {
+			case command.OpEqual:
+				out = append(out, a[next])
+				next++
+			case command.OpDelete:
+				next++
+			case command.OpInsert:
+				if op.NewLine != len(out)+1 {
+					return nil
+				}
+				out = append(out, op.Text)
+			}
+		}
+	}
+	return append(out, a[next:]...)
+}
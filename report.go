@@ -0,0 +1,237 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// reportEntry is one row of the index of an HTMLReport
+type reportEntry struct {
+	Path                  string
+	Status                string
+	Insertions, Deletions int
+	// Page is the file name of the pair's page, for differing text pairs
+	Page string
+}
+
+// htmlReport collects an HTMLReport while the walk runs. Pages are written
+// as soon as their pair is compared; the index once the walk is done.
+type htmlReport struct {
+	dir     string
+	entries []reportEntry
+	// pending holds what compareFilePair learned about a differing pair
+	// until the walk yields it, by relative path
+	pending map[string]reportEntry
+	// pages are the page names taken so far, folded to lower case for
+	// file systems that ignore case
+	pages map[string]bool
+}
+
+// add records the outcome of an entry of the walk
+func (r *htmlReport) add(result PairResult) {
+	entry, ok := r.pending[result.Path]
+	delete(r.pending, result.Path)
+	if !ok {
+		entry = reportEntry{Path: result.Path, Status: result.Kind.String()}
+	}
+	r.entries = append(r.entries, entry)
+}
+
+// pageName names the page of the pair at the relative path: the path with
+// anything but letters, digits, dots and dashes replaced, and a hash of the
+// path appended when that name is already taken
+func (r *htmlReport) pageName(path string) string {
+	base := strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' {
+			return c
+		}
+		return '_'
+	}, path)
+	name := base + ".html"
+	if r.pages[strings.ToLower(name)] || strings.EqualFold(name, "index.html") {
+		sum := sha256.Sum256([]byte(path))
+		name = base + "-" + hex.EncodeToString(sum[:4]) + ".html"
+	}
+	r.pages[strings.ToLower(name)] = true
+	return name
+}
+
+// newHTMLReport creates the report directory of HTMLReport
+func (p command) newHTMLReport() (*htmlReport, error) {
+	dir := string(p.Flags.HTMLReport)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, &FileError{Path: dir, Err: err}
+	}
+	return &htmlReport{dir: dir, pending: make(map[string]reportEntry), pages: make(map[string]bool)}, nil
+}
+
+// reportPair writes the page of a differing file pair to the HTMLReport.
+// A binary pair gets a row in the index but no page.
+func (p command) reportPair(stderr io.Writer, state *walkState, path1, path2 string, lines1, lines2 []string, binary bool) {
+	if state.report == nil {
+		return
+	}
+	path := state.relative(path1)
+	entry := reportEntry{Path: path, Status: PairDiffer.String()}
+	if binary {
+		entry.Status = "binary"
+		state.report.pending[path] = entry
+		return
+	}
+	script := p.compare(lines1, lines2)
+	stats := scriptStats(script, 0)
+	entry.Insertions, entry.Deletions = stats.Insertions, stats.Deletions
+	entry.Page = state.report.pageName(path)
+
+	page := filepath.Join(state.report.dir, entry.Page)
+	file, err := os.Create(page)
+	if err == nil {
+		err = reportPageTemplate.Execute(file, p.reportPage(path1, path2, lines1, lines2, script))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		p.notice(stderr, "%s: %v", page, err)
+		state.fail(page, err)
+		entry.Page = ""
+	}
+	state.report.pending[path] = entry
+}
+
+// writeHTMLReport writes the index of the report once the walk is done
+func (p command) writeHTMLReport(state *walkState) error {
+	index := filepath.Join(state.report.dir, "index.html")
+	file, err := os.Create(index)
+	if err != nil {
+		return &FileError{Path: index, Err: err}
+	}
+	err = reportIndexTemplate.Execute(file, struct {
+		Old, New string
+		Entries  []reportEntry
+	}{p.displayPath(state.roots[0]), p.displayPath(state.roots[1]), state.report.entries})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return &FileError{Path: index, Err: err}
+	}
+	return nil
+}
+
+// reportCell is one side of a row of a report page. A changed row splits
+// its text around the part that differs from the other side.
+type reportCell struct {
+	Line                   int
+	Before, Changed, After string
+}
+
+// reportRow is one row of the side-by-side table of a report page
+type reportRow struct {
+	Class       string
+	Left, Right *reportCell
+	Header      string
+}
+
+// reportRowClasses are the classes of the rows of a report page, by their
+// side-by-side marker
+var reportRowClasses = map[byte]string{' ': "same", '|': "changed", '<': "deleted", '>': "inserted"}
+
+// reportPage lays out the hunks of a differing pair side by side, with a
+// header row before each hunk
+func (p command) reportPage(path1, path2 string, lines1, lines2 []string, script []edit) any {
+	before, after := p.Flags.unifiedContext()
+	if bool(p.Flags.FullContext) || p.Flags.UnifiedContext < 0 {
+		before, after = len(script), len(script)
+	}
+	var rows []reportRow
+	for _, h := range p.hunks(script, lines1, lines2, before, after) {
+		rows = append(rows, reportRow{Class: "hunk", Header: fmt.Sprintf("@@ -%s +%s @@", unifiedRange(h.startA, h.countA), unifiedRange(h.startB, h.countB))})
+		for _, row := range sideBySideRows(h.edits, lines1, lines2) {
+			r := reportRow{Class: reportRowClasses[row.marker]}
+			if row.hasL {
+				r.Left = &reportCell{Line: row.lineL, Before: row.left}
+			}
+			if row.hasR {
+				r.Right = &reportCell{Line: row.lineR, Before: row.right}
+			}
+			if row.marker == '|' {
+				r.Left, r.Right = intralineCells(r.Left, r.Right)
+			}
+			rows = append(rows, r)
+		}
+	}
+	return struct {
+		Old, New string
+		Rows     []reportRow
+	}{p.displayPath(path1), p.displayPath(path2), rows}
+}
+
+// intralineCells splits the text of a changed row around the part that
+// differs between its sides, where the common prefix and suffix end. The
+// split only falls between grapheme clusters on both sides, so an accent,
+// an emoji sequence or a flag is highlighted whole when any of it changed.
+func intralineCells(left, right *reportCell) (*reportCell, *reportCell) {
+	a, b := left.Before, right.Before
+	breaksA, breaksB := graphemeBreaks(a), graphemeBreaks(b)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for !breaksA[prefix] || !breaksB[prefix] {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for !breaksA[len(a)-suffix] || !breaksB[len(b)-suffix] {
+		suffix--
+	}
+	split := func(cell *reportCell, s string) *reportCell {
+		return &reportCell{Line: cell.Line, Before: s[:prefix], Changed: s[prefix : len(s)-suffix], After: s[len(s)-suffix:]}
+	}
+	return split(left, a), split(right, b)
+}
+
+// reportStyle is the stylesheet every report page embeds
+const reportStyle = `body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 0 .4em; text-align: left; vertical-align: top; }
+td.code { font-family: monospace; white-space: pre-wrap; width: 50%; }
+td.num { font-family: monospace; color: #888; text-align: right; }
+tr.hunk td { background: #eef; color: #558; font-family: monospace; }
+tr.deleted td.old, tr.changed td.old { background: #fee; }
+tr.inserted td.new, tr.changed td.new { background: #efe; }
+del { background: #fbb; text-decoration: none; }
+ins { background: #bfb; text-decoration: none; }
+.identical { color: #888; }
+.differ, .binary, .error { color: #a50; }
+.only-left { color: #a00; }
+.only-right { color: #070; }
+`
+
+var reportIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>diff {{.Old}} {{.New}}</title>
+<style>` + reportStyle + `</style></head>
+<body><h1>diff {{.Old}} {{.New}}</h1>
+<table><tr><th>Path</th><th>Status</th><th>Insertions</th><th>Deletions</th></tr>
+{{range .Entries}}<tr class="{{.Status}}"><td>{{if .Page}}<a href="{{.Page}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td><td>{{.Status}}</td><td>{{if .Page}}+{{.Insertions}}{{end}}</td><td>{{if .Page}}-{{.Deletions}}{{end}}</td></tr>
+{{end}}</table></body></html>
+`))
+
+var reportPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>diff {{.Old}} {{.New}}</title>
+<style>` + reportStyle + `</style></head>
+<body><h1>diff {{.Old}} {{.New}}</h1><p><a href="index.html">index</a></p>
+<table><tr><th></th><th>{{.Old}}</th><th></th><th>{{.New}}</th></tr>
+{{range .Rows}}{{if .Header}}<tr class="hunk"><td colspan="4">{{.Header}}</td></tr>
+{{else}}<tr class="{{.Class}}">{{with .Left}}<td class="num">{{.Line}}</td><td class="code old">{{.Before}}{{if .Changed}}<del>{{.Changed}}</del>{{end}}{{.After}}</td>{{else}}<td class="num"></td><td class="code old"></td>{{end}}{{with .Right}}<td class="num">{{.Line}}</td><td class="code new">{{.Before}}{{if .Changed}}<ins>{{.Changed}}</ins>{{end}}{{.After}}</td>{{else}}<td class="num"></td><td class="code new"></td>{{end}}</tr>
+{{end}}{{end}}</table></body></html>
+`))
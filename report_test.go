@@ -0,0 +1,115 @@
+package command_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_HTMLReport(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	// Both pairs would be named a_b.txt.html, and the lines need escaping
+	writeTree(t, dir1, map[string]string{"a/b.txt": "x <b>old</b> & y\n"})
+	writeTree(t, dir2, map[string]string{"a/b.txt": "x <b>new</b> & y\n"})
+	writeFile(t, dir1, "a_b.txt", "one\n")
+	writeFile(t, dir2, "a_b.txt", "two\n")
+	writeFile(t, dir1, "data.bin", "\x00\x01")
+	writeFile(t, dir2, "data.bin", "\x00\x02")
+	report := filepath.Join(t.TempDir(), "report")
+
+	stdout, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.HTMLReport(report)), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stdout, "Files "+filepath.Join(dir1, "changed.txt")) {
+		t.Errorf("the usual output is still printed, got:\n%s", stdout)
+	}
+
+	index, err := os.ReadFile(filepath.Join(report, "index.html"))
+	assertion.NoError(t, err)
+	for _, want := range []string{
+		`<tr class="identical"><td>same.txt</td>`,
+		`<tr class="only-left"><td>only1.txt</td>`,
+		`<tr class="binary"><td>data.bin</td>`,
+	} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("missing %s in:\n%s", want, index)
+		}
+	}
+
+	// Every link leads to a page of its own holding the pair's hunk
+	pages := make(map[string]string)
+	for _, link := range regexp.MustCompile(`<a href="([^"]+)">([^<]+)</a>`).FindAllStringSubmatch(string(index), -1) {
+		page, err := os.ReadFile(filepath.Join(report, link[1]))
+		assertion.NoError(t, err)
+		pages[link[2]] = string(page)
+	}
+	if len(pages) != 4 {
+		t.Fatalf("got links to %v", pages)
+	}
+	for path, want := range map[string][]string{
+		"changed.txt":    {"@@ -1 &#43;1 @@", `<td class="code old"><del>old</del></td>`, `<td class="code new"><ins>new</ins></td>`},
+		"sub/nested.txt": {`nested <del>old</del>`, `nested <ins>new</ins>`},
+		"a/b.txt":        {`x &lt;b&gt;<del>old</del>&lt;/b&gt; &amp; y`, `x &lt;b&gt;<ins>new</ins>&lt;/b&gt; &amp; y`},
+		"a_b.txt":        {`<del>one</del>`, `<ins>two</ins>`},
+	} {
+		for _, text := range want {
+			if !strings.Contains(pages[path], text) {
+				t.Errorf("%s: missing %s in:\n%s", path, text, pages[path])
+			}
+		}
+	}
+	if strings.Contains(string(index), "<b>") || strings.Contains(string(index), "http") {
+		t.Errorf("the index loads or injects nothing:\n%s", index)
+	}
+}
+
+func TestDiff_HTMLReportRequiresRecursive(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	_, _, err := execute(command.Diff(dir1, dir2, command.HTMLReport(t.TempDir())), "")
+	if err == nil || !strings.Contains(err.Error(), "HTMLReport requires Recursive") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestDiff_HTMLReportGraphemes(t *testing.T) {
+	// The highlight widens to whole grapheme clusters where the bytes in
+	// common end inside one
+	tests := []struct {
+		name, old, new string
+		del, ins       string
+	}{
+		{"composed", "caf\u00e9 au lait", "cafe\u0301 au lait", "caf<del>\u00e9</del> au lait", "caf<ins>e\u0301</ins> au lait"},
+		{"accent", "cafe\u0301!", "cafe\u0300!", "caf<del>e\u0301</del>!", "caf<ins>e\u0300</ins>!"},
+		{"accent after", "xa\u0301", "xb\u0301", "x<del>a\u0301</del>", "x<ins>b\u0301</ins>"},
+		{"family", "we \U0001F468\u200d\U0001F469\u200d\U0001F467 go", "we \U0001F468\u200d\U0001F469\u200d\U0001F466 go",
+			"we <del>\U0001F468\u200d\U0001F469\u200d\U0001F467</del> go", "we <ins>\U0001F468\u200d\U0001F469\u200d\U0001F466</ins> go"},
+		{"skin tone", "ok \U0001F44D\U0001F3FB", "ok \U0001F44D\U0001F3FD", "ok <del>\U0001F44D\U0001F3FB</del>", "ok <ins>\U0001F44D\U0001F3FD</ins>"},
+		{"flag", "\U0001F1EB\U0001F1F7\U0001F1E9\U0001F1EA", "\U0001F1EB\U0001F1F7\U0001F1E9\U0001F1F0",
+			"\U0001F1EB\U0001F1F7<del>\U0001F1E9\U0001F1EA</del>", "\U0001F1EB\U0001F1F7<ins>\U0001F1E9\U0001F1F0</ins>"},
+		{"flag run", "\U0001F1EB\U0001F1F7\U0001F1E9\U0001F1EA", "\U0001F1EB\U0001F1F8\U0001F1E9\U0001F1EA",
+			"<del>\U0001F1EB\U0001F1F7</del>\U0001F1E9\U0001F1EA", "<ins>\U0001F1EB\U0001F1F8</ins>\U0001F1E9\U0001F1EA"},
+	}
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	for i, tt := range tests {
+		name := fmt.Sprintf("%d.txt", i)
+		writeFile(t, dir1, name, tt.old+"\n")
+		writeFile(t, dir2, name, tt.new+"\n")
+	}
+	report := filepath.Join(t.TempDir(), "report")
+	_, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.Brief, command.HTMLReport(report)), "")
+	assertDiffers(t, err)
+	for i, tt := range tests {
+		page, err := os.ReadFile(filepath.Join(report, fmt.Sprintf("%d.txt.html", i)))
+		assertion.NoError(t, err)
+		for _, want := range []string{`<td class="code old">` + tt.del + `</td>`, `<td class="code new">` + tt.ins + `</td>`} {
+			if !strings.Contains(string(page), want) {
+				t.Errorf("%s: missing %s in:\n%s", tt.name, want, page)
+			}
+		}
+	}
+}
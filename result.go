@@ -0,0 +1,227 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Source is one side of a comparison made with Run
+type Source struct {
+	name string
+	read func(p command) ([]string, error)
+}
+
+// FileSource reads the file at path, which may name a zip member as
+// archive.zip//member. "-" is a file name here, not standard input.
+func FileSource(path string) Source {
+	return Source{name: path, read: func(p command) ([]string, error) { return p.readFileLines(path) }}
+}
+
+// ReaderSource reads lines from r, shown as name in headers
+func ReaderSource(name string, r io.Reader) Source {
+	return Source{name: name, read: func(p command) ([]string, error) {
+		var buf lineBuffer
+		return p.readNamed(&buf, name, r)
+	}}
+}
+
+// LinesSource compares lines that are already in memory, shown as name in
+// headers. They are treated as the lines of a file read from disk would be:
+// ShowIndex hashes, CanonicalJSON parses and JSONDetail locates them as
+// newline-terminated text,
+// and StripANSI and FocusLines apply to a copy.
+func LinesSource(name string, lines []string) Source {
+	return Source{name: name, read: func(p command) ([]string, error) {
+		var buf lineBuffer
+		if p.Flags.digests != nil || bool(p.Flags.CanonicalJSON) || p.Flags.lineRanges != nil {
+			for _, line := range lines {
+				buf.data.WriteString(line)
+				buf.data.WriteByte('\n')
+			}
+		}
+		buf.lines = append(buf.lines, lines...)
+		p.stripOutput(buf.lines)
+		p.Flags.usage.recordRead(0, &buf)
+		p.finishRead(&buf, name)
+		return buf.lines, nil
+	}}
+}
+
+// Result is a comparison computed once by Run. Its text renderings are
+// produced on first request and cached; every method is safe for concurrent
+// use.
+type Result struct {
+	Identical bool
+	Stats     Stats
+	Hunks     []Hunk
+
+	p              command
+	name1, name2   string
+	lines1, lines2 []string
+	script         []edit
+
+	mu   sync.Mutex
+	text map[Format]string
+}
+
+// Run compares a and b with the given options and returns the result, from
+// which any number of formats can be rendered without comparing again.
+// String parameters are ignored: the operands are a and b.
+func Run(ctx context.Context, a, b Source, opts ...any) (*Result, error) {
+	return newCommand(opts...).run(ctx, a, b)
+}
+
+// RunWithFlags is Run configured by a Flags struct instead of options
+func RunWithFlags(ctx context.Context, a, b Source, f Flags) (*Result, error) {
+	return build(nil, f).run(ctx, a, b)
+}
+
+// run compares a and b under the prepared command p
+func (p command) run(ctx context.Context, a, b Source) (*Result, error) {
+	if p.Flags.err != nil {
+		return nil, p.Flags.err
+	}
+	p.begin()
+	if bool(p.Flags.Reverse) {
+		a, b = b, a
+	}
+
+	usage := p.Flags.usage
+	usage.start()
+	lines1, err := a.read(p)
+	if err != nil {
+		return nil, &FileError{Path: a.name, Err: err}
+	}
+	usage.nextInput(lines1)
+	// Taken before b is read, which may have the same name
+	ranges1, open1 := p.Flags.lineRanges[a.name], p.Flags.unterminated[a.name]
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	lines2, err := b.read(p)
+	if err != nil {
+		return nil, &FileError{Path: b.name, Err: err}
+	}
+	usage.nextInput(lines2)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	usage.lap(phaseRead)
+
+	p.Flags.open = [2]bool{open1, p.Flags.unterminated[b.name]}
+	script := p.compare(lines1, lines2)
+	usage.lap(phaseCompare)
+	stats, exported := p.summarize(lines1, lines2, ranges1, p.Flags.lineRanges[b.name], script)
+	identical := p.identical(lines1, lines2)
+	p.logComparison(a.name, b.name, lines1, lines2, script, identical)
+	if usage != nil {
+		usage.lap(phaseHunks)
+		resources := *usage
+		stats.Resources = &resources
+	}
+	return &Result{
+		Identical: identical,
+		Stats:     stats,
+		Hunks:     exported,
+		p:         p,
+		name1:     a.name,
+		name2:     b.name,
+		lines1:    lines1,
+		lines2:    lines2,
+		script:    script,
+		text:      make(map[Format]string),
+	}, nil
+}
+
+// summarize groups script into the exported hunks of a comparison, with
+// their details under JSONDetail given the byte ranges of the lines, and
+// counts its statistics
+func (p command) summarize(lines1, lines2 []string, ranges1, ranges2 []ByteRange, script []edit) (Stats, []Hunk) {
+	before, after := p.Flags.unifiedContext()
+	hunks := p.hunks(script, lines1, lines2, before, after)
+	exported := exportHunks(hunks, lines1, lines2)
+	if bool(p.Flags.JSONDetail) {
+		attachDetails(exported, hunks, lines1, lines2, ranges1, ranges2)
+	}
+	return scriptStats(script, len(hunks)), exported
+}
+
+// outputJSON writes the comparison as Result.JSON encodes it, on one line:
+// the same hunks and statistics, without the Resources only Run measures
+func (p command) outputJSON(w io.Writer, file1Path, file2Path string, lines1, lines2 []string, script []edit) {
+	stats, hunks := p.summarize(lines1, lines2, p.Flags.lineRanges[file1Path], p.Flags.lineRanges[file2Path], script)
+	data, err := encodeResult(p.identical(lines1, lines2), stats, hunks)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(append(data, '\n'))
+}
+
+// encodeResult is the JSON encoding of a comparison
+func encodeResult(identical bool, stats Stats, hunks []Hunk) ([]byte, error) {
+	return json.Marshal(struct {
+		Identical bool
+		Stats     Stats
+		Hunks     []Hunk
+	}{identical, stats, hunks})
+}
+
+// begin sets up the per-run state of a comparison made without the
+// Executor, where color is only used when ColorAlways forces it
+func (p *command) begin() {
+	p.Flags.color = p.Flags.Color == ColorAlways
+	if bool(p.Flags.ShowIndex) {
+		p.Flags.digests = make(map[string]string)
+	}
+	if bool(p.Flags.CanonicalJSON) {
+		p.Flags.canonical = make(map[string]bool)
+	}
+	if bool(p.Flags.FocusKeepNumbers) {
+		p.Flags.focusIndex = make(map[string][]int)
+	}
+	if bool(p.Flags.JSONDetail) {
+		p.Flags.lineRanges = make(map[string][]ByteRange)
+	}
+	p.Flags.unterminated = make(map[string]bool)
+	if bool(p.Flags.ResourceStats) {
+		p.Flags.usage = &ResourceUsage{}
+	}
+	p.Flags.binary = make(map[string]bool)
+}
+
+// Text renders the result in format, exactly as the command would print it
+func (r *Result) Text(format Format) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if text, ok := r.text[format]; ok {
+		return text
+	}
+
+	if format == FormatJSON {
+		// Machine-readable, so never prefixed
+		data, _ := r.JSON()
+		r.text[format] = string(data) + "\n"
+		return r.text[format]
+	}
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	if r.p.Flags.OutputPrefix != "" {
+		w = newPrefixWriter(w, string(r.p.Flags.OutputPrefix))
+	}
+	if r.Identical {
+		r.p.writeIdentical(w, format, r.name1, r.name2, r.lines1, r.lines2, r.script)
+	} else {
+		r.p.writeFormat(w, format, r.name1, r.name2, r.lines1, r.lines2, r.script)
+	}
+	r.text[format] = buf.String()
+	return r.text[format]
+}
+
+// JSON encodes Identical, Stats and Hunks
+func (r *Result) JSON() ([]byte, error) {
+	return encodeResult(r.Identical, r.Stats, r.Hunks)
+}
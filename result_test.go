@@ -0,0 +1,134 @@
+package command_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestRun_TextAndJSONFromOnePass(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, nil))
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{3: "changed 3", 20: "changed 20"}))
+
+	compares := 0
+	hook := command.CompareHook(func() { compares++ })
+	result, err := command.Run(context.Background(), command.FileSource(a), command.FileSource(b), hook)
+	assertion.NoError(t, err)
+
+	unified := result.Text(command.FormatUnified)
+	encoded, err := result.JSON()
+	assertion.NoError(t, err)
+	_ = result.Text(command.FormatNormal)
+	if compares != 1 {
+		t.Errorf("engine ran %d times, want once", compares)
+	}
+
+	stdout, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	if unified != stdout {
+		t.Errorf("unified text differs from the command:\n%s\nwant:\n%s", unified, stdout)
+	}
+
+	var decoded struct {
+		Identical bool
+		Stats     command.Stats
+		Hunks     []command.Hunk
+	}
+	assertion.NoError(t, json.Unmarshal(encoded, &decoded))
+	if decoded.Identical || decoded.Stats != (command.Stats{Insertions: 2, Deletions: 2, Hunks: 2}) {
+		t.Errorf("unexpected JSON summary: %s", encoded)
+	}
+	for _, h := range decoded.Hunks {
+		header := fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+		if !strings.Contains(unified, header) {
+			t.Errorf("JSON hunk %q not in the unified text:\n%s", header, unified)
+		}
+	}
+}
+
+func TestRun_ConcurrentRendering(t *testing.T) {
+	result, err := command.Run(context.Background(),
+		command.LinesSource("old", []string{"a", "b"}),
+		command.ReaderSource("new", strings.NewReader("a\nc\n")),
+		command.Unified)
+	assertion.NoError(t, err)
+
+	var wg sync.WaitGroup
+	texts := make([]string, 8)
+	for i := range texts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			texts[i] = result.Text(command.Format(i % 2))
+		}(i)
+	}
+	wg.Wait()
+	for i := 2; i < len(texts); i++ {
+		if texts[i] != texts[i%2] {
+			t.Errorf("render %d differs: %q vs %q", i, texts[i], texts[i%2])
+		}
+	}
+	if want := "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n"; texts[1] != want {
+		t.Errorf("got %q, want %q", texts[1], want)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	_, err := command.Run(context.Background(), command.FileSource("testdata/missing.txt"), command.FileSource("testdata/a.txt"))
+	assertion.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = command.Run(ctx, command.FileSource("testdata/a.txt"), command.FileSource("testdata/b.txt"))
+	assertion.Error(t, err)
+
+	result, err := command.Run(context.Background(), command.FileSource("testdata/a.txt"), command.FileSource("testdata/a.txt"))
+	assertion.NoError(t, err)
+	if !result.Identical || result.Text(command.FormatUnified) != "" || result.Text(command.FormatCount) != "0\n" {
+		t.Errorf("identical result rendered unexpectedly")
+	}
+}
+
+func TestRun_LinesSourceReadLikeFiles(t *testing.T) {
+	a := []string{"\x1b[1mtitle\x1b[0m", "keep 1", "skip", "keep 2"}
+	b := []string{"title", "keep 1", "other", "keep two"}
+	result, err := command.Run(context.Background(), command.LinesSource("a", a), command.LinesSource("b", b),
+		command.IgnoreANSI, command.StripANSI, command.FocusLines("^keep"), command.Unified)
+	assertion.NoError(t, err)
+	want := "--- a\n+++ b\n@@ -1,2 +1,2 @@\n keep 1\n-keep 2\n+keep two\n"
+	if got := result.Text(command.FormatUnified); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if a[0] != "\x1b[1mtitle\x1b[0m" {
+		t.Errorf("the caller's lines were changed: %q", a[0])
+	}
+
+	result, err = command.Run(context.Background(), command.LinesSource("a", []string{`{"b": 1,`, `"a": 2}`}),
+		command.LinesSource("b", []string{`{"a":2,"b":1}`}), command.CanonicalJSON)
+	assertion.NoError(t, err)
+	if !result.Identical {
+		t.Errorf("canonical JSON lines should compare equal:\n%s", result.Text(command.FormatUnified))
+	}
+}
+
+func TestRunWithFlags_MatchesRun(t *testing.T) {
+	a := command.LinesSource("a", []string{"one", "Two", "three"})
+	b := command.LinesSource("b", []string{"one", "two", "3"})
+	byFlags, err := command.RunWithFlags(context.Background(), a, b, command.Flags{IgnoreCase: command.IgnoreCase})
+	assertion.NoError(t, err)
+	byOptions, err := command.Run(context.Background(), a, b, command.IgnoreCase)
+	assertion.NoError(t, err)
+	if got, want := byFlags.Text(command.FormatUnified), byOptions.Text(command.FormatUnified); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	_, err = command.RunWithFlags(context.Background(), a, b, command.Flags{Labels: []command.Label{"1", "2", "3"}})
+	assertion.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+)
+
+// outputSequencer orders diagnostics with output when stdout and stderr
+// reach the same writer. A diagnostic written while the output of a file
+// pair is in progress is held until that output is complete, so it never
+// lands inside a hunk; under EncodedOutput, whose single line only ends with
+// the run, diagnostics are held until the run ends. Writes to either side
+// are serialized.
+type outputSequencer struct {
+	mu        sync.Mutex
+	out, errs io.Writer
+
+	// open counts the units of output in progress, and whole holds every
+	// diagnostic until finish
+	open  int
+	whole bool
+
+	held     bytes.Buffer
+	deferred int
+}
+
+// sequencedWriter is one side of an outputSequencer
+type sequencedWriter struct {
+	s          *outputSequencer
+	diagnostic bool
+}
+
+func (w sequencedWriter) Write(b []byte) (int, error) {
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !w.diagnostic:
+		return s.out.Write(b)
+	case s.open > 0 || s.whole:
+		s.deferred++
+		return s.held.Write(b)
+	default:
+		return s.errs.Write(b)
+	}
+}
+
+// sameWriter reports whether a and b are the same writer. Writers of a
+// type that cannot be compared are taken to differ.
+func sameWriter(a, b io.Writer) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// sequence returns the writers to use in place of stdout and stderr, which
+// go through an outputSequencer when they are the same writer or
+// SynchronizedOutput is set. The sequencer is kept in the flags for
+// beginOutput and endOutput.
+func (p *command) sequence(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if !bool(p.Flags.SynchronizedOutput) && !sameWriter(stdout, stderr) {
+		return stdout, stderr
+	}
+	s := &outputSequencer{out: stdout, errs: stderr, whole: p.Flags.EncodedOutput != EncodeNone}
+	p.Flags.sequencer = s
+	return sequencedWriter{s: s}, sequencedWriter{s: s, diagnostic: true}
+}
+
+// beginOutput starts a unit of output, such as the output of a file pair,
+// that diagnostics must not interrupt
+func (s *outputSequencer) beginOutput() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open++
+}
+
+// endOutput ends a unit of output, writing the diagnostics held back during
+// it once no unit is open
+func (s *outputSequencer) endOutput() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open--
+	if s.open == 0 && !s.whole {
+		s.release()
+	}
+}
+
+// finish writes the diagnostics still held once all output is written, and
+// logs how many were held back to Logger
+func (s *outputSequencer) finish(logger *slog.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release()
+	if logger != nil && s.deferred > 0 {
+		logger.Info("diagnostics deferred", "writes", s.deferred)
+	}
+}
+
+// release writes the held diagnostics
+func (s *outputSequencer) release() {
+	if s.held.Len() > 0 {
+		_, _ = s.errs.Write(s.held.Bytes())
+		s.held.Reset()
+	}
+}
@@ -0,0 +1,110 @@
+package command_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	gloo "github.com/gloo-foo/framework"
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// otherHalf writes to a buffer through a writer of another type, so it is
+// not detected as the same writer
+type otherHalf struct{ buf *bytes.Buffer }
+
+func (o otherHalf) Write(b []byte) (int, error) { return o.buf.Write(b) }
+
+// executeShared runs cmd with stdout and stderr both going to one buffer,
+// through stderr when it is given
+func executeShared(cmd gloo.Command, stderr func(*bytes.Buffer) io.Writer) (string, error) {
+	var buf bytes.Buffer
+	err := cmd.Executor()(context.Background(), strings.NewReader(""), &buf, stderr(&buf))
+	return buf.String(), err
+}
+
+// sharedTrees makes two directories whose differing files include one
+// that is not JSON, noted in the middle of a CanonicalJSON run
+func sharedTrees(t *testing.T) (string, string) {
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	writeFile(t, dir1, "a.json", `{"a": 1, "b": [1, 2, 3]}`)
+	writeFile(t, dir2, "a.json", `{"a": 2, "b": [1, 2, 3]}`)
+	writeFile(t, dir1, "b.json", "one\ntwo\nthree\n")
+	writeFile(t, dir2, "b.json", "one\n2\nthree\n")
+	writeFile(t, dir1, "c.json", `{"c": true}`)
+	writeFile(t, dir2, "c.json", `{"c": false}`)
+	return dir1, dir2
+}
+
+func TestDiff_SharedOutputRecursive(t *testing.T) {
+	dir1, dir2 := sharedTrees(t)
+	params := []any{dir1, dir2, command.Recursive, command.Unified, command.CanonicalJSON}
+	stdout, stderr, err := execute(command.Diff(params...), "")
+	assertDiffers(t, err)
+	if !strings.Contains(stderr, "b.json: not JSON") {
+		t.Fatalf("no notice in %q", stderr)
+	}
+
+	// The notices sit between the file diffs, which parse as written alone
+	shared, err := executeShared(command.Diff(params...), func(buf *bytes.Buffer) io.Writer { return buf })
+	assertDiffers(t, err)
+	var kept, notices []string
+	for _, line := range strings.SplitAfter(shared, "\n") {
+		if strings.HasPrefix(line, "diff: ") {
+			notices = append(notices, line)
+		} else {
+			kept = append(kept, line)
+		}
+	}
+	if got := strings.Join(kept, ""); got != stdout {
+		t.Errorf("got:\n%s\nwant:\n%s", got, stdout)
+	}
+	if got := strings.Join(notices, ""); got != stderr {
+		t.Errorf("got notices %q, want %q", got, stderr)
+	}
+	want, err := command.Parse(strings.NewReader(stdout))
+	assertion.NoError(t, err)
+	got, err := command.Parse(strings.NewReader(shared))
+	assertion.NoError(t, err)
+	if len(got) != 3 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDiff_SharedOutputEncoded(t *testing.T) {
+	// An encoded line spans the run, so the notice waits for its end
+	dir1, dir2 := sharedTrees(t)
+	params := []any{dir1, dir2, command.Recursive, command.Unified, command.CanonicalJSON, command.EncodedOutput(command.EncodeBase64)}
+	stdout, stderr, err := execute(command.Diff(params...), "")
+	assertDiffers(t, err)
+	for _, tt := range []struct {
+		name   string
+		params []any
+		stderr func(*bytes.Buffer) io.Writer
+	}{
+		{"same writer", params, func(buf *bytes.Buffer) io.Writer { return buf }},
+		{"SynchronizedOutput", append(params, command.SynchronizedOutput), func(buf *bytes.Buffer) io.Writer { return otherHalf{buf} }},
+	} {
+		shared, err := executeShared(command.Diff(tt.params...), tt.stderr)
+		assertDiffers(t, err)
+		if shared != stdout+stderr {
+			t.Errorf("%s: got %q, want %q", tt.name, shared, stdout+stderr)
+		}
+		decoded, err := command.DecodeDiff(strings.SplitAfter(shared, "\n")[0], command.EncodeBase64)
+		assertion.NoError(t, err)
+		if diffs, err := command.Parse(strings.NewReader(decoded)); err != nil || len(diffs) != 3 {
+			t.Errorf("%s: got %d file diffs, %v", tt.name, len(diffs), err)
+		}
+	}
+
+	// Writers not known to be shared are written as the run goes
+	shared, err := executeShared(command.Diff(params...), func(buf *bytes.Buffer) io.Writer { return otherHalf{buf} })
+	assertDiffers(t, err)
+	if strings.HasSuffix(shared, stderr) {
+		t.Errorf("notice not written as raised: %q", shared)
+	}
+}
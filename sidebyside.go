@@ -0,0 +1,188 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// gutterWidth is the minimum space between the two columns, as in GNU diff
+const gutterWidth = 3
+
+// sideBySideLayout computes the column width and the offset of the right
+// column for a total output width, following GNU diff -y -t
+func sideBySideLayout(width int) (half, offset int) {
+	offset = (width + 1 + gutterWidth) / 2
+	half = max(0, min(offset-gutterWidth, width-offset))
+	if half == 0 {
+		offset = width
+	}
+	return half, offset
+}
+
+// expandCell expands tabs to spaces and cuts the result to width columns,
+// returning the cell and the columns it uses. Every grapheme cluster is one
+// column and is kept or cut whole; under raw every byte is, so invalid UTF-8
+// is never rewritten.
+func expandCell(line string, width int, raw bool) (string, int) {
+	var breaks []bool
+	if !raw {
+		breaks = graphemeBreaks(line)
+	}
+	var b strings.Builder
+	column := 0
+	for i := 0; i < len(line) && column < width; {
+		next := i + 1
+		for !raw && !breaks[next] {
+			next++
+		}
+		if line[i:next] == "\t" {
+			spaces := min(8-column%8, width-column)
+			b.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		} else {
+			b.WriteString(line[i:next])
+			column++
+		}
+		i = next
+	}
+	return b.String(), column
+}
+
+// sideBySideRow is one aligned row of side-by-side output. left or right is
+// absent for rows that exist on one side only.
+type sideBySideRow struct {
+	marker       byte
+	left, right  string
+	hasL, hasR   bool
+	movedL       bool
+	movedR       bool
+	lineL, lineR int
+}
+
+// sideBySideRows aligns an edit script into rows. Within a change group the
+// deleted and inserted lines are paired off and the surplus is one-sided.
+// Each column shows its own file's text, so an unchanged row may differ
+// between the columns in what the comparison ignores.
+func sideBySideRows(script []edit, lines1, lines2 []string) []sideBySideRow {
+	var rows []sideBySideRow
+	for i := 0; i < len(script); {
+		if script[i].kind == opEqual {
+			e := script[i]
+			rows = append(rows, sideBySideRow{marker: ' ', left: lines1[e.a], right: lines2[e.b], hasL: true, hasR: true, lineL: e.a + 1, lineR: e.b + 1})
+			i++
+			continue
+		}
+
+		var deleted, inserted []edit
+		deleted, inserted, i = changeGroup(script, i)
+		for k := 0; k < max(len(deleted), len(inserted)); k++ {
+			row := sideBySideRow{marker: '|'}
+			if k < len(deleted) {
+				row.left, row.hasL, row.movedL, row.lineL = lines1[deleted[k].a], true, deleted[k].move != 0, deleted[k].a+1
+			} else {
+				row.marker = '>'
+			}
+			if k < len(inserted) {
+				row.right, row.hasR, row.movedR, row.lineR = lines2[inserted[k].b], true, inserted[k].move != 0, inserted[k].b+1
+			} else {
+				row.marker = '<'
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// outputSideBySide outputs in side-by-side format. Padding is computed on
+// the plain cell text before any color is applied, so escape sequences never
+// shift the columns.
+func (p command) outputSideBySide(w io.Writer, lines1, lines2 []string, script []edit) {
+	half, offset := sideBySideLayout(int(p.Flags.Width))
+
+	rows := sideBySideRows(script, lines1, lines2)
+	for i := range rows {
+		rows[i].marker = p.Flags.openEndMarker(rows[i], len(lines1), len(lines2))
+	}
+	folds := p.folds(len(rows), func(i int) bool { return rows[i].marker == ' ' })
+	for i := 0; i < len(rows); i++ {
+		if len(folds) > 0 && folds[0].start == i {
+			_, _ = fmt.Fprintln(w, foldMarker(folds[0].end-folds[0].start))
+			i = folds[0].end - 1
+			folds = folds[1:]
+			continue
+		}
+
+		row := rows[i]
+		var b strings.Builder
+
+		left, leftWidth := expandCell(row.left, half, bool(p.Flags.RawBytes))
+		leftColor, rightColor := "", ""
+		switch row.marker {
+		case '<':
+			leftColor = sgrRed
+		case '>':
+			rightColor = sgrGreen
+		case '|', '\\', '/':
+			leftColor, rightColor = sgrRed, sgrGreen
+		}
+		if row.movedL {
+			leftColor = sgrMagenta
+		}
+		if row.movedR {
+			rightColor = sgrCyan
+		}
+
+		if row.hasL && leftColor != "" {
+			b.WriteString(p.paint(leftColor, left))
+		} else {
+			b.WriteString(left)
+		}
+
+		if row.marker == ' ' {
+			b.WriteString(strings.Repeat(" ", offset-leftWidth))
+		} else {
+			b.WriteString(strings.Repeat(" ", half-leftWidth+1))
+			b.WriteString(p.paint(sgrBold, string(row.marker)))
+			if row.hasR {
+				b.WriteString(strings.Repeat(" ", offset-half-2))
+			}
+		}
+
+		if row.hasR {
+			right, _ := expandCell(row.right, half, bool(p.Flags.RawBytes))
+			if rightColor != "" {
+				right = p.paint(rightColor, right)
+			}
+			b.WriteString(right)
+		}
+
+		_, _ = fmt.Fprintln(w, b.String())
+	}
+}
+
+// outputSideBySideTSV writes the side-by-side rows as tab separated records
+// for spreadsheets and other tools:
+//
+//	marker<TAB>leftLine<TAB>leftText<TAB>rightLine<TAB>rightText
+//
+// The marker is = for an unchanged row, | for a changed one, and < or > for
+// a line found in the left or right file only. The cells of the missing side
+// are empty. Texts are escaped like RawEvents texts.
+func outputSideBySideTSV(w io.Writer, lines1, lines2 []string, script []edit) {
+	for _, row := range sideBySideRows(script, lines1, lines2) {
+		marker := string(row.marker)
+		if row.marker == ' ' {
+			marker = "="
+		}
+		leftLine, rightLine := "", ""
+		if row.hasL {
+			leftLine = strconv.Itoa(row.lineL)
+		}
+		if row.hasR {
+			rightLine = strconv.Itoa(row.lineR)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", marker, leftLine, eventEscaper.Replace(row.left), rightLine, eventEscaper.Replace(row.right))
+	}
+}
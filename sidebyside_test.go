@@ -0,0 +1,165 @@
+package command_test
+
+import (
+	"encoding/csv"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+var sgr = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestDiff_SideBySide(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "a\nb\nc\nd\n")
+	b := writeFile(t, dir, "b", "a\nB\nc\nd\ne\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.SideBySide, command.Width(30)), "")
+	assertDiffers(t, err)
+	want := "a                a\n" +
+		"b             |  B\n" +
+		"c                c\n" +
+		"d                d\n" +
+		"              >  e\n"
+	if stdout != want {
+		t.Errorf("got:\n%q\nwant:\n%q", stdout, want)
+	}
+
+	stdout, _, err = execute(command.Diff(b, a, command.SideBySide, command.Width(30)), "")
+	assertDiffers(t, err)
+	if want := "e             <\n"; stdout[len(stdout)-len(want):] != want {
+		t.Errorf("left-only row: got:\n%q", stdout)
+	}
+}
+
+func TestDiff_SideBySideTruncatesAndExpandsTabs(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\ty\n")
+	b := writeFile(t, dir, "b", "abcdefghijklmnopqrstuvwxyz\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.SideBySide, command.Width(30)), "")
+	assertDiffers(t, err)
+	if want := "x       y     |  abcdefghijklm\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_SideBySideTruncatesGraphemeClusters(t *testing.T) {
+	// Each cluster starts in the last column of a 30-column layout's cells,
+	// so it is kept whole and nothing after it is
+	prefix := "abcdefghijkl"
+	for name, cluster := range map[string]string{
+		"combining accent": "e\u0301\u0323",
+		"ZWJ sequence":     "\U0001F469\u200D\U0001F467\u200D\U0001F466",
+		"skin tone":        "\U0001F44D\U0001F3FD",
+		"flag":             "\U0001F1E9\U0001F1EA",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			long := writeFile(t, dir, "long", prefix+cluster+"\U0001F1EB\U0001F1F7z\n")
+			short := writeFile(t, dir, "short", "b\n")
+
+			stdout, _, err := execute(command.Diff(short, long, command.SideBySide, command.Width(30)), "")
+			assertDiffers(t, err)
+			if want := "b             |  " + prefix + cluster + "\n"; stdout != want {
+				t.Errorf("right column: got %q, want %q", stdout, want)
+			}
+
+			stdout, _, err = execute(command.Diff(long, short, command.SideBySide, command.Width(30)), "")
+			assertDiffers(t, err)
+			if want := prefix + cluster + " |  b\n"; stdout != want {
+				t.Errorf("left column: got %q, want %q", stdout, want)
+			}
+		})
+	}
+}
+
+func TestDiff_SideBySideColor(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "a\nb\nc\n")
+	b := writeFile(t, dir, "b", "a\nB\nc\nd\n")
+
+	tests := []struct {
+		name, file1, file2, want string
+	}{
+		{"right only", a, b, "a                a\n" +
+			"\x1b[31mb\x1b[0m             \x1b[1m|\x1b[0m  \x1b[32mB\x1b[0m\n" +
+			"c                c\n" +
+			"              \x1b[1m>\x1b[0m  \x1b[32md\x1b[0m\n"},
+		{"left only", b, a, "a                a\n" +
+			"\x1b[31mB\x1b[0m             \x1b[1m|\x1b[0m  \x1b[32mb\x1b[0m\n" +
+			"c                c\n" +
+			"\x1b[31md\x1b[0m             \x1b[1m<\x1b[0m\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, _, err := execute(command.Diff(tt.file1, tt.file2, command.SideBySide, command.Width(30)), "")
+			assertDiffers(t, err)
+			colored, _, err := execute(command.Diff(tt.file1, tt.file2, command.SideBySide, command.Width(30), command.ColorAlways), "")
+			assertDiffers(t, err)
+
+			if colored != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", colored, tt.want)
+			}
+			if stripped := sgr.ReplaceAllString(colored, ""); stripped != plain {
+				t.Errorf("escapes changed the layout:\n%q\nplain:\n%q", stripped, plain)
+			}
+
+			never, _, err := execute(command.Diff(tt.file1, tt.file2, command.SideBySide, command.Width(30), command.ColorNever), "")
+			assertDiffers(t, err)
+			if never != plain {
+				t.Errorf("ColorNever changed the output: %q", never)
+			}
+		})
+	}
+}
+
+// unescapeTSV reverses the escaping of side-by-side TSV texts
+var unescapeTSV = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+
+func TestDiff_SideBySideTSV(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "keep\tthis\nold\\path\ncommon\ngone\n")
+	b := writeFile(t, dir, "b", "keep\tthis\nnew\tvalue\ncommon\n")
+
+	stdout, _, err := execute(command.Diff(a, b, command.SideBySideTSV), "")
+	assertDiffers(t, err)
+
+	reader := csv.NewReader(strings.NewReader(stdout))
+	reader.Comma = '\t'
+	records, err := reader.ReadAll()
+	assertion.NoError(t, err)
+	want := [][]string{
+		{"=", "1", "keep\tthis", "1", "keep\tthis"},
+		{"|", "2", "old\\path", "2", "new\tvalue"},
+		{"=", "3", "common", "3", "common"},
+		{"<", "4", "gone", "", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d:\n%s", len(records), len(want), stdout)
+	}
+	for i, record := range records {
+		if len(record) != 5 {
+			t.Fatalf("record %d has %d fields", i, len(record))
+		}
+		record[2], record[4] = unescapeTSV.Replace(record[2]), unescapeTSV.Replace(record[4])
+		if !slices.Equal(record, want[i]) {
+			t.Errorf("record %d: got %q, want %q", i, record, want[i])
+		}
+	}
+
+	// Identical files are one unchanged row per line
+	stdout, _, _ = execute(command.Diff(a, a, command.SideBySideTSV), "")
+	if strings.Count(stdout, "\n") != 4 || strings.Count(stdout, "=\t") != 4 {
+		t.Errorf("got %q", stdout)
+	}
+
+	stdout, _, _ = execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Brief, command.SideBySideTSV), "")
+	if !strings.HasPrefix(stdout, "=\t1\tline 1\t1\tline 1\n") {
+		t.Errorf("Brief should not replace the table, got %q", stdout)
+	}
+}
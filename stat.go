@@ -0,0 +1,76 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// minStatBar is the narrowest bar TreeStat scales to, however long the names
+const minStatBar = 10
+
+// statRow is one differing file of a TreeStat summary
+type statRow struct {
+	name                  string
+	insertions, deletions int
+}
+
+// statBar scales a row's changes to at most width characters. Bars are only
+// scaled when the largest change does not fit, and a side with any changes
+// keeps at least one character.
+func statBar(insertions, deletions, largest, width int) (plus, minus int) {
+	if largest <= width {
+		return insertions, deletions
+	}
+	scale := func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		return 1 + n*(width-1)/largest
+	}
+	return scale(insertions), scale(deletions)
+}
+
+// writeTreeStat prints the TreeStat summary of a directory comparison: a row
+// per differing file in the style of diffstat, the Only-in messages, then
+// the totals
+func (p command) writeTreeStat(w io.Writer, rows []statRow, onlyIn []string) {
+	nameWidth, largest, insertions, deletions := 0, 0, 0, 0
+	for _, row := range rows {
+		nameWidth = max(nameWidth, len(p.displayPath(row.name)))
+		largest = max(largest, row.insertions+row.deletions)
+		insertions += row.insertions
+		deletions += row.deletions
+	}
+	countWidth := len(strconv.Itoa(largest))
+	// " name | count bar" within Width
+	barWidth := max(minStatBar, int(p.Flags.Width)-nameWidth-countWidth-5)
+
+	for _, row := range rows {
+		line := fmt.Sprintf(" %-*s | %*d ", nameWidth, p.displayPath(row.name), countWidth, row.insertions+row.deletions)
+		plus, minus := statBar(row.insertions, row.deletions, largest, barWidth)
+		if plus > 0 {
+			line += p.paint(sgrGreen, strings.Repeat("+", plus))
+		}
+		if minus > 0 {
+			line += p.paint(sgrRed, strings.Repeat("-", minus))
+		}
+		_, _ = fmt.Fprintln(w, strings.TrimSuffix(line, " "))
+	}
+	for _, message := range onlyIn {
+		_, _ = fmt.Fprintln(w, message)
+	}
+	_, _ = fmt.Fprintf(w, " %d %s changed, %d %s(+), %d %s(-)\n",
+		len(rows), plural(len(rows), "file", "files"),
+		insertions, plural(insertions, "insertion", "insertions"),
+		deletions, plural(deletions, "deletion", "deletions"))
+}
+
+// plural picks the singular or plural form for n
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// stdinLabel names standard input in file headers unless a Label is given
+const stdinLabel = "standard input"
+
+// stdinMemory is how much of standard input a stdinBuffer holds in memory
+// before the rest goes to a temporary file, unless MaxMemory says otherwise
+const stdinMemory = 4 << 20
+
+// stdinBuffer captures standard input once for a comparison that reads it
+// more than once. The first stdinMemory bytes are kept in memory and the
+// rest is spilled to a temporary file, which Close removes.
+type stdinBuffer struct {
+	source io.Reader
+	limit  int
+
+	once   sync.Once
+	err    error
+	memory []byte
+	spill  *os.File
+	size   int64
+}
+
+func newStdinBuffer(source io.Reader, limit int) *stdinBuffer {
+	return &stdinBuffer{source: source, limit: limit}
+}
+
+// capture reads the source to its end
+func (b *stdinBuffer) capture() error {
+	b.once.Do(func() {
+		var head bytes.Buffer
+		if _, b.err = io.CopyN(&head, b.source, int64(b.limit)+1); b.err == io.EOF {
+			b.err, b.memory = nil, head.Bytes()
+			return
+		}
+		if b.err != nil {
+			return
+		}
+
+		// Everything past the limit, including the byte that showed there is
+		// more, goes to the temporary file
+		b.memory = head.Bytes()[:b.limit]
+		if b.spill, b.err = os.CreateTemp("", "diff-stdin-*"); b.err != nil {
+			return
+		}
+		if _, b.err = b.spill.Write(head.Bytes()[b.limit:]); b.err != nil {
+			return
+		}
+		var n int64
+		n, b.err = io.Copy(b.spill, b.source)
+		b.size = n + 1
+	})
+	return b.err
+}
+
+// open returns a reader over everything captured, from the start
+func (b *stdinBuffer) open() (io.ReadCloser, error) {
+	if err := b.capture(); err != nil {
+		return nil, err
+	}
+	var r io.Reader = bytes.NewReader(b.memory)
+	if b.spill != nil {
+		r = io.MultiReader(r, io.NewSectionReader(b.spill, 0, b.size))
+	}
+	return io.NopCloser(r), nil
+}
+
+// Close removes the temporary file, if standard input needed one
+func (b *stdinBuffer) Close() error {
+	if b.spill == nil {
+		return nil
+	}
+	closeErr := b.spill.Close()
+	if err := os.Remove(b.spill.Name()); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// stdinLimit is how much of standard input a stdinBuffer holds in memory
+func (f flags) stdinLimit() int {
+	if f.MaxMemory > 0 {
+		return int(f.MaxMemory)
+	}
+	return stdinMemory
+}
+
+// readsStdin reports whether the operand at position side of a two-operand
+// comparison reads standard input: it is "-" before any EndOfOptions, and
+// not replaced by Expected or a ReaderOperand
+func (p command) readsStdin(side int, path string) bool {
+	return path == "-" && side < p.Flags.literalFrom && !p.supplied(side)
+}
+
+// supplied reports whether one side of a two-operand comparison is read
+// from Expected or a ReaderOperand rather than from the path it names
+func (p command) supplied(side int) bool {
+	return side == 1 && p.Flags.Expected != nil || p.Flags.readers[side] != nil
+}
+
+// stdinReads counts the operands of a two-operand comparison that read
+// standard input
+func (p command) stdinReads(operands []string) int {
+	reads := 0
+	for side, path := range operands[:min(len(operands), 2)] {
+		if p.readsStdin(side, path) {
+			reads++
+		}
+	}
+	return reads
+}
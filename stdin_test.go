@@ -0,0 +1,157 @@
+package command_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_StdinHeaders(t *testing.T) {
+	file := writeFile(t, t.TempDir(), "file.txt", "one\ntwo\n")
+
+	stdout, _, _ := execute(command.Diff("-", file, command.Unified), "one\n2\n")
+	want := "--- standard input\n+++ " + file + "\n@@ -1,2 +1,2 @@\n one\n-2\n+two\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	stdout, _, _ = execute(command.Diff(file, "-", command.ContextDiff), "one\n2\n")
+	if !strings.HasPrefix(stdout, "*** "+file+"\n--- standard input\n") {
+		t.Errorf("unexpected headers:\n%s", stdout)
+	}
+
+	// Reverse moves the header along with its operand
+	stdout, _, _ = execute(command.Diff("-", file, command.Unified, command.Reverse), "one\n2\n")
+	if !strings.HasPrefix(stdout, "--- "+file+"\n+++ standard input\n") {
+		t.Errorf("unexpected headers:\n%s", stdout)
+	}
+
+	// Brief messages keep the operand as given
+	stdout, _, _ = execute(command.Diff(file, "-", command.Brief), "one\n2\n")
+	if want := "Files " + file + " and - differ\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_Labels(t *testing.T) {
+	file := writeFile(t, t.TempDir(), "file.txt", "one\ntwo\n")
+
+	stdout, _, _ := execute(command.Diff("-", file, command.Unified, command.TimestampRFC3339,
+		command.Label("before"), command.Label("after")), "one\n2\n")
+	if !strings.HasPrefix(stdout, "--- before\n+++ after\n") {
+		t.Errorf("labels should replace names and timestamps:\n%s", stdout)
+	}
+
+	// A single label names the first operand only
+	stdout, _, _ = execute(command.Diff(file, "-", command.Unified, command.Label("mine")), "one\n2\n")
+	if !strings.HasPrefix(stdout, "--- mine\n+++ standard input\n") {
+		t.Errorf("unexpected headers:\n%s", stdout)
+	}
+
+	_, stderr, err := execute(command.Diff("a", "b", command.Label("1"), command.Label("2"), command.Label("3")), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "at most two Label options") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
+
+func TestDiff_StdinOnBothSides(t *testing.T) {
+	for _, format := range []any{command.Unified, command.Brief} {
+		stdout, _, err := execute(command.Diff("-", "-", format), "one\ntwo\n")
+		assertion.NoError(t, err)
+		if stdout != "" {
+			t.Errorf("%v: stdin should equal itself, got %q", format, stdout)
+		}
+	}
+
+	// Input larger than the in-memory part is spilled and removed again
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	large := strings.Repeat("a line of generated input\n", 400000)
+	stdout, _, err := execute(command.Diff("-", "-", command.Unified), large)
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("large stdin should equal itself, got %.200q", stdout)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+// spillWatcher is standard input that lists dir once it has been read to
+// the end, or fails after its content when fail is set
+type spillWatcher struct {
+	io.Reader
+	dir     string
+	fail    bool
+	spilled []os.DirEntry
+}
+
+func (w *spillWatcher) Read(b []byte) (int, error) {
+	n, err := w.Reader.Read(b)
+	if err == io.EOF {
+		w.spilled, _ = os.ReadDir(w.dir)
+		if w.fail {
+			err = errors.New("broken pipe")
+		}
+	}
+	return n, err
+}
+
+func TestDiff_StdinMaxMemory(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	large := strings.Repeat("a line of generated input\n", 20000)
+
+	// Past a tiny budget the rest of stdin goes to a temporary file, which
+	// is gone once the comparison ends
+	for _, format := range []any{command.Unified, command.Brief, command.SideBySide} {
+		var stdout, stderr bytes.Buffer
+		in := &spillWatcher{Reader: strings.NewReader(large), dir: tmp}
+		err := command.Diff("-", "-", format, command.MaxMemory(1024)).Executor()(context.Background(), in, &stdout, &stderr)
+		assertion.NoError(t, err)
+		if stdout.Len() != 0 || stderr.Len() != 0 {
+			t.Errorf("%v: stdin should equal itself, got %.200q and %q", format, stdout.String(), stderr.String())
+		}
+		if len(in.spilled) != 1 {
+			t.Errorf("%v: want one temporary file while stdin is read, got %v", format, in.spilled)
+		}
+		if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+			t.Errorf("%v: temporary files left behind: %v", format, entries)
+		}
+	}
+
+	// Stdin failing part way through leaves nothing behind either
+	var stdout, stderr bytes.Buffer
+	in := &spillWatcher{Reader: strings.NewReader(large), dir: tmp, fail: true}
+	err := command.Diff("-", "-", command.MaxMemory(1024)).Executor()(context.Background(), in, &stdout, &stderr)
+	assertion.Error(t, err)
+	if !strings.Contains(stderr.String(), "broken pipe") {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temporary files left behind after an error: %v", entries)
+	}
+
+	_, _, err = execute(command.Diff("-", "-", command.MaxMemory(-1)), "")
+	assertion.Error(t, err)
+}
+
+func TestDiff_LiteralDashHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "-", "two\n")
+	writeFile(t, dir, "one", "one\n")
+	t.Chdir(dir)
+
+	stdout, _, _ := execute(command.Diff("one", command.EndOfOptions, "-", command.Unified), "stdin must not be read\n")
+	if !strings.HasPrefix(stdout, "--- one\n+++ -\n") {
+		t.Errorf("a file named - keeps its name:\n%s", stdout)
+	}
+}
@@ -0,0 +1,294 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+)
+
+// streamsPrefix reports whether a two-file comparison can read past the
+// equal prefix of its inputs without keeping it: plain normal or unified
+// output, whose hunks never look further back than their context.
+// Everything else reads both files whole.
+func (f flags) streamsPrefix() bool {
+	switch {
+	case f.primaryFormat() != FormatNormal && f.primaryFormat() != FormatUnified || bool(f.Brief):
+		return false
+	case f.template != nil || len(f.Tees) > 0:
+		return false
+	case bool(f.FullContext) || f.UnifiedContext < 0:
+		return false
+	case bool(f.ShowIndex) || bool(f.CanonicalJSON) || f.Focus != "" || bool(f.PreserveEOL):
+		// These need every byte or every line of an input
+		return false
+	case f.AssumeUnchanged != "":
+		// The patch is checked rather than printed
+		return false
+	case f.RetryOnChange > 0:
+		// A changed input is read again from the start
+		return false
+	case f.SectionLabel != nil || bool(f.DetectMoves) || f.MaxCompareBytes > 0 || f.StatsHook != nil:
+		// These look at lines outside the hunks
+		return false
+	case f.Logger != nil:
+		// The log describes the inputs and comparison as a whole
+		return false
+	default:
+		return true
+	}
+}
+
+// prefixRing keeps the last lines of both sides of an equal prefix, which
+// compare keeps as its horizon and become the leading context of the first
+// hunk. Its slots are reused, so
+// streaming the prefix allocates nothing per line.
+type prefixRing struct {
+	lines [2][][]byte
+	next  int
+}
+
+// push copies in a pair of equal lines, replacing the oldest pair once size
+// pairs are kept
+func (r *prefixRing) push(size int, line1, line2 []byte) {
+	if size == 0 {
+		return
+	}
+	if len(r.lines[0]) < size {
+		r.lines[0] = append(r.lines[0], bytes.Clone(line1))
+		r.lines[1] = append(r.lines[1], bytes.Clone(line2))
+		return
+	}
+	r.lines[0][r.next] = append(r.lines[0][r.next][:0], line1...)
+	r.lines[1][r.next] = append(r.lines[1][r.next][:0], line2...)
+	r.next = (r.next + 1) % size
+}
+
+// side returns the kept lines of one side as read by s, oldest first
+func (r *prefixRing) side(i int, s *streamSide) []string {
+	kept := r.lines[i]
+	lines := make([]string, len(kept))
+	for j := range kept {
+		lines[j] = s.text(kept[(r.next+j)%len(kept)])
+	}
+	return lines
+}
+
+// streamSide reads the lines of one input for diffStreamed
+type streamSide struct {
+	name    string
+	scanner *bufio.Scanner
+	strip   bool
+
+	// open is whether the last line read has no terminator
+	open bool
+}
+
+// text is the line as compared and printed
+func (s *streamSide) text(line []byte) string {
+	if s.strip {
+		return stripANSI(string(line))
+	}
+	return string(line)
+}
+
+// rest reads the remaining lines after first, the current line when ok
+func (s *streamSide) rest(ok bool) []string {
+	var lines []string
+	for ok {
+		lines = append(lines, s.text(s.scanner.Bytes()))
+		ok = s.scanner.Scan()
+	}
+	return lines
+}
+
+// equalBlockBytes is how much of both inputs skipEqualBytes compares at a
+// time
+const equalBlockBytes = 64 << 10
+
+// skipEqualBytes discards the bytes both readers start with, a block at a
+// time, up to the line boundary that leaves the last keep lines of them
+// unread, and returns the number of lines discarded. It stops early at a
+// line longer than what is left of a block, which is then read as lines.
+// An error other than io.EOF is returned for its side.
+func skipEqualBytes(readers [2]*bufio.Reader, keep int) (int, [2]error) {
+	skipped := 0
+	for {
+		var blocks [2][]byte
+		var errs [2]error
+		for side, r := range readers {
+			blocks[side], errs[side] = r.Peek(r.Size())
+			if errs[side] == io.EOF {
+				errs[side] = nil
+			} else if errs[side] != nil {
+				return skipped, errs
+			}
+		}
+		n := commonPrefix(blocks[0], blocks[1])
+		equal := blocks[0][:n]
+		// The kept lines are whole: the cut follows the newline keep
+		// newlines before the last one
+		cut := len(equal)
+		for range keep + 1 {
+			if cut = bytes.LastIndexByte(equal[:cut], '\n'); cut < 0 {
+				return skipped, errs
+			}
+		}
+		cut++
+		skipped += bytes.Count(equal[:cut], []byte{'\n'})
+		for _, r := range readers {
+			_, _ = r.Discard(cut)
+		}
+		if n < len(blocks[0]) || n < len(blocks[1]) || len(blocks[0]) < readers[0].Size() {
+			// The inputs differ or end within the block
+			return skipped, errs
+		}
+	}
+}
+
+// commonPrefix is the length of the bytes a and b start with, compared a
+// block at a time up to the first difference
+func commonPrefix(a, b []byte) int {
+	n := min(len(a), len(b))
+	if bytes.Equal(a[:n], b[:n]) {
+		return n
+	}
+	i := 0
+	for ; i+64 <= n && bytes.Equal(a[i:i+64], b[i:i+64]); i += 64 {
+	}
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// diffStreamed prints the normal or unified diff of two inputs read in
+// lockstep while they are equal, keeping only the horizon lines compare
+// would keep of the prefix anyway and the leading context of a change that
+// starts in them, as one of the algorithms that do not slide changes down can have it. From the
+// first difference on both inputs are read whole and compared, numbered from
+// where the kept lines start. Inputs that end the same but for the
+// terminator of their last line differ in that line.
+func (p command) diffStreamed(stdin io.Reader, stdout, stderr io.Writer, file1Path, file2Path string) error {
+	// Streaming never reads an input twice, so a change while it is read
+	// is only warned about
+	var changed [2]func() bool
+	for side, path := range [2]string{file1Path, file2Path} {
+		changed[side] = func() bool { return false }
+		if p.watchesInput(side, path) {
+			changed[side] = p.watch(path)
+		}
+	}
+
+	file1, err := p.openInput(stdin, 0, file1Path)
+	if err != nil {
+		return fileTrouble(stderr, file1Path, err)
+	}
+	defer file1.Close()
+
+	file2, err := p.openInput(stdin, 1, file2Path)
+	if err != nil {
+		return fileTrouble(stderr, file2Path, err)
+	}
+	defer file2.Close()
+
+	// The start of each input is classified before its lines are read
+	binary := false
+	sample := p.Flags.binaryCheck().SampleBytes
+	size := max(sample, equalBlockBytes)
+	readers := [2]*bufio.Reader{bufio.NewReaderSize(file1, size), bufio.NewReaderSize(file2, size)}
+	for side, path := range [2]string{file1Path, file2Path} {
+		start, err := readers[side].Peek(sample)
+		if err != nil && err != io.EOF {
+			return fileTrouble(stderr, path, err)
+		}
+		binary = p.detectBinary(path, start) || binary
+	}
+
+	// Lines are compared as read unless a normalization or StripANSI needs
+	// their text, and inputs compared as read are the same up to a line
+	// boundary wherever their bytes are, so most of an equal prefix is
+	// skipped without being split into lines at all. The last pair is always
+	// kept, in case only one of them turns out to be terminated.
+	raw := !p.Flags.normalizes() && !bool(p.Flags.StripANSI)
+	before, _ := p.Flags.unifiedContext()
+	horizon := max(p.Flags.horizonLines()+before, 1)
+	skipped := 0
+	if raw {
+		var errs [2]error
+		skipped, errs = skipEqualBytes(readers, horizon)
+		for side, path := range [2]string{file1Path, file2Path} {
+			if errs[side] != nil {
+				return fileTrouble(stderr, path, errs[side])
+			}
+		}
+	}
+
+	var sides [2]*streamSide
+	for i, path := range [2]string{file1Path, file2Path} {
+		sides[i] = &streamSide{name: path, strip: bool(p.Flags.StripANSI)}
+		sides[i].scanner = p.endScanner(readers[i], &sides[i].open)
+		// Lines of any length, as when a file is read whole
+		sides[i].scanner.Buffer(nil, math.MaxInt32)
+	}
+	if bool(p.Flags.Reverse) {
+		sides[0], sides[1] = sides[1], sides[0]
+	}
+
+	equal := func(line1, line2 []byte) bool {
+		if raw {
+			return bytes.Equal(line1, line2)
+		}
+		return p.linesEqual(sides[0].text(line1), sides[1].text(line2))
+	}
+
+	var ring prefixRing
+	ok1, ok2 := sides[0].scanner.Scan(), sides[1].scanner.Scan()
+	for ok1 && ok2 && equal(sides[0].scanner.Bytes(), sides[1].scanner.Bytes()) {
+		ring.push(horizon, sides[0].scanner.Bytes(), sides[1].scanner.Bytes())
+		skipped++
+		ok1, ok2 = sides[0].scanner.Scan(), sides[1].scanner.Scan()
+	}
+
+	lines1 := append(ring.side(0, sides[0]), sides[0].rest(ok1)...)
+	lines2 := append(ring.side(1, sides[1]), sides[1].rest(ok2)...)
+	for _, side := range sides {
+		if err := side.scanner.Err(); err != nil {
+			return fileTrouble(stderr, side.name, err)
+		}
+	}
+	var trouble error
+	for side, path := range [2]string{file1Path, file2Path} {
+		if changed[side]() {
+			trouble = p.changedTrouble(stderr, path, ErrChangedWhileRead)
+		}
+	}
+
+	p.Flags.open = [2]bool{sides[0].open, sides[1].open}
+	if !ok1 && !ok2 && !p.Flags.endsDiffer(p.Flags.open) {
+		// Both inputs ended inside the equal prefix
+		return trouble
+	}
+	if binary {
+		differ := !p.identical(lines1, lines2)
+		if differ {
+			p.writeBinaryDiffer(stdout, sides[0].name, sides[1].name)
+		}
+		if trouble != nil {
+			return trouble
+		}
+		return verdict(differ)
+	}
+	off := skipped - len(ring.lines[0])
+	script, settled := p.compareSettled(lines1, lines2)
+	p.noteNotMinimal(sides[0].name, sides[1].name, settled)
+	if p.primaryFormat() == FormatUnified {
+		p.outputUnifiedDiffAt(stdout, sides[0].name, sides[1].name, lines1, lines2, script, off, off)
+	} else {
+		p.outputNormalDiffAt(stdout, lines1, lines2, script, off, off)
+	}
+	if trouble != nil {
+		return trouble
+	}
+	return verdict(!p.identical(lines1, lines2))
+}
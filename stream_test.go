@@ -0,0 +1,219 @@
+package command_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+// bufferedUnified renders the unified diff of two files read whole, for
+// comparison with the streamed output of Diff
+func bufferedUnified(t *testing.T, a, b string, opts ...any) string {
+	t.Helper()
+	result, err := command.Run(context.Background(), command.FileSource(a), command.FileSource(b), append(opts, command.Unified)...)
+	assertion.NoError(t, err)
+	return result.Text(command.FormatUnified)
+}
+
+func TestDiff_StreamedPrefixMatchesBuffered(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(40, nil))
+	contexts := map[string][]any{
+		"default":    nil,
+		"one":        {command.UnifiedContext(1)},
+		"wide":       {command.UnifiedContext(8)},
+		"asymmetric": {command.UnifiedContextBeforeAfter(5, 1)},
+	}
+
+	// Changes at every distance from the start, so the first hunk's context
+	// reaches back into the streamed prefix by every amount, or not at all
+	for line := 1; line <= 40; line++ {
+		for name, opts := range contexts {
+			changes := map[int]string{line: "changed"}
+			if line+4 <= 40 {
+				changes[line+4] = "changed too"
+			}
+			b := writeFile(t, dir, fmt.Sprintf("b%d", line), numberedLines(40, changes))
+			got, _, _ := execute(command.Diff(append([]any{a, b, command.Unified}, opts...)...), "")
+			if want := bufferedUnified(t, a, b, opts...); got != want {
+				t.Fatalf("line %d, %s context: got:\n%s\nwant:\n%s", line, name, got, want)
+			}
+		}
+	}
+}
+
+func TestDiff_StreamedPrefixNormal(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(40, nil))
+	contents := map[string]string{
+		"appended":     numberedLines(40, nil) + "line 41\n",
+		"truncated":    numberedLines(37, nil),
+		"unterminated": strings.TrimSuffix(numberedLines(40, nil), "\n"),
+	}
+	for line := 1; line <= 40; line++ {
+		contents[fmt.Sprintf("line %d", line)] = numberedLines(40, map[int]string{line: "changed"})
+	}
+
+	// Normal output keeps no context, but its line numbers count the
+	// streamed prefix
+	for name, content := range contents {
+		b := writeFile(t, dir, "b", content)
+		for _, pair := range [][2]string{{a, b}, {b, a}} {
+			got, _, _ := execute(command.Diff(pair[0], pair[1]), "")
+			result, err := command.Run(context.Background(), command.FileSource(pair[0]), command.FileSource(pair[1]))
+			assertion.NoError(t, err)
+			if want := result.Text(command.FormatNormal); got != want {
+				t.Errorf("%s: got:\n%s\nwant:\n%s", name, got, want)
+			}
+		}
+	}
+}
+
+func TestDiff_StreamedPrefixAcrossBlocks(t *testing.T) {
+	dir := t.TempDir()
+	const n = 20000
+	a := writeFile(t, dir, "a", numberedLines(n, nil))
+
+	// The equal prefix is skipped a block of bytes at a time; changes on
+	// either side of where the first blocks end keep their line numbers
+	// and context
+	contents := map[string]string{
+		"appended": numberedLines(n, nil) + "line 20001\n",
+		"end":      numberedLines(n, map[int]string{n: "changed"}),
+	}
+	for line := 5940; line <= 5980; line++ {
+		contents[fmt.Sprintf("line %d", line)] = numberedLines(n, map[int]string{line: "changed"})
+	}
+	for name, content := range contents {
+		b := writeFile(t, dir, "b", content)
+		got, _, _ := execute(command.Diff(a, b, command.Unified), "")
+		if want := bufferedUnified(t, a, b); got != want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+}
+
+func TestDiff_StreamedPrefixLengthChanges(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(20, nil))
+	cases := map[string]string{
+		"appended":  numberedLines(20, nil) + "line 21\nline 22\n",
+		"truncated": numberedLines(17, nil),
+		"emptied":   "",
+		"identical": numberedLines(20, nil),
+	}
+	for name, content := range cases {
+		b := writeFile(t, dir, name, content)
+		for _, pair := range [][2]string{{a, b}, {b, a}} {
+			got, _, _ := execute(command.Diff(pair[0], pair[1], command.Unified), "")
+			if want := bufferedUnified(t, pair[0], pair[1]); got != want {
+				t.Errorf("%s: got:\n%s\nwant:\n%s", name, got, want)
+			}
+		}
+	}
+}
+
+func TestDiff_StreamedPrefixNormalizedLines(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(10, map[int]string{9: "old"}))
+	b := writeFile(t, dir, "b", strings.ToUpper(numberedLines(10, map[int]string{9: "new"})))
+
+	// The kept context is each side's own text, not the other's
+	got, _, _ := execute(command.Diff(a, b, command.Unified, command.IgnoreCase, command.ShowLineNumbers), "")
+	if want := bufferedUnified(t, a, b, command.IgnoreCase, command.ShowLineNumbers); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(got, " line 8\n") {
+		t.Errorf("context should come from the first file:\n%s", got)
+	}
+
+	got, _, _ = execute(command.Diff(a, b, command.Unified, command.IgnoreCase, command.Reverse), "")
+	if !strings.HasPrefix(got, "--- "+b+"\n+++ "+a+"\n") || !strings.Contains(got, " LINE 8\n") {
+		t.Errorf("Reverse should swap the streamed sides:\n%s", got)
+	}
+}
+
+func TestDiff_StreamedPrefixHistogram(t *testing.T) {
+	dir := t.TempDir()
+	lines := "r\na\n\n\na\n\nb\na \nR\nc\nc\ny\ny\na\nr2\n\n\n\n%s\n\na\n"
+	a := writeFile(t, dir, "a", fmt.Sprintf(lines, "a"))
+	b := writeFile(t, dir, "b", fmt.Sprintf(lines, "x"))
+
+	// Histogram starts the change a line before the first that differs,
+	// inside the horizon, and its leading context lies before that
+	for _, opts := range [][]any{{command.Histogram}, {command.Histogram, command.UnifiedContextBeforeAfter(2, 0)}} {
+		got, _, _ := execute(command.Diff(append([]any{a, b, command.Unified}, opts...)...), "")
+		if want := bufferedUnified(t, a, b, opts...); got != want {
+			t.Errorf("%v: got:\n%s\nwant:\n%s", opts, got, want)
+		}
+	}
+}
+
+func TestDiff_StreamedPrefixLongLines(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("x", 1<<20)
+	a := writeFile(t, dir, "a", long+"\nold\n")
+	b := writeFile(t, dir, "b", long+"\nnew\n")
+
+	got, _, err := execute(command.Diff(a, b, command.Unified), "")
+	assertDiffers(t, err)
+	if want := bufferedUnified(t, a, b); got != want {
+		t.Errorf("long lines should stream like any other:\n%.200s", got)
+	}
+}
+
+func TestDiff_StreamedPrefixMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "one\n")
+	missing := filepath.Join(dir, "missing")
+
+	_, stderr, err := execute(command.Diff(a, missing, command.Unified), "")
+	assertion.Error(t, err)
+	if !strings.HasPrefix(stderr, "diff: "+missing+": ") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
+
+// BenchmarkDiff_ChangeNearEnd diffs two large files that differ in their
+// last lines. Streamed reading keeps only the context of the equal prefix;
+// a TeeFormat sink forces both files to be read whole for comparison.
+func BenchmarkDiff_ChangeNearEnd(b *testing.B) {
+	dir := b.TempDir()
+	const lines = 200000
+	var old, changed strings.Builder
+	for i := 1; i <= lines; i++ {
+		fmt.Fprintf(&old, "line %d of a large log\n", i)
+		if i == lines-2 {
+			changed.WriteString("changed\n")
+			continue
+		}
+		fmt.Fprintf(&changed, "line %d of a large log\n", i)
+	}
+	path1 := filepath.Join(dir, "a")
+	path2 := filepath.Join(dir, "b")
+	if err := os.WriteFile(path1, []byte(old.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(path2, []byte(changed.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for name, opts := range map[string][]any{
+		"streamed": nil,
+		"buffered": {command.TeeFormat(command.FormatCount, io.Discard)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				execute(command.Diff(append([]any{path1, path2, command.Unified}, opts...)...), "")
+			}
+		})
+	}
+}
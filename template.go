@@ -0,0 +1,163 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// OpKind names what an Op does to a line
+type OpKind string
+
+const (
+	OpEqual  OpKind = "equal"
+	OpDelete OpKind = "delete"
+	OpInsert OpKind = "insert"
+)
+
+// Op is one line of a hunk. OldLine and NewLine are 1-based line numbers in
+// the first and second file, and zero on the side that lacks the line.
+// MoveID is shared by the deletion and insertion of a block DetectMoves
+// found moved, and zero otherwise.
+type Op struct {
+	Kind    OpKind
+	OldLine int
+	NewLine int
+	Text    string
+	MoveID  int
+}
+
+// Hunk is a group of changes with their surrounding context. The ranges
+// follow unified diff conventions: an empty range starts at the line before it.
+// Detail is set under JSONDetail.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Ops                []Op
+	Detail             *HunkDetail `json:",omitempty"`
+}
+
+// Stats summarizes a comparison. Resources is only set by Run under
+// ResourceStats.
+type Stats struct {
+	Insertions int
+	Deletions  int
+	Hunks      int
+	Resources  *ResourceUsage `json:",omitempty"`
+}
+
+// TemplateData is the value a Template is executed with
+type TemplateData struct {
+	FileA     string
+	FileB     string
+	Identical bool
+	Hunks     []Hunk
+	Stats     Stats
+}
+
+// templateFuncs are the helpers available to a Template
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"texts": func(ops []Op) []string {
+		texts := make([]string, len(ops))
+		for i, op := range ops {
+			texts[i] = op.Text
+		}
+		return texts
+	},
+	"count": func(ops []Op, kind OpKind) int {
+		n := 0
+		for _, op := range ops {
+			if op.Kind == kind {
+				n++
+			}
+		}
+		return n
+	},
+	"truncate": func(s string, n int) string {
+		if n < 0 {
+			return s
+		}
+		// n grapheme clusters, never a part of one
+		breaks := graphemeBreaks(s)
+		for i := range s {
+			if breaks[i] {
+				if n == 0 {
+					return s[:i] + "…"
+				}
+				n--
+			}
+		}
+		return s
+	},
+}
+
+// parseTemplate parses the text of a Template option
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("diff").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// exportHunks converts internal hunks into their exported form
+func exportHunks(hunks []hunk, lines1, lines2 []string) []Hunk {
+	exported := make([]Hunk, len(hunks))
+	for i, h := range hunks {
+		out := Hunk{OldLines: h.countA, NewLines: h.countB}
+		out.OldStart, out.NewStart = h.startA+1, h.startB+1
+		if h.countA == 0 {
+			out.OldStart = h.startA
+		}
+		if h.countB == 0 {
+			out.NewStart = h.startB
+		}
+		for _, e := range h.edits {
+			switch e.kind {
+			case opEqual:
+				out.Ops = append(out.Ops, Op{Kind: OpEqual, OldLine: e.a + 1, NewLine: e.b + 1, Text: lines1[e.a]})
+			case opDelete:
+				out.Ops = append(out.Ops, Op{Kind: OpDelete, OldLine: e.a + 1, Text: lines1[e.a], MoveID: e.move})
+			case opInsert:
+				out.Ops = append(out.Ops, Op{Kind: OpInsert, NewLine: e.b + 1, Text: lines2[e.b], MoveID: e.move})
+			}
+		}
+		exported[i] = out
+	}
+	return exported
+}
+
+// scriptStats counts the insertions and deletions of an edit script
+func scriptStats(script []edit, hunks int) Stats {
+	stats := Stats{Hunks: hunks}
+	for _, e := range script {
+		switch e.kind {
+		case opDelete:
+			stats.Deletions++
+		case opInsert:
+			stats.Insertions++
+		}
+	}
+	return stats
+}
+
+// outputTemplate renders the comparison through the Template option
+func (p command) outputTemplate(w io.Writer, file1Path, file2Path string, lines1, lines2 []string) error {
+	script := p.compare(lines1, lines2)
+	before, after := p.Flags.unifiedContext()
+	hunks := p.hunks(script, lines1, lines2, before, after)
+
+	data := TemplateData{
+		FileA:     p.displayPath(file1Path),
+		FileB:     p.displayPath(file2Path),
+		Identical: !hasChanges(script),
+		Hunks:     exportHunks(hunks, lines1, lines2),
+		Stats:     scriptStats(script, len(hunks)),
+	}
+	if err := p.Flags.template.Execute(w, data); err != nil {
+		return fmt.Errorf("template: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_TemplateCSV(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", numberedLines(30, nil))
+	b := writeFile(t, dir, "b", numberedLines(30, map[int]string{3: "changed 3", 20: "changed 20"}))
+
+	tmpl := command.Template(`{{range .Hunks}}{{$.FileB}},{{.OldStart}},{{.OldLines}},{{.NewStart}},{{.NewLines}},{{count .Ops "delete"}},{{count .Ops "insert"}}
+{{end}}total,{{.Stats.Hunks}},{{.Stats.Insertions}},{{.Stats.Deletions}}
+`)
+	stdout, _, err := execute(command.Diff(a, b, tmpl), "")
+	assertDiffers(t, err)
+	want := b + ",1,6,1,6,1,1\n" + b + ",17,7,17,7,1,1\ntotal,2,2,2\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestDiff_TemplateIdenticalAndHelpers(t *testing.T) {
+	tmpl := command.Template(`{{if .Identical}}same{{else}}differ{{end}} {{truncate .FileA 4}}{{range .Hunks}} {{join (texts .Ops) "|"}}{{end}}`)
+	stdout, _, err := execute(command.Diff("testdata/a.txt", "testdata/a.txt", tmpl), "")
+	assertion.NoError(t, err)
+	if stdout != "same test…" {
+		t.Errorf("got %q", stdout)
+	}
+
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "x\ny\n")
+	b := writeFile(t, dir, "b", "x\nz\n")
+	stdout, _, err = execute(command.Diff(a, b, tmpl), "")
+	assertDiffers(t, err)
+	if want := "differ " + string([]rune(a)[:4]) + "… x|y|z"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_TemplateTruncatesGraphemeClusters(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", "e\u0301te\n\U0001F1E9\U0001F1EA\U0001F1EB\U0001F1F7\n\U0001F469\u200D\U0001F467!\n")
+	b := writeFile(t, dir, "b", "x\n")
+	stdout, _, err := execute(command.Diff(a, b, command.Template(`{{range .Hunks}}{{range .Ops}}{{truncate .Text 1}}|{{end}}{{end}}`)), "")
+	assertDiffers(t, err)
+	if want := "e\u0301…|\U0001F1E9\U0001F1EA…|\U0001F469\u200D\U0001F467…|x|"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestDiff_TemplateErrors(t *testing.T) {
+	_, stderr, err := execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Template("{{.Broken")), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "invalid template") {
+		t.Errorf("parse error not reported: %q", stderr)
+	}
+
+	_, _, err = execute(command.Diff("testdata/a.txt", "testdata/b.txt", command.Template("{{.NoSuchField}}")), "")
+	assertion.Error(t, err)
+}
@@ -0,0 +1,33 @@
+#!/bin/sh
+# Regenerates changes.patch, a patch git diff prints for a commit that
+# edits, adds, deletes, renames and changes the mode of files, among them a
+# binary file and files without a final newline, with one line of context
+# so that hunk headers name the function they are in.
+set -e
+cd "$(dirname "$0")"
+out=$PWD/changes.patch
+repo=$(mktemp -d)
+trap 'rm -rf "$repo"' EXIT
+cd "$repo"
+git init -q
+git config user.name test
+git config user.email test@example.com
+
+printf 'package main\n\nfunc main() {\n\tprintln("hello")\n}\n\nfunc helper() int {\n\treturn 1\n}\n' >main.go
+printf 'one\ntwo' >tail.txt
+printf 'doomed\n' >gone.txt
+printf 'a\nb\nc\nd\ne\nf\ng\n' >old-name.txt
+printf '#!/bin/sh\necho hi\n' >run.sh
+printf '\000\001\002' >data.bin
+git add . && git commit -q -m base
+
+printf 'package main\n\nfunc main() {\n\tprintln("hello, world")\n}\n\nfunc helper() int {\n\treturn 2\n}\n' >main.go
+printf 'one\ntwo\nthree\n' >tail.txt
+rm gone.txt
+printf 'fresh\nfile' >new.txt
+git mv old-name.txt new-name.txt
+printf 'a\nb\nc\nD\ne\nf\ng\n' >new-name.txt
+chmod +x run.sh
+printf '\000\001\003' >data.bin
+git add -A
+git diff --cached -M -U1 >"$out"
@@ -0,0 +1,28 @@
+#!/bin/sh
+# Regenerates the expected output of every case with GNU diff. Each case
+# directory holds the operands a and b; NAME.out is the output of the flag
+# set NAME, run from inside the case directory. The flag sets must match
+# gnuFlagSets in gnu_test.go. Headers are given labels so they carry no
+# timestamps.
+set -e
+cd "$(dirname "$0")"
+labels="--label a --label b"
+for dir in */; do
+	dir=${dir%/}
+	if [ -d "$dir/a" ]; then
+		sets="normal: brief:-q recursive:-r recursive-brief:-r_-q"
+	else
+		sets="normal: unified:-u unified-0:-U0 unified-1:-U1 unified-5:-U5 context:-c context-0:-C0 context-1:-C1 brief:-q ignore-case:-i ignore-whitespace:-w ignore-case-unified:-i_-u ignore-blank-lines:-B ignore-blank-lines-unified:-B_-u ed:-e forward-ed:-f"
+	fi
+	for set in $sets; do
+		name=${set%%:*}
+		args=$(echo "${set#*:}" | tr _ ' ')
+		case $args in
+		*-[uUcC]*) args="$args $labels" ;;
+		esac
+		# Status 1 means the files differ. GNU diff -e and -f warn of a missing
+		# newline at the end of a file, which an ed script cannot remove,
+		# with status 2.
+		(cd "$dir" && diff $args a b >"$name.out" 2>/dev/null) || [ $? -eq 1 ] || [ "$name" = ed ] || [ "$name" = forward-ed ]
+	done
+done
@@ -0,0 +1,162 @@
+package command
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// tree is one side of a directory comparison: the fs.FS its entries are
+// listed, examined and read through, and the path printed for its root,
+// below which the walk names every entry. A tree on disk has the os.DirFS of
+// its root, and is also where the metadata an fs.FS lacks comes from: file
+// identities, devices and symlinks, and the retries of RetryOnChange.
+type tree struct {
+	fsys fs.FS
+	root string
+	disk bool
+}
+
+// diskTree is the tree of the directory dir on disk
+func diskTree(dir string) tree {
+	return tree{fsys: os.DirFS(dir), root: dir, disk: true}
+}
+
+// name is the name in t.fsys of the entry the walk calls path
+func (t tree) name(path string) string {
+	rel, err := filepath.Rel(t.root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// pathError names path in an error of t.fsys, which names its entries
+// relative to the root, so messages show the path the walk prints
+func (t tree) pathError(err error, path string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &fs.PathError{Op: pathErr.Op, Path: path, Err: pathErr.Err}
+	}
+	return err
+}
+
+// stat returns the file information of path, following symlinks
+func (t tree) stat(path string) (fs.FileInfo, error) {
+	info, err := fs.Stat(t.fsys, t.name(path))
+	return info, t.pathError(err, path)
+}
+
+// kind reports what kind of entry lives at path, following symlinks
+func (t tree) kind(path string) (entryKind, error) {
+	info, err := t.stat(path)
+	if err != nil {
+		return kindMissing, err
+	}
+	switch {
+	case info.Mode().IsRegular():
+		return kindRegular, nil
+	case info.IsDir():
+		return kindDirectory, nil
+	default:
+		return kindOther, nil
+	}
+}
+
+// readDirNames lists the entry names of the directory at path in sorted
+// order
+func (t tree) readDirNames(path string) ([]string, error) {
+	entries, err := fs.ReadDir(t.fsys, t.name(path))
+	if err != nil {
+		return nil, t.pathError(err, path)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+// signature reports the size and modification time of the file at path
+func (t tree) signature(path string) (fileSignature, error) {
+	info, err := t.stat(path)
+	if err != nil {
+		return fileSignature{}, err
+	}
+	return fileSignature{Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+// readLines reads all lines of the file at path using buf's storage. A
+// file on disk is read as any other file operand is; the files of other
+// trees are read once.
+func (t tree) readLines(p command, buf *lineBuffer, path string) ([]string, error) {
+	if t.disk {
+		return p.readFileLinesInto(buf, path)
+	}
+	if p.Flags.OpenHook != nil {
+		p.Flags.OpenHook(path)
+	}
+	file, err := t.fsys.Open(t.name(path))
+	if err != nil {
+		return nil, t.pathError(err, path)
+	}
+	defer file.Close()
+	lines, err := p.readNamed(buf, path, file)
+	return lines, t.pathError(err, path)
+}
+
+// describe examines the entry at path for OnlyInDetails. A tree on disk
+// tells symlinks apart; elsewhere an entry is described as what it leads
+// to.
+func (t tree) describe(path string) (EntryInfo, error) {
+	if t.disk {
+		return describeEntry(path)
+	}
+	info, err := t.stat(path)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	switch {
+	case info.Mode().IsRegular():
+		return EntryInfo{Type: "file", Size: info.Size()}, nil
+	case info.IsDir():
+		dir, err := t.fsys.Open(t.name(path))
+		if err != nil {
+			return EntryInfo{}, t.pathError(err, path)
+		}
+		defer dir.Close()
+		lister, ok := dir.(fs.ReadDirFile)
+		if !ok {
+			return EntryInfo{}, &fs.PathError{Op: "readdir", Path: path, Err: errors.ErrUnsupported}
+		}
+		// An empty directory reads as io.EOF
+		entries, err := lister.ReadDir(maxOnlyInEntries + 1)
+		if err != nil && err != io.EOF {
+			return EntryInfo{}, t.pathError(err, path)
+		}
+		return EntryInfo{Type: "directory", Entries: min(len(entries), maxOnlyInEntries), More: len(entries) > maxOnlyInEntries}, nil
+	default:
+		return EntryInfo{Type: "other"}, nil
+	}
+}
+
+// walk calls visit for path and every entry below it, in lexical order, as
+// filepath.WalkDir does, with the entries named as the walk prints them. On
+// disk a symlink is visited without being followed; elsewhere it is what it
+// leads to.
+func (t tree) walk(path string, visit func(path string, entry fs.DirEntry, err error) error) error {
+	if t.disk {
+		return filepath.WalkDir(path, visit)
+	}
+	root := t.name(path)
+	return fs.WalkDir(t.fsys, root, func(name string, entry fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(filepath.FromSlash(root), filepath.FromSlash(name))
+		if relErr != nil {
+			rel = ""
+		}
+		file := filepath.Join(path, rel)
+		return visit(file, entry, t.pathError(err, file))
+	})
+}
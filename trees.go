@@ -0,0 +1,173 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+)
+
+// PairKind classifies an entry of a CompareTrees walk
+type PairKind int
+
+const (
+	// PairIdentical is a pair of files that compare equal
+	PairIdentical PairKind = iota
+	// PairDiffer is a pair of files that differ, or entries of different
+	// kinds such as a file and a directory
+	PairDiffer
+	// PairOnlyLeft is an entry found in the first tree only
+	PairOnlyLeft
+	// PairOnlyRight is an entry found in the second tree only
+	PairOnlyRight
+	// PairError is an entry that could not be compared
+	PairError
+)
+
+func (k PairKind) String() string {
+	switch k {
+	case PairIdentical:
+		return "identical"
+	case PairDiffer:
+		return "differ"
+	case PairOnlyLeft:
+		return "only-left"
+	case PairOnlyRight:
+		return "only-right"
+	default:
+		return "error"
+	}
+}
+
+// PairResult is the outcome of one entry of a CompareTrees walk
+type PairResult struct {
+	// Path names the entry relative to the tree it was found in, with
+	// forward slashes
+	Path string
+	Kind PairKind
+	// Text is what the command prints for the entry, such as the banner
+	// and hunks of a differing pair, when WithDiffText is set
+	Text string
+	// Entry describes an entry found in one tree only when OnlyInDetails
+	// is set
+	Entry *EntryInfo
+}
+
+// errStopped aborts a CompareTrees walk whose consumer stopped ranging
+var errStopped = errors.New("comparison stopped")
+
+// CompareTrees compares two directories recursively and yields the outcome
+// of each entry as it is compared, in the order the command prints them. A
+// problem with one entry is yielded with it and the walk goes on; an
+// invalid configuration, an operand that is not a directory or a
+// cancelled ctx is yielded with an empty PairResult and ends the walk, as
+// does breaking out of the range. String parameters are ignored: the
+// operands are dir1 and dir2.
+func CompareTrees(ctx context.Context, dir1, dir2 string, opts ...any) iter.Seq2[PairResult, error] {
+	return func(yield func(PairResult, error) bool) {
+		p := newCommand(opts...)
+		if p.Flags.err != nil {
+			yield(PairResult{}, p.Flags.err)
+			return
+		}
+		if bool(p.Flags.UpdateFirst) && !bool(p.Flags.UpdateRecursive) {
+			yield(PairResult{}, errors.New("refusing to update directory contents without UpdateRecursive"))
+			return
+		}
+		for _, dir := range []string{dir1, dir2} {
+			info, err := os.Stat(dir)
+			if err == nil && !info.IsDir() {
+				err = errors.New("not a directory")
+			}
+			if err != nil {
+				yield(PairResult{}, &FileError{Path: dir, Err: err})
+				return
+			}
+		}
+		p.compareTrees(ctx, yield, diskTree(dir1), diskTree(dir2))
+	}
+}
+
+// CompareFS compares the trees of fs1 and fs2 as CompareTrees compares two
+// directories, reading them only through Open, ReadDir and Stat, so a tree
+// can be an embedded snapshot, an fstest.MapFS or a remote store. The first
+// two string parameters name the roots in the output, "a" and "b" when
+// they are not given.
+//
+// What only the platform knows of a file is unknown in an fs.FS, and the
+// features needing it degrade: a symlink is what it leads to, so only the
+// fs.FS itself ends a symlink cycle and OnlyInDetails describes no
+// symlinks; OneFileSystem keeps nothing out; files are read once whatever
+// RetryOnChange says; headers carry no timestamps and names are never
+// hyperlinks. UpdateFirst, which writes to the first tree, is refused.
+func CompareFS(ctx context.Context, fs1, fs2 fs.FS, opts ...any) iter.Seq2[PairResult, error] {
+	return func(yield func(PairResult, error) bool) {
+		p := newCommand(opts...)
+		if p.Flags.err != nil {
+			yield(PairResult{}, p.Flags.err)
+			return
+		}
+		if bool(p.Flags.UpdateFirst) {
+			yield(PairResult{}, errors.New("refusing to update a tree that is not on disk"))
+			return
+		}
+		names := [2]string{"a", "b"}
+		copy(names[:], p.Positional)
+		trees := [2]tree{{fsys: fs1, root: names[0]}, {fsys: fs2, root: names[1]}}
+		for _, t := range trees {
+			info, err := t.stat(t.root)
+			if err == nil && !info.IsDir() {
+				err = errors.New("not a directory")
+			}
+			if err != nil {
+				yield(PairResult{}, &FileError{Path: t.root, Err: err})
+				return
+			}
+		}
+		p.Flags.TimestampFormat, p.Flags.Hyperlinks = TimestampNone, ""
+		p.compareTrees(ctx, yield, trees[0], trees[1])
+	}
+}
+
+// compareTrees walks two trees for CompareTrees or CompareFS, passing the
+// outcome of each entry to yield
+func (p command) compareTrees(ctx context.Context, yield func(PairResult, error) bool, tree1, tree2 tree) {
+	p.begin()
+	p.Flags.Recursive = true
+	if bool(p.Flags.Reverse) {
+		tree1, tree2 = tree2, tree1
+	}
+	dir1, dir2 := tree1.root, tree2.root
+
+	// Without the text, pairs are compared as for Brief, which never
+	// renders hunks
+	var text bytes.Buffer
+	stdout := io.Discard
+	if bool(p.Flags.DiffText) {
+		stdout = &text
+		if p.Flags.OutputPrefix != "" {
+			stdout = newPrefixWriter(stdout, string(p.Flags.OutputPrefix))
+		}
+	} else {
+		p.Flags.Brief = true
+	}
+
+	_, _ = p.walk(stdout, io.Discard, dir1, func(state *walkState) {
+		state.roots = [2]string{dir1, dir2}
+		state.trees = [2]tree{tree1, tree2}
+		state.pairs = func(result PairResult, err error) bool {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				yield(PairResult{}, ctxErr)
+				return false
+			}
+			result.Text = text.String()
+			text.Reset()
+			return yield(result, err)
+		}
+		p.enterRoots(dir1, dir2, state)
+		p.compareDirectoryLevel(stdout, io.Discard, dir1, dir2, state)
+	})
+}
@@ -0,0 +1,239 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	command "github.com/yupsh/diff"
+)
+
+// collectTrees ranges over CompareTrees and keeps each result by path
+func collectTrees(t *testing.T, dir1, dir2 string, opts ...any) map[string]command.PairResult {
+	t.Helper()
+	results := make(map[string]command.PairResult)
+	for result, err := range command.CompareTrees(context.Background(), dir1, dir2, opts...) {
+		if err != nil {
+			t.Fatalf("%s: %v", result.Path, err)
+		}
+		results[result.Path] = result
+	}
+	return results
+}
+
+func TestCompareTrees(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	results := collectTrees(t, dir1, dir2)
+	want := map[string]command.PairKind{
+		"changed.txt":    command.PairDiffer,
+		"only1.txt":      command.PairOnlyLeft,
+		"only2.txt":      command.PairOnlyRight,
+		"same.txt":       command.PairIdentical,
+		"sub/nested.txt": command.PairDiffer,
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %v", results)
+	}
+	for path, kind := range want {
+		if got := results[path]; got.Kind != kind || got.Text != "" {
+			t.Errorf("%s: got %v %q, want %v without text", path, got.Kind, got.Text, kind)
+		}
+	}
+}
+
+func TestCompareTrees_DiffText(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	results := collectTrees(t, dir1, dir2, command.Unified, command.WithDiffText)
+
+	changed := results["changed.txt"].Text
+	if !strings.HasPrefix(changed, "diff -r -u "+dir1+"/changed.txt "+dir2+"/changed.txt\n") || !strings.HasSuffix(changed, "@@ -1 +1 @@\n-old\n+new\n") {
+		t.Errorf("got:\n%s", changed)
+	}
+	if nested := results["sub/nested.txt"].Text; !strings.Contains(nested, "-nested old\n+nested new\n") || strings.Contains(nested, "changed.txt") {
+		t.Errorf("each pair has only its own text:\n%s", nested)
+	}
+	if only := results["only2.txt"].Text; only != "Only in "+dir2+": only2.txt\n" {
+		t.Errorf("got %q", only)
+	}
+	if same := results["same.txt"].Text; same != "" {
+		t.Errorf("got %q", same)
+	}
+}
+
+func TestCompareTrees_Break(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	opened := 0
+	var seen []string
+	for result, err := range command.CompareTrees(context.Background(), dir1, dir2, command.OpenHook(func(string) { opened++ })) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen = append(seen, result.Path)
+		if result.Kind == command.PairDiffer {
+			break
+		}
+	}
+	// changed.txt sorts first, and nothing is read once the range stops
+	if len(seen) != 1 || seen[0] != "changed.txt" || opened != 2 {
+		t.Errorf("got %v after %d reads", seen, opened)
+	}
+}
+
+func TestCompareTrees_Errors(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+	if err := os.Symlink(filepath.Join(t.TempDir(), "missing"), filepath.Join(dir1, "dangling")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	writeFile(t, dir2, "dangling", "x\n")
+
+	// A pair that cannot be compared is yielded with its error, and the
+	// walk goes on
+	var kinds []command.PairKind
+	for result, err := range command.CompareTrees(context.Background(), dir1, dir2) {
+		if result.Path == "dangling" {
+			var fileErr *command.FileError
+			if result.Kind != command.PairError || !errors.As(err, &fileErr) {
+				t.Errorf("got %v, %v", result.Kind, err)
+			}
+		}
+		kinds = append(kinds, result.Kind)
+	}
+	if len(kinds) != 6 {
+		t.Errorf("got %v", kinds)
+	}
+
+	// Operands that are not directories end the walk at once
+	results := 0
+	for result, err := range command.CompareTrees(context.Background(), filepath.Join(dir1, "same.txt"), dir2) {
+		results++
+		if result.Path != "" || err == nil || !strings.Contains(err.Error(), "not a directory") {
+			t.Errorf("got %v, %v", result, err)
+		}
+	}
+	if results != 1 {
+		t.Errorf("got %d results", results)
+	}
+
+	// So does a cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range command.CompareTrees(ctx, dir1, dir2) {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v", err)
+		}
+	}
+}
+
+// collectFS ranges over CompareFS and keeps each result by path
+func collectFS(t *testing.T, fs1, fs2 fs.FS, opts ...any) map[string]command.PairResult {
+	t.Helper()
+	results := make(map[string]command.PairResult)
+	for result, err := range command.CompareFS(context.Background(), fs1, fs2, opts...) {
+		if err != nil {
+			t.Fatalf("%s: %v", result.Path, err)
+		}
+		results[result.Path] = result
+	}
+	return results
+}
+
+// failingFS fails to open the file bad.txt of its MapFS
+type failingFS struct{ fstest.MapFS }
+
+func (f failingFS) Open(name string) (fs.File, error) {
+	if name == "bad.txt" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MapFS.Open(name)
+}
+
+func TestCompareFS(t *testing.T) {
+	// An in-memory tree compares as the directory it mirrors, under the
+	// names of the roots
+	dir1, dir2 := fixtureTrees(t)
+	mem := fstest.MapFS{
+		"same.txt":       {Data: []byte("same\n")},
+		"changed.txt":    {Data: []byte("old\n")},
+		"only1.txt":      {Data: []byte("left\n")},
+		"sub/nested.txt": {Data: []byte("nested old\n")},
+	}
+	opts := []any{command.Unified, command.WithDiffText, command.OnlyInDetails}
+	want := collectTrees(t, dir1, dir2, opts...)
+	renamed := strings.NewReplacer(dir1, "a", dir2, "b")
+	for _, tt := range []struct {
+		name     string
+		fs1      fs.FS
+		names    []any
+		replacer *strings.Replacer
+	}{
+		{"MapFS", mem, nil, renamed},
+		{"DirFS", os.DirFS(dir1), nil, renamed},
+		{"DirFS named", os.DirFS(dir1), []any{dir1, dir2}, strings.NewReplacer()},
+	} {
+		got := collectFS(t, tt.fs1, os.DirFS(dir2), append(opts, tt.names...)...)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %v, want %v", tt.name, got, want)
+		}
+		for path, result := range want {
+			result.Text = tt.replacer.Replace(result.Text)
+			if !reflect.DeepEqual(got[path], result) {
+				t.Errorf("%s: %s: got %+v, want %+v", tt.name, path, got[path], result)
+			}
+		}
+	}
+
+	// Files are opened as from disk, and not at all once the range stops
+	opened := 0
+	for result := range command.CompareFS(context.Background(), mem, os.DirFS(dir2), command.OpenHook(func(string) { opened++ })) {
+		if result.Kind == command.PairDiffer {
+			break
+		}
+	}
+	if opened != 2 {
+		t.Errorf("got %d reads", opened)
+	}
+}
+
+func TestCompareFS_Errors(t *testing.T) {
+	fs1 := failingFS{fstest.MapFS{
+		"bad.txt":  {Data: []byte("x\n")},
+		"kind":     {Data: []byte("x\n")},
+		"same.txt": {Data: []byte("same\n")},
+	}}
+	fs2 := fstest.MapFS{
+		"bad.txt":     {Data: []byte("x\n")},
+		"kind/in.txt": {Data: []byte("x\n")},
+		"same.txt":    {Data: []byte("same\n")},
+	}
+
+	// Entries and their errors are named as printed, with the trees in the
+	// order Reverse gives them
+	var results []command.PairResult
+	for result, err := range command.CompareFS(context.Background(), fs1, fs2, "old", "new", command.WithDiffText, command.Reverse) {
+		if result.Path == "bad.txt" && (err == nil || err.Error() != "old/bad.txt: open old/bad.txt: permission denied") {
+			t.Errorf("got %v", err)
+		}
+		results = append(results, result)
+	}
+	want := []command.PairResult{
+		{Path: "bad.txt", Kind: command.PairError},
+		{Path: "kind", Kind: command.PairDiffer, Text: "File new/kind is a directory while file old/kind is a regular file\n"},
+		{Path: "same.txt", Kind: command.PairIdentical},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+
+	// Nothing is written to a tree that is not on disk
+	for result, err := range command.CompareFS(context.Background(), fs1, fs2, command.UpdateFirst, command.UpdateRecursive) {
+		if result.Path != "" || err == nil || !strings.Contains(err.Error(), "not on disk") {
+			t.Errorf("got %v, %v", result, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// updateFirst replaces the first operand with the content of the second when
+// the two differ, instead of printing a diff
+func (p command) updateFirst(stdin io.Reader, stdout, stderr io.Writer, file1Path, file2Path string) error {
+	if file1Path == "-" {
+		_, _ = fmt.Fprintf(stderr, "diff: cannot update standard input\n")
+		return fmt.Errorf("cannot update standard input")
+	}
+
+	source, err := p.openInput(stdin, 1, file2Path)
+	if err != nil {
+		return fileTrouble(stderr, file2Path, err)
+	}
+	content, err := io.ReadAll(source)
+	_ = source.Close()
+	if err != nil {
+		return fileTrouble(stderr, file2Path, err)
+	}
+
+	if err := p.updateIfDifferent(stdout, file1Path, file2Path, content); err != nil {
+		return fileTrouble(stderr, file1Path, err)
+	}
+	return nil
+}
+
+// updateIfDifferent compares the bytes of target against content and, if
+// they differ at all, even only in line terminators, atomically rewrites
+// target with content
+func (p command) updateIfDifferent(stdout io.Writer, target, sourceLabel string, content []byte) error {
+	if p.Flags.OpenHook != nil {
+		p.Flags.OpenHook(target)
+	}
+	current, err := os.ReadFile(target)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(current, content) {
+		return nil
+	}
+
+	if err := writeFileAtomic(target, content); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "Updated %s from %s\n", p.displayPath(target), p.displayPath(sourceLabel))
+	return nil
+}
+
+// writeFileAtomic replaces path with content by writing a temporary file in
+// the same directory and renaming it over the original, so a failure part
+// way through leaves the original untouched
+func writeFileAtomic(path string, content []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
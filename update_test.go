@@ -0,0 +1,100 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_UpdateFirstReplacesBytes(t *testing.T) {
+	dir := t.TempDir()
+	golden := writeFile(t, dir, "golden.txt", "old\n")
+	actual := writeFile(t, dir, "actual.txt", "new\r\nlast line without newline")
+
+	stdout, _, err := execute(command.Diff(golden, actual, command.UpdateFirst), "")
+	assertion.NoError(t, err)
+	if want := "Updated " + golden + " from " + actual + "\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	got, err := os.ReadFile(golden)
+	assertion.NoError(t, err)
+	if string(got) != "new\r\nlast line without newline" {
+		t.Errorf("golden file not replaced byte for byte: %q", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	assertion.NoError(t, err)
+	if len(entries) != 2 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
+
+func TestDiff_UpdateFirstIdenticalLeavesFileAlone(t *testing.T) {
+	dir := t.TempDir()
+	golden := writeFile(t, dir, "golden.txt", "same\n")
+	actual := writeFile(t, dir, "actual.txt", "same\n")
+
+	stdout, _, err := execute(command.Diff(golden, actual, command.UpdateFirst), "")
+	assertion.NoError(t, err)
+	if stdout != "" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+}
+
+func TestDiff_UpdateFirstLineEnds(t *testing.T) {
+	tests := []struct{ name, golden, actual string }{
+		{"final newline added", "a\nb", "a\nb\n"},
+		{"final newline removed", "a\nb\n", "a\nb"},
+		{"CRLF to LF", "a\r\nb\r\n", "a\nb\n"},
+		{"LF to CRLF", "a\nb\n", "a\r\nb\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			golden := writeFile(t, dir, "golden.txt", tt.golden)
+			actual := writeFile(t, dir, "actual.txt", tt.actual)
+
+			// Only the line terminators differ, which still makes the
+			// files differ byte for byte
+			stdout, _, err := execute(command.Diff(golden, actual, command.UpdateFirst), "")
+			assertion.NoError(t, err)
+			if want := "Updated " + golden + " from " + actual + "\n"; stdout != want {
+				t.Errorf("got %q, want %q", stdout, want)
+			}
+			got, err := os.ReadFile(golden)
+			assertion.NoError(t, err)
+			if string(got) != tt.actual {
+				t.Errorf("got %q, want %q", got, tt.actual)
+			}
+		})
+	}
+}
+
+func TestDiff_UpdateFirstRefusesStdin(t *testing.T) {
+	actual := writeFile(t, t.TempDir(), "actual.txt", "new\n")
+
+	_, stderr, err := execute(command.Diff("-", actual, command.UpdateFirst), "old\n")
+	assertion.Error(t, err)
+	if stderr == "" {
+		t.Error("expected a diagnostic on stderr")
+	}
+}
+
+func TestDiff_UpdateFirstDirectoriesNeedUpdateRecursive(t *testing.T) {
+	dir1, dir2 := fixtureTrees(t)
+
+	_, _, err := execute(command.Diff(dir1, dir2, command.Recursive, command.UpdateFirst), "")
+	assertion.Error(t, err)
+
+	_, _, err = execute(command.Diff(dir1, dir2, command.Recursive, command.UpdateFirst, command.UpdateRecursive), "")
+	assertDiffers(t, err)
+	got, err := os.ReadFile(filepath.Join(dir1, "sub", "nested.txt"))
+	assertion.NoError(t, err)
+	if string(got) != "nested new\n" {
+		t.Errorf("nested file not updated: %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package command
+
+import "time"
+
+// stringHeaderBytes is what keeping a line costs besides its text
+const stringHeaderBytes = 16
+
+// ResourceUsage is what a comparison made by Run with ResourceStats cost.
+// The inputs are counted in the order they are compared, after Reverse.
+type ResourceUsage struct {
+	// BytesRead is the bytes read of each input, every attempt counted
+	// when RetryOnChange reads it again. Lines given to LinesSource are
+	// not read.
+	BytesRead [2]int64
+	// LinesScanned is the lines each input was split into
+	LinesScanned [2]int
+	// PeakBufferBytes estimates the most line data held at once: the
+	// lines kept of the inputs read so far with the buffer of the one
+	// being read
+	PeakBufferBytes int64
+
+	// SearchSteps is the edit steps the Myers searches took, SearchBudget
+	// the most steps one split of the largest search may take before it
+	// settles for a good split, and BudgetExhausted how many splits did
+	SearchSteps     int
+	SearchBudget    int
+	BudgetExhausted int
+
+	// Read, Compare and Hunks are the wall-clock time taken reading the
+	// inputs, comparing their lines and grouping the changes into hunks,
+	// and Total is their sum
+	Read, Compare, Hunks, Total time.Duration
+
+	// side is the input being read, kept the line data held of those
+	// read before it, and last the end of the previous phase
+	side int
+	kept int64
+	last time.Time
+}
+
+// recordRead counts an attempt at reading the current input, read bytes of
+// which buf now holds
+func (u *ResourceUsage) recordRead(read int, buf *lineBuffer) {
+	if u == nil {
+		return
+	}
+	u.BytesRead[u.side] += int64(read)
+	u.LinesScanned[u.side] += len(buf.lines)
+	held := u.kept + int64(buf.data.Cap()+read+cap(buf.lines)*stringHeaderBytes)
+	u.PeakBufferBytes = max(u.PeakBufferBytes, held)
+}
+
+// nextInput moves on to the next input once the current one, whose lines
+// are kept, is read
+func (u *ResourceUsage) nextInput(lines []string) {
+	if u == nil {
+		return
+	}
+	for _, line := range lines {
+		u.kept += int64(len(line))
+	}
+	u.kept += int64(cap(lines) * stringHeaderBytes)
+	u.side++
+}
+
+// recordSearch adds the cost of one Myers search, whose splits may take
+// budget steps
+func (u *ResourceUsage) recordSearch(steps, budget, settled int) {
+	if u == nil {
+		return
+	}
+	u.SearchSteps += steps
+	u.SearchBudget = max(u.SearchBudget, budget)
+	u.BudgetExhausted += settled
+}
+
+// start starts timing the first phase
+func (u *ResourceUsage) start() {
+	if u != nil {
+		u.last = time.Now()
+	}
+}
+
+// phase is a part of a comparison timed by ResourceUsage
+type phase int
+
+const (
+	phaseRead phase = iota
+	phaseCompare
+	phaseHunks
+)
+
+// lap ends the phase given, starting the next
+func (u *ResourceUsage) lap(phase phase) {
+	if u == nil {
+		return
+	}
+	now := time.Now()
+	took := now.Sub(u.last)
+	switch phase {
+	case phaseRead:
+		u.Read += took
+	case phaseCompare:
+		u.Compare += took
+	case phaseHunks:
+		u.Hunks += took
+	}
+	u.Total += took
+	u.last = now
+}
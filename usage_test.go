@@ -0,0 +1,98 @@
+package command_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestRun_ResourceStats(t *testing.T) {
+	paths := [2]string{"testdata/gnu/moved/a", "testdata/gnu/moved/b"}
+	var sizes [2]int64
+	var lines [2]int
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		assertion.NoError(t, err)
+		sizes[i], lines[i] = int64(len(data)), strings.Count(string(data), "\n")
+	}
+
+	result, err := command.Run(context.Background(), command.FileSource(paths[0]), command.FileSource(paths[1]), command.ResourceStats)
+	assertion.NoError(t, err)
+	u := result.Stats.Resources
+	if u == nil {
+		t.Fatal("no resource usage")
+	}
+	if u.BytesRead != sizes || u.LinesScanned != lines {
+		t.Errorf("got %d bytes and %d lines, want %d and %d", u.BytesRead, u.LinesScanned, sizes, lines)
+	}
+	if u.PeakBufferBytes < sizes[0]+sizes[1] {
+		t.Errorf("peak %d is below the %d bytes of the inputs", u.PeakBufferBytes, sizes[0]+sizes[1])
+	}
+	if u.Read+u.Compare+u.Hunks != u.Total || u.Total <= 0 {
+		t.Errorf("phases %v, %v and %v against a total of %v", u.Read, u.Compare, u.Hunks, u.Total)
+	}
+	if u.SearchSteps <= 0 || u.SearchBudget < 4096 || u.BudgetExhausted != 0 {
+		t.Errorf("got %d steps against a budget of %d, %d exhausted", u.SearchSteps, u.SearchBudget, u.BudgetExhausted)
+	}
+
+	// Reverse compares the second input first
+	reversed, err := command.Run(context.Background(), command.FileSource(paths[0]), command.FileSource(paths[1]), command.ResourceStats, command.Reverse)
+	assertion.NoError(t, err)
+	if got := reversed.Stats.Resources.BytesRead; got != [2]int64{sizes[1], sizes[0]} {
+		t.Errorf("reversed: got %d bytes", got)
+	}
+
+	// Nothing is counted or encoded unless asked for
+	plain, err := command.Run(context.Background(), command.FileSource(paths[0]), command.FileSource(paths[1]))
+	assertion.NoError(t, err)
+	if plain.Stats.Resources != nil {
+		t.Errorf("got %+v", plain.Stats.Resources)
+	}
+	if data, _ := json.Marshal(plain); strings.Contains(string(data), "Resources") {
+		t.Errorf("got %s", data)
+	}
+	if data, _ := json.Marshal(result); !strings.Contains(string(data), `"BytesRead":[`) {
+		t.Errorf("got %s", data)
+	}
+}
+
+func TestRun_ResourceStatsBudget(t *testing.T) {
+	// Lines from a small alphabet match all over, so the search of inputs
+	// this different runs past its budget
+	r := rand.New(rand.NewPCG(3, 0))
+	random := func() []string {
+		lines := make([]string, 8000)
+		for i := range lines {
+			lines[i] = string(rune('a' + r.IntN(10)))
+		}
+		return lines
+	}
+	a, b := random(), random()
+	for _, tt := range []struct {
+		name      string
+		opts      []any
+		exhausted bool
+	}{
+		{"default", nil, true},
+		{"minimal", []any{command.Minimal}, false},
+		{"histogram", []any{command.Histogram}, true},
+	} {
+		opts := append([]any{command.ResourceStats}, tt.opts...)
+		result, err := command.Run(context.Background(), command.LinesSource("a", a), command.LinesSource("b", b), opts...)
+		assertion.NoError(t, err)
+		u := result.Stats.Resources
+		// Lines already in memory are scanned but not read
+		if u.BytesRead != [2]int64{} || u.LinesScanned != [2]int{len(a), len(b)} {
+			t.Errorf("%s: got %d bytes and %d lines", tt.name, u.BytesRead, u.LinesScanned)
+		}
+		if (u.BudgetExhausted > 0) != tt.exhausted || u.SearchSteps < u.SearchBudget {
+			t.Errorf("%s: got %d steps against a budget of %d, %d exhausted", tt.name, u.SearchSteps, u.SearchBudget, u.BudgetExhausted)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The patterns of the VolatileKinds. They are applied in the order of the
+// kinds, so a timestamp is replaced before its digits could pass for a
+// duration or a hash.
+const (
+	// TimestampPattern matches RFC 3339 and ISO 8601 date-times, with a "T"
+	// or a space between date and time, and RFC 1123 dates as found in HTTP
+	// headers
+	TimestampPattern = `\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?` +
+		`|\b(?:Mon|Tue|Wed|Thu|Fri|Sat|Sun), \d{1,2} (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) \d{4} \d{2}:\d{2}:\d{2} (?:[+-]\d{4}|[A-Z]{2,4})\b`
+
+	// UUIDPattern matches UUIDs in their hyphenated form
+	UUIDPattern = `\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`
+
+	// AddressPattern matches dotted IPv4 addresses and IPv6 addresses, in
+	// full or with a "::" run of zero groups
+	AddressPattern = `\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b` +
+		`|\b(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b` +
+		`|(?:\b[0-9a-fA-F]{1,4}:){0,6}(?:\b[0-9a-fA-F]{1,4})?::(?:[0-9a-fA-F]{1,4}:){0,6}[0-9a-fA-F]{1,4}\b`
+
+	// HashPattern matches lowercase hexadecimal strings of 7 or more digits,
+	// the shortest abbreviated git commit. A match made of decimal digits
+	// only is a number rather than a hash and is kept.
+	HashPattern = `\b[0-9a-f]{7,}\b`
+
+	// DurationPattern matches durations such as "123ms", "1.5s" or "1h2m3s"
+	DurationPattern = `\b(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h))+\b`
+)
+
+// volatileRule replaces the content of one VolatileKind. Matches for which
+// keep reports true are left alone.
+type volatileRule struct {
+	pattern     *regexp.Regexp
+	placeholder string
+	keep        func(match string) bool
+}
+
+// volatileRules holds the rule of each VolatileKind, in the order they apply
+var volatileRules = []volatileRule{
+	VolatileTimestamp: {regexp.MustCompile(TimestampPattern), "<timestamp>", nil},
+	VolatileUUID:      {regexp.MustCompile(UUIDPattern), "<uuid>", nil},
+	VolatileAddress:   {regexp.MustCompile(AddressPattern), "<address>", nil},
+	VolatileHash:      {regexp.MustCompile(HashPattern), "<hash>", isDecimal},
+	VolatileDuration:  {regexp.MustCompile(DurationPattern), "<duration>", nil},
+}
+
+func (k VolatileKind) String() string {
+	switch k {
+	case VolatileTimestamp:
+		return "timestamp"
+	case VolatileUUID:
+		return "uuid"
+	case VolatileAddress:
+		return "address"
+	case VolatileHash:
+		return "hash"
+	case VolatileDuration:
+		return "duration"
+	default:
+		return fmt.Sprintf("VolatileKind(%d)", int(k))
+	}
+}
+
+// validate reports a kind without built-in patterns
+func (v VolatileKinds) validate() error {
+	for _, kind := range v {
+		if kind < 0 || int(kind) >= len(volatileRules) {
+			return fmt.Errorf("unknown volatile kind %d", int(kind))
+		}
+	}
+	return nil
+}
+
+// rules lists the rules of the selected kinds once each, in the order they
+// apply
+func (v VolatileKinds) rules() []volatileRule {
+	var rules []volatileRule
+	for kind := range volatileRules {
+		if slices.Contains(v, VolatileKind(kind)) {
+			rules = append(rules, volatileRules[kind])
+		}
+	}
+	return rules
+}
+
+// names lists the selected kinds once each, in the order they apply
+func (v VolatileKinds) names() string {
+	var names []string
+	for kind := range volatileRules {
+		if slices.Contains(v, VolatileKind(kind)) {
+			names = append(names, VolatileKind(kind).String())
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// maskVolatile replaces the volatile content of a line with placeholders
+func (p command) maskVolatile(line string) string {
+	for _, rule := range p.Flags.volatile {
+		if rule.keep == nil {
+			line = rule.pattern.ReplaceAllLiteralString(line, rule.placeholder)
+			continue
+		}
+		line = rule.pattern.ReplaceAllStringFunc(line, func(match string) string {
+			if rule.keep(match) {
+				return match
+			}
+			return rule.placeholder
+		})
+	}
+	return line
+}
+
+// isDecimal reports whether s consists of decimal digits only
+func isDecimal(s string) bool {
+	return strings.Trim(s, "0123456789") == ""
+}
@@ -0,0 +1,104 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gloo-foo/testable/assertion"
+	command "github.com/yupsh/diff"
+)
+
+func TestDiff_IgnoreVolatileKinds(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   command.VolatileKind
+		a, b   string
+		others []command.VolatileKind
+	}{
+		{
+			name: "timestamp",
+			kind: command.VolatileTimestamp,
+			a:    "2024-01-02T03:04:05Z start\nlogged 2024-01-02 03:04:05.123+01:00\nDate: Tue, 02 Jan 2024 03:04:05 GMT\n",
+			b:    "2025-11-30T23:59:59Z start\nlogged 2025-11-30 23:59:59.999-05:00\nDate: Sun, 30 Nov 2025 23:59:59 GMT\n",
+		},
+		{
+			name: "uuid",
+			kind: command.VolatileUUID,
+			a:    "request 123e4567-e89b-12d3-a456-426614174000 done\n",
+			b:    "request 9F1C2D3E-4B5A-6978-8A9B-0C1D2E3F4A5B done\n",
+		},
+		{
+			name: "address",
+			kind: command.VolatileAddress,
+			a:    "peer 10.0.0.1:8080\npeer fe80::1\npeer 2001:0db8:85a3:0000:0000:8a2e:0370:7334\n",
+			b:    "peer 192.168.255.254:8080\npeer ::1\npeer 2001:db8::8a2e:370:7334\n",
+		},
+		{
+			name: "hash",
+			kind: command.VolatileHash,
+			a:    "HEAD is now at 1a2b3c4 fix\nsha256 " + strings.Repeat("ab", 32) + "\n",
+			b:    "HEAD is now at deadbeef fix\nsha256 " + strings.Repeat("cd", 32) + "\n",
+		},
+		{
+			name: "duration",
+			kind: command.VolatileDuration,
+			a:    "ok  pkg 123ms\ntook 1h2m3.5s\n",
+			b:    "ok  pkg 4.2s\ntook 15µs\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := writeFile(t, dir, "a.log", tt.a)
+			b := writeFile(t, dir, "b.log", tt.b)
+
+			stdout, _, err := execute(command.Diff(a, b, command.IgnoreVolatile(tt.kind)), "")
+			assertion.NoError(t, err)
+			if stdout != "" {
+				t.Errorf("logs should compare equal under %v:\n%s", tt.kind, stdout)
+			}
+
+			stdout, _, _ = execute(command.Diff(a, b), "")
+			if stdout == "" {
+				t.Error("without IgnoreVolatile the logs should differ")
+			}
+		})
+	}
+}
+
+func TestDiff_IgnoreVolatileKeepsOtherDifferences(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.log", "2024-01-02T03:04:05Z ok pkg/a 12ms\n2024-01-02T03:04:06Z ok pkg/b 3ms\n")
+	b := writeFile(t, dir, "b.log", "2025-06-07T08:09:10Z ok pkg/a 40ms\n2025-06-07T08:09:11Z FAIL pkg/b 5ms\n")
+
+	stdout, _, _ := execute(command.Diff(a, b, command.IgnoreVolatile(command.VolatileTimestamp, command.VolatileDuration)), "")
+	want := "2c2\n< 2024-01-02T03:04:06Z ok pkg/b 3ms\n---\n> 2025-06-07T08:09:11Z FAIL pkg/b 5ms\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// Only the selected kinds are masked
+	stdout, _, _ = execute(command.Diff(a, b, command.IgnoreVolatile(command.VolatileTimestamp)), "")
+	if !strings.HasPrefix(stdout, "1,2c1,2\n") {
+		t.Errorf("durations should still differ without VolatileDuration:\n%s", stdout)
+	}
+}
+
+func TestDiff_IgnoreVolatileKeepsNumbers(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.log", "wrote 1234567 bytes\n")
+	b := writeFile(t, dir, "b.log", "wrote 7654321 bytes\n")
+
+	stdout, _, _ := execute(command.Diff(a, b, command.IgnoreVolatile(command.VolatileHash)), "")
+	if stdout == "" {
+		t.Error("decimal numbers are not hashes")
+	}
+}
+
+func TestDiff_IgnoreVolatileUnknownKind(t *testing.T) {
+	_, stderr, err := execute(command.Diff("a", "b", command.IgnoreVolatile(command.VolatileKind(99))), "")
+	assertion.Error(t, err)
+	if !strings.Contains(stderr, "unknown volatile kind 99") {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}